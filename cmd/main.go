@@ -9,7 +9,9 @@ import (
 	"os/signal"
 	"time"
 
+	"example.poc/device-monitoring-system/internal/api"
 	"example.poc/device-monitoring-system/internal/config"
+	"example.poc/device-monitoring-system/internal/repository"
 	"example.poc/device-monitoring-system/internal/web"
 	"example.poc/device-monitoring-system/internal/worker"
 	"example.poc/device-monitoring-system/pkg"
@@ -23,6 +25,7 @@ func main() {
 		fmt.Println("  web_service              Start the web service")
 		fmt.Println("  polling_worker   		Start the polling worker")
 		fmt.Println("  start_device_simulator   Start one device simulator")
+		fmt.Println("  validate_config          Validate and print the config")
 		os.Exit(1)
 	}
 
@@ -33,6 +36,8 @@ func main() {
 		startPollingWorker()
 	case "start_device_simulator":
 		startDeviceSimulator()
+	case "validate_config":
+		validateConfig()
 	default:
 		fmt.Printf("Unknown command: %s\n", os.Args[1])
 		fmt.Printf("Usage: %s <command>\n", os.Args[0])
@@ -40,6 +45,7 @@ func main() {
 		fmt.Println("  web_service              Start the web service")
 		fmt.Println("  polling_worker   		Start the polling worker")
 		fmt.Println("  start_device_simulator   Start one device simulator")
+		fmt.Println("  validate_config          Validate and print the config")
 		os.Exit(1)
 	}
 }
@@ -49,6 +55,7 @@ func startWebService() {
 	if err != nil {
 		log.Fatal().Err(err).Msg("failed to create router")
 	}
+	router.StartDiagnosticsReconciler(context.Background())
 	if err = http.ListenAndServe(fmt.Sprintf(":%d", config.WebServicePort()), router); err != nil {
 		log.Fatal().Err(err).Msg("web server stopped")
 	}
@@ -63,6 +70,14 @@ func startPollingWorker() {
 		log.Fatal().Err(err).Msg("failed to create polling worker")
 	}
 
+	if port := config.WorkerControlPort(); port > 0 {
+		go func() {
+			if err := worker.ServeControl(ctx, pollingWorker, port); err != nil {
+				log.Error().Err(err).Msg("worker control server stopped")
+			}
+		}()
+	}
+
 	go func() {
 		err := pollingWorker.Start(ctx)
 		if err != nil && !errors.Is(err, context.Canceled) {
@@ -75,15 +90,50 @@ func startPollingWorker() {
 
 	log.Info().Msg("shutting down device polling worker in 10 seconds...")
 	time.Sleep(10 * time.Second)
-	log.Info().Msg("worker shutdown")
+	log.Info().EmbedObject(pollingWorker.Summary()).Msg("worker shutdown")
 }
 
 func startDeviceSimulator() {
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
 	defer cancel()
 
-	ds := pkg.NewDeviceSimulator()
+	ds, err := pkg.NewDeviceSimulator()
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create device simulator")
+	}
 	if err := ds.Start(ctx); err != nil {
 		log.Fatal().Err(err).Msg("failed to start device simulator")
 	}
 }
+
+func validateConfig() {
+	for _, s := range config.Summary() {
+		if s.Set {
+			fmt.Printf("%s=%s\n", s.Name, s.Value)
+		} else {
+			fmt.Printf("%s (not set)\n", s.Name)
+		}
+	}
+
+	errs := config.Validate()
+	psy, err := api.NewPollingStrategy(config.PollingStrategy())
+	if err != nil {
+		errs = append(errs, fmt.Errorf("POLLING_STRATEGY: %w", err))
+	} else if err = api.ValidatePollingStrategy(psy, repository.KnownDeviceTypes); err != nil {
+		errs = append(errs, fmt.Errorf("POLLING_STRATEGY: %w", err))
+	}
+	if _, err = api.ParseResponseValidationConfig(config.ResponseValidationRules()); err != nil {
+		errs = append(errs, fmt.Errorf("RESPONSE_VALIDATION_RULES: %w", err))
+	}
+
+	if len(errs) > 0 {
+		fmt.Println()
+		fmt.Println("Invalid config:")
+		for _, err := range errs {
+			fmt.Printf("  %v\n", err)
+		}
+		os.Exit(1)
+	}
+	fmt.Println()
+	fmt.Println("Config is valid")
+}
@@ -0,0 +1,12 @@
+-- migrate:up
+CREATE TABLE
+    if NOT EXISTS worker_heartbeats (
+        worker_id text PRIMARY key,
+        created_at timestamptz NOT NULL DEFAULT now (),
+        updated_at timestamptz NOT NULL DEFAULT now ()
+    );
+
+CREATE index if NOT EXISTS idx_worker_heartbeats_updated_at ON worker_heartbeats (updated_at);
+
+-- migrate:down
+DROP TABLE if EXISTS worker_heartbeats;
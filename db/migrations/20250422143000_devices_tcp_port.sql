@@ -0,0 +1,7 @@
+-- migrate:up
+ALTER TABLE devices
+ADD COLUMN if NOT EXISTS tcp_port INT;
+
+-- migrate:down
+ALTER TABLE devices
+DROP COLUMN if EXISTS tcp_port;
@@ -0,0 +1,13 @@
+-- migrate:up
+ALTER TABLE devices
+ADD COLUMN if NOT EXISTS expected_fw_version TEXT;
+
+ALTER TABLE device_types
+ADD COLUMN if NOT EXISTS expected_fw_version TEXT;
+
+-- migrate:down
+ALTER TABLE devices
+DROP COLUMN if EXISTS expected_fw_version;
+
+ALTER TABLE device_types
+DROP COLUMN if EXISTS expected_fw_version;
@@ -0,0 +1,9 @@
+-- migrate:up
+ALTER TABLE devices
+ADD COLUMN if NOT EXISTS deleted_reason TEXT,
+ADD COLUMN if NOT EXISTS deleted_by TEXT;
+
+-- migrate:down
+ALTER TABLE devices
+DROP COLUMN if EXISTS deleted_reason,
+DROP COLUMN if EXISTS deleted_by;
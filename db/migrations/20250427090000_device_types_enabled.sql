@@ -0,0 +1,7 @@
+-- migrate:up
+ALTER TABLE device_types
+ADD COLUMN if NOT EXISTS enabled BOOLEAN NOT NULL DEFAULT TRUE;
+
+-- migrate:down
+ALTER TABLE device_types
+DROP COLUMN if EXISTS enabled;
@@ -0,0 +1,16 @@
+-- migrate:up
+ALTER TABLE devices
+ADD COLUMN if NOT EXISTS capabilities JSONB;
+
+UPDATE devices d SET capabilities = (
+    SELECT coalesce(jsonb_agg(jsonb_strip_nulls(jsonb_build_object(
+        'protocol', p,
+        'port', CASE p WHEN 'rest' THEN d.rest_port WHEN 'grpc' THEN d.grpc_port WHEN 'tcp' THEN d.tcp_port END,
+        'path', CASE p WHEN 'rest' THEN d.rest_path END
+    ))), '[]'::jsonb)
+    FROM unnest(d.protocols) AS p
+) WHERE capabilities IS NULL;
+
+-- migrate:down
+ALTER TABLE devices
+DROP COLUMN if EXISTS capabilities;
@@ -0,0 +1,7 @@
+-- migrate:up
+ALTER TABLE devices
+ADD COLUMN if NOT EXISTS insecure_skip_verify BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- migrate:down
+ALTER TABLE devices
+DROP COLUMN if EXISTS insecure_skip_verify;
@@ -0,0 +1,7 @@
+-- migrate:up
+ALTER TABLE polling_history
+ADD COLUMN if NOT EXISTS grpc_code INTEGER;
+
+-- migrate:down
+ALTER TABLE polling_history
+DROP COLUMN if EXISTS grpc_code;
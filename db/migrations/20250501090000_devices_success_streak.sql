@@ -0,0 +1,7 @@
+-- migrate:up
+ALTER TABLE devices
+ADD COLUMN if NOT EXISTS success_streak INTEGER NOT NULL DEFAULT 0;
+
+-- migrate:down
+ALTER TABLE devices
+DROP COLUMN if EXISTS success_streak;
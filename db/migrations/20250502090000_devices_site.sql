@@ -0,0 +1,11 @@
+-- migrate:up
+ALTER TABLE devices
+ADD COLUMN if NOT EXISTS site TEXT;
+
+CREATE index if NOT EXISTS idx_devices_site ON devices (site);
+
+-- migrate:down
+DROP index if EXISTS idx_devices_site;
+
+ALTER TABLE devices
+DROP COLUMN if EXISTS site;
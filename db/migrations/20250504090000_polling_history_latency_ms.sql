@@ -0,0 +1,7 @@
+-- migrate:up
+ALTER TABLE polling_history
+ADD COLUMN if NOT EXISTS latency_ms INTEGER;
+
+-- migrate:down
+ALTER TABLE polling_history
+DROP COLUMN if EXISTS latency_ms;
@@ -0,0 +1,19 @@
+-- migrate:up
+CREATE TABLE
+    if NOT EXISTS polling_history_rollup (
+        device_id text NOT NULL,
+        bucket_start timestamptz NOT NULL,
+        succeeded_count INTEGER NOT NULL DEFAULT 0,
+        failed_count INTEGER NOT NULL DEFAULT 0,
+        hw_version text,
+        sw_version text,
+        fw_version text,
+        device_status text,
+        last_polled_at timestamptz NOT NULL,
+        PRIMARY key (device_id, bucket_start)
+    );
+
+CREATE index if NOT EXISTS idx_polling_history_rollup_bucket_start ON polling_history_rollup (bucket_start);
+
+-- migrate:down
+DROP TABLE if EXISTS polling_history_rollup;
@@ -0,0 +1,7 @@
+-- migrate:up
+ALTER TABLE devices
+ADD COLUMN if NOT EXISTS poll_interval_override BIGINT;
+
+-- migrate:down
+ALTER TABLE devices
+DROP COLUMN if EXISTS poll_interval_override;
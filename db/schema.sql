@@ -23,7 +23,9 @@ CREATE TABLE public.device_types (
     name text NOT NULL,
     description text,
     created_at timestamp with time zone DEFAULT now() NOT NULL,
-    deleted_at timestamp with time zone
+    deleted_at timestamp with time zone,
+    expected_fw_version text,
+    enabled boolean DEFAULT true NOT NULL
 );
 
 
@@ -63,7 +65,17 @@ CREATE TABLE public.devices (
     polling_status text,
     created_at timestamp with time zone DEFAULT now() NOT NULL,
     last_checked_at timestamp with time zone,
-    deleted_at timestamp with time zone
+    deleted_at timestamp with time zone,
+    tcp_port integer,
+    expected_fw_version text,
+    deleted_reason text,
+    deleted_by text,
+    capabilities jsonb,
+    insecure_skip_verify boolean DEFAULT false NOT NULL,
+    success_streak integer DEFAULT 0 NOT NULL,
+    site text,
+    total_failure_count integer DEFAULT 0 NOT NULL,
+    poll_interval_override bigint
 );
 
 
@@ -101,7 +113,9 @@ CREATE TABLE public.polling_history (
     device_checksum text,
     polling_result text NOT NULL,
     failure_reason text,
-    created_at timestamp with time zone DEFAULT now() NOT NULL
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    grpc_code integer,
+    latency_ms integer
 );
 
 
@@ -134,6 +148,34 @@ CREATE TABLE public.schema_migrations (
 );
 
 
+--
+-- Name: polling_history_rollup; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.polling_history_rollup (
+    device_id text NOT NULL,
+    bucket_start timestamp with time zone NOT NULL,
+    succeeded_count integer DEFAULT 0 NOT NULL,
+    failed_count integer DEFAULT 0 NOT NULL,
+    hw_version text,
+    sw_version text,
+    fw_version text,
+    device_status text,
+    last_polled_at timestamp with time zone NOT NULL
+);
+
+
+--
+-- Name: worker_heartbeats; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.worker_heartbeats (
+    worker_id text NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    updated_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
 --
 -- Name: device_types id; Type: DEFAULT; Schema: public; Owner: -
 --
@@ -203,6 +245,22 @@ ALTER TABLE ONLY public.schema_migrations
     ADD CONSTRAINT schema_migrations_pkey PRIMARY KEY (version);
 
 
+--
+-- Name: polling_history_rollup polling_history_rollup_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.polling_history_rollup
+    ADD CONSTRAINT polling_history_rollup_pkey PRIMARY KEY (device_id, bucket_start);
+
+
+--
+-- Name: worker_heartbeats worker_heartbeats_pkey; Type: CONSTRAINT; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.worker_heartbeats
+    ADD CONSTRAINT worker_heartbeats_pkey PRIMARY KEY (worker_id);
+
+
 --
 -- Name: devices unique_hostname_grpc_port; Type: CONSTRAINT; Schema: public; Owner: -
 --
@@ -268,6 +326,13 @@ CREATE INDEX idx_devices_last_checked_at ON public.devices USING btree (last_che
 CREATE INDEX idx_devices_poll_status_last_checked_at ON public.devices USING btree (polling_status, last_checked_at);
 
 
+--
+-- Name: idx_devices_site; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX idx_devices_site ON public.devices USING btree (site);
+
+
 --
 -- Name: idx_polling_history_created_at; Type: INDEX; Schema: public; Owner: -
 --
@@ -282,6 +347,20 @@ CREATE INDEX idx_polling_history_created_at ON public.polling_history USING btre
 CREATE INDEX idx_polling_history_device_id ON public.polling_history USING btree (device_id);
 
 
+--
+-- Name: idx_polling_history_rollup_bucket_start; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX idx_polling_history_rollup_bucket_start ON public.polling_history_rollup USING btree (bucket_start);
+
+
+--
+-- Name: idx_worker_heartbeats_updated_at; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX idx_worker_heartbeats_updated_at ON public.worker_heartbeats USING btree (updated_at);
+
+
 --
 -- Name: devices devices_device_type_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
 --
@@ -308,4 +387,18 @@ ALTER TABLE ONLY public.polling_history
 --
 
 INSERT INTO public.schema_migrations (version) VALUES
-    ('20250408170630');
+    ('20250408170630'),
+    ('20250420101500'),
+    ('20250422143000'),
+    ('20250425090000'),
+    ('20250426090000'),
+    ('20250427090000'),
+    ('20250428090000'),
+    ('20250429090000'),
+    ('20250430090000'),
+    ('20250501090000'),
+    ('20250502090000'),
+    ('20250503090000'),
+    ('20250504090000'),
+    ('20250505090000'),
+    ('20250506090000');
@@ -3,26 +3,40 @@ package api
 import (
 	"context"
 	"fmt"
+	"net/netip"
+	"net/url"
+	"strings"
 	"time"
 
 	"example.poc/device-monitoring-system/internal/config"
 	"example.poc/device-monitoring-system/internal/repository"
 	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/robfig/cron/v3"
+	"github.com/samber/lo"
 )
 
 var _ IDeviceMonitor = (*GrpcDeviceMonitor)(nil)
 
 var _ IDeviceMonitor = (*RESTDeviceMonitor)(nil)
 
+var _ IDeviceMonitor = (*TCPDeviceMonitor)(nil)
+
 type Connectivity string
 
 const (
-	Connected    Connectivity = "connected"
-	Disconnected Connectivity = "disconnected"
-	Unknown      Connectivity = "unknown"
-	Connecting   Connectivity = "connecting"
+	Connected     Connectivity = "connected"
+	Disconnected  Connectivity = "disconnected"
+	Unknown       Connectivity = "unknown"
+	Connecting    Connectivity = "connecting"
+	Degraded      Connectivity = "degraded"
+	Misconfigured Connectivity = "misconfigured"
+	// Onboarding is the connectivity of a device recently added and not polled yet
+	Onboarding Connectivity = "onboarding"
 )
 
+// Connectivities lists all the connectivities a device can have
+var Connectivities = []Connectivity{Connected, Disconnected, Unknown, Connecting, Degraded, Misconfigured, Onboarding}
+
 var (
 	ErrInvalidResponse = fmt.Errorf("invalid server response")
 )
@@ -32,9 +46,14 @@ type IDeviceMonitor interface {
 }
 
 type PollDeviceRequest struct {
-	Hostname string  `json:"hostname"`
-	Port     *int    `json:"port"`
-	Path     *string `json:"path"`
+	// DeviceID tells a gateway serving several devices over gRPC which one is polled, it is left out when empty
+	DeviceID string `json:"device_id,omitempty"`
+	// DeviceType is the type the device is registered with, its responses are validated against the rule of it
+	DeviceType         string  `json:"device_type,omitempty"`
+	Hostname           string  `json:"hostname"`
+	Port               *int    `json:"port"`
+	Path               *string `json:"path"`
+	InsecureSkipVerify bool    `json:"insecure_skip_verify,omitempty"` // only applies to the devices polled over https
 }
 
 type PollDeviceResponse struct {
@@ -51,6 +70,12 @@ func (info *PollDeviceRequest) validate() error {
 	if info.Hostname == "" {
 		return fmt.Errorf("hostname cannot be empty")
 	}
+	// only IPv6 literals can contain colons
+	if host := strings.TrimSuffix(strings.TrimPrefix(info.Hostname, "["), "]"); strings.Contains(host, ":") {
+		if _, err := netip.ParseAddr(host); err != nil {
+			return fmt.Errorf("invalid hostname: %s", info.Hostname)
+		}
+	}
 	if info.Port != nil {
 		if *info.Port < 0 || *info.Port > 65535 {
 			return fmt.Errorf("invalid port number: %d", *info.Port)
@@ -69,11 +94,56 @@ type BackoffConfig struct {
 	MaxDelay  time.Duration `json:"backoff_max_delay"`
 }
 
+const maxTimeoutToIntervalRatio = 0.8
+
 type PollingConfig struct {
-	Interval  time.Duration  `json:"interval"`
-	Timeout   time.Duration  `json:"request_timeout"`
-	BatchSize int            `json:"batch_size"`
-	Backoff   *BackoffConfig `json:"backoff"`
+	Interval          time.Duration  `json:"interval"`
+	Timeout           time.Duration  `json:"request_timeout"`
+	BatchSize         int            `json:"batch_size"`
+	Backoff           *BackoffConfig `json:"backoff"`
+	UnhealthyStatuses []string       `json:"unhealthy_statuses,omitempty"`
+	// Schedule is an optional cron expression, e.g. "*/5 9-17 * * 1-5" for every 5 minutes during business hours, the
+	// devices are then polled on the schedule instead of on every interval. The interval still tells which devices are
+	// due for polling.
+	Schedule string `json:"schedule,omitempty"`
+	// ColdStreak is the number of polls in a row a device must succeed to be demoted to the cold tier, where it is
+	// polled every ColdInterval instead of every interval, until it fails. There is no cold tier when 0.
+	ColdStreak   int           `json:"cold_streak,omitempty"`
+	ColdInterval time.Duration `json:"cold_interval,omitempty"`
+	// SlowThreshold flags a connected device as slow when its latest successful poll took longer, never when 0
+	SlowThreshold time.Duration `json:"slow_threshold,omitempty"`
+}
+
+// IntervalOf returns the polling interval of a device which succeeded successStreak polls in a row
+func (pc PollingConfig) IntervalOf(successStreak int) time.Duration {
+	if pc.ColdStreak > 0 && successStreak >= pc.ColdStreak {
+		return pc.ColdInterval
+	}
+	return pc.Interval
+}
+
+// ValidateIntervalOverride checks the polling interval overriding the interval of a device, the timeout of a poll has
+// to fit in it like in the polling interval
+func (pc PollingConfig) ValidateIntervalOverride(interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("polling interval override must be a positive duration")
+	}
+	if float64(pc.Timeout) > maxTimeoutToIntervalRatio*float64(interval) {
+		return fmt.Errorf("polling timeout %s must not exceed %.0f%% of the polling interval override", pc.Timeout, maxTimeoutToIntervalRatio*100)
+	}
+	return nil
+}
+
+// ParseSchedule parses the cron expression of the schedule, nil if the devices are polled on every interval
+func (pc PollingConfig) ParseSchedule() (cron.Schedule, error) {
+	if pc.Schedule == "" {
+		return nil, nil
+	}
+	schedule, err := cron.ParseStandard(pc.Schedule)
+	if err != nil {
+		return nil, fmt.Errorf("invalid polling schedule '%s': %w", pc.Schedule, err)
+	}
+	return schedule, nil
 }
 
 func (pc *PollingConfig) Validate() error {
@@ -99,13 +169,58 @@ func (pc *PollingConfig) Validate() error {
 		return err
 	}
 
+	// devices are polled again an interval after their last check, so a poll has to finish well within the interval
+	if float64(pc.Timeout) > maxTimeoutToIntervalRatio*float64(pc.Interval) {
+		return fmt.Errorf("polling timeout must not exceed %.0f%% of the polling interval", maxTimeoutToIntervalRatio*100)
+	}
+
 	if pc.Backoff.BaseDelay >= pc.Backoff.MaxDelay {
 		return fmt.Errorf("backoff base delay must be less than or equal to backoff max delay")
 	}
 
+	if ratio := config.BackoffMaxDelayIntervalRatio(); ratio > 0 && float64(pc.Backoff.MaxDelay) > ratio*float64(pc.Interval) {
+		return fmt.Errorf("backoff max delay %s must not exceed %g times the polling interval %s", pc.Backoff.MaxDelay, ratio, pc.Interval)
+	}
+
+	if _, err := pc.ParseSchedule(); err != nil {
+		return err
+	}
+
+	if pc.ColdStreak < 0 {
+		return fmt.Errorf("cold streak must be greater than or equal to 0")
+	}
+	if pc.ColdStreak > 0 && pc.ColdInterval <= pc.Interval {
+		return fmt.Errorf("cold interval must be greater than the polling interval")
+	}
+	if pc.SlowThreshold < 0 {
+		return fmt.Errorf("slow threshold must be greater than or equal to 0")
+	}
+
 	return nil
 }
 
+// ValidatePollingStrategy checks that the strategy provides a valid polling config for each of the device types
+func ValidatePollingStrategy(psy IPollingStrategy, deviceTypes []string) error {
+	if psy == nil {
+		return fmt.Errorf("polling strategy cannot be nil")
+	}
+	for _, deviceType := range deviceTypes {
+		cfg, err := psy.GetPollingConfigByDeviceType(deviceType)
+		if err != nil {
+			return fmt.Errorf("failed to get polling config for device type %s: %w", deviceType, err)
+		}
+		if err = cfg.Validate(); err != nil {
+			return fmt.Errorf("invalid polling config for device type %s: %w", deviceType, err)
+		}
+	}
+	return nil
+}
+
+// defaultUnhealthyStatuses returns the device statuses which are not operational, as configured by OPERATIONAL_STATUSES
+func defaultUnhealthyStatuses() []string {
+	return lo.Without(config.DeviceStatuses, config.OperationalStatuses()...)
+}
+
 type DefaultPollingStrategy struct{}
 
 func (s *DefaultPollingStrategy) GetPollingConfigByDeviceType(deviceType string) (PollingConfig, error) {
@@ -120,6 +235,7 @@ func (s *DefaultPollingStrategy) GetPollingConfigByDeviceType(deviceType string)
 				MaxDelay:  120 * time.Second,
 				Factor:    2.0,
 			},
+			UnhealthyStatuses: defaultUnhealthyStatuses(),
 		}, nil
 	case repository.Switch:
 		return PollingConfig{
@@ -131,6 +247,7 @@ func (s *DefaultPollingStrategy) GetPollingConfigByDeviceType(deviceType string)
 				MaxDelay:  300 * time.Second,
 				Factor:    2.0,
 			},
+			UnhealthyStatuses: defaultUnhealthyStatuses(),
 		}, nil
 	case repository.Camera:
 		return PollingConfig{
@@ -142,6 +259,7 @@ func (s *DefaultPollingStrategy) GetPollingConfigByDeviceType(deviceType string)
 				MaxDelay:  60 * time.Second,
 				Factor:    2.0,
 			},
+			UnhealthyStatuses: defaultUnhealthyStatuses(),
 		}, nil
 	case repository.DoorAccessSystem:
 		return PollingConfig{
@@ -153,6 +271,7 @@ func (s *DefaultPollingStrategy) GetPollingConfigByDeviceType(deviceType string)
 				MaxDelay:  30 * time.Second,
 				Factor:    2.0,
 			},
+			UnhealthyStatuses: defaultUnhealthyStatuses(),
 		}, nil
 	default:
 		return PollingConfig{}, fmt.Errorf("unsupported device type: %s", deviceType)
@@ -160,23 +279,54 @@ func (s *DefaultPollingStrategy) GetPollingConfigByDeviceType(deviceType string)
 }
 
 type DeviceDiagnostics struct {
-	Id            uint         `json:"id"`
-	DeviceID      string       `json:"device_id"`
-	DeviceType    string       `json:"device_type"`
-	DeviceHost    string       `json:"device_host"`
-	HwVersion     string       `json:"hw_version"`
-	SwVersion     string       `json:"sw_version"`
-	FwVersion     string       `json:"fw_version"`
-	Status        string       `json:"status"`
-	Checksum      string       `json:"checksum"`
-	Connectivity  Connectivity `json:"connectivity"`
-	LastCheckedAt *time.Time   `json:"last_checked_at,omitempty"`
+	Id         uint     `json:"id"`
+	DeviceID   string   `json:"device_id"`
+	DeviceType string   `json:"device_type"`
+	DeviceHost string   `json:"device_host"`
+	Protocols  []string `json:"protocols"`
+	RestPort   *int     `json:"rest_port,omitempty"`
+	RestPath   *string  `json:"rest_path,omitempty"`
+	GrpcPort   *int     `json:"grpc_port,omitempty"`
+	TcpPort    *int     `json:"tcp_port,omitempty"`
+	// PollIntervalOverride is the polling interval of the device overriding the one of its device type, e.g. "10s"
+	PollIntervalOverride string       `json:"poll_interval_override,omitempty"`
+	HwVersion            string       `json:"hw_version"`
+	SwVersion            string       `json:"sw_version"`
+	FwVersion            string       `json:"fw_version"`
+	FwCompliant          *bool        `json:"fw_compliant,omitempty"`
+	Status               string       `json:"status"`
+	Checksum             string       `json:"checksum"`
+	Connectivity         Connectivity `json:"connectivity"`
+	LastCheckedAt        *Timestamp   `json:"last_checked_at,omitempty"`
+	NextPollEstimate     *Timestamp   `json:"next_poll_estimate,omitempty"`
+	// TotalFailureCount is the number of the failed polls of the device across the polling cycles
+	TotalFailureCount int `json:"total_failure_count"`
+	// Slow tells that the latest successful poll of a connected device took longer than the slow threshold of its type
+	Slow bool `json:"slow"`
+}
+
+// DeviceDiagnosticsFailure tells why the diagnostics of a device could not be computed
+type DeviceDiagnosticsFailure struct {
+	DeviceID string `json:"device_id"`
+	Error    string `json:"error"`
+}
+
+type FieldChange struct {
+	Field    string `json:"field"`
+	Previous string `json:"previous"`
+	Current  string `json:"current"`
+}
+
+type DeviceChange struct {
+	ChangedAt Timestamp     `json:"changed_at"`
+	Changes   []FieldChange `json:"changes"`
 }
 
 type PollingCapability struct {
-	Protocol string  `json:"protocol"`
-	Port     *int    `json:"port,omitempty"`
-	Path     *string `json:"path,omitempty"`
+	Protocol string            `json:"protocol"`
+	Port     *int              `json:"port,omitempty"`
+	Path     *string           `json:"path,omitempty"`
+	Extra    map[string]string `json:"extra,omitempty"`
 }
 
 type DeviceHealthCheckResponse struct {
@@ -202,7 +352,42 @@ func (resp *DeviceHealthCheckResponse) Validate() error {
 		if capability.Port != nil && (*capability.Port < 0 || *capability.Port > 65535) {
 			return fmt.Errorf("invalid port number: %d", *capability.Port)
 		}
+		if capability.Protocol == repository.TCP && capability.Port == nil {
+			return fmt.Errorf("port cannot be empty for protocol %s", repository.TCP)
+		}
+		if capability.Path != nil && *capability.Path != "" {
+			if err := validatePath(*capability.Path); err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
 }
+
+// DeviceIDPlaceholder in a REST or health check path is replaced with the id of the device, so that the devices behind
+// a shared gateway are told apart by their paths, e.g. '/devices/{device_id}/data'
+const DeviceIDPlaceholder = "{device_id}"
+
+// ExpandDevicePath replaces the device id placeholder of a path with the escaped id of the device
+func ExpandDevicePath(path, deviceID string) (string, error) {
+	if !strings.Contains(path, DeviceIDPlaceholder) {
+		return path, nil
+	}
+	if deviceID == "" {
+		return "", fmt.Errorf("invalid path '%s': the device id is required to expand it", path)
+	}
+	return strings.ReplaceAll(path, DeviceIDPlaceholder, url.PathEscape(deviceID)), nil
+}
+
+// validatePath checks that a REST path is a plain path, the URL is built by appending it to the device address
+func validatePath(path string) error {
+	u, err := url.Parse(path)
+	if err != nil {
+		return fmt.Errorf("invalid path '%s': %w", path, err)
+	}
+	if u.Scheme != "" || u.Host != "" || strings.ContainsAny(path, "?#") {
+		return fmt.Errorf("invalid path '%s': scheme, host, query and fragment are not allowed", path)
+	}
+	return nil
+}
@@ -3,24 +3,33 @@ package api
 import (
 	"context"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
 	"example.poc/device-monitoring-system/internal/config"
+	"example.poc/device-monitoring-system/internal/util"
 	"example.poc/device-monitoring-system/proto"
 	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/samber/lo"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/metadata"
 )
 
 const defaultGrpcRequestTimeout = 30 * time.Second
 
+// GrpcDeviceMonitor polls device data over gRPC. A poll is always bound by the deadline of the passed context,
+// the default timeout applies only when the context has no deadline.
 type GrpcDeviceMonitor struct {
 	clientCache map[string]grpcClientWrapper
 	dialOpts    []grpc.DialOption
 	rwLock      sync.RWMutex
+	rules       ResponseValidationConfig
 }
 
 type grpcClientWrapper struct {
+	conn         *grpc.ClientConn
 	client       proto.DeviceMonitorClient
 	lastUsedTime *time.Time // can be utilized for cache eviction
 }
@@ -33,7 +42,13 @@ func NewGrpcDeviceMonitor(opts ...grpc.DialOption) *GrpcDeviceMonitor {
 	}
 }
 
+// SetResponseValidation sets the per device type rules that a device data response is validated against
+func (g *GrpcDeviceMonitor) SetResponseValidation(rules ResponseValidationConfig) {
+	g.rules = rules
+}
+
 func (g *GrpcDeviceMonitor) PollDevice(ctx context.Context, req PollDeviceRequest) (*PollDeviceResponse, error) {
+	ctx = util.NonNilContext(ctx, "GrpcDeviceMonitor.PollDevice")
 	if err := req.validate(); err != nil {
 		return nil, err
 	}
@@ -48,7 +63,17 @@ func (g *GrpcDeviceMonitor) PollDevice(ctx context.Context, req PollDeviceReques
 		return nil, err
 	}
 
-	resp, err := c.GetDeviceData(ctx, &proto.DeviceDataRequest{})
+	var cancel context.CancelFunc
+	if _, ok := ctx.Deadline(); !ok {
+		ctx, cancel = context.WithTimeout(ctx, defaultGrpcRequestTimeout)
+		defer cancel()
+	}
+
+	if tp := util.TraceParent(ctx); tp != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, util.TraceParentHeader, tp)
+	}
+
+	resp, err := c.GetDeviceData(ctx, &proto.DeviceDataRequest{DeviceId: lo.EmptyableToPtr(req.DeviceID)})
 	if err != nil {
 		return nil, err
 	}
@@ -56,7 +81,7 @@ func (g *GrpcDeviceMonitor) PollDevice(ctx context.Context, req PollDeviceReques
 		return nil, err
 	}
 
-	return &PollDeviceResponse{
+	data := &PollDeviceResponse{
 		Id:       *resp.DeviceId,
 		Type:     *resp.DeviceType,
 		Hw:       *resp.HardwareVersion,
@@ -64,35 +89,86 @@ func (g *GrpcDeviceMonitor) PollDevice(ctx context.Context, req PollDeviceReques
 		Fw:       *resp.FirmwareVersion,
 		Status:   *resp.Status,
 		Checksum: *resp.Checksum,
-	}, nil
+	}
+	if err = g.rules.Validate(req.DeviceType, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// Connect establishes the connection to a device ahead of its first poll and waits until it is ready, so that the
+// first poll does not pay the cost of establishing it. The connections are otherwise established lazily.
+func (g *GrpcDeviceMonitor) Connect(ctx context.Context, req PollDeviceRequest) error {
+	ctx = util.NonNilContext(ctx, "GrpcDeviceMonitor.Connect")
+	if err := req.validate(); err != nil {
+		return err
+	}
+
+	port := config.GrpcPort()
+	if req.Port != nil {
+		port = *req.Port
+	}
+
+	gw, err := g.getGrpcClientWrapper(req.Hostname, port)
+	if err != nil {
+		return err
+	}
+
+	gw.conn.Connect()
+	for {
+		state := gw.conn.GetState()
+		if state == connectivity.Ready {
+			return nil
+		}
+		if !gw.conn.WaitForStateChange(ctx, state) {
+			return fmt.Errorf("failed to connect to %s, last state %s: %w", gw.conn.Target(), state, ctx.Err())
+		}
+	}
 }
 
 func (g *GrpcDeviceMonitor) getGrpcClient(hostname string, port int) (proto.DeviceMonitorClient, error) {
-	target := fmt.Sprintf("%s:%d", hostname, port)
+	gw, err := g.getGrpcClientWrapper(hostname, port)
+	if err != nil {
+		return nil, err
+	}
+	return gw.client, nil
+}
+
+func (g *GrpcDeviceMonitor) getGrpcClientWrapper(hostname string, port int) (grpcClientWrapper, error) {
+	target := grpcTarget(hostname, port)
 	g.rwLock.RLock()
 	gw, ok := g.clientCache[target]
 	g.rwLock.RUnlock()
 	if ok {
-		return gw.client, nil
+		return gw, nil
 	}
 
 	g.rwLock.Lock()
 	if gw, ok = g.clientCache[target]; ok {
 		g.rwLock.Unlock()
-		return gw.client, nil
+		return gw, nil
 	}
 
 	defer g.rwLock.Unlock()
 	conn, err := grpc.NewClient(target, g.dialOpts...)
 	if err != nil {
-		return nil, err
+		return grpcClientWrapper{}, err
 	}
 
 	gw = grpcClientWrapper{
+		conn:   conn,
 		client: proto.NewDeviceMonitorClient(conn),
 	}
 	g.clientCache[target] = gw
-	return gw.client, nil
+	return gw, nil
+}
+
+// grpcTarget normalizes the hostname, so that the spellings of the same hostname, e.g. 'Example.com.' and
+// 'example.com', share a cached client. The aliases of a device, e.g. its IP and its DNS name, are not resolved.
+func grpcTarget(hostname string, port int) string {
+	hostname = strings.TrimRight(strings.ToLower(hostname), ".")
+	return util.HostPort(hostname, port)
 }
 
 func validateGrpcDeviceDataResp(resp *proto.DeviceDataResponse) error {
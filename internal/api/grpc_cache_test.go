@@ -0,0 +1,28 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestGrpcClientCacheNormalizesHostname(t *testing.T) {
+	g := NewGrpcDeviceMonitor(grpc.WithTransportCredentials(insecure.NewCredentials()))
+
+	c1, err := g.getGrpcClient("Example.com", 50051)
+	assert.NoError(t, err)
+	c2, err := g.getGrpcClient("example.com", 50051)
+	assert.NoError(t, err)
+	c3, err := g.getGrpcClient("EXAMPLE.COM.", 50051)
+	assert.NoError(t, err)
+	assert.Same(t, c1, c2)
+	assert.Same(t, c1, c3)
+	assert.Len(t, g.clientCache, 1)
+
+	_, err = g.getGrpcClient("example.com", 50052)
+	assert.NoError(t, err)
+	assert.Len(t, g.clientCache, 2)
+	assert.Contains(t, g.clientCache, "example.com:50051")
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
+	"net"
 	"testing"
 	"time"
 
@@ -51,6 +52,7 @@ func (s *grpcDeviceMonitorTestSuite) SetupTest() {
 	s.sdms.SetError(nil)
 	s.sdms.SetDelay(0)
 	s.sdms.SetResponse(nil)
+	s.gdm.SetResponseValidation(nil)
 }
 
 func (s *grpcDeviceMonitorTestSuite) TearDownSuite() {
@@ -134,6 +136,160 @@ func (s *grpcDeviceMonitorTestSuite) TestSuccessResponse() {
 	s.Equal(checksum, resp.Checksum)
 }
 
+func (s *grpcDeviceMonitorTestSuite) TestConnect() {
+	gdm := api.NewGrpcDeviceMonitor(grpc.WithTransportCredentials(insecure.NewCredentials()))
+	err := gdm.Connect(s.T().Context(), api.PollDeviceRequest{
+		Hostname: "localhost",
+		Port:     lo.ToPtr(config.GrpcPort()),
+	})
+	s.NoError(err)
+
+	ctx, cancel := context.WithTimeout(s.T().Context(), 200*time.Millisecond)
+	defer cancel()
+	err = gdm.Connect(ctx, api.PollDeviceRequest{
+		Hostname: "localhost",
+		Port:     lo.ToPtr(randPort()),
+	})
+	s.ErrorIs(err, context.DeadlineExceeded)
+}
+
+func (s *grpcDeviceMonitorTestSuite) TestDeviceIDInRequest() {
+	s.sdms.SetError(errNoDeviceInfo)
+	req := api.PollDeviceRequest{
+		Hostname: "localhost",
+		Port:     lo.ToPtr(config.GrpcPort()),
+	}
+
+	// a device ID is sent only when there is one, like the single device servers expect
+	_, err := s.gdm.PollDevice(s.T().Context(), req)
+	s.ErrorIs(err, errNoDeviceInfo)
+	s.Require().NotNil(s.sdms.LastRequest())
+	s.Nil(s.sdms.LastRequest().DeviceId)
+
+	req.DeviceID = uuid.NewString()
+	_, err = s.gdm.PollDevice(s.T().Context(), req)
+	s.ErrorIs(err, errNoDeviceInfo)
+	s.Equal(req.DeviceID, s.sdms.LastRequest().GetDeviceId())
+}
+
+func (s *grpcDeviceMonitorTestSuite) TestNilContext() {
+	deviceID := uuid.NewString()
+	deviceType := repository.Router
+	version := helper.RandomString(10)
+	status := "operational"
+	checksum := helper.RandomString(32)
+	s.sdms.SetResponse(&proto.DeviceDataResponse{
+		DeviceId:        &deviceID,
+		DeviceType:      &deviceType,
+		HardwareVersion: &version,
+		SoftwareVersion: &version,
+		FirmwareVersion: &version,
+		Status:          &status,
+		Checksum:        &checksum,
+	})
+
+	// polled with the background context, bound by the default timeout, instead of panicking
+	var ctx context.Context
+	s.NotPanics(func() {
+		resp, err := s.gdm.PollDevice(ctx, api.PollDeviceRequest{
+			Hostname: "localhost",
+			Port:     lo.ToPtr(config.GrpcPort()),
+		})
+		s.NoError(err)
+		s.Equal(deviceID, resp.Id)
+	})
+}
+
+func (s *grpcDeviceMonitorTestSuite) TestIPv6Hostname() {
+	lis, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		s.T().Skipf("IPv6 loopback is not available: %v", err)
+	}
+
+	deviceID := uuid.NewString()
+	deviceType := repository.Router
+	version := helper.RandomString(10)
+	status := "operational"
+	checksum := helper.RandomString(32)
+	sdms := &helper.SimpleDeviceMonitorServer{}
+	sdms.SetResponse(&proto.DeviceDataResponse{
+		DeviceId:        &deviceID,
+		DeviceType:      &deviceType,
+		HardwareVersion: &version,
+		SoftwareVersion: &version,
+		FirmwareVersion: &version,
+		Status:          &status,
+		Checksum:        &checksum,
+	})
+	gs := grpc.NewServer()
+	proto.RegisterDeviceMonitorServer(gs, sdms)
+	go func() {
+		_ = gs.Serve(lis)
+	}()
+	defer gs.Stop()
+
+	port := lis.Addr().(*net.TCPAddr).Port
+	for _, hostname := range []string{"::1", "[::1]"} {
+		resp, err := s.gdm.PollDevice(s.T().Context(), api.PollDeviceRequest{
+			Hostname: hostname,
+			Port:     &port,
+		})
+		s.NoError(err, hostname)
+		s.NotNil(resp)
+		s.Equal(deviceID, resp.Id)
+	}
+
+	_, err = s.gdm.PollDevice(s.T().Context(), api.PollDeviceRequest{
+		Hostname: "::1::zz",
+		Port:     &port,
+	})
+	s.ErrorContains(err, "invalid hostname")
+}
+
+func (s *grpcDeviceMonitorTestSuite) TestStatusNotAllowed() {
+	rules, err := api.ParseResponseValidationConfig(`{"router": {"allowed_statuses": ["operating", "rebooting"]}}`)
+	s.NoError(err)
+	s.gdm.SetResponseValidation(rules)
+
+	deviceID := uuid.NewString()
+	status := "internal error"
+	deviceType := repository.Router
+	version := helper.RandomString(10)
+	checksum := helper.RandomString(32)
+	s.sdms.SetResponse(&proto.DeviceDataResponse{
+		DeviceId:        &deviceID,
+		DeviceType:      &deviceType,
+		HardwareVersion: &version,
+		SoftwareVersion: &version,
+		FirmwareVersion: &version,
+		Status:          &status,
+		Checksum:        &checksum,
+	})
+
+	req := api.PollDeviceRequest{
+		DeviceType: repository.Router,
+		Hostname:   "localhost",
+		Port:       lo.ToPtr(config.GrpcPort()),
+	}
+	_, err = s.gdm.PollDevice(s.T().Context(), req)
+	s.Error(err)
+	s.ErrorIs(err, api.ErrInvalidResponse)
+	s.Contains(err.Error(), "status 'internal error' is not allowed")
+
+	// the rule of the registered device type applies whatever type the device reports
+	deviceType = repository.Switch
+	_, err = s.gdm.PollDevice(s.T().Context(), req)
+	s.ErrorIs(err, api.ErrInvalidResponse)
+	s.Contains(err.Error(), "status 'internal error' is not allowed for device type router")
+
+	// and does not apply to the devices of other device types
+	deviceType = repository.Router
+	req.DeviceType = repository.Switch
+	resp, err := s.gdm.PollDevice(s.T().Context(), req)
+	s.NoError(err)
+	s.Equal(status, resp.Status)
+}
+
 func randPort() int {
 	port := 50000 + rand.Intn(1000)
 	if _, ok := usedPort[port]; ok {
@@ -5,7 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
-	"strings"
+	"slices"
 	"time"
 
 	"example.poc/device-monitoring-system/internal/config"
@@ -16,20 +16,48 @@ import (
 
 const defaultRESTRequestTimeout = 30 * time.Second
 
+// RESTDeviceMonitor polls device data over http. A poll is always bound by the deadline of the passed context,
+// the default timeout applies only when the context has no deadline. Avoid setting a timeout on the http client,
+// since it would compete with the context deadline.
 type RESTDeviceMonitor struct {
-	client *http.Client
+	client   *http.Client
+	insecure *http.Client // skips the verification of the tls certificates, nil if the client does not support it
+	rules    ResponseValidationConfig
 }
 
 type HTTPClientOptions func(*http.Client)
 
 func NewRESTDeviceMonitor(opts ...HTTPClientOptions) *RESTDeviceMonitor {
-	c := &http.Client{}
+	c := &http.Client{
+		CheckRedirect: redirectPolicy(config.RESTMaxRedirects(), config.RESTRedirectAllowedHosts()),
+	}
 	if len(opts) > 0 {
 		for _, opt := range opts {
 			opt(c)
 		}
 	}
-	return &RESTDeviceMonitor{client: c}
+	insecure, _ := util.InsecureClient(c)
+	return &RESTDeviceMonitor{client: c, insecure: insecure}
+}
+
+// redirectPolicy follows at most maxRedirects redirects, to the host of the device or to one of the allowed hosts,
+// e.g. a device behind a reverse proxy which redirects the data path
+func redirectPolicy(maxRedirects int, allowedHosts []string) func(req *http.Request, via []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) > maxRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxRedirects)
+		}
+		host := req.URL.Hostname()
+		if host != via[0].URL.Hostname() && !slices.Contains(allowedHosts, host) {
+			return fmt.Errorf("redirect to host %s is not allowed", host)
+		}
+		return nil
+	}
+}
+
+// SetResponseValidation sets the per device type rules that a device data response is validated against
+func (r *RESTDeviceMonitor) SetResponseValidation(rules ResponseValidationConfig) {
+	r.rules = rules
 }
 
 type RestPollDeviceResponse struct {
@@ -43,6 +71,7 @@ type RestPollDeviceResponse struct {
 }
 
 func (r *RESTDeviceMonitor) PollDevice(ctx context.Context, info PollDeviceRequest) (*PollDeviceResponse, error) {
+	ctx = util.NonNilContext(ctx, "RESTDeviceMonitor.PollDevice")
 	if err := info.validate(); err != nil {
 		return nil, err
 	}
@@ -56,8 +85,14 @@ func (r *RESTDeviceMonitor) PollDevice(ctx context.Context, info PollDeviceReque
 	if info.Path != nil && len(*info.Path) > 0 {
 		path = *info.Path
 	}
-	path = strings.TrimPrefix(path, "/")
-	reqURL := fmt.Sprintf("%s://%s:%d/%s", config.RESTSchema(), info.Hostname, port, path)
+	path, err := ExpandDevicePath(path, info.DeviceID)
+	if err != nil {
+		return nil, err
+	}
+	if err := validatePath(path); err != nil {
+		return nil, err
+	}
+	reqURL := fmt.Sprintf("%s://%s%s", config.RESTSchema(), util.HostPort(info.Hostname, port), util.CanonicalPath(path))
 	u, err := url.Parse(reqURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse request URL '%s': %w", reqURL, err)
@@ -69,9 +104,17 @@ func (r *RESTDeviceMonitor) PollDevice(ctx context.Context, info PollDeviceReque
 		defer cancel()
 	}
 
+	client := r.client
+	if info.InsecureSkipVerify {
+		if r.insecure == nil {
+			return nil, fmt.Errorf("skipping the tls verification is not supported by the http client")
+		}
+		client = r.insecure
+	}
+
 	header := http.Header{}
 	header.Set("Accept", "application/json")
-	resp, err := util.SendHttpRequest[RestPollDeviceResponse](ctx, r.client, util.HTTPRequestParams{
+	resp, err := util.SendHttpRequest[RestPollDeviceResponse](ctx, client, util.HTTPRequestParams{
 		Method:       http.MethodGet,
 		RequestURL:   u.String(),
 		Header:       header,
@@ -90,7 +133,7 @@ func (r *RESTDeviceMonitor) PollDevice(ctx context.Context, info PollDeviceReque
 		}
 	}
 
-	return &PollDeviceResponse{
+	data := &PollDeviceResponse{
 		Id:       v.Id,
 		Type:     v.Type,
 		Hw:       v.Hw,
@@ -98,7 +141,16 @@ func (r *RESTDeviceMonitor) PollDevice(ctx context.Context, info PollDeviceReque
 		Fw:       v.Fw,
 		Status:   v.Status,
 		Checksum: v.Checksum,
-	}, nil
+	}
+	if err = r.rules.Validate(info.DeviceType, data); err != nil {
+		return nil, util.HTTPResponseError{
+			Code:  resp.Code,
+			Body:  resp.Body,
+			Cause: err,
+		}
+	}
+
+	return data, nil
 }
 
 func validateRESTDeviceDataResp(resp *RestPollDeviceResponse) error {
@@ -19,6 +19,7 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -162,3 +163,387 @@ func (s *restDeviceMonitorTestSuite) TestValidResponse() {
 	s.Equal(status, resp.Status)
 	s.Equal(checksum, resp.Checksum)
 }
+
+func (s *restDeviceMonitorTestSuite) TestNilContext() {
+	s.restDeviceMonitor = api.NewRESTDeviceMonitor()
+	h := chi.NewRouter()
+	h.Get(config.RESTApiPath(), func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(api.RestPollDeviceResponse{
+			Id:       uuid.NewString(),
+			Type:     repository.Router,
+			Hw:       "hw",
+			Sw:       "sw",
+			Fw:       "fw",
+			Status:   "active",
+			Checksum: helper.RandomString(32),
+		})
+	})
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(u.Port())
+	req := api.PollDeviceRequest{
+		Hostname: u.Hostname(),
+		Port:     &port,
+	}
+
+	// polled with the background context instead of panicking
+	var ctx context.Context
+	s.NotPanics(func() {
+		resp, err := s.restDeviceMonitor.PollDevice(ctx, req)
+		s.NoError(err)
+		s.Equal("active", resp.Status)
+	})
+}
+
+func (s *restDeviceMonitorTestSuite) TestRedirects() {
+	var hops int
+	h := chi.NewRouter()
+	h.Get(config.RESTApiPath(), func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/v2/data", http.StatusMovedPermanently)
+	})
+	h.Get("/v2/data", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(api.RestPollDeviceResponse{
+			Id:       uuid.NewString(),
+			Type:     repository.Router,
+			Hw:       "hw",
+			Sw:       "sw",
+			Fw:       "fw",
+			Status:   "active",
+			Checksum: helper.RandomString(32),
+		})
+	})
+	h.Get("/loop", func(w http.ResponseWriter, r *http.Request) {
+		hops++
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(u.Port())
+	poll := func(path string) (*api.PollDeviceResponse, error) {
+		return api.NewRESTDeviceMonitor().PollDevice(context.Background(), api.PollDeviceRequest{
+			Hostname: u.Hostname(),
+			Port:     &port,
+			Path:     lo.ToPtr(path),
+		})
+	}
+
+	// redirected once to the data
+	resp, err := poll(config.RESTApiPath())
+	s.NoError(err)
+	s.Equal("active", resp.Status)
+
+	// a redirect loop is stopped
+	s.T().Setenv("REST_MAX_REDIRECTS", "2")
+	_, err = poll("/loop")
+	s.ErrorContains(err, "stopped after 2 redirects")
+	s.Equal(3, hops)
+
+	// another host is not followed unless it is allowed
+	h.Get("/elsewhere", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, fmt.Sprintf("http://localhost:%d/v2/data", port), http.StatusFound)
+	})
+	_, err = poll("/elsewhere")
+	s.ErrorContains(err, "redirect to host localhost is not allowed")
+
+	s.T().Setenv("REST_REDIRECT_ALLOWED_HOSTS", "proxy.local, localhost")
+	resp, err = poll("/elsewhere")
+	s.NoError(err)
+	s.Equal("active", resp.Status)
+}
+
+func (s *restDeviceMonitorTestSuite) TestInsecureSkipVerify() {
+	s.T().Setenv("REST_SCHEMA", "https")
+	s.restDeviceMonitor = api.NewRESTDeviceMonitor()
+	h := chi.NewRouter()
+	h.Get(config.RESTApiPath(), func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(api.RestPollDeviceResponse{
+			Id:       uuid.NewString(),
+			Type:     repository.Router,
+			Hw:       "hw",
+			Sw:       "sw",
+			Fw:       "fw",
+			Status:   "active",
+			Checksum: helper.RandomString(32),
+		})
+	})
+	// the certificate of the test server is self-signed
+	server := httptest.NewTLSServer(h)
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(u.Port())
+	req := api.PollDeviceRequest{
+		Hostname: u.Hostname(),
+		Port:     &port,
+	}
+	_, err := s.restDeviceMonitor.PollDevice(context.Background(), req)
+	s.ErrorContains(err, "certificate")
+
+	req.InsecureSkipVerify = true
+	resp, err := s.restDeviceMonitor.PollDevice(context.Background(), req)
+	s.NoError(err)
+	s.Equal(repository.Router, resp.Type)
+
+	// the devices polled without the flag are still verified
+	req.InsecureSkipVerify = false
+	_, err = s.restDeviceMonitor.PollDevice(context.Background(), req)
+	s.ErrorContains(err, "certificate")
+}
+
+func (s *restDeviceMonitorTestSuite) TestIPv6Hostname() {
+	lis, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		s.T().Skipf("IPv6 loopback is not available: %v", err)
+	}
+
+	deviceID := uuid.NewString()
+	s.restDeviceMonitor = api.NewRESTDeviceMonitor()
+	h := chi.NewRouter()
+	h.Get(config.RESTApiPath(), func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(api.RestPollDeviceResponse{
+			Id:       deviceID,
+			Type:     repository.Camera,
+			Hw:       helper.RandomString(8),
+			Sw:       helper.RandomString(8),
+			Fw:       helper.RandomString(8),
+			Status:   "active",
+			Checksum: helper.RandomString(32),
+		})
+	})
+	server := httptest.NewUnstartedServer(h)
+	server.Listener = lis
+	server.Start()
+	defer server.Close()
+
+	port := lis.Addr().(*net.TCPAddr).Port
+	for _, hostname := range []string{"::1", "[::1]"} {
+		resp, err := s.restDeviceMonitor.PollDevice(context.Background(), api.PollDeviceRequest{
+			Hostname: hostname,
+			Port:     &port,
+		})
+		s.NoError(err, hostname)
+		s.NotNil(resp)
+		s.Equal(deviceID, resp.Id)
+	}
+
+	_, err = s.restDeviceMonitor.PollDevice(context.Background(), api.PollDeviceRequest{
+		Hostname: "fe80::1::zz",
+		Port:     &port,
+	})
+	s.ErrorContains(err, "invalid hostname")
+}
+
+func (s *restDeviceMonitorTestSuite) TestPathWithQuery() {
+	var requested bool
+	s.restDeviceMonitor = api.NewRESTDeviceMonitor()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = true
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(u.Port())
+	for _, path := range []string{"/data?token=x", "/data#section", "http://other.host/data"} {
+		_, err := s.restDeviceMonitor.PollDevice(context.Background(), api.PollDeviceRequest{
+			Hostname: u.Hostname(),
+			Port:     &port,
+			Path:     lo.ToPtr(path),
+		})
+		s.ErrorContains(err, "invalid path", path)
+	}
+	s.False(requested)
+
+	resp := api.DeviceHealthCheckResponse{
+		DeviceID:   uuid.NewString(),
+		DeviceType: repository.Router,
+		Capabilities: []api.PollingCapability{
+			{Protocol: repository.REST, Port: &port, Path: lo.ToPtr("/data?token=x")},
+		},
+	}
+	s.ErrorContains(resp.Validate(), "invalid path")
+
+	resp.Capabilities[0].Path = lo.ToPtr("/api/v1/data")
+	s.NoError(resp.Validate())
+}
+
+func (s *restDeviceMonitorTestSuite) TestPathNormalization() {
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.String())
+		_ = json.NewEncoder(w).Encode(api.RestPollDeviceResponse{
+			Id:       uuid.NewString(),
+			Type:     repository.Router,
+			Hw:       "hw",
+			Sw:       "sw",
+			Fw:       "fw",
+			Status:   "active",
+			Checksum: helper.RandomString(32),
+		})
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(u.Port())
+	for _, path := range []string{"data", "/data", "/data/"} {
+		_, err := api.NewRESTDeviceMonitor().PollDevice(context.Background(), api.PollDeviceRequest{
+			Hostname: u.Hostname(),
+			Port:     &port,
+			Path:     lo.ToPtr(path),
+		})
+		s.NoError(err, path)
+	}
+	s.Equal([]string{"/data", "/data", "/data"}, requested)
+}
+
+func (s *restDeviceMonitorTestSuite) TestGatewayPaths() {
+	var requested []string
+	mux := chi.NewRouter()
+	mux.Get("/devices/{device_id}/data", func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.EscapedPath())
+		util.ResponseAsJSON(w, http.StatusOK, api.RestPollDeviceResponse{
+			Id:       chi.URLParam(r, "device_id"),
+			Type:     repository.Router,
+			Hw:       "hw",
+			Sw:       "sw",
+			Fw:       "fw",
+			Status:   "active",
+			Checksum: helper.RandomString(32),
+		})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(u.Port())
+	poll := func(deviceID string) (*api.PollDeviceResponse, error) {
+		return api.NewRESTDeviceMonitor().PollDevice(context.Background(), api.PollDeviceRequest{
+			DeviceID: deviceID,
+			Hostname: u.Hostname(),
+			Port:     &port,
+			Path:     lo.ToPtr("/devices/{device_id}/data"),
+		})
+	}
+
+	// two logical devices on the same host and port
+	for _, deviceID := range []string{"device1", "device2"} {
+		resp, err := poll(deviceID)
+		s.NoError(err, deviceID)
+		s.Equal(deviceID, resp.Id)
+	}
+	s.Equal([]string{"/devices/device1/data", "/devices/device2/data"}, requested)
+
+	_, err := poll("")
+	s.ErrorContains(err, "the device id is required")
+	s.Len(requested, 2)
+}
+
+func TestExpandDevicePath(t *testing.T) {
+	for path, expected := range map[string]string{
+		"/data":                          "/data",
+		"/devices/{device_id}/data":      "/devices/a%2Fb%20c/data",
+		"/{device_id}/data/{device_id}/": "/a%2Fb%20c/data/a%2Fb%20c/",
+	} {
+		expanded, err := api.ExpandDevicePath(path, "a/b c")
+		assert.NoError(t, err, path)
+		assert.Equal(t, expected, expanded)
+	}
+
+	expanded, err := api.ExpandDevicePath("/data", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "/data", expanded)
+	_, err = api.ExpandDevicePath("/devices/{device_id}/data", "")
+	assert.Error(t, err)
+}
+
+func (s *restDeviceMonitorTestSuite) TestUserAgentHeader() {
+	var userAgent string
+	s.restDeviceMonitor = api.NewRESTDeviceMonitor()
+	h := chi.NewRouter()
+	h.Get(config.RESTApiPath(), func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.Header.Get("User-Agent")
+		_ = json.NewEncoder(w).Encode(api.RestPollDeviceResponse{
+			Id:       uuid.NewString(),
+			Type:     repository.Router,
+			Hw:       helper.RandomString(8),
+			Sw:       helper.RandomString(8),
+			Fw:       helper.RandomString(8),
+			Status:   "active",
+			Checksum: helper.RandomString(32),
+		})
+	})
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(u.Port())
+	req := api.PollDeviceRequest{
+		Hostname: u.Hostname(),
+		Port:     &port,
+	}
+
+	s.T().Setenv("HTTP_USER_AGENT", "device-monitor/1.2.3")
+	_, err := s.restDeviceMonitor.PollDevice(context.Background(), req)
+	s.NoError(err)
+	s.Equal("device-monitor/1.2.3", userAgent)
+
+	s.T().Setenv("HTTP_USER_AGENT", "")
+	_, err = s.restDeviceMonitor.PollDevice(context.Background(), req)
+	s.NoError(err)
+	s.Equal("device-monitor/"+config.Version, userAgent)
+}
+
+func (s *restDeviceMonitorTestSuite) TestChecksumPatternMismatch() {
+	rules, err := api.ParseResponseValidationConfig(`{"door_access_system": {"checksum_pattern": "^[a-zA-Z0-9]{32}$"}}`)
+	s.NoError(err)
+
+	checksum := helper.RandomString(16)
+	s.restDeviceMonitor = api.NewRESTDeviceMonitor()
+	s.restDeviceMonitor.SetResponseValidation(rules)
+	h := chi.NewRouter()
+	h.Get(config.RESTApiPath(), func(w http.ResponseWriter, r *http.Request) {
+		resp := api.RestPollDeviceResponse{
+			Id:       uuid.NewString(),
+			Type:     repository.DoorAccessSystem,
+			Hw:       "1.0",
+			Sw:       "1.0",
+			Fw:       "1.0",
+			Status:   "active",
+			Checksum: checksum,
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	})
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(u.Port())
+	req := api.PollDeviceRequest{
+		DeviceType: repository.DoorAccessSystem,
+		Hostname:   u.Hostname(),
+		Port:       &port,
+	}
+
+	_, err = s.restDeviceMonitor.PollDevice(context.Background(), req)
+	s.Error(err)
+	var hErr util.HTTPResponseError
+	s.ErrorAs(err, &hErr)
+	s.ErrorIs(hErr.Cause, api.ErrInvalidResponse)
+	s.Contains(err.Error(), "checksum does not match pattern")
+
+	checksum = helper.RandomString(32)
+	resp, err := s.restDeviceMonitor.PollDevice(context.Background(), req)
+	s.NoError(err)
+	s.Equal(checksum, resp.Checksum)
+}
+
+func (s *restDeviceMonitorTestSuite) TestInvalidValidationConfig() {
+	_, err := api.ParseResponseValidationConfig(`{"router": {"checksum_pattern": "[a-z"}}`)
+	s.Error(err)
+
+	_, err = api.ParseResponseValidationConfig(`not json`)
+	s.Error(err)
+}
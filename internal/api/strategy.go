@@ -0,0 +1,197 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"example.poc/device-monitoring-system/internal/config"
+	"github.com/samber/lo"
+)
+
+const (
+	DefaultPollingStrategyName  = "default"
+	FilePollingStrategyName     = "file"
+	AdaptivePollingStrategyName = "adaptive"
+)
+
+var (
+	pollingStrategiesMu sync.RWMutex
+	pollingStrategies   = map[string]func() (IPollingStrategy, error){
+		DefaultPollingStrategyName: func() (IPollingStrategy, error) { return &DefaultPollingStrategy{}, nil },
+		FilePollingStrategyName: func() (IPollingStrategy, error) {
+			return NewFilePollingStrategy(config.PollingStrategyFile())
+		},
+		AdaptivePollingStrategyName: func() (IPollingStrategy, error) { return &AdaptivePollingStrategy{}, nil },
+	}
+)
+
+// RegisterPollingStrategy makes a polling strategy selectable by its name through POLLING_STRATEGY
+func RegisterPollingStrategy(name string, constructor func() (IPollingStrategy, error)) {
+	pollingStrategiesMu.Lock()
+	defer pollingStrategiesMu.Unlock()
+	pollingStrategies[name] = constructor
+}
+
+// NewPollingStrategy creates the polling strategy registered by the name
+func NewPollingStrategy(name string) (IPollingStrategy, error) {
+	pollingStrategiesMu.RLock()
+	defer pollingStrategiesMu.RUnlock()
+	constructor, ok := pollingStrategies[name]
+	if !ok {
+		names := lo.Keys(pollingStrategies)
+		slices.Sort(names)
+		return nil, fmt.Errorf("unknown polling strategy '%s', expecting one of %v", name, names)
+	}
+	psy, err := constructor()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create polling strategy '%s': %w", name, err)
+	}
+	return psy, nil
+}
+
+// FilePollingStrategy reads the polling configs of the device types from a json file. The fields set for a device type
+// in the file override the ones of its default polling config, e.g.
+// {"router": {"interval": "1m", "request_timeout": "20s", "backoff": {"max_delay": "5m"}}}
+type FilePollingStrategy struct {
+	defaults DefaultPollingStrategy
+	configs  map[string]filePollingConfig
+}
+
+type filePollingConfig struct {
+	Interval  *fileDuration      `json:"interval"`
+	Timeout   *fileDuration      `json:"request_timeout"`
+	BatchSize *int               `json:"batch_size"`
+	Backoff   *fileBackoffConfig `json:"backoff"`
+	Schedule  *string            `json:"schedule"`
+	// the devices which succeeded cold_streak polls in a row are polled every cold_interval
+	ColdStreak   *int          `json:"cold_streak"`
+	ColdInterval *fileDuration `json:"cold_interval"`
+	// a connected device is flagged slow when its latest successful poll took longer than slow_threshold_ms
+	SlowThresholdMs *int `json:"slow_threshold_ms"`
+	// a device polled successfully but reporting one of the unhealthy statuses is degraded
+	UnhealthyStatuses []string `json:"unhealthy_statuses"`
+}
+
+type fileBackoffConfig struct {
+	BaseDelay *fileDuration `json:"base_delay"`
+	Factor    *float64      `json:"factor"`
+	MaxDelay  *fileDuration `json:"max_delay"`
+}
+
+// fileDuration is a duration written as a string in the polling config file, e.g. "1m30s"
+type fileDuration time.Duration
+
+func (d *fileDuration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string, e.g. \"30s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = fileDuration(v)
+	return nil
+}
+
+// NewFilePollingStrategy loads the polling configs of the file, every one of them must be valid
+func NewFilePollingStrategy(path string) (*FilePollingStrategy, error) {
+	if path == "" {
+		return nil, fmt.Errorf("POLLING_STRATEGY_FILE must be set")
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read polling config file: %w", err)
+	}
+	s := &FilePollingStrategy{}
+	if err := json.Unmarshal(b, &s.configs); err != nil {
+		return nil, fmt.Errorf("failed to json decode polling config file %s: %w", path, err)
+	}
+	for deviceType := range s.configs {
+		cfg, err := s.GetPollingConfigByDeviceType(deviceType)
+		if err != nil {
+			return nil, err
+		}
+		if err := cfg.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid polling config for device type %s in %s: %w", deviceType, path, err)
+		}
+	}
+	return s, nil
+}
+
+func (s *FilePollingStrategy) GetPollingConfigByDeviceType(deviceType string) (PollingConfig, error) {
+	fc, ok := s.configs[deviceType]
+	cfg, err := s.defaults.GetPollingConfigByDeviceType(deviceType)
+	if err != nil && !ok {
+		return PollingConfig{}, err
+	}
+	if ok {
+		fc.applyTo(&cfg)
+	}
+	return cfg, nil
+}
+
+func (fc filePollingConfig) applyTo(cfg *PollingConfig) {
+	if fc.Interval != nil {
+		cfg.Interval = time.Duration(*fc.Interval)
+	}
+	if fc.Timeout != nil {
+		cfg.Timeout = time.Duration(*fc.Timeout)
+	}
+	if fc.BatchSize != nil {
+		cfg.BatchSize = *fc.BatchSize
+	}
+	if fc.Backoff != nil {
+		backoff := lo.FromPtr(cfg.Backoff)
+		if fc.Backoff.BaseDelay != nil {
+			backoff.BaseDelay = time.Duration(*fc.Backoff.BaseDelay)
+		}
+		if fc.Backoff.Factor != nil {
+			backoff.Factor = *fc.Backoff.Factor
+		}
+		if fc.Backoff.MaxDelay != nil {
+			backoff.MaxDelay = time.Duration(*fc.Backoff.MaxDelay)
+		}
+		cfg.Backoff = &backoff
+	}
+	if fc.Schedule != nil {
+		cfg.Schedule = *fc.Schedule
+	}
+	if fc.ColdStreak != nil {
+		cfg.ColdStreak = *fc.ColdStreak
+	}
+	if fc.ColdInterval != nil {
+		cfg.ColdInterval = time.Duration(*fc.ColdInterval)
+	}
+	if fc.SlowThresholdMs != nil {
+		cfg.SlowThreshold = time.Duration(*fc.SlowThresholdMs) * time.Millisecond
+	}
+	if fc.UnhealthyStatuses != nil {
+		cfg.UnhealthyStatuses = fc.UnhealthyStatuses
+	}
+}
+
+const (
+	adaptiveColdStreak = 10
+	adaptiveColdFactor = 5
+)
+
+// AdaptivePollingStrategy polls the devices on the default polling configs, except the stable ones: a device which
+// succeeded adaptiveColdStreak polls in a row is polled adaptiveColdFactor times less often, until it fails
+type AdaptivePollingStrategy struct {
+	DefaultPollingStrategy
+}
+
+func (s *AdaptivePollingStrategy) GetPollingConfigByDeviceType(deviceType string) (PollingConfig, error) {
+	cfg, err := s.DefaultPollingStrategy.GetPollingConfigByDeviceType(deviceType)
+	if err != nil {
+		return PollingConfig{}, err
+	}
+	cfg.ColdStreak = adaptiveColdStreak
+	cfg.ColdInterval = adaptiveColdFactor * cfg.Interval
+	return cfg, nil
+}
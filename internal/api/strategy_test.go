@@ -0,0 +1,323 @@
+package api_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"example.poc/device-monitoring-system/internal/api"
+	"example.poc/device-monitoring-system/internal/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+type fixedPollingStrategy struct {
+	api.DefaultPollingStrategy
+}
+
+// writePollingConfigFile writes the polling config file read by the file polling strategy
+func writePollingConfigFile(t *testing.T, content string) {
+	path := filepath.Join(t.TempDir(), "polling.json")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	t.Setenv("POLLING_STRATEGY_FILE", path)
+}
+
+func TestNewPollingStrategy(t *testing.T) {
+	writePollingConfigFile(t, `{}`)
+	for name, expected := range map[string]api.IPollingStrategy{
+		api.DefaultPollingStrategyName:  &api.DefaultPollingStrategy{},
+		api.FilePollingStrategyName:     &api.FilePollingStrategy{},
+		api.AdaptivePollingStrategyName: &api.AdaptivePollingStrategy{},
+	} {
+		psy, err := api.NewPollingStrategy(name)
+		assert.NoError(t, err, name)
+		assert.IsType(t, expected, psy, name)
+		assert.NoError(t, api.ValidatePollingStrategy(psy, repository.KnownDeviceTypes), name)
+	}
+
+	api.RegisterPollingStrategy("fixed", func() (api.IPollingStrategy, error) { return &fixedPollingStrategy{}, nil })
+	psy, err := api.NewPollingStrategy("fixed")
+	assert.NoError(t, err)
+	assert.IsType(t, &fixedPollingStrategy{}, psy)
+
+	_, err = api.NewPollingStrategy("random")
+	assert.ErrorContains(t, err, "unknown polling strategy 'random'")
+}
+
+func TestFilePollingStrategy(t *testing.T) {
+	writePollingConfigFile(t, `{
+		"router": {"interval": "1m", "request_timeout": "20s", "batch_size": 5, "backoff": {"max_delay": "5m"}},
+		"printer": {"interval": "5m", "request_timeout": "1m", "batch_size": 10,
+			"backoff": {"base_delay": "1s", "factor": 1.5, "max_delay": "10m"}}
+	}`)
+	psy, err := api.NewPollingStrategy(api.FilePollingStrategyName)
+	assert.NoError(t, err)
+
+	// the fields set in the file override the default ones
+	defaults, err := (&api.DefaultPollingStrategy{}).GetPollingConfigByDeviceType(repository.Router)
+	assert.NoError(t, err)
+	cfg, err := psy.GetPollingConfigByDeviceType(repository.Router)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, cfg.Interval)
+	assert.Equal(t, 20*time.Second, cfg.Timeout)
+	assert.Equal(t, 5, cfg.BatchSize)
+	assert.Equal(t, api.BackoffConfig{BaseDelay: defaults.Backoff.BaseDelay, Factor: defaults.Backoff.Factor, MaxDelay: 5 * time.Minute}, *cfg.Backoff)
+
+	// the device types left out of the file keep their default config
+	cfg, err = psy.GetPollingConfigByDeviceType(repository.Camera)
+	assert.NoError(t, err)
+	defaults, err = (&api.DefaultPollingStrategy{}).GetPollingConfigByDeviceType(repository.Camera)
+	assert.NoError(t, err)
+	assert.Equal(t, defaults, cfg)
+
+	// a device type without a default config is configured by the file alone
+	cfg, err = psy.GetPollingConfigByDeviceType("printer")
+	assert.NoError(t, err)
+	assert.Equal(t, 5*time.Minute, cfg.Interval)
+	_, err = psy.GetPollingConfigByDeviceType("scanner")
+	assert.ErrorContains(t, err, "unsupported device type: scanner")
+
+	for content, expected := range map[string]string{
+		`{"router": {"schedule": "every monday"}}`: "invalid polling schedule 'every monday'",
+		`{"router": {"cold_streak": 5}}`:           "cold interval must be greater than the polling interval",
+		`{"router": {"slow_threshold_ms": -1}}`:    "slow threshold must be greater than or equal to 0",
+		`{"router": {"interval": 30}}`:             "duration must be a string",
+		`{"router": {"interval": "1s"}}`:           "invalid polling config for device type router",
+		`{"printer": {"interval": "1m"}}`:          "invalid polling config for device type printer",
+		`{"router": {"interval": "a while"}}`:      "invalid duration",
+	} {
+		writePollingConfigFile(t, content)
+		_, err = api.NewPollingStrategy(api.FilePollingStrategyName)
+		assert.ErrorContains(t, err, expected, content)
+	}
+
+	t.Setenv("POLLING_STRATEGY_FILE", "")
+	_, err = api.NewPollingStrategy(api.FilePollingStrategyName)
+	assert.ErrorContains(t, err, "POLLING_STRATEGY_FILE must be set")
+}
+
+func TestFilePollingStrategySchedule(t *testing.T) {
+	writePollingConfigFile(t, `{"camera": {"schedule": "*/30 9-17 * * 1-5"}}`)
+	psy, err := api.NewPollingStrategy(api.FilePollingStrategyName)
+	assert.NoError(t, err)
+
+	cfg, err := psy.GetPollingConfigByDeviceType(repository.Camera)
+	assert.NoError(t, err)
+	assert.Equal(t, "*/30 9-17 * * 1-5", cfg.Schedule)
+	schedule, err := cfg.ParseSchedule()
+	assert.NoError(t, err)
+	assert.NotNil(t, schedule)
+
+	cfg, err = psy.GetPollingConfigByDeviceType(repository.Router)
+	assert.NoError(t, err)
+	assert.Empty(t, cfg.Schedule)
+}
+
+func TestFilePollingStrategyColdTier(t *testing.T) {
+	writePollingConfigFile(t, `{"switch": {"cold_streak": 20, "cold_interval": "10m"}}`)
+	psy, err := api.NewPollingStrategy(api.FilePollingStrategyName)
+	assert.NoError(t, err)
+
+	cfg, err := psy.GetPollingConfigByDeviceType(repository.Switch)
+	assert.NoError(t, err)
+	assert.Equal(t, cfg.Interval, cfg.IntervalOf(19))
+	assert.Equal(t, 10*time.Minute, cfg.IntervalOf(20))
+
+	// no cold tier unless configured
+	cfg, err = psy.GetPollingConfigByDeviceType(repository.Router)
+	assert.NoError(t, err)
+	assert.Zero(t, cfg.ColdStreak)
+}
+
+func TestFilePollingStrategySlowThreshold(t *testing.T) {
+	writePollingConfigFile(t, `{"camera": {"slow_threshold_ms": 500}}`)
+	psy, err := api.NewPollingStrategy(api.FilePollingStrategyName)
+	assert.NoError(t, err)
+
+	cfg, err := psy.GetPollingConfigByDeviceType(repository.Camera)
+	assert.NoError(t, err)
+	assert.Equal(t, 500*time.Millisecond, cfg.SlowThreshold)
+
+	cfg, err = psy.GetPollingConfigByDeviceType(repository.Router)
+	assert.NoError(t, err)
+	assert.Zero(t, cfg.SlowThreshold)
+}
+
+func TestFilePollingStrategyUnhealthyStatuses(t *testing.T) {
+	writePollingConfigFile(t, `{"door_access_system": {"unhealthy_statuses": ["offline", "loading configuration"]}}`)
+	psy, err := api.NewPollingStrategy(api.FilePollingStrategyName)
+	assert.NoError(t, err)
+
+	cfg, err := psy.GetPollingConfigByDeviceType(repository.DoorAccessSystem)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"offline", "loading configuration"}, cfg.UnhealthyStatuses)
+
+	cfg, err = psy.GetPollingConfigByDeviceType(repository.Router)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"internal error", "offline"}, cfg.UnhealthyStatuses)
+}
+
+func TestAdaptivePollingStrategy(t *testing.T) {
+	psy, err := api.NewPollingStrategy(api.AdaptivePollingStrategyName)
+	assert.NoError(t, err)
+
+	// the stable devices are polled less often
+	for _, deviceType := range repository.KnownDeviceTypes {
+		cfg, err := psy.GetPollingConfigByDeviceType(deviceType)
+		assert.NoError(t, err)
+		assert.Positive(t, cfg.ColdStreak, deviceType)
+		assert.Equal(t, 5*cfg.Interval, cfg.IntervalOf(cfg.ColdStreak), deviceType)
+		assert.Equal(t, cfg.Interval, cfg.IntervalOf(cfg.ColdStreak-1), deviceType)
+	}
+}
+
+func TestDefaultUnhealthyStatuses(t *testing.T) {
+	psy, err := api.NewPollingStrategy(api.DefaultPollingStrategyName)
+	assert.NoError(t, err)
+
+	// the device statuses which are not operational by default
+	for _, deviceType := range repository.KnownDeviceTypes {
+		cfg, err := psy.GetPollingConfigByDeviceType(deviceType)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"internal error", "offline"}, cfg.UnhealthyStatuses, deviceType)
+	}
+
+	// and the ones which are not configured as operational
+	t.Setenv("OPERATIONAL_STATUSES", "operating")
+	for _, deviceType := range repository.KnownDeviceTypes {
+		cfg, err := psy.GetPollingConfigByDeviceType(deviceType)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"rebooting", "loading configuration", "internal error", "offline"}, cfg.UnhealthyStatuses, deviceType)
+	}
+}
+
+func TestPollingConfigTimeoutWithinInterval(t *testing.T) {
+	cfg := api.PollingConfig{
+		Interval:  10 * time.Second,
+		Timeout:   8 * time.Second,
+		BatchSize: 10,
+		Backoff: &api.BackoffConfig{
+			BaseDelay: time.Second,
+			Factor:    2,
+			MaxDelay:  time.Minute,
+		},
+	}
+	assert.NoError(t, cfg.Validate())
+
+	for _, timeout := range []time.Duration{9 * time.Second, 10 * time.Second, time.Minute} {
+		cfg.Timeout = timeout
+		assert.ErrorContains(t, cfg.Validate(), "polling timeout must not exceed 80% of the polling interval", timeout)
+	}
+}
+
+func TestPollingConfigSchedule(t *testing.T) {
+	cfg := api.PollingConfig{
+		Interval:  10 * time.Second,
+		Timeout:   time.Second,
+		BatchSize: 10,
+		Backoff: &api.BackoffConfig{
+			BaseDelay: time.Second,
+			Factor:    2,
+			MaxDelay:  time.Minute,
+		},
+		Schedule: "*/30 9-17 * * 1-5",
+	}
+	assert.NoError(t, cfg.Validate())
+
+	schedule, err := cfg.ParseSchedule()
+	assert.NoError(t, err)
+	// Friday at 17:30, then on Monday at 9:00 since the business hours are over
+	friday := time.Date(2025, 5, 2, 17, 15, 0, 0, time.UTC)
+	next := schedule.Next(friday)
+	assert.Equal(t, time.Date(2025, 5, 2, 17, 30, 0, 0, time.UTC), next)
+	next = schedule.Next(next)
+	assert.Equal(t, time.Date(2025, 5, 5, 9, 0, 0, 0, time.UTC), next)
+
+	cfg.Schedule = ""
+	schedule, err = cfg.ParseSchedule()
+	assert.NoError(t, err)
+	assert.Nil(t, schedule)
+
+	cfg.Schedule = "every monday"
+	assert.ErrorContains(t, cfg.Validate(), "invalid polling schedule 'every monday'")
+}
+
+func TestPollingConfigColdTier(t *testing.T) {
+	cfg := api.PollingConfig{
+		Interval:  10 * time.Second,
+		Timeout:   time.Second,
+		BatchSize: 10,
+		Backoff: &api.BackoffConfig{
+			BaseDelay: time.Second,
+			Factor:    2,
+			MaxDelay:  time.Minute,
+		},
+	}
+	assert.Equal(t, cfg.Interval, cfg.IntervalOf(1000))
+
+	cfg.ColdStreak = 5
+	cfg.ColdInterval = time.Minute
+	assert.NoError(t, cfg.Validate())
+	assert.Equal(t, cfg.Interval, cfg.IntervalOf(0))
+	assert.Equal(t, cfg.Interval, cfg.IntervalOf(4))
+	assert.Equal(t, cfg.ColdInterval, cfg.IntervalOf(5))
+
+	cfg.ColdInterval = cfg.Interval
+	assert.ErrorContains(t, cfg.Validate(), "cold interval must be greater than the polling interval")
+}
+
+func TestPollingConfigSlowThreshold(t *testing.T) {
+	cfg := api.PollingConfig{
+		Interval:  10 * time.Second,
+		Timeout:   time.Second,
+		BatchSize: 1,
+		Backoff: &api.BackoffConfig{
+			BaseDelay: time.Second,
+			Factor:    2,
+			MaxDelay:  time.Minute,
+		},
+		SlowThreshold: 500 * time.Millisecond,
+	}
+	assert.NoError(t, cfg.Validate())
+
+	cfg.SlowThreshold = -time.Millisecond
+	assert.ErrorContains(t, cfg.Validate(), "slow threshold must be greater than or equal to 0")
+}
+
+func TestPollingConfigIntervalOverride(t *testing.T) {
+	cfg := api.PollingConfig{Interval: 10 * time.Second, Timeout: 2 * time.Second}
+	for _, interval := range []time.Duration{2500 * time.Millisecond, 5 * time.Second, time.Minute} {
+		assert.NoError(t, cfg.ValidateIntervalOverride(interval), interval)
+	}
+	for _, interval := range []time.Duration{0, -time.Second} {
+		assert.ErrorContains(t, cfg.ValidateIntervalOverride(interval), "must be a positive duration", interval)
+	}
+	for _, interval := range []time.Duration{time.Second, 2 * time.Second} {
+		assert.ErrorContains(t, cfg.ValidateIntervalOverride(interval), "must not exceed 80% of the polling interval override", interval)
+	}
+}
+
+func TestPollingConfigBackoffMaxDelayCap(t *testing.T) {
+	cfg := api.PollingConfig{
+		Interval:  10 * time.Second,
+		Timeout:   time.Second,
+		BatchSize: 10,
+		Backoff: &api.BackoffConfig{
+			BaseDelay: time.Second,
+			Factor:    2,
+			MaxDelay:  time.Minute,
+		},
+	}
+	// no cap by default
+	assert.NoError(t, cfg.Validate())
+
+	t.Setenv("BACKOFF_MAX_DELAY_INTERVAL_RATIO", "2")
+	assert.ErrorContains(t, cfg.Validate(), "backoff max delay 1m0s must not exceed 2 times the polling interval 10s")
+
+	cfg.Backoff.MaxDelay = 20 * time.Second
+	assert.NoError(t, cfg.Validate())
+
+	t.Setenv("BACKOFF_MAX_DELAY_INTERVAL_RATIO", "0")
+	cfg.Backoff.MaxDelay = time.Hour
+	assert.NoError(t, cfg.Validate())
+}
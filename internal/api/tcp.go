@@ -0,0 +1,52 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"example.poc/device-monitoring-system/internal/util"
+)
+
+const (
+	defaultTCPRequestTimeout = 30 * time.Second
+
+	TCPReachable = "reachable"
+)
+
+// TCPDeviceMonitor only checks whether the device accepts TCP connections on the given port, it is meant
+// for devices without any application protocol. The response carries no versions nor checksum, hence
+// it is not subject to the response validation applied to the other monitors.
+type TCPDeviceMonitor struct {
+	dialer *net.Dialer
+}
+
+func NewTCPDeviceMonitor() *TCPDeviceMonitor {
+	return &TCPDeviceMonitor{dialer: &net.Dialer{}}
+}
+
+func (t *TCPDeviceMonitor) PollDevice(ctx context.Context, req PollDeviceRequest) (*PollDeviceResponse, error) {
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+	if req.Port == nil {
+		return nil, fmt.Errorf("port cannot be empty for tcp polling")
+	}
+
+	var cancel context.CancelFunc
+	if _, ok := ctx.Deadline(); !ok {
+		ctx, cancel = context.WithTimeout(ctx, defaultTCPRequestTimeout)
+		defer cancel()
+	}
+
+	conn, err := t.dialer.DialContext(ctx, "tcp", util.HostPort(req.Hostname, *req.Port))
+	if err != nil {
+		return nil, err
+	}
+	_ = conn.Close()
+
+	return &PollDeviceResponse{
+		Status: TCPReachable,
+	}, nil
+}
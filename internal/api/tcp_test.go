@@ -0,0 +1,72 @@
+package api_test
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"example.poc/device-monitoring-system/internal/api"
+	"github.com/stretchr/testify/suite"
+)
+
+type tcpDeviceMonitorTestSuite struct {
+	suite.Suite
+	tcpDeviceMonitor *api.TCPDeviceMonitor
+}
+
+func TestTCPDeviceMonitor(t *testing.T) {
+	suite.Run(t, new(tcpDeviceMonitorTestSuite))
+}
+
+func (s *tcpDeviceMonitorTestSuite) SetupTest() {
+	s.tcpDeviceMonitor = api.NewTCPDeviceMonitor()
+}
+
+func (s *tcpDeviceMonitorTestSuite) TestOpenPort() {
+	lis, err := net.Listen("tcp", "localhost:0")
+	s.NoError(err)
+	defer lis.Close()
+
+	port := lis.Addr().(*net.TCPAddr).Port
+	req := api.PollDeviceRequest{
+		Hostname: "localhost",
+		Port:     &port,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	resp, err := s.tcpDeviceMonitor.PollDevice(ctx, req)
+	s.NoError(err)
+	s.NotNil(resp)
+	s.Equal(api.TCPReachable, resp.Status)
+	s.Empty(resp.Hw)
+	s.Empty(resp.Sw)
+	s.Empty(resp.Fw)
+	s.Empty(resp.Checksum)
+}
+
+func (s *tcpDeviceMonitorTestSuite) TestClosedPort() {
+	lis, err := net.Listen("tcp", "localhost:0")
+	s.NoError(err)
+	_, p, _ := net.SplitHostPort(lis.Addr().String())
+	_ = lis.Close()
+
+	port, _ := strconv.Atoi(p)
+	req := api.PollDeviceRequest{
+		Hostname: "localhost",
+		Port:     &port,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = s.tcpDeviceMonitor.PollDevice(ctx, req)
+	s.Error(err)
+	s.T().Logf("expected error: %v", err)
+}
+
+func (s *tcpDeviceMonitorTestSuite) TestMissingPort() {
+	_, err := s.tcpDeviceMonitor.PollDevice(context.Background(), api.PollDeviceRequest{Hostname: "localhost"})
+	s.Error(err)
+}
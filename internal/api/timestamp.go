@@ -0,0 +1,26 @@
+package api
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// TimestampFormat is the format of all the timestamps of the API, always in UTC, it is the same as the one of the logs
+const TimestampFormat = time.RFC3339Nano
+
+// Timestamp is a point in time serialized in TimestampFormat whatever its location
+type Timestamp struct {
+	time.Time
+}
+
+// NewTimestamp returns nil for a nil time
+func NewTimestamp(t *time.Time) *Timestamp {
+	if t == nil {
+		return nil
+	}
+	return &Timestamp{Time: *t}
+}
+
+func (t Timestamp) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.UTC().Format(TimestampFormat))
+}
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimestampJSON(t *testing.T) {
+	checkedAt := time.Date(2025, 4, 27, 11, 30, 15, 123000000, time.FixedZone("CEST", 2*60*60))
+	dia := DeviceDiagnostics{
+		DeviceID:      "device1",
+		LastCheckedAt: NewTimestamp(&checkedAt),
+	}
+
+	b, err := json.Marshal(dia)
+	assert.NoError(t, err)
+	var raw map[string]any
+	assert.NoError(t, json.Unmarshal(b, &raw))
+	assert.Equal(t, "2025-04-27T09:30:15.123Z", raw["last_checked_at"])
+	assert.NotContains(t, raw, "next_poll_estimate")
+
+	var decoded DeviceDiagnostics
+	assert.NoError(t, json.Unmarshal(b, &decoded))
+	assert.True(t, checkedAt.Equal(decoded.LastCheckedAt.Time))
+	assert.Equal(t, time.UTC, decoded.LastCheckedAt.Location())
+
+	assert.Nil(t, NewTimestamp(nil))
+}
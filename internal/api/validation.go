@@ -0,0 +1,72 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+)
+
+// ResponseValidationRule describes the constraints a device data response must satisfy
+// on top of having all the required fields.
+type ResponseValidationRule struct {
+	AllowedStatuses []string `json:"allowed_statuses,omitempty"`
+	ChecksumPattern string   `json:"checksum_pattern,omitempty"`
+	checksumRegexp  *regexp.Regexp
+}
+
+// ResponseValidationConfig maps a device type to the validation rule of its responses.
+type ResponseValidationConfig map[string]ResponseValidationRule
+
+// ParseResponseValidationConfig parses validation rules from a json object keyed by device type, e.g.
+// {"router": {"allowed_statuses": ["operating"], "checksum_pattern": "^[a-zA-Z0-9]{32}$"}}
+func ParseResponseValidationConfig(raw string) (ResponseValidationConfig, error) {
+	cfg := make(ResponseValidationConfig)
+	if raw == "" {
+		return cfg, nil
+	}
+	if err := json.Unmarshal([]byte(raw), &cfg); err != nil {
+		return nil, fmt.Errorf("failed to json decode response validation config: %w", err)
+	}
+	for deviceType, rule := range cfg {
+		if rule.ChecksumPattern == "" {
+			continue
+		}
+		re, err := regexp.Compile(rule.ChecksumPattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid checksum pattern for device type %s: %w", deviceType, err)
+		}
+		rule.checksumRegexp = re
+		cfg[deviceType] = rule
+	}
+	return cfg, nil
+}
+
+// Validate checks the response against the rule of the device type the device is registered with, not the one it
+// reports, so that a device cannot evade the rule of its type. Responses of device types without a rule are always
+// valid.
+func (cfg ResponseValidationConfig) Validate(deviceType string, resp *PollDeviceResponse) error {
+	if resp == nil {
+		return fmt.Errorf("%w: device data response is nil", ErrInvalidResponse)
+	}
+	rule, ok := cfg[deviceType]
+	if !ok {
+		return nil
+	}
+	if len(rule.AllowedStatuses) > 0 && !slices.Contains(rule.AllowedStatuses, resp.Status) {
+		return fmt.Errorf("%w: status '%s' is not allowed for device type %s, allowed: %v", ErrInvalidResponse, resp.Status, deviceType, rule.AllowedStatuses)
+	}
+	if rule.ChecksumPattern != "" {
+		re := rule.checksumRegexp
+		if re == nil {
+			var err error
+			if re, err = regexp.Compile(rule.ChecksumPattern); err != nil {
+				return fmt.Errorf("invalid checksum pattern for device type %s: %w", deviceType, err)
+			}
+		}
+		if !re.MatchString(resp.Checksum) {
+			return fmt.Errorf("%w: checksum does not match pattern '%s' for device type %s", ErrInvalidResponse, rule.ChecksumPattern, deviceType)
+		}
+	}
+	return nil
+}
@@ -20,52 +20,221 @@ import (
 	"github.com/samber/lo"
 )
 
-func GetListOfDevicesDiagnostics(ctx context.Context, repo repository.IRepository, historyCheckingSize int, psy api.IPollingStrategy, page, size int, deviceType string) ([]*api.DeviceDiagnostics, int, error) {
-	if page < 0 || size <= 0 {
-		return nil, 0, fmt.Errorf("illegal argument: invalid page or size")
+// DeviceFilter narrows down the devices to list, the criteria are combined with 'and'
+type DeviceFilter struct {
+	DeviceTypes []string // matches any of the device types, all of them when empty
+	NeverPolled bool
+	Site        string
+}
+
+// condition returns the sql condition of the filter along with its named params
+func (f DeviceFilter) condition() (string, map[string]any) {
+	conds := []string{"1=1"}
+	params := make(map[string]any)
+	if len(f.DeviceTypes) > 0 {
+		conds = append(conds, "device_type in @device_types")
+		params["device_types"] = f.DeviceTypes
 	}
+	if f.NeverPolled {
+		conds = append(conds, string(repository.NeverPolledDevices))
+	}
+	if f.Site != "" {
+		conds = append(conds, "site = @site")
+		params["site"] = f.Site
+	}
+	return strings.Join(conds, " and "), params
+}
 
-	var cond string
-	if deviceType != "" {
-		cond = fmt.Sprintf("device_type = '%s'", deviceType)
-	} else {
-		cond = "1=1"
+// MinHistoryCheckingSize is the number of the latest polling histories it takes to tell that a device is disconnected,
+// the diagnostics of a device are never computed from fewer histories
+const MinHistoryCheckingSize = 10
+
+// ErrNoPollableProtocol tells that none of the capabilities of a device is of a protocol it can be polled with
+var ErrNoPollableProtocol = errors.New("no pollable protocol")
+
+// maxHealthCheckBodySize bounds the response of a health check, which lists the capabilities of a device
+const maxHealthCheckBodySize = 10 << 20
+
+// OutOfSyncIntervals is the number of polling intervals without being polled after which a device is out of sync
+const OutOfSyncIntervals = 10
+
+// AddDeviceOutcome tells what adding a device has done to the device records
+type AddDeviceOutcome string
+
+const (
+	DeviceCreated  AddDeviceOutcome = "created"
+	DeviceRestored AddDeviceOutcome = "restored"
+	DeviceExisted  AddDeviceOutcome = "already_exists"
+)
+
+// GetListOfDevicesDiagnostics returns the diagnostics of a page of devices, the cached diagnostics of a device are
+// served when there are any in the optional cache. The devices whose diagnostics fail are left out of the page and
+// reported along with the failures.
+func GetListOfDevicesDiagnostics(ctx context.Context, repo repository.IRepository, cache *DiagnosticsCache, historyCheckingSize int, psy api.IPollingStrategy, page, size int, filter DeviceFilter) ([]*api.DeviceDiagnostics, []api.DeviceDiagnosticsFailure, int, error) {
+	if page < 0 || size <= 0 {
+		return nil, nil, 0, fmt.Errorf("illegal argument: invalid page or size")
 	}
 
-	devices, total, err := repo.GetDevicesByPage(page, size, cond)
+	condition, params := filter.condition()
+	devices, total, err := repo.GetDevicesByPage(page, size, condition, params)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to get devices by page: %w", err)
+		return nil, nil, 0, fmt.Errorf("failed to get devices by page: %w", err)
 	}
 	if len(devices) == 0 {
-		return nil, 0, nil
+		return nil, nil, 0, nil
 	}
 
 	slices.SortFunc(devices, func(d1, d2 repository.Device) int {
 		return int(d1.ID - d2.ID)
 	})
 
+	deviceTypes, err := deviceTypesByName(repo)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	dias, failures := diagnoseDevices(ctx, repo, cache, historyCheckingSize, psy, deviceTypes, devices)
+	return dias, failures, total, nil
+}
+
+// StreamDevicesDiagnostics computes the diagnostics of all the devices matching the filter batch by batch, calling fn
+// with each diagnostics in the order of the devices, so that the diagnostics of the whole fleet are never held in
+// memory at once. The devices whose diagnostics fail are left out, streaming stops on the first error of fn or when the
+// context is cancelled.
+func StreamDevicesDiagnostics(ctx context.Context, repo repository.IRepository, cache *DiagnosticsCache, historyCheckingSize int, psy api.IPollingStrategy, filter DeviceFilter, fn func(*api.DeviceDiagnostics) error) error {
+	deviceTypes, err := deviceTypesByName(repo)
+	if err != nil {
+		return err
+	}
+	condition, params := filter.condition()
+	for page := 0; ; page++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		devices, _, err := repo.GetDevicesByPage(page, reconcileBatchSize, condition, params)
+		if err != nil {
+			return fmt.Errorf("failed to get devices by page: %w", err)
+		}
+		dias, _ := diagnoseDevices(ctx, repo, cache, historyCheckingSize, psy, deviceTypes, devices)
+		for _, dia := range dias {
+			if err = fn(dia); err != nil {
+				return err
+			}
+		}
+		if len(devices) < reconcileBatchSize {
+			return nil
+		}
+	}
+}
+
+// DeviceSearchCriteria combines the criteria applied by the database with the ones computed from the diagnostics
+type DeviceSearchCriteria struct {
+	repository.DeviceSearch
+	Connectivity api.Connectivity
+	Status       string // the latest polled status of the device
+}
+
+func (c DeviceSearchCriteria) computed() bool {
+	return c.Connectivity != "" || c.Status != ""
+}
+
+func (c DeviceSearchCriteria) match(dia *api.DeviceDiagnostics) bool {
+	return (c.Connectivity == "" || dia.Connectivity == c.Connectivity) && (c.Status == "" || dia.Status == c.Status)
+}
+
+// SearchDevicesDiagnostics returns the diagnostics of a page of the devices matching the criteria along with the total
+// number of the matching devices. When criteria computed from the diagnostics are set, the diagnostics of all the
+// devices matching the database criteria are computed, batch by batch, before the page is cut.
+func SearchDevicesDiagnostics(ctx context.Context, repo repository.IRepository, cache *DiagnosticsCache, historyCheckingSize int, psy api.IPollingStrategy, criteria DeviceSearchCriteria, page, size int) ([]*api.DeviceDiagnostics, []api.DeviceDiagnosticsFailure, int, error) {
+	if page < 0 || size <= 0 {
+		return nil, nil, 0, fmt.Errorf("illegal argument: invalid page or size")
+	}
+
+	deviceTypes, err := deviceTypesByName(repo)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	search := criteria.DeviceSearch
+	if !criteria.computed() {
+		search.Offset, search.Limit = page*size, size
+		devices, total, err := repo.SearchDevices(search)
+		if err != nil {
+			return nil, nil, 0, fmt.Errorf("failed to search devices: %w", err)
+		}
+		dias, failures := diagnoseDevices(ctx, repo, cache, historyCheckingSize, psy, deviceTypes, devices)
+		return dias, failures, total, nil
+	}
+
+	var matched []*api.DeviceDiagnostics
+	var failures []api.DeviceDiagnosticsFailure
+	search.Limit = reconcileBatchSize
+	for search.Offset = 0; ; search.Offset += reconcileBatchSize {
+		devices, _, err := repo.SearchDevices(search)
+		if err != nil {
+			return nil, nil, 0, fmt.Errorf("failed to search devices: %w", err)
+		}
+		dias, fs := diagnoseDevices(ctx, repo, cache, historyCheckingSize, psy, deviceTypes, devices)
+		matched = append(matched, lo.Filter(dias, func(d *api.DeviceDiagnostics, _ int) bool {
+			return criteria.match(d)
+		})...)
+		failures = append(failures, fs...)
+		if len(devices) < reconcileBatchSize {
+			break
+		}
+	}
+
+	from, to := min(page*size, len(matched)), min((page+1)*size, len(matched))
+	return matched[from:to], failures, len(matched), nil
+}
+
+// diagnoseDevices computes the diagnostics of the devices concurrently, keeping their order. The devices whose
+// diagnostics fail are left out and reported along with the failures.
+func diagnoseDevices(ctx context.Context, repo repository.IRepository, cache *DiagnosticsCache, historyCheckingSize int, psy api.IPollingStrategy, deviceTypes map[string]*repository.DeviceType, devices []repository.Device) ([]*api.DeviceDiagnostics, []api.DeviceDiagnosticsFailure) {
 	diagnostics := make([]*api.DeviceDiagnostics, len(devices))
+	errs := make([]error, len(devices))
 	wg := sync.WaitGroup{}
+	// every device not in the cache issues a history query, bound them so a large page cannot exhaust the connection pool
+	slots := make(chan struct{}, max(config.DiagnosticsConcurrency(), 1))
 	for i := range len(devices) {
+		slots <- struct{}{}
 		wg.Add(1)
 		go func(idx int) {
-			defer wg.Done()
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
 			device := devices[idx]
-			dia, err := GetDeviceDiagnostic(repo, device, historyCheckingSize, psy)
+			if dia := cache.get(device.DeviceID); dia != nil {
+				diagnostics[idx] = dia
+				return
+			}
+			dia, err := GetDeviceDiagnostic(repo, device, deviceTypes[device.DeviceType], historyCheckingSize, psy)
 			if err != nil {
 				zerolog.Ctx(ctx).Err(err).Msgf("failed to get device diagnostics for device %s", device.DeviceID)
+				errs[idx] = err
 				return
 			}
 			diagnostics[idx] = dia
 		}(i)
 	}
 	wg.Wait()
+
+	var failures []api.DeviceDiagnosticsFailure
+	for i, err := range errs {
+		if err != nil {
+			failures = append(failures, api.DeviceDiagnosticsFailure{
+				DeviceID: devices[i].DeviceID,
+				Error:    err.Error(),
+			})
+		}
+	}
 	return lo.Filter(diagnostics, func(d *api.DeviceDiagnostics, _ int) bool {
 		return d != nil
-	}), total, nil
+	}), failures
 }
 
-func GetDeviceDiagnostic(repo repository.IRepository, device repository.Device, historyCheckingSize int, psy api.IPollingStrategy) (*api.DeviceDiagnostics, error) {
+// GetDeviceDiagnostic computes the diagnostics of a device, the device type is the one of the device if known, whose
+// expected firmware version the device inherits
+func GetDeviceDiagnostic(repo repository.IRepository, device repository.Device, deviceType *repository.DeviceType, historyCheckingSize int, psy api.IPollingStrategy) (*api.DeviceDiagnostics, error) {
 	cfg, err := psy.GetPollingConfigByDeviceType(device.DeviceType)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get polling config for device of type %s: %w", device.DeviceType, err)
@@ -74,19 +243,24 @@ func GetDeviceDiagnostic(repo repository.IRepository, device repository.Device,
 		return nil, fmt.Errorf("invalid polling config for device %s: %w", device.DeviceType, err)
 	}
 
-	deviceId := device.DeviceID
-	history, err := repo.GetDevicePollingHistory(deviceId, historyCheckingSize)
+	dia := newDeviceDiagnostics(device)
+	dia.NextPollEstimate = api.NewTimestamp(NextPollEstimate(device, cfg))
+	if device.PollingStatus != nil && *device.PollingStatus == repository.PollingMisconfigured {
+		dia.Connectivity = api.Misconfigured
+		dia.LastCheckedAt = api.NewTimestamp(device.LastCheckedAt)
+		return dia, nil
+	}
+
+	history, err := repo.GetDevicePollingHistory(device.DeviceID, max(historyCheckingSize, MinHistoryCheckingSize))
 	if err != nil {
 		return nil, fmt.Errorf("failed to get device polling history: %w", err)
 	}
 	if len(history) == 0 {
-		return &api.DeviceDiagnostics{
-			Id:           device.ID,
-			DeviceID:     deviceId,
-			DeviceType:   device.DeviceType,
-			DeviceHost:   device.Hostname,
-			Connectivity: api.Unknown,
-		}, nil
+		dia.Connectivity = api.Unknown
+		if device.CreatedAt.After(time.Now().Add(-config.OnboardingWindow())) {
+			dia.Connectivity = api.Onboarding
+		}
+		return dia, nil
 	}
 
 	slices.SortFunc(history, func(h1, h2 repository.PollingHistory) int {
@@ -94,70 +268,219 @@ func GetDeviceDiagnostic(repo repository.IRepository, device repository.Device,
 	})
 
 	latest := history[0]
+	dia.LastCheckedAt = api.NewTimestamp(&latest.CreatedAt)
 	if IsDeviceOutOfSync(device, latest, cfg) { // the device has not been polled for a long time
-		return &api.DeviceDiagnostics{
-			Id:            device.ID,
-			DeviceID:      deviceId,
-			DeviceType:    device.DeviceType,
-			DeviceHost:    device.Hostname,
-			Connectivity:  api.Unknown,
-			LastCheckedAt: &latest.CreatedAt,
-		}, nil
+		dia.Connectivity = api.Unknown
+		return dia, nil
 	}
 
 	if IsDeviceAlive(device, latest, cfg) {
-		return &api.DeviceDiagnostics{
-			Id:            device.ID,
-			DeviceID:      deviceId,
-			DeviceType:    device.DeviceType,
-			DeviceHost:    device.Hostname,
-			HwVersion:     lo.FromPtr(latest.HwVersion),
-			SwVersion:     lo.FromPtr(latest.SwVersion),
-			FwVersion:     lo.FromPtr(latest.FwVersion),
-			Status:        lo.FromPtr(latest.DeviceStatus),
-			Checksum:      lo.FromPtr(latest.DeviceChecksum),
-			Connectivity:  api.Connected,
-			LastCheckedAt: &latest.CreatedAt,
-		}, nil
+		dia.HwVersion = lo.FromPtr(latest.HwVersion)
+		dia.SwVersion = lo.FromPtr(latest.SwVersion)
+		dia.FwVersion = lo.FromPtr(latest.FwVersion)
+		dia.Status = lo.FromPtr(latest.DeviceStatus)
+		dia.Checksum = lo.FromPtr(latest.DeviceChecksum)
+		dia.Connectivity = api.Connected
+		dia.Slow = IsDeviceSlow(latest, cfg)
+		dia.FwCompliant = IsFwCompliant(device, deviceType, latest)
+		if IsDeviceUnhealthy(device, latest, cfg) {
+			dia.Connectivity = api.Degraded
+		}
+		return dia, nil
 	}
 
 	if IsDeviceDisconnected(device, history, cfg) {
-		return &api.DeviceDiagnostics{
-			Id:            device.ID,
-			DeviceID:      deviceId,
-			DeviceType:    device.DeviceType,
-			DeviceHost:    device.Hostname,
-			Connectivity:  api.Disconnected,
-			LastCheckedAt: &latest.CreatedAt,
-		}, nil
-	}
-
-	return &api.DeviceDiagnostics{
-		Id:            device.ID,
-		DeviceID:      deviceId,
-		DeviceType:    device.DeviceType,
-		DeviceHost:    device.Hostname,
-		Connectivity:  api.Connecting,
-		LastCheckedAt: &latest.CreatedAt,
-	}, nil
+		dia.Connectivity = api.Disconnected
+		return dia, nil
+	}
+
+	dia.Connectivity = api.Connecting
+	return dia, nil
+}
+
+func newDeviceDiagnostics(device repository.Device) *api.DeviceDiagnostics {
+	dia := &api.DeviceDiagnostics{
+		Id:                device.ID,
+		DeviceID:          device.DeviceID,
+		DeviceType:        device.DeviceType,
+		DeviceHost:        device.Hostname,
+		Protocols:         device.Protocols,
+		RestPort:          device.RestPort,
+		RestPath:          device.RestPath,
+		GrpcPort:          device.GrpcPort,
+		TcpPort:           device.TcpPort,
+		TotalFailureCount: device.TotalFailureCount,
+	}
+	if device.PollIntervalOverride != nil {
+		dia.PollIntervalOverride = device.PollIntervalOverride.String()
+	}
+	return dia
+}
+
+// DevicePollingStats counts the polls of a device in a time window, from the hourly rollups of the old polling history
+// and from the polling history not rolled up yet
+type DevicePollingStats struct {
+	repository.PollingCounts
+	RolledUp int // the number of the polls counted from the rollups
+}
+
+// SuccessRatio is the ratio of the successful polls, 0 without any poll
+func (s DevicePollingStats) SuccessRatio() float64 {
+	total := s.Succeeded + s.Failed
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Succeeded) / float64(total)
+}
+
+// GetDevicePollingStats counts the polls of a device in the time window [from, to). The polling history rolled up is
+// no longer stored, so the rollups are counted along with it, by the hour they start in.
+func GetDevicePollingStats(repo repository.IRepository, deviceID string, from, to *time.Time) (*DevicePollingStats, error) {
+	rollups, err := repo.GetPollingHistoryRollups(deviceID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get polling history rollups: %w", err)
+	}
+	counts, err := repo.CountPollingHistory(deviceID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count polling history: %w", err)
+	}
+
+	stats := &DevicePollingStats{PollingCounts: counts}
+	for _, rollup := range rollups {
+		stats.Succeeded += rollup.SucceededCount
+		stats.Failed += rollup.FailedCount
+		stats.RolledUp += rollup.SucceededCount + rollup.FailedCount
+	}
+	return stats, nil
+}
+
+// GetDeviceChanges returns the changes of the monitored fields between consecutive successful polls of a device,
+// the first poll in the time window serves as the baseline
+func GetDeviceChanges(repo repository.IRepository, deviceID string, from, to *time.Time) ([]api.DeviceChange, error) {
+	histories, err := repo.GetSucceededPollingHistory(deviceID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device polling history: %w", err)
+	}
+
+	changes := make([]api.DeviceChange, 0)
+	for i := 1; i < len(histories); i++ {
+		if fields := diffPollingHistory(histories[i-1], histories[i]); len(fields) > 0 {
+			changes = append(changes, api.DeviceChange{
+				ChangedAt: api.Timestamp{Time: histories[i].CreatedAt},
+				Changes:   fields,
+			})
+		}
+	}
+	return changes, nil
+}
+
+// ChecksumField names the change of the device checksum, which is masked unless asked otherwise
+const ChecksumField = "checksum"
+
+func diffPollingHistory(prev, cur repository.PollingHistory) []api.FieldChange {
+	monitored := []struct {
+		field     string
+		prev, cur *string
+	}{
+		{"hw_version", prev.HwVersion, cur.HwVersion},
+		{"sw_version", prev.SwVersion, cur.SwVersion},
+		{"fw_version", prev.FwVersion, cur.FwVersion},
+		{ChecksumField, prev.DeviceChecksum, cur.DeviceChecksum},
+	}
+
+	var changes []api.FieldChange
+	for _, m := range monitored {
+		p, c := lo.FromPtr(m.prev), lo.FromPtr(m.cur)
+		if p != c {
+			changes = append(changes, api.FieldChange{
+				Field:    m.field,
+				Previous: p,
+				Current:  c,
+			})
+		}
+	}
+	return changes
 }
 
-func IsDeviceOutOfSync(_ repository.Device, latest repository.PollingHistory, cfg api.PollingConfig) bool {
+// IsDeviceSlow tells whether the successful poll took longer than the slow threshold of the polling config
+func IsDeviceSlow(latest repository.PollingHistory, cfg api.PollingConfig) bool {
+	if cfg.SlowThreshold <= 0 || latest.PollingResult != repository.PollSucceed || latest.LatencyMs == nil {
+		return false
+	}
+	return time.Duration(*latest.LatencyMs)*time.Millisecond > cfg.SlowThreshold
+}
+
+// NextPollEstimate estimates when the device is eligible for polling again, there is no estimate while it is being polled
+func NextPollEstimate(device repository.Device, cfg api.PollingConfig) *time.Time {
+	if device.PollingStatus != nil && *device.PollingStatus == repository.PollingInProgress {
+		return nil
+	}
+	if device.LastCheckedAt == nil {
+		// never polled, eligible right away
+		return lo.ToPtr(time.Now())
+	}
+	return lo.ToPtr(device.LastCheckedAt.Add(PollingIntervalOf(device, cfg)))
+}
+
+// PollingIntervalOf returns the interval a device is polled on, its own override if any, the one of its tier otherwise
+func PollingIntervalOf(device repository.Device, cfg api.PollingConfig) time.Duration {
+	if device.PollIntervalOverride != nil {
+		return *device.PollIntervalOverride
+	}
+	return cfg.IntervalOf(device.SuccessStreak)
+}
+
+func IsDeviceOutOfSync(device repository.Device, latest repository.PollingHistory, cfg api.PollingConfig) bool {
 	// simplified logic for out of sync detection
-	return latest.CreatedAt.Before(time.Now().Add(-10 * cfg.Interval))
+	return latest.CreatedAt.Before(outOfSyncCheckpoint(PollingIntervalOf(device, cfg)))
 }
 
-func IsDeviceAlive(_ repository.Device, latest repository.PollingHistory, cfg api.PollingConfig) bool {
+// outOfSyncCheckpoint is the time before which the devices polled for the last time are out of sync
+func outOfSyncCheckpoint(interval time.Duration) time.Time {
+	return time.Now().Add(-OutOfSyncIntervals * interval)
+}
+
+func IsDeviceAlive(device repository.Device, latest repository.PollingHistory, cfg api.PollingConfig) bool {
 	// simplified logic for considering device is alive
-	if latest.PollingResult == repository.PollSucceed && latest.CreatedAt.After(time.Now().Add(-2*cfg.Interval)) {
+	if latest.PollingResult == repository.PollSucceed && latest.CreatedAt.After(time.Now().Add(-2*PollingIntervalOf(device, cfg))) {
 		return true
 	}
 	return false
 }
 
+func IsDeviceUnhealthy(_ repository.Device, latest repository.PollingHistory, cfg api.PollingConfig) bool {
+	return latest.DeviceStatus != nil && slices.Contains(cfg.UnhealthyStatuses, *latest.DeviceStatus)
+}
+
+// IsFwCompliant compares the polled firmware version with the one expected for the device, which is inherited from
+// its device type, nil if unknown, unless the device overrides it. There is no verdict when no version is expected.
+func IsFwCompliant(device repository.Device, deviceType *repository.DeviceType, latest repository.PollingHistory) *bool {
+	expected := device.ExpectedFwVersion
+	if expected == nil && deviceType != nil {
+		expected = deviceType.ExpectedFwVersion
+	}
+	if expected == nil {
+		return nil
+	}
+	return lo.ToPtr(lo.FromPtr(latest.FwVersion) == *expected)
+}
+
+// deviceTypesByName resolves the device types once for the diagnostics of many devices
+func deviceTypesByName(repo repository.IRepository) (map[string]*repository.DeviceType, error) {
+	deviceTypes, err := repo.GetAllDeviceTypes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get all device types: %w", err)
+	}
+	byName := make(map[string]*repository.DeviceType, len(deviceTypes))
+	for i := range deviceTypes {
+		byName[deviceTypes[i].Name] = &deviceTypes[i]
+	}
+	return byName, nil
+}
+
 func IsDeviceDisconnected(_ repository.Device, histories []repository.PollingHistory, _ api.PollingConfig) bool {
 	// simplified logic for considering device is disconnected
-	numOfEvidences := 10
+	numOfEvidences := MinHistoryCheckingSize
 	if len(histories) < numOfEvidences {
 		// not enough history to determine
 		return false
@@ -172,100 +495,195 @@ func IsDeviceDisconnected(_ repository.Device, histories []repository.PollingHis
 	return true
 }
 
-func AddDevice(ctx context.Context, repo repository.IRepository, client *http.Client, deviceId, deviceType, hostname string, healthCheckPort int) error {
-	device, err := repo.GetDeviceByID(deviceId)
+// AddDeviceParameter describes a device to add, the optional fields are left nil or false
+type AddDeviceParameter struct {
+	DeviceID           string
+	DeviceType         string
+	Hostname           string
+	HealthCheckPort    int
+	ExpectedFwVersion  *string
+	InsecureSkipVerify bool
+	Site               *string
+	// PollIntervalOverride is the polling interval of the device overriding the one of its device type
+	PollIntervalOverride *time.Duration
+}
+
+func AddDevice(ctx context.Context, repo repository.IRepository, client *http.Client, param AddDeviceParameter) (AddDeviceOutcome, error) {
+	device, err := repo.GetDeviceByID(param.DeviceID)
 	if err != nil && !errors.Is(err, repository.ErrRecordNotFound) {
-		return fmt.Errorf("failed to check device db record by deviceId: %w", err)
+		return "", fmt.Errorf("failed to check device db record by deviceId: %w", err)
 	}
 	if device != nil {
-		if device.DeletedAt != nil {
-			if err = repo.RestoreDevice(device.ID); err != nil {
-				return fmt.Errorf("failed to restore device: %w", err)
+		if device.DeletedAt == nil {
+			return DeviceExisted, nil
+		}
+		// a device which is not polled recently is checked again, so that it is not blindly restored as healthy
+		if device.LastCheckedAt == nil || device.LastCheckedAt.Before(time.Now().Add(-config.RestoreHealthCheckMaxAge())) {
+			if _, err = CheckDeviceHealth(ctx, client, param.DeviceID, param.DeviceType, param.Hostname, param.HealthCheckPort); err != nil {
+				return "", err
 			}
 		}
-		return nil
-	}
-
-	path := config.HealthCheckPath()
-	path = strings.TrimPrefix(path, "/")
-	reqURL := fmt.Sprintf("%s://%s:%d/%s", config.RESTSchema(), hostname, healthCheckPort, path)
-	_, err = url.Parse(reqURL)
-	if err != nil {
-		return fmt.Errorf("failed to parse url %s: %w", reqURL, err)
+		if err = repo.RestoreDevice(device.ID); err != nil {
+			return "", fmt.Errorf("failed to restore device: %w", err)
+		}
+		// the settings of the request apply to the restored device like to a created one, the unset ones are kept
+		if param.InsecureSkipVerify != device.InsecureSkipVerify {
+			if err = repo.UpdateDeviceInsecureSkipVerify(param.DeviceID, param.InsecureSkipVerify); err != nil {
+				return "", err
+			}
+		}
+		if param.Site != nil {
+			if err = repo.UpdateDeviceSite(param.DeviceID, param.Site); err != nil {
+				return "", err
+			}
+		}
+		if param.ExpectedFwVersion != nil {
+			if err = repo.UpdateDeviceExpectedFwVersion(param.DeviceID, param.ExpectedFwVersion); err != nil {
+				return "", err
+			}
+		}
+		if param.PollIntervalOverride != nil {
+			if err = repo.UpdateDevicePollIntervalOverride(param.DeviceID, param.PollIntervalOverride); err != nil {
+				return "", err
+			}
+		}
+		return DeviceRestored, nil
 	}
-	header := http.Header{}
-	header.Set("Accept", "application/json")
 
-	resp, err := util.SendHttpRequest[api.DeviceHealthCheckResponse](ctx, client, util.HTTPRequestParams{
-		Method:       http.MethodGet,
-		RequestURL:   reqURL,
-		Header:       header,
-		DecodeSchema: lo.ToPtr(util.JSON),
-	})
+	healthCheckResp, err := CheckDeviceHealth(ctx, client, param.DeviceID, param.DeviceType, param.Hostname, param.HealthCheckPort)
 	if err != nil {
-		return fmt.Errorf("failed to check device health: %w", err)
+		return "", err
 	}
 
-	healthCheckResp := resp.DecodedValue
-	if err = healthCheckResp.Validate(); err != nil {
-		return util.HTTPResponseError{
-			Code:   resp.Code,
-			Header: resp.Header,
-			Body:   resp.Body,
-			Cause:  fmt.Errorf("invalid health check response: %w", err),
-		}
-	}
-	if healthCheckResp.DeviceID != deviceId {
-		return fmt.Errorf("device id mismatch: expected %s, got %s", deviceId, healthCheckResp.DeviceID)
-	}
-	if healthCheckResp.DeviceType != deviceType {
-		return fmt.Errorf("device type mismatch: expected %s, got %s", deviceType, healthCheckResp.DeviceType)
-	}
-
-	var restPort, grpcPort *int
+	var restPort, grpcPort, tcpPort *int
 	var restPath *string
 	protocols := make([]string, 0, len(healthCheckResp.Capabilities))
+	capabilities := make(repository.Capabilities, 0, len(healthCheckResp.Capabilities))
 	for _, cap := range healthCheckResp.Capabilities {
+		if cap.Path != nil && *cap.Path != "" {
+			cap.Path = lo.ToPtr(util.CanonicalPath(*cap.Path))
+		}
+		capabilities = append(capabilities, repository.Capability{
+			Protocol: cap.Protocol,
+			Port:     cap.Port,
+			Path:     cap.Path,
+			Extra:    cap.Extra,
+		})
 		switch cap.Protocol {
 		case repository.REST:
 			restPort = cap.Port
 			restPath = cap.Path
 		case repository.GRPC:
 			grpcPort = cap.Port
+		case repository.TCP:
+			tcpPort = cap.Port
 		}
 		protocols = append(protocols, cap.Protocol)
 	}
+	// a device which cannot be polled is not created, it would never be polled
+	if !slices.ContainsFunc(protocols, func(protocol string) bool {
+		return slices.Contains(repository.PollableProtocols, protocol)
+	}) {
+		return "", fmt.Errorf("%w: device %s advertises %s, expecting one of %s", ErrNoPollableProtocol, param.DeviceID,
+			strings.Join(protocols, ", "), strings.Join(repository.PollableProtocols, ", "))
+	}
 
-	dt, err := repo.GetDeviceTypeByName(deviceType)
+	dt, err := repo.GetDeviceTypeByName(param.DeviceType)
 	if err != nil {
-		return fmt.Errorf("failed to get device type by name: %w", err)
+		return "", fmt.Errorf("failed to get device type by name: %w", err)
 	}
 	if dt == nil {
 		if err = repo.CreateDeviceTypes([]*repository.DeviceType{
 			{
-				Name: deviceType,
+				Name: param.DeviceType,
 			},
 		}); err != nil {
-			return fmt.Errorf("failed to create device type: %w", err)
+			return "", fmt.Errorf("failed to create device type: %w", err)
 		}
 	} else if dt.DeletedAt != nil {
 		if err = repo.RestoreDeviceType(dt.ID); err != nil {
-			return fmt.Errorf("failed to restore device type: %w", err)
+			return "", fmt.Errorf("failed to restore device type: %w", err)
 		}
 	}
 
 	device = &repository.Device{
-		DeviceID:   deviceId,
-		DeviceType: deviceType,
-		Hostname:   hostname,
-		Protocols:  pq.StringArray(protocols),
-		RestPort:   restPort,
-		RestPath:   restPath,
-		GrpcPort:   grpcPort,
+		DeviceID:             param.DeviceID,
+		DeviceType:           param.DeviceType,
+		Hostname:             param.Hostname,
+		Protocols:            pq.StringArray(protocols),
+		RestPort:             restPort,
+		RestPath:             restPath,
+		GrpcPort:             grpcPort,
+		TcpPort:              tcpPort,
+		Capabilities:         capabilities,
+		ExpectedFwVersion:    param.ExpectedFwVersion,
+		InsecureSkipVerify:   param.InsecureSkipVerify,
+		Site:                 param.Site,
+		PollIntervalOverride: param.PollIntervalOverride,
 	}
 	if err := repo.CreateDevice(device); err != nil {
-		return fmt.Errorf("failed to create device: %w", err)
+		return "", fmt.Errorf("failed to create device: %w", err)
+	}
+
+	return DeviceCreated, nil
+}
+
+// CheckDeviceHealth asks a device for its capabilities on its health check endpoint, making sure it is the expected
+// device, nothing is persisted
+func CheckDeviceHealth(ctx context.Context, client *http.Client, deviceId, deviceType, hostname string, healthCheckPort int) (*api.DeviceHealthCheckResponse, error) {
+	path, err := api.ExpandDevicePath(util.CanonicalPath(config.HealthCheckPath()), deviceId)
+	if err != nil {
+		return nil, err
+	}
+	reqURL := fmt.Sprintf("%s://%s%s", config.RESTSchema(), util.HostPort(hostname, healthCheckPort), path)
+	_, err = url.Parse(reqURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse url %s: %w", reqURL, err)
+	}
+	header := http.Header{}
+	header.Set("Accept", "application/json")
+	streamDecode := config.HealthCheckStreamDecode()
+
+	// a device which is starting up may not answer yet, so the request is retried within the deadline of the context
+	var resp *util.HTTPResponse[api.DeviceHealthCheckResponse]
+	backoff := util.Backoff{
+		BaseDelay: config.HealthCheckRetryDelay(),
+		Factor:    2,
+		MaxDelay:  config.HealthCheckTimeout(),
+	}
+	err = util.Retry(ctx, config.HealthCheckRetries(), backoff, func(ctx context.Context) error {
+		resp, err = util.SendHttpRequest[api.DeviceHealthCheckResponse](ctx, client, util.HTTPRequestParams{
+			Method:       http.MethodGet,
+			RequestURL:   reqURL,
+			Header:       header,
+			DecodeSchema: lo.ToPtr(util.JSON),
+			MaxBodySize:  maxHealthCheckBodySize,
+			StreamDecode: streamDecode,
+		})
+		if err != nil {
+			zerolog.Ctx(ctx).Debug().Err(err).Msgf("health check of device %s failed", deviceId)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to check device health: %w", err)
+	}
+
+	healthCheckResp := resp.DecodedValue
+	if err = healthCheckResp.Validate(); err != nil {
+		return nil, util.HTTPResponseError{
+			Code:   resp.Code,
+			Header: resp.Header,
+			Body:   resp.Body,
+			Cause:  fmt.Errorf("invalid health check response: %w", err),
+		}
+	}
+	if healthCheckResp.DeviceID != deviceId {
+		return nil, fmt.Errorf("device id mismatch: expected %s, got %s", deviceId, healthCheckResp.DeviceID)
+	}
+	if healthCheckResp.DeviceType != deviceType {
+		return nil, fmt.Errorf("device type mismatch: expected %s, got %s", deviceType, healthCheckResp.DeviceType)
 	}
 
-	return nil
+	return &healthCheckResp, nil
 }
@@ -0,0 +1,495 @@
+package business
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"example.poc/device-monitoring-system/internal/api"
+	"example.poc/device-monitoring-system/internal/repository"
+	"example.poc/device-monitoring-system/internal/util"
+	"example.poc/device-monitoring-system/test/mocks"
+	"github.com/go-chi/chi/v5"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestHistoryCheckingSizeTooSmall(t *testing.T) {
+	psy, err := api.NewPollingStrategy(api.DefaultPollingStrategyName)
+	assert.NoError(t, err)
+
+	device := repository.Device{
+		ID:            1,
+		DeviceID:      "device1",
+		DeviceType:    repository.Router,
+		PollingStatus: lo.ToPtr(repository.PollingDone),
+	}
+	history := make([]repository.PollingHistory, MinHistoryCheckingSize)
+	for i := range history {
+		history[i] = repository.PollingHistory{
+			DeviceID:      device.DeviceID,
+			PollingResult: repository.PollFailed,
+			CreatedAt:     time.Now(),
+		}
+	}
+
+	repo := mocks.NewMockIRepository(t)
+	repo.EXPECT().GetDevicePollingHistory(device.DeviceID, MinHistoryCheckingSize).Return(history, nil).Once()
+
+	// a size too small to ever tell the device is disconnected is raised to the minimum
+	dia, err := GetDeviceDiagnostic(repo, device, nil, 3, psy)
+	assert.NoError(t, err)
+	assert.Equal(t, api.Disconnected, dia.Connectivity)
+}
+
+func TestListingWithFailedDiagnostics(t *testing.T) {
+	psy, err := api.NewPollingStrategy(api.DefaultPollingStrategyName)
+	assert.NoError(t, err)
+
+	devices := []repository.Device{
+		{ID: 1, DeviceID: "device1", DeviceType: repository.Router},
+		{ID: 2, DeviceID: "device2", DeviceType: "printer"},
+		{ID: 3, DeviceID: "device3", DeviceType: repository.Camera},
+	}
+	repo := mocks.NewMockIRepository(t)
+	repo.EXPECT().GetDevicesByPage(0, 10, "1=1", map[string]any{}).Return(devices, 3, nil).Once()
+	repo.EXPECT().GetAllDeviceTypes().Return(nil, nil).Once()
+	repo.EXPECT().GetDevicePollingHistory(mock.Anything, MinHistoryCheckingSize).Return(nil, nil).Twice()
+
+	dias, failures, total, err := GetListOfDevicesDiagnostics(context.TODO(), repo, nil, MinHistoryCheckingSize, psy, 0, 10, DeviceFilter{})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, total)
+	assert.Equal(t, []string{"device1", "device3"}, lo.Map(dias, func(d *api.DeviceDiagnostics, _ int) string {
+		return d.DeviceID
+	}))
+	assert.Len(t, failures, 1)
+	assert.Equal(t, "device2", failures[0].DeviceID)
+	assert.Contains(t, failures[0].Error, "printer")
+}
+
+func TestDiagnosticsConcurrency(t *testing.T) {
+	t.Setenv("DIAGNOSTICS_CONCURRENCY", "5")
+	psy, err := api.NewPollingStrategy(api.DefaultPollingStrategyName)
+	assert.NoError(t, err)
+
+	devices := make([]repository.Device, 1000)
+	for i := range devices {
+		devices[i] = repository.Device{ID: uint(i + 1), DeviceID: fmt.Sprintf("device%d", i+1), DeviceType: repository.Router}
+	}
+	var inFlight, maxInFlight atomic.Int32
+	repo := mocks.NewMockIRepository(t)
+	repo.EXPECT().GetDevicesByPage(0, len(devices), "1=1", map[string]any{}).Return(devices, len(devices), nil).Once()
+	repo.EXPECT().GetAllDeviceTypes().Return(nil, nil).Once()
+	repo.EXPECT().GetDevicePollingHistory(mock.Anything, MinHistoryCheckingSize).RunAndReturn(func(string, int) ([]repository.PollingHistory, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		return nil, nil
+	}).Times(len(devices))
+
+	dias, failures, _, err := GetListOfDevicesDiagnostics(context.TODO(), repo, nil, MinHistoryCheckingSize, psy, 0, len(devices), DeviceFilter{})
+	assert.NoError(t, err)
+	assert.Empty(t, failures)
+	assert.LessOrEqual(t, maxInFlight.Load(), int32(5))
+	assert.Equal(t, lo.Map(devices, func(d repository.Device, _ int) string {
+		return d.DeviceID
+	}), lo.Map(dias, func(d *api.DeviceDiagnostics, _ int) string {
+		return d.DeviceID
+	}))
+}
+
+func TestFwComplianceOfListedDevices(t *testing.T) {
+	psy, err := api.NewPollingStrategy(api.DefaultPollingStrategyName)
+	assert.NoError(t, err)
+
+	devices := []repository.Device{
+		{ID: 1, DeviceID: "device1", DeviceType: repository.Router, PollingStatus: lo.ToPtr(repository.PollingDone)},
+		{ID: 2, DeviceID: "device2", DeviceType: repository.Router, PollingStatus: lo.ToPtr(repository.PollingDone), ExpectedFwVersion: lo.ToPtr("fw2")},
+		{ID: 3, DeviceID: "device3", DeviceType: repository.Camera, PollingStatus: lo.ToPtr(repository.PollingDone)},
+	}
+	repo := mocks.NewMockIRepository(t)
+	repo.EXPECT().GetDevicesByPage(0, 10, "1=1", map[string]any{}).Return(devices, 3, nil).Once()
+	// the device types are resolved once for all the devices
+	repo.EXPECT().GetAllDeviceTypes().Return([]repository.DeviceType{
+		{Name: repository.Router, ExpectedFwVersion: lo.ToPtr("fw1")},
+		{Name: repository.Camera},
+	}, nil).Once()
+	repo.EXPECT().GetDevicePollingHistory(mock.Anything, MinHistoryCheckingSize).Return([]repository.PollingHistory{{
+		FwVersion:     lo.ToPtr("fw1"),
+		PollingResult: repository.PollSucceed,
+		CreatedAt:     time.Now(),
+	}}, nil).Times(3)
+
+	dias, failures, _, err := GetListOfDevicesDiagnostics(context.TODO(), repo, nil, MinHistoryCheckingSize, psy, 0, 10, DeviceFilter{})
+	assert.NoError(t, err)
+	assert.Empty(t, failures)
+	assert.Equal(t, []*bool{lo.ToPtr(true), lo.ToPtr(false), nil}, lo.Map(dias, func(d *api.DeviceDiagnostics, _ int) *bool {
+		return d.FwCompliant
+	}))
+
+	repo.EXPECT().GetDevicesByPage(0, 10, "1=1", map[string]any{}).Return(devices, 3, nil).Once()
+	repo.EXPECT().GetAllDeviceTypes().Return(nil, fmt.Errorf("db error")).Once()
+	_, _, _, err = GetListOfDevicesDiagnostics(context.TODO(), repo, nil, MinHistoryCheckingSize, psy, 0, 10, DeviceFilter{})
+	assert.Error(t, err)
+}
+
+func TestStreamDevicesDiagnostics(t *testing.T) {
+	psy, err := api.NewPollingStrategy(api.DefaultPollingStrategyName)
+	assert.NoError(t, err)
+
+	devices := []repository.Device{
+		{ID: 1, DeviceID: "device1", DeviceType: repository.Router},
+		{ID: 2, DeviceID: "device2", DeviceType: "printer"},
+		{ID: 3, DeviceID: "device3", DeviceType: repository.Camera},
+	}
+	repo := mocks.NewMockIRepository(t)
+	repo.EXPECT().GetDevicesByPage(0, reconcileBatchSize, "1=1", map[string]any{}).Return(devices, 3, nil).Once()
+	repo.EXPECT().GetAllDeviceTypes().Return(nil, nil).Twice()
+	repo.EXPECT().GetDevicePollingHistory(mock.Anything, MinHistoryCheckingSize).Return(nil, nil).Twice()
+
+	var streamed []string
+	err = StreamDevicesDiagnostics(context.TODO(), repo, nil, MinHistoryCheckingSize, psy, DeviceFilter{}, func(dia *api.DeviceDiagnostics) error {
+		streamed = append(streamed, dia.DeviceID)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"device1", "device3"}, streamed)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = StreamDevicesDiagnostics(ctx, repo, nil, MinHistoryCheckingSize, psy, DeviceFilter{}, func(*api.DeviceDiagnostics) error {
+		return nil
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestFreshness(t *testing.T) {
+	psy, err := api.NewPollingStrategy(api.DefaultPollingStrategyName)
+	assert.NoError(t, err)
+	cfg, err := psy.GetPollingConfigByDeviceType(repository.Router)
+	assert.NoError(t, err)
+
+	repo := mocks.NewMockIRepository(t)
+	repo.EXPECT().GetAllDeviceTypes().Return([]repository.DeviceType{
+		{Name: repository.Router, Enabled: true},
+		{Name: repository.Switch, Enabled: true},
+		{Name: repository.Camera},
+	}, nil).Once()
+	// the devices polled before the out of sync checkpoint of their own interval are stale
+	repo.EXPECT().GetDeviceFreshness(repository.DeviceFreshnessParameter{
+		DeviceType: repository.Router,
+		Intervals:  OutOfSyncIntervals,
+		Interval:   cfg.Interval,
+	}).Return(repository.DeviceFreshness{Total: 4, Fresh: 3}, nil).Once()
+	repo.EXPECT().GetDeviceFreshness(mock.MatchedBy(func(param repository.DeviceFreshnessParameter) bool {
+		return param.DeviceType == repository.Switch
+	})).Return(repository.DeviceFreshness{}, nil).Once()
+
+	freshness, score, err := GetFreshness(repo, psy)
+	assert.NoError(t, err)
+	assert.Equal(t, 75.0, score)
+	assert.Len(t, freshness, 2)
+	assert.Equal(t, repository.Router, freshness[0].DeviceType)
+	assert.Equal(t, 75.0, freshness[0].Score())
+	assert.Equal(t, repository.Switch, freshness[1].DeviceType)
+	assert.Equal(t, 100.0, freshness[1].Score())
+}
+
+func TestOnboardingDevice(t *testing.T) {
+	t.Setenv("ONBOARDING_WINDOW", "10m")
+	psy, err := api.NewPollingStrategy(api.DefaultPollingStrategyName)
+	assert.NoError(t, err)
+
+	repo := mocks.NewMockIRepository(t)
+	repo.EXPECT().GetDevicePollingHistory(mock.Anything, MinHistoryCheckingSize).Return(nil, nil)
+
+	device := repository.Device{
+		ID:         1,
+		DeviceID:   "device1",
+		DeviceType: repository.Router,
+		CreatedAt:  time.Now().Add(-time.Minute),
+	}
+	dia, err := GetDeviceDiagnostic(repo, device, nil, MinHistoryCheckingSize, psy)
+	assert.NoError(t, err)
+	assert.Equal(t, api.Onboarding, dia.Connectivity)
+
+	// still never polled past the onboarding window
+	device.CreatedAt = time.Now().Add(-time.Hour)
+	dia, err = GetDeviceDiagnostic(repo, device, nil, MinHistoryCheckingSize, psy)
+	assert.NoError(t, err)
+	assert.Equal(t, api.Unknown, dia.Connectivity)
+
+	t.Setenv("ONBOARDING_WINDOW", "0")
+	device.CreatedAt = time.Now()
+	dia, err = GetDeviceDiagnostic(repo, device, nil, MinHistoryCheckingSize, psy)
+	assert.NoError(t, err)
+	assert.Equal(t, api.Unknown, dia.Connectivity)
+}
+
+func TestAddDeviceWithoutPollableProtocol(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		util.ResponseAsJSON(w, http.StatusOK, api.DeviceHealthCheckResponse{
+			DeviceID:   "device1",
+			DeviceType: repository.Router,
+			Capabilities: []api.PollingCapability{
+				{Protocol: "snmp", Port: lo.ToPtr(161)},
+			},
+		})
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+	port, err := strconv.Atoi(u.Port())
+	assert.NoError(t, err)
+
+	repo := mocks.NewMockIRepository(t)
+	repo.EXPECT().GetDeviceByID("device1").Return(nil, repository.ErrRecordNotFound).Once()
+
+	// the device is not created, the mock fails on any other call
+	_, err = AddDevice(context.TODO(), repo, server.Client(), AddDeviceParameter{
+		DeviceID:        "device1",
+		DeviceType:      repository.Router,
+		Hostname:        u.Hostname(),
+		HealthCheckPort: port,
+	})
+	assert.ErrorIs(t, err, ErrNoPollableProtocol)
+	assert.ErrorContains(t, err, "snmp")
+}
+
+func TestAddDeviceRestoresWithRequestSettings(t *testing.T) {
+	device := &repository.Device{
+		ID:            1,
+		DeviceID:      "device1",
+		DeviceType:    repository.Router,
+		Site:          lo.ToPtr("old-site"),
+		LastCheckedAt: lo.ToPtr(time.Now()),
+		DeletedAt:     lo.ToPtr(time.Now()),
+	}
+	repo := mocks.NewMockIRepository(t)
+	repo.EXPECT().GetDeviceByID("device1").Return(device, nil).Once()
+	repo.EXPECT().RestoreDevice(device.ID).Return(nil).Once()
+	repo.EXPECT().UpdateDeviceInsecureSkipVerify("device1", true).Return(nil).Once()
+	repo.EXPECT().UpdateDeviceSite("device1", lo.ToPtr("new-site")).Return(nil).Once()
+
+	// a recently polled device is restored without checking its health, so no client is needed
+	param := AddDeviceParameter{
+		DeviceID:           "device1",
+		DeviceType:         repository.Router,
+		Hostname:           "some.faked.host",
+		HealthCheckPort:    8080,
+		InsecureSkipVerify: true,
+		Site:               lo.ToPtr("new-site"),
+	}
+	outcome, err := AddDevice(context.TODO(), repo, nil, param)
+	assert.NoError(t, err)
+	assert.Equal(t, DeviceRestored, outcome)
+
+	// the settings the request leaves unset are kept
+	device.InsecureSkipVerify = true
+	repo.EXPECT().GetDeviceByID("device1").Return(device, nil).Once()
+	repo.EXPECT().RestoreDevice(device.ID).Return(nil).Once()
+	param.Site = nil
+	outcome, err = AddDevice(context.TODO(), repo, nil, param)
+	assert.NoError(t, err)
+	assert.Equal(t, DeviceRestored, outcome)
+}
+
+func TestAddDeviceNormalizesRestPath(t *testing.T) {
+	for _, path := range []string{"data", "/data", "/data/"} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			util.ResponseAsJSON(w, http.StatusOK, api.DeviceHealthCheckResponse{
+				DeviceID:   "device1",
+				DeviceType: repository.Router,
+				Capabilities: []api.PollingCapability{
+					{Protocol: repository.REST, Port: lo.ToPtr(8080), Path: lo.ToPtr(path)},
+				},
+			})
+		}))
+		u, err := url.Parse(server.URL)
+		assert.NoError(t, err)
+		port, err := strconv.Atoi(u.Port())
+		assert.NoError(t, err)
+
+		repo := mocks.NewMockIRepository(t)
+		repo.EXPECT().GetDeviceByID("device1").Return(nil, repository.ErrRecordNotFound).Once()
+		repo.EXPECT().GetDeviceTypeByName(repository.Router).Return(&repository.DeviceType{Name: repository.Router}, nil).Once()
+		repo.EXPECT().CreateDevice(mock.Anything).RunAndReturn(func(device *repository.Device) error {
+			assert.Equal(t, "/data", lo.FromPtr(device.RestPath), path)
+			assert.Equal(t, "/data", lo.FromPtr(device.Capabilities[0].Path), path)
+			return nil
+		}).Once()
+
+		outcome, err := AddDevice(context.TODO(), repo, server.Client(), AddDeviceParameter{
+			DeviceID:        "device1",
+			DeviceType:      repository.Router,
+			Hostname:        u.Hostname(),
+			HealthCheckPort: port,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, DeviceCreated, outcome)
+		server.Close()
+	}
+}
+
+func TestAddDevicesBehindGateway(t *testing.T) {
+	t.Setenv("HEALTH_CHECK_PATH", "/devices/{device_id}/health")
+	mux := chi.NewRouter()
+	mux.Get("/devices/{device_id}/health", func(w http.ResponseWriter, r *http.Request) {
+		util.ResponseAsJSON(w, http.StatusOK, api.DeviceHealthCheckResponse{
+			DeviceID:   chi.URLParam(r, "device_id"),
+			DeviceType: repository.Router,
+			Capabilities: []api.PollingCapability{
+				{Protocol: repository.REST, Port: lo.ToPtr(8080), Path: lo.ToPtr("/devices/{device_id}/data")},
+			},
+		})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+	port, err := strconv.Atoi(u.Port())
+	assert.NoError(t, err)
+
+	// the devices share the host and port of the gateway, the path template is kept to be expanded on every poll
+	repo := mocks.NewMockIRepository(t)
+	for _, deviceID := range []string{"device1", "device2"} {
+		repo.EXPECT().GetDeviceByID(deviceID).Return(nil, repository.ErrRecordNotFound).Once()
+		repo.EXPECT().GetDeviceTypeByName(repository.Router).Return(&repository.DeviceType{Name: repository.Router}, nil).Once()
+		repo.EXPECT().CreateDevice(mock.Anything).RunAndReturn(func(device *repository.Device) error {
+			assert.Equal(t, deviceID, device.DeviceID)
+			assert.Equal(t, "/devices/{device_id}/data", lo.FromPtr(device.RestPath))
+			return nil
+		}).Once()
+
+		outcome, err := AddDevice(context.TODO(), repo, server.Client(), AddDeviceParameter{
+			DeviceID:        deviceID,
+			DeviceType:      repository.Router,
+			Hostname:        u.Hostname(),
+			HealthCheckPort: port,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, DeviceCreated, outcome)
+	}
+}
+
+func TestPollIntervalOverride(t *testing.T) {
+	psy, err := api.NewPollingStrategy(api.DefaultPollingStrategyName)
+	assert.NoError(t, err)
+	cfg, err := psy.GetPollingConfigByDeviceType(repository.Router)
+	assert.NoError(t, err)
+
+	lastCheckedAt := time.Now().Add(-time.Minute)
+	device := repository.Device{
+		DeviceID:      "device1",
+		DeviceType:    repository.Router,
+		PollingStatus: lo.ToPtr(repository.PollingDone),
+		LastCheckedAt: &lastCheckedAt,
+	}
+	assert.Equal(t, cfg.Interval, PollingIntervalOf(device, cfg))
+	assert.Equal(t, lastCheckedAt.Add(cfg.Interval), *NextPollEstimate(device, cfg))
+
+	device.PollIntervalOverride = lo.ToPtr(cfg.Interval / 2)
+	assert.Equal(t, cfg.Interval/2, PollingIntervalOf(device, cfg))
+	assert.Equal(t, lastCheckedAt.Add(cfg.Interval/2), *NextPollEstimate(device, cfg))
+
+	// a device polled on its own interval is not demoted to the cold tier
+	cfg.ColdStreak, cfg.ColdInterval = 3, 10*cfg.Interval
+	device.SuccessStreak = 5
+	assert.Equal(t, cfg.Interval/2, PollingIntervalOf(device, cfg))
+
+	repo := mocks.NewMockIRepository(t)
+	repo.EXPECT().GetDevicePollingHistory(device.DeviceID, MinHistoryCheckingSize).Return(nil, nil).Once()
+	dia, err := GetDeviceDiagnostic(repo, device, nil, MinHistoryCheckingSize, psy)
+	assert.NoError(t, err)
+	assert.Equal(t, (cfg.Interval / 2).String(), dia.PollIntervalOverride)
+}
+
+func TestSlowDevice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "polling.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"router": {"slow_threshold_ms": 500}}`), 0o600))
+	t.Setenv("POLLING_STRATEGY_FILE", path)
+	psy, err := api.NewPollingStrategy(api.FilePollingStrategyName)
+	assert.NoError(t, err)
+
+	device := repository.Device{
+		ID:                1,
+		DeviceID:          "device1",
+		DeviceType:        repository.Router,
+		PollingStatus:     lo.ToPtr(repository.PollingDone),
+		ExpectedFwVersion: lo.ToPtr("1.0.0"),
+	}
+	polled := func(latencyMs int) []repository.PollingHistory {
+		return []repository.PollingHistory{{
+			DeviceID:      device.DeviceID,
+			FwVersion:     lo.ToPtr("1.0.0"),
+			PollingResult: repository.PollSucceed,
+			LatencyMs:     &latencyMs,
+			CreatedAt:     time.Now(),
+		}}
+	}
+
+	repo := mocks.NewMockIRepository(t)
+	repo.EXPECT().GetDevicePollingHistory(device.DeviceID, MinHistoryCheckingSize).Return(polled(800), nil).Once()
+	dia, err := GetDeviceDiagnostic(repo, device, nil, MinHistoryCheckingSize, psy)
+	assert.NoError(t, err)
+	assert.Equal(t, api.Connected, dia.Connectivity)
+	assert.True(t, dia.Slow)
+
+	repo.EXPECT().GetDevicePollingHistory(device.DeviceID, MinHistoryCheckingSize).Return(polled(50), nil).Once()
+	dia, err = GetDeviceDiagnostic(repo, device, nil, MinHistoryCheckingSize, psy)
+	assert.NoError(t, err)
+	assert.Equal(t, api.Connected, dia.Connectivity)
+	assert.False(t, dia.Slow)
+
+	// no device is slow without a threshold
+	psy, err = api.NewPollingStrategy(api.DefaultPollingStrategyName)
+	assert.NoError(t, err)
+	repo.EXPECT().GetDevicePollingHistory(device.DeviceID, MinHistoryCheckingSize).Return(polled(800), nil).Once()
+	dia, err = GetDeviceDiagnostic(repo, device, nil, MinHistoryCheckingSize, psy)
+	assert.NoError(t, err)
+	assert.False(t, dia.Slow)
+}
+
+func TestDevicePollingStats(t *testing.T) {
+	from := time.Now().Truncate(time.Hour).Add(-48 * time.Hour)
+	to := time.Now()
+
+	repo := mocks.NewMockIRepository(t)
+	repo.EXPECT().GetPollingHistoryRollups("device1", &from, &to).Return([]repository.PollingHistoryRollup{
+		{DeviceID: "device1", BucketStart: from, SucceededCount: 10, FailedCount: 2},
+		{DeviceID: "device1", BucketStart: from.Add(time.Hour), SucceededCount: 5, FailedCount: 3},
+	}, nil).Once()
+	repo.EXPECT().CountPollingHistory("device1", &from, &to).Return(repository.PollingCounts{Succeeded: 5}, nil).Once()
+
+	stats, err := GetDevicePollingStats(repo, "device1", &from, &to)
+	assert.NoError(t, err)
+	assert.Equal(t, 20, stats.Succeeded)
+	assert.Equal(t, 5, stats.Failed)
+	assert.Equal(t, 20, stats.RolledUp)
+	assert.InDelta(t, 0.8, stats.SuccessRatio(), 1e-9)
+
+	repo.EXPECT().GetPollingHistoryRollups("device2", (*time.Time)(nil), (*time.Time)(nil)).Return(nil, nil).Once()
+	repo.EXPECT().CountPollingHistory("device2", (*time.Time)(nil), (*time.Time)(nil)).Return(repository.PollingCounts{}, nil).Once()
+	stats, err = GetDevicePollingStats(repo, "device2", nil, nil)
+	assert.NoError(t, err)
+	assert.Zero(t, stats.SuccessRatio())
+
+	repo.EXPECT().GetPollingHistoryRollups("device3", (*time.Time)(nil), (*time.Time)(nil)).Return(nil, fmt.Errorf("db error")).Once()
+	_, err = GetDevicePollingStats(repo, "device3", nil, nil)
+	assert.Error(t, err)
+}
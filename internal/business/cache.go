@@ -0,0 +1,98 @@
+package business
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"example.poc/device-monitoring-system/internal/api"
+	"example.poc/device-monitoring-system/internal/repository"
+	"github.com/rs/zerolog"
+)
+
+const reconcileBatchSize = 500
+
+// DiagnosticsCache holds the diagnostics of all devices, so that listing devices does not have to query the
+// polling history of each device on every request. The cache is refreshed as a whole by Reconcile.
+type DiagnosticsCache struct {
+	mu          sync.RWMutex
+	items       map[string]*api.DeviceDiagnostics
+	refreshedAt *time.Time
+}
+
+func NewDiagnosticsCache() *DiagnosticsCache {
+	return &DiagnosticsCache{
+		items: make(map[string]*api.DeviceDiagnostics),
+	}
+}
+
+// Reconcile recomputes the diagnostics of all devices and replaces the cached ones
+func (c *DiagnosticsCache) Reconcile(ctx context.Context, repo repository.IRepository, historyCheckingSize int, psy api.IPollingStrategy) error {
+	deviceTypes, err := deviceTypesByName(repo)
+	if err != nil {
+		return err
+	}
+	items := make(map[string]*api.DeviceDiagnostics)
+	for page := 0; ; page++ {
+		devices, _, err := repo.GetDevicesByPage(page, reconcileBatchSize, "", nil)
+		if err != nil {
+			return fmt.Errorf("failed to get devices by page: %w", err)
+		}
+		for _, device := range devices {
+			dia, err := GetDeviceDiagnostic(repo, device, deviceTypes[device.DeviceType], historyCheckingSize, psy)
+			if err != nil {
+				zerolog.Ctx(ctx).Err(err).Msgf("failed to get device diagnostics for device %s", device.DeviceID)
+				continue
+			}
+			items[device.DeviceID] = dia
+		}
+		if len(devices) < reconcileBatchSize {
+			break
+		}
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items = items
+	c.refreshedAt = &now
+	return nil
+}
+
+// Run reconciles the cache on every interval until the context is cancelled
+func (c *DiagnosticsCache) Run(ctx context.Context, interval time.Duration, repo repository.IRepository, historyCheckingSize int, psy api.IPollingStrategy) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.Reconcile(ctx, repo, historyCheckingSize, psy); err != nil {
+			zerolog.Ctx(ctx).Err(err).Msg("failed to reconcile device diagnostics")
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// RefreshedAt returns when the cache was last reconciled, nil if it never was
+func (c *DiagnosticsCache) RefreshedAt() *time.Time {
+	if c == nil {
+		return nil
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.refreshedAt
+}
+
+func (c *DiagnosticsCache) get(deviceID string) *api.DeviceDiagnostics {
+	if c == nil {
+		return nil
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.items[deviceID]
+}
@@ -0,0 +1,65 @@
+package business
+
+import (
+	"fmt"
+
+	"example.poc/device-monitoring-system/internal/api"
+	"example.poc/device-monitoring-system/internal/repository"
+)
+
+// DeviceTypeFreshness tells how many devices of a device type are polled in time, i.e. are not out of sync
+type DeviceTypeFreshness struct {
+	DeviceType string
+	repository.DeviceFreshness
+}
+
+// Score is the percentage of the devices polled in time, a device type without devices is fully fresh
+func (f DeviceTypeFreshness) Score() float64 {
+	return freshnessScore(f.Fresh, f.Total)
+}
+
+// GetFreshness computes the freshness of the devices of every enabled device type, along with the overall score of
+// all of them. The devices never polled are not fresh, and the ones of disabled device types are left out since they
+// are not polled on purpose.
+func GetFreshness(repo repository.IRepository, psy api.IPollingStrategy) ([]DeviceTypeFreshness, float64, error) {
+	dts, err := repo.GetAllDeviceTypes()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get all device types: %w", err)
+	}
+
+	var freshness []DeviceTypeFreshness
+	total, fresh := 0, 0
+	for _, dt := range dts {
+		if !dt.Enabled {
+			continue
+		}
+		cfg, err := psy.GetPollingConfigByDeviceType(dt.Name)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to get polling config for device type %s: %w", dt.Name, err)
+		}
+
+		// every device is judged by the interval it is polled on, like when it is diagnosed out of sync
+		f, err := repo.GetDeviceFreshness(repository.DeviceFreshnessParameter{
+			DeviceType:   dt.Name,
+			Intervals:    OutOfSyncIntervals,
+			Interval:     cfg.Interval,
+			ColdStreak:   cfg.ColdStreak,
+			ColdInterval: cfg.ColdInterval,
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		freshness = append(freshness, DeviceTypeFreshness{DeviceType: dt.Name, DeviceFreshness: f})
+		total += f.Total
+		fresh += f.Fresh
+	}
+
+	return freshness, freshnessScore(fresh, total), nil
+}
+
+func freshnessScore(fresh, total int) float64 {
+	if total == 0 {
+		return 100
+	}
+	return float64(fresh) * 100 / float64(total)
+}
@@ -0,0 +1,87 @@
+package business
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+
+	"example.poc/device-monitoring-system/internal/api"
+	"example.poc/device-monitoring-system/internal/repository"
+)
+
+// DeviceTypeConnectivity counts the devices of a device type by connectivity, every connectivity is counted even
+// when no device has it
+type DeviceTypeConnectivity struct {
+	DeviceType string
+	Total      int
+	Counts     map[api.Connectivity]int
+}
+
+// SummarizeConnectivity counts the devices of every device type by connectivity in a single pass over the
+// diagnostics of all the devices, sorted by device type, along with the total number of the devices counted. The
+// devices whose diagnostics fail are not counted.
+func SummarizeConnectivity(ctx context.Context, repo repository.IRepository, cache *DiagnosticsCache, historyCheckingSize int, psy api.IPollingStrategy) ([]DeviceTypeConnectivity, int, error) {
+	byType := make(map[string]*DeviceTypeConnectivity)
+	total := 0
+	err := StreamDevicesDiagnostics(ctx, repo, cache, historyCheckingSize, psy, DeviceFilter{}, func(dia *api.DeviceDiagnostics) error {
+		c, ok := byType[dia.DeviceType]
+		if !ok {
+			c = &DeviceTypeConnectivity{DeviceType: dia.DeviceType, Counts: newConnectivityCounts()}
+			byType[dia.DeviceType] = c
+		}
+		c.Counts[dia.Connectivity]++
+		c.Total++
+		total++
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	summary := make([]DeviceTypeConnectivity, 0, len(byType))
+	for _, c := range byType {
+		summary = append(summary, *c)
+	}
+	slices.SortFunc(summary, func(c1, c2 DeviceTypeConnectivity) int {
+		return cmp.Compare(c1.DeviceType, c2.DeviceType)
+	})
+	return summary, total, nil
+}
+
+// SiteDiagnostics holds the diagnostics of the devices of a site along with their counts by connectivity
+type SiteDiagnostics struct {
+	Site    string
+	Total   int
+	Counts  map[api.Connectivity]int
+	Devices []*api.DeviceDiagnostics
+}
+
+// DiagnoseSite computes the diagnostics of all the devices of a site and counts them by connectivity, the devices
+// whose diagnostics fail are not counted
+func DiagnoseSite(ctx context.Context, repo repository.IRepository, cache *DiagnosticsCache, historyCheckingSize int, psy api.IPollingStrategy, site string) (*SiteDiagnostics, error) {
+	if site == "" {
+		return nil, fmt.Errorf("illegal argument: site cannot be empty")
+	}
+
+	sd := &SiteDiagnostics{Site: site, Counts: newConnectivityCounts(), Devices: []*api.DeviceDiagnostics{}}
+	err := StreamDevicesDiagnostics(ctx, repo, cache, historyCheckingSize, psy, DeviceFilter{Site: site}, func(dia *api.DeviceDiagnostics) error {
+		sd.Counts[dia.Connectivity]++
+		sd.Total++
+		sd.Devices = append(sd.Devices, dia)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sd, nil
+}
+
+// newConnectivityCounts counts every connectivity as 0
+func newConnectivityCounts() map[api.Connectivity]int {
+	counts := make(map[api.Connectivity]int, len(api.Connectivities))
+	for _, connectivity := range api.Connectivities {
+		counts[connectivity] = 0
+	}
+	return counts
+}
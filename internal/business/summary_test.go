@@ -0,0 +1,98 @@
+package business
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"example.poc/device-monitoring-system/internal/api"
+	"example.poc/device-monitoring-system/internal/repository"
+	"example.poc/device-monitoring-system/test/mocks"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummarizeConnectivity(t *testing.T) {
+	psy, err := api.NewPollingStrategy(api.DefaultPollingStrategyName)
+	assert.NoError(t, err)
+
+	devices := []repository.Device{
+		{ID: 1, DeviceID: "router1", DeviceType: repository.Router},
+		{ID: 2, DeviceID: "router2", DeviceType: repository.Router},
+		{ID: 3, DeviceID: "router3", DeviceType: repository.Router, PollingStatus: lo.ToPtr(repository.PollingMisconfigured)},
+		{ID: 4, DeviceID: "camera1", DeviceType: repository.Camera},
+		{ID: 5, DeviceID: "camera2", DeviceType: repository.Camera},
+		{ID: 6, DeviceID: "printer1", DeviceType: "printer"},
+	}
+	failed := make([]repository.PollingHistory, MinHistoryCheckingSize)
+	for i := range failed {
+		failed[i] = repository.PollingHistory{PollingResult: repository.PollFailed, CreatedAt: time.Now()}
+	}
+
+	repo := mocks.NewMockIRepository(t)
+	repo.EXPECT().GetDevicesByPage(0, reconcileBatchSize, "1=1", map[string]any{}).Return(devices, len(devices), nil).Once()
+	repo.EXPECT().GetAllDeviceTypes().Return(nil, nil).Once()
+	repo.EXPECT().GetDevicePollingHistory("router1", MinHistoryCheckingSize).Return(nil, nil).Once()
+	repo.EXPECT().GetDevicePollingHistory("router2", MinHistoryCheckingSize).Return(failed, nil).Once()
+	repo.EXPECT().GetDevicePollingHistory("camera1", MinHistoryCheckingSize).Return(failed, nil).Once()
+	repo.EXPECT().GetDevicePollingHistory("camera2", MinHistoryCheckingSize).Return(nil, nil).Once()
+
+	summary, total, err := SummarizeConnectivity(context.TODO(), repo, nil, MinHistoryCheckingSize, psy)
+	assert.NoError(t, err)
+	// the printer is of an unknown device type, so its diagnostics fail and it is not counted
+	assert.Equal(t, 5, total)
+	assert.Equal(t, []string{repository.Camera, repository.Router}, lo.Map(summary, func(c DeviceTypeConnectivity, _ int) string {
+		return c.DeviceType
+	}))
+
+	camera, router := summary[0], summary[1]
+	assert.Equal(t, 2, camera.Total)
+	assert.Equal(t, 1, camera.Counts[api.Disconnected])
+	assert.Equal(t, 1, camera.Counts[api.Unknown])
+	assert.Equal(t, 3, router.Total)
+	assert.Equal(t, 1, router.Counts[api.Disconnected])
+	assert.Equal(t, 1, router.Counts[api.Unknown])
+	assert.Equal(t, 1, router.Counts[api.Misconfigured])
+
+	sum := 0
+	for _, c := range summary {
+		assert.Len(t, c.Counts, len(api.Connectivities))
+		assert.Equal(t, c.Total, lo.Sum(lo.Values(c.Counts)))
+		sum += c.Total
+	}
+	assert.Equal(t, total, sum)
+}
+
+func TestDiagnoseSite(t *testing.T) {
+	psy, err := api.NewPollingStrategy(api.DefaultPollingStrategyName)
+	assert.NoError(t, err)
+
+	_, err = DiagnoseSite(context.TODO(), nil, nil, MinHistoryCheckingSize, psy, "")
+	assert.Error(t, err)
+
+	devices := []repository.Device{
+		{ID: 1, DeviceID: "router1", DeviceType: repository.Router, Site: lo.ToPtr("o'hare")},
+		{ID: 2, DeviceID: "camera1", DeviceType: repository.Camera, Site: lo.ToPtr("o'hare")},
+	}
+	failed := make([]repository.PollingHistory, MinHistoryCheckingSize)
+	for i := range failed {
+		failed[i] = repository.PollingHistory{PollingResult: repository.PollFailed, CreatedAt: time.Now()}
+	}
+
+	repo := mocks.NewMockIRepository(t)
+	repo.EXPECT().GetDevicesByPage(0, reconcileBatchSize, "1=1 and site = @site", map[string]any{"site": "o'hare"}).Return(devices, len(devices), nil).Once()
+	repo.EXPECT().GetAllDeviceTypes().Return(nil, nil).Once()
+	repo.EXPECT().GetDevicePollingHistory("router1", MinHistoryCheckingSize).Return(nil, nil).Once()
+	repo.EXPECT().GetDevicePollingHistory("camera1", MinHistoryCheckingSize).Return(failed, nil).Once()
+
+	sd, err := DiagnoseSite(context.TODO(), repo, nil, MinHistoryCheckingSize, psy, "o'hare")
+	assert.NoError(t, err)
+	assert.Equal(t, "o'hare", sd.Site)
+	assert.Equal(t, 2, sd.Total)
+	assert.Len(t, sd.Counts, len(api.Connectivities))
+	assert.Equal(t, 1, sd.Counts[api.Unknown])
+	assert.Equal(t, 1, sd.Counts[api.Disconnected])
+	assert.Equal(t, []string{"router1", "camera1"}, lo.Map(sd.Devices, func(d *api.DeviceDiagnostics, _ int) string {
+		return d.DeviceID
+	}))
+}
@@ -3,6 +3,8 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -12,6 +14,10 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// Version is the version of the service, set at build time with
+// -ldflags "-X example.poc/device-monitoring-system/internal/config.Version=<version>"
+var Version = "dev"
+
 func init() {
 	switch Environment() {
 	case "staging", "sandbox", "production":
@@ -42,6 +48,21 @@ func WebServicePort() int {
 	return 8080
 }
 
+// WorkerControlPort returns the port of the control endpoints of the polling worker, 0 disables them
+func WorkerControlPort() int {
+	port := 0
+	s := os.Getenv("WORKER_CONTROL_PORT")
+	if s != "" {
+		p, err := strconv.Atoi(s)
+		if err != nil {
+			log.Fatal().Err(err).Msgf("failed to parse WORKER_CONTROL_PORT: %s", s)
+		}
+		port = p
+	}
+
+	return port
+}
+
 func GrpcPort() int {
 	port := 50051
 	s := os.Getenv("GRPC_PORT")
@@ -78,6 +99,45 @@ func RESTApiPort() int {
 	return port
 }
 
+// RESTMaxRedirects returns how many redirects at most are followed when polling a device over http
+func RESTMaxRedirects() int {
+	limit := 3
+	s := os.Getenv("REST_MAX_REDIRECTS")
+	if s != "" {
+		l, err := strconv.Atoi(s)
+		if err != nil {
+			log.Fatal().Err(err).Msgf("failed to parse REST_MAX_REDIRECTS: %s", s)
+		}
+		limit = l
+	}
+
+	return limit
+}
+
+// RESTRedirectAllowedHosts returns the hosts other than the one of the device that polling a device over http may be
+// redirected to, comma separated in the env
+func RESTRedirectAllowedHosts() []string {
+	var hosts []string
+	for _, host := range strings.Split(os.Getenv("REST_REDIRECT_ALLOWED_HOSTS"), ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// WorkerDeviceTypes returns the device types that the polling worker polls, comma separated in the env, so that the
+// device types can be partitioned among the workers. All of them are polled when it is empty.
+func WorkerDeviceTypes() []string {
+	var deviceTypes []string
+	for _, dt := range strings.Split(os.Getenv("WORKER_DEVICE_TYPES"), ",") {
+		if dt = strings.TrimSpace(dt); dt != "" {
+			deviceTypes = append(deviceTypes, dt)
+		}
+	}
+	return deviceTypes
+}
+
 func RESTSchema() string {
 	s := os.Getenv("REST_SCHEMA")
 	if s == "" {
@@ -106,6 +166,93 @@ func HealthCheckTimeout() time.Duration {
 	return t
 }
 
+// HealthCheckRetries returns how many times a failed health check of a device being added is retried, within the
+// health check timeout
+func HealthCheckRetries() int {
+	retries := 2
+	s := os.Getenv("HEALTH_CHECK_RETRIES")
+	if s != "" {
+		r, err := strconv.Atoi(s)
+		if err != nil {
+			log.Fatal().Err(err).Msgf("failed to parse HEALTH_CHECK_RETRIES: %s", s)
+		}
+		retries = r
+	}
+
+	return retries
+}
+
+// HealthCheckRetryDelay returns the base delay between the retries of a health check, it doubles on each retry
+func HealthCheckRetryDelay() time.Duration {
+	delay := os.Getenv("HEALTH_CHECK_RETRY_DELAY")
+	if delay == "" {
+		return 200 * time.Millisecond
+	}
+	d, err := time.ParseDuration(delay)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("failed to parse HEALTH_CHECK_RETRY_DELAY: %s", delay)
+	}
+	return d
+}
+
+// HealthCheckStreamDecode tells whether a health check response is decoded as it is read, instead of being read all
+// before decoding, e.g. for devices listing many capabilities
+func HealthCheckStreamDecode() bool {
+	enable := os.Getenv("HEALTH_CHECK_STREAM_DECODE")
+	if enable == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(enable)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("failed to parse HEALTH_CHECK_STREAM_DECODE: %s", enable)
+	}
+	return b
+}
+
+// DBConnectRetries returns how many times connecting to the database is retried at startup, e.g. while it is still
+// starting up along with the service, 0 fails right away
+func DBConnectRetries() int {
+	retries := 0
+	s := os.Getenv("DB_CONNECT_RETRIES")
+	if s != "" {
+		r, err := strconv.Atoi(s)
+		if err != nil {
+			log.Fatal().Err(err).Msgf("failed to parse DB_CONNECT_RETRIES: %s", s)
+		}
+		retries = r
+	}
+
+	return retries
+}
+
+// DBConnectRetryDelay returns the base delay between the retries of connecting to the database, it doubles on each
+// retry up to 30 seconds
+func DBConnectRetryDelay() time.Duration {
+	delay := os.Getenv("DB_CONNECT_RETRY_DELAY")
+	if delay == "" {
+		return time.Second
+	}
+	d, err := time.ParseDuration(delay)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("failed to parse DB_CONNECT_RETRY_DELAY: %s", delay)
+	}
+	return d
+}
+
+// VerifyChecksum tells whether the polling worker verifies the checksums reported by the devices against the ones
+// computed by the external checksum generator
+func VerifyChecksum() bool {
+	enable := os.Getenv("VERIFY_CHECKSUM")
+	if enable == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(enable)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("failed to parse VERIFY_CHECKSUM: %s", enable)
+	}
+	return b
+}
+
 func ExternalChecksumGeneratorLocation() string {
 	location := os.Getenv("EXTERNAL_CHECKSUM_GENERATOR_LOCATION")
 	if location == "" {
@@ -126,6 +273,161 @@ func EnableGormLogging() bool {
 	return b
 }
 
+// LogSuccessfulPollsAtInfo tells whether every successful poll is logged at info level, only the first one after
+// failures is by default, the routine ones are logged at debug level
+func LogSuccessfulPollsAtInfo() bool {
+	enable := os.Getenv("LOG_SUCCESSFUL_POLLS_AT_INFO")
+	if enable == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(enable)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("failed to parse LOG_SUCCESSFUL_POLLS_AT_INFO: %s", enable)
+	}
+	return b
+}
+
+// GrpcWarmupConcurrency returns how many gRPC devices at most the polling worker connects to at the same time on
+// start, ahead of their first poll, there is no warmup when 0
+func GrpcWarmupConcurrency() int {
+	s := os.Getenv("GRPC_WARMUP_CONCURRENCY")
+	if s == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		log.Fatal().Err(err).Msgf("failed to parse GRPC_WARMUP_CONCURRENCY: %s", s)
+	}
+	return n
+}
+
+// IdempotencyKeyTTL returns how long the response of adding devices with an Idempotency-Key header is replayed to the
+// requests made again with the same key, 0 means the header is ignored
+func IdempotencyKeyTTL() time.Duration {
+	ttl := os.Getenv("IDEMPOTENCY_KEY_TTL")
+	if ttl == "" {
+		return 24 * time.Hour
+	}
+	d, err := time.ParseDuration(ttl)
+	if err != nil || d < 0 {
+		log.Fatal().Err(err).Msgf("failed to parse IDEMPOTENCY_KEY_TTL: %s", ttl)
+	}
+	return d
+}
+
+// IdempotencyMaxEntries returns the maximum number of Idempotency-Key headers whose responses are remembered, the
+// oldest ones are evicted beyond it
+func IdempotencyMaxEntries() int {
+	s := os.Getenv("IDEMPOTENCY_MAX_ENTRIES")
+	if s == "" {
+		return 10000
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		log.Fatal().Err(err).Msgf("failed to parse IDEMPOTENCY_MAX_ENTRIES: %s", s)
+	}
+	return n
+}
+
+// PollOnStart tells whether the polling worker polls the devices of a device type right away, instead of waiting for
+// a full polling interval first
+func PollOnStart() bool {
+	enable := os.Getenv("POLL_ON_START")
+	if enable == "" {
+		return true
+	}
+	b, err := strconv.ParseBool(enable)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("failed to parse POLL_ON_START: %s", enable)
+	}
+	return b
+}
+
+// PreferFailingDevices tells whether the polling worker starts by polling the devices whose latest poll failed ahead of
+// the healthy ones, so that their recovery is detected faster, e.g. during an incident. It can be toggled at runtime.
+func PreferFailingDevices() bool {
+	enable := os.Getenv("PREFER_FAILING_DEVICES")
+	if enable == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(enable)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("failed to parse PREFER_FAILING_DEVICES: %s", enable)
+	}
+	return b
+}
+
+// MaxDeviceTypeFailures returns how many times in a row the polling worker may fail to get the device types before it
+// gives up, 0 means it never gives up
+func MaxDeviceTypeFailures() int {
+	limit := 0
+	s := os.Getenv("MAX_DEVICE_TYPE_FAILURES")
+	if s != "" {
+		l, err := strconv.Atoi(s)
+		if err != nil {
+			log.Fatal().Err(err).Msgf("failed to parse MAX_DEVICE_TYPE_FAILURES: %s", s)
+		}
+		limit = l
+	}
+
+	return limit
+}
+
+// IdleDeviceTypeTimeout returns how long the polling worker keeps polling a device type without devices before it stops,
+// 0 means it never stops
+func IdleDeviceTypeTimeout() time.Duration {
+	timeout := os.Getenv("IDLE_DEVICE_TYPE_TIMEOUT")
+	if timeout == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(timeout)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("failed to parse IDLE_DEVICE_TYPE_TIMEOUT: %s", timeout)
+	}
+	return d
+}
+
+// PollingHistoryRollupAfter returns the age after which the polling history is rolled up into hourly summaries and
+// removed, 0 means it is never rolled up
+func PollingHistoryRollupAfter() time.Duration {
+	after := os.Getenv("POLLING_HISTORY_ROLLUP_AFTER")
+	if after == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(after)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("failed to parse POLLING_HISTORY_ROLLUP_AFTER: %s", after)
+	}
+	return d
+}
+
+// PollingHistoryRollupRetention returns the age after which the hourly rollups of the polling history are removed,
+// 0 means they are kept forever
+func PollingHistoryRollupRetention() time.Duration {
+	retention := os.Getenv("POLLING_HISTORY_ROLLUP_RETENTION")
+	if retention == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(retention)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("failed to parse POLLING_HISTORY_ROLLUP_RETENTION: %s", retention)
+	}
+	return d
+}
+
+// PollingHistoryRollupInterval returns how often the polling worker rolls up the old polling history
+func PollingHistoryRollupInterval() time.Duration {
+	interval := os.Getenv("POLLING_HISTORY_ROLLUP_INTERVAL")
+	if interval == "" {
+		return time.Hour
+	}
+	d, err := time.ParseDuration(interval)
+	if err != nil || d <= 0 {
+		log.Fatal().Err(err).Msgf("failed to parse POLLING_HISTORY_ROLLUP_INTERVAL: %s", interval)
+	}
+	return d
+}
+
 func GetPollingBatchSize() int {
 	batchSize := 100
 	s := os.Getenv("POLLING_BATCH_SIZE")
@@ -140,6 +442,360 @@ func GetPollingBatchSize() int {
 	return batchSize
 }
 
+// PollingStrategy returns the name of the polling strategy used by the web service and the polling worker
+func PollingStrategy() string {
+	name := os.Getenv("POLLING_STRATEGY")
+	if name == "" {
+		return "default"
+	}
+	return name
+}
+
+// PollingStrategyFile returns the path of the json file of the polling configs read by the file polling strategy
+func PollingStrategyFile() string {
+	return os.Getenv("POLLING_STRATEGY_FILE")
+}
+
+// ResponseValidationRules returns the raw json of the per device type rules
+// that the polled device data is validated against
+func ResponseValidationRules() string {
+	return os.Getenv("RESPONSE_VALIDATION_RULES")
+}
+
+func WorkerID() string {
+	id := os.Getenv("WORKER_ID")
+	if id == "" {
+		h, err := os.Hostname()
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to get hostname as the worker id")
+		}
+		id = h
+	}
+	return id
+}
+
+func WorkerHeartbeatMaxAge() time.Duration {
+	maxAge := os.Getenv("WORKER_HEARTBEAT_MAX_AGE")
+	if maxAge == "" {
+		return 90 * time.Second
+	}
+	d, err := time.ParseDuration(maxAge)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("failed to parse WORKER_HEARTBEAT_MAX_AGE: %s", maxAge)
+	}
+	return d
+}
+
+// PollingHistoryBufferSize returns how many polling histories are buffered before being saved in one batch,
+// 0 disables buffering
+func PollingHistoryBufferSize() int {
+	size := 0
+	s := os.Getenv("POLLING_HISTORY_BUFFER_SIZE")
+	if s != "" {
+		b, err := strconv.Atoi(s)
+		if err != nil {
+			log.Fatal().Err(err).Msgf("failed to parse POLLING_HISTORY_BUFFER_SIZE: %s", s)
+		}
+		size = b
+	}
+
+	return size
+}
+
+func PollingHistoryFlushInterval() time.Duration {
+	interval := os.Getenv("POLLING_HISTORY_FLUSH_INTERVAL")
+	if interval == "" {
+		return 1 * time.Second
+	}
+	d, err := time.ParseDuration(interval)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("failed to parse POLLING_HISTORY_FLUSH_INTERVAL: %s", interval)
+	}
+	return d
+}
+
+// GlobalMaxConcurrentPolls returns how many devices can be polled at the same time across all device types,
+// 0 means no limit
+func GlobalMaxConcurrentPolls() int {
+	limit := 0
+	s := os.Getenv("GLOBAL_MAX_CONCURRENT_POLLS")
+	if s != "" {
+		l, err := strconv.Atoi(s)
+		if err != nil {
+			log.Fatal().Err(err).Msgf("failed to parse GLOBAL_MAX_CONCURRENT_POLLS: %s", s)
+		}
+		limit = l
+	}
+
+	return limit
+}
+
+// RetryBudgetPerDeviceType returns how many devices of a device type can wait to retry polling at the same time,
+// 0 means no limit
+func RetryBudgetPerDeviceType() int {
+	budget := 0
+	s := os.Getenv("RETRY_BUDGET_PER_DEVICE_TYPE")
+	if s != "" {
+		b, err := strconv.Atoi(s)
+		if err != nil {
+			log.Fatal().Err(err).Msgf("failed to parse RETRY_BUDGET_PER_DEVICE_TYPE: %s", s)
+		}
+		budget = b
+	}
+
+	return budget
+}
+
+// FailureLogBurst returns how many repetitive polling failure logs are let through per FailureLogPeriod
+func FailureLogBurst() int {
+	burst := 10
+	s := os.Getenv("FAILURE_LOG_BURST")
+	if s != "" {
+		b, err := strconv.Atoi(s)
+		if err != nil {
+			log.Fatal().Err(err).Msgf("failed to parse FAILURE_LOG_BURST: %s", s)
+		}
+		burst = b
+	}
+
+	return burst
+}
+
+func FailureLogPeriod() time.Duration {
+	period := os.Getenv("FAILURE_LOG_PERIOD")
+	if period == "" {
+		return 1 * time.Minute
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("failed to parse FAILURE_LOG_PERIOD: %s", period)
+	}
+	return d
+}
+
+// FailureLogSampleRate returns N, so that every Nth repetitive polling failure log exceeding the burst is let through,
+// 0 drops them all
+func FailureLogSampleRate() int {
+	rate := 100
+	s := os.Getenv("FAILURE_LOG_SAMPLE_RATE")
+	if s != "" {
+		r, err := strconv.Atoi(s)
+		if err != nil {
+			log.Fatal().Err(err).Msgf("failed to parse FAILURE_LOG_SAMPLE_RATE: %s", s)
+		}
+		rate = r
+	}
+
+	return rate
+}
+
+// RestoreHealthCheckMaxAge returns how long after its last poll a soft-deleted device can be restored without
+// checking its health again
+func RestoreHealthCheckMaxAge() time.Duration {
+	maxAge := os.Getenv("RESTORE_HEALTH_CHECK_MAX_AGE")
+	if maxAge == "" {
+		return 24 * time.Hour
+	}
+	d, err := time.ParseDuration(maxAge)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("failed to parse RESTORE_HEALTH_CHECK_MAX_AGE: %s", maxAge)
+	}
+	return d
+}
+
+// DiagnosticsCacheInterval returns how often the web service recomputes the cached device diagnostics, 0 disables the cache
+func DiagnosticsCacheInterval() time.Duration {
+	interval := os.Getenv("DIAGNOSTICS_CACHE_INTERVAL")
+	if interval == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(interval)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("failed to parse DIAGNOSTICS_CACHE_INTERVAL: %s", interval)
+	}
+	return d
+}
+
+// OnboardingWindow returns how long the devices just added and not polled yet are reported as onboarding rather than
+// unknown, 0 disables it
+func OnboardingWindow() time.Duration {
+	window := os.Getenv("ONBOARDING_WINDOW")
+	if window == "" {
+		return 10 * time.Minute
+	}
+	d, err := time.ParseDuration(window)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("failed to parse ONBOARDING_WINDOW: %s", window)
+	}
+	return d
+}
+
+// DeviceIDPattern returns the regular expression the whole id of an added device must match, nil when any id is accepted
+func DeviceIDPattern() *regexp.Regexp {
+	pattern := os.Getenv("DEVICE_ID_PATTERN")
+	if pattern == "" {
+		return nil
+	}
+	re, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		log.Fatal().Err(err).Msgf("failed to parse DEVICE_ID_PATTERN: %s", pattern)
+	}
+	return re
+}
+
+// AddDevicesConcurrency returns how many devices of a request at most are health checked at the same time
+func AddDevicesConcurrency() int {
+	limit := 50
+	s := os.Getenv("ADD_DEVICES_CONCURRENCY")
+	if s != "" {
+		l, err := strconv.Atoi(s)
+		if err != nil {
+			log.Fatal().Err(err).Msgf("failed to parse ADD_DEVICES_CONCURRENCY: %s", s)
+		}
+		limit = l
+	}
+
+	return limit
+}
+
+// DiagnosticsConcurrency returns how many devices of a page at most are diagnosed at the same time, keep it below
+// the max open connections of the database
+func DiagnosticsConcurrency() int {
+	limit := 20
+	s := os.Getenv("DIAGNOSTICS_CONCURRENCY")
+	if s != "" {
+		l, err := strconv.Atoi(s)
+		if err != nil {
+			log.Fatal().Err(err).Msgf("failed to parse DIAGNOSTICS_CONCURRENCY: %s", s)
+		}
+		limit = l
+	}
+
+	return limit
+}
+
+// FailureCountResetStreak returns the number of successful polls in a row after which the total failure count of a
+// device is reset, it is never reset when 0
+func FailureCountResetStreak() int {
+	streak := 10
+	s := os.Getenv("FAILURE_COUNT_RESET_STREAK")
+	if s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			log.Fatal().Err(err).Msgf("failed to parse FAILURE_COUNT_RESET_STREAK: %s", s)
+		}
+		streak = n
+	}
+
+	return streak
+}
+
+// RejectPortConflicts tells whether a request adding several devices on the same hostname and health check port is
+// rejected, instead of adding them with a warning
+func RejectPortConflicts() bool {
+	enable := os.Getenv("REJECT_PORT_CONFLICTS")
+	if enable == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(enable)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("failed to parse REJECT_PORT_CONFLICTS: %s", enable)
+	}
+	return b
+}
+
+// PollNowMaxDevices returns how many devices of a device type at most are scheduled for polling by one poll-now request
+func PollNowMaxDevices() int {
+	limit := 1000
+	s := os.Getenv("POLL_NOW_MAX_DEVICES")
+	if s != "" {
+		l, err := strconv.Atoi(s)
+		if err != nil {
+			log.Fatal().Err(err).Msgf("failed to parse POLL_NOW_MAX_DEVICES: %s", s)
+		}
+		limit = l
+	}
+
+	return limit
+}
+
+// AdminAPIToken returns the bearer token guarding the destructive endpoints of the web service, they are disabled
+// when it is empty
+func AdminAPIToken() string {
+	return os.Getenv("ADMIN_API_TOKEN")
+}
+
+// SimTypeWeights returns the weights of the device types randomly selected by the device simulator,
+// e.g. 'router:5,camera:50,switch:10,door_access_system:1'
+func SimTypeWeights() string {
+	return os.Getenv("SIM_TYPE_WEIGHTS")
+}
+
+// SimProtocols returns the comma separated protocols the device simulator can be polled with, e.g. 'rest,grpc'
+func SimProtocols() string {
+	return os.Getenv("PROTOCOLS")
+}
+
+// DeviceStatuses are all the statuses a device reports
+var DeviceStatuses = []string{"operating", "rebooting", "loading configuration", "internal error", "offline"}
+
+// DefaultOperationalStatuses are the device statuses in which a device serves its data unless configured otherwise,
+// the other device statuses are unhealthy
+var DefaultOperationalStatuses = []string{"operating", "rebooting", "loading configuration"}
+
+// OperationalStatuses returns the device statuses in which a device serves its data, comma separated in the env
+func OperationalStatuses() []string {
+	s := os.Getenv("OPERATIONAL_STATUSES")
+	if s == "" {
+		return slices.Clone(DefaultOperationalStatuses)
+	}
+
+	var statuses []string
+	for _, status := range strings.Split(s, ",") {
+		if status = strings.TrimSpace(status); status != "" {
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses
+}
+
+// HTTPUserAgent returns the User-Agent header of outbound http requests
+func HTTPUserAgent() string {
+	ua := os.Getenv("HTTP_USER_AGENT")
+	if ua == "" {
+		return "device-monitor/" + Version
+	}
+	return ua
+}
+
+// SLOFreshnessThreshold returns the percentage of devices polled in time under which the freshness SLO is breached,
+// 0 means it is never breached
+func SLOFreshnessThreshold() float64 {
+	s := os.Getenv("SLO_FRESHNESS_THRESHOLD")
+	if s == "" {
+		return 0
+	}
+	threshold, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("failed to parse SLO_FRESHNESS_THRESHOLD: %s", s)
+	}
+	return threshold
+}
+
+// BackoffMaxDelayIntervalRatio returns the multiple of the polling interval the backoff max delay of a device type must
+// not exceed, so that the retries of a failing device do not drift far past its schedule, there is no cap when 0
+func BackoffMaxDelayIntervalRatio() float64 {
+	s := os.Getenv("BACKOFF_MAX_DELAY_INTERVAL_RATIO")
+	if s == "" {
+		return 0
+	}
+	ratio, err := strconv.ParseFloat(s, 64)
+	if err != nil || ratio < 0 {
+		log.Fatal().Err(err).Msgf("failed to parse BACKOFF_MAX_DELAY_INTERVAL_RATIO: %s", s)
+	}
+	return ratio
+}
+
 func maybeLoadDotEnv() error {
 	dir, err := os.Getwd()
 	if err != nil {
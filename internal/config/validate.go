@@ -0,0 +1,181 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type settingKind int
+
+const (
+	stringSetting settingKind = iota
+	dsnSetting
+	portSetting
+	intSetting
+	durationSetting
+	boolSetting
+	floatSetting
+	regexpSetting
+)
+
+type setting struct {
+	name   string
+	kind   settingKind
+	secret bool
+}
+
+// settings are all the config values read from the env
+var settings = []setting{
+	{name: "ENVIRONMENT"},
+	{name: "LOG_LEVEL"},
+	{name: "DATABASE_URL", kind: dsnSetting, secret: true},
+	{name: "DB_CONNECT_RETRIES", kind: intSetting},
+	{name: "DB_CONNECT_RETRY_DELAY", kind: durationSetting},
+	{name: "ENABLE_GORM_LOGGING", kind: boolSetting},
+	{name: "ADMIN_API_TOKEN", secret: true},
+	{name: "HTTP_USER_AGENT"},
+	{name: "WORKER_ID"},
+	{name: "WORKER_CONTROL_PORT", kind: portSetting},
+	{name: "WORKER_HEARTBEAT_MAX_AGE", kind: durationSetting},
+	{name: "GRPC_PORT", kind: portSetting},
+	{name: "REST_PORT", kind: portSetting},
+	{name: "REST_SCHEMA"},
+	{name: "REST_MAX_REDIRECTS", kind: intSetting},
+	{name: "REST_REDIRECT_ALLOWED_HOSTS"},
+	{name: "REST_DEVICE_DATA_PATH"},
+	{name: "HEALTH_CHECK_PATH"},
+	{name: "HEALTH_CHECK_TIMEOUT", kind: durationSetting},
+	{name: "HEALTH_CHECK_RETRIES", kind: intSetting},
+	{name: "HEALTH_CHECK_RETRY_DELAY", kind: durationSetting},
+	{name: "HEALTH_CHECK_STREAM_DECODE", kind: boolSetting},
+	{name: "RESTORE_HEALTH_CHECK_MAX_AGE", kind: durationSetting},
+	{name: "ADD_DEVICES_CONCURRENCY", kind: intSetting},
+	{name: "DIAGNOSTICS_CONCURRENCY", kind: intSetting},
+	{name: "FAILURE_COUNT_RESET_STREAK", kind: intSetting},
+	{name: "REJECT_PORT_CONFLICTS", kind: boolSetting},
+	{name: "DEVICE_ID_PATTERN", kind: regexpSetting},
+	{name: "ONBOARDING_WINDOW", kind: durationSetting},
+	{name: "DIAGNOSTICS_CACHE_INTERVAL", kind: durationSetting},
+	{name: "SLO_FRESHNESS_THRESHOLD", kind: floatSetting},
+	{name: "POLLING_STRATEGY"},
+	{name: "POLLING_STRATEGY_FILE"},
+	{name: "POLLING_BATCH_SIZE", kind: intSetting},
+	{name: "POLL_ON_START", kind: boolSetting},
+	{name: "GRPC_WARMUP_CONCURRENCY", kind: intSetting},
+	{name: "BACKOFF_MAX_DELAY_INTERVAL_RATIO", kind: floatSetting},
+	{name: "IDEMPOTENCY_KEY_TTL", kind: durationSetting},
+	{name: "IDEMPOTENCY_MAX_ENTRIES", kind: intSetting},
+	{name: "PREFER_FAILING_DEVICES", kind: boolSetting},
+	{name: "POLL_NOW_MAX_DEVICES", kind: intSetting},
+	{name: "MAX_DEVICE_TYPE_FAILURES", kind: intSetting},
+	{name: "IDLE_DEVICE_TYPE_TIMEOUT", kind: durationSetting},
+	{name: "POLLING_HISTORY_ROLLUP_AFTER", kind: durationSetting},
+	{name: "POLLING_HISTORY_ROLLUP_INTERVAL", kind: durationSetting},
+	{name: "POLLING_HISTORY_ROLLUP_RETENTION", kind: durationSetting},
+	{name: "GLOBAL_MAX_CONCURRENT_POLLS", kind: intSetting},
+	{name: "RETRY_BUDGET_PER_DEVICE_TYPE", kind: intSetting},
+	{name: "POLLING_HISTORY_BUFFER_SIZE", kind: intSetting},
+	{name: "POLLING_HISTORY_FLUSH_INTERVAL", kind: durationSetting},
+	{name: "LOG_SUCCESSFUL_POLLS_AT_INFO", kind: boolSetting},
+	{name: "FAILURE_LOG_BURST", kind: intSetting},
+	{name: "FAILURE_LOG_PERIOD", kind: durationSetting},
+	{name: "FAILURE_LOG_SAMPLE_RATE", kind: intSetting},
+	{name: "RESPONSE_VALIDATION_RULES"},
+	{name: "VERIFY_CHECKSUM", kind: boolSetting},
+	{name: "EXTERNAL_CHECKSUM_GENERATOR_LOCATION"},
+	{name: "SIM_TYPE_WEIGHTS"},
+	{name: "PROTOCOLS"},
+	{name: "OPERATIONAL_STATUSES"},
+	{name: "WORKER_DEVICE_TYPES"},
+}
+
+// Setting is a config value as it is set in the env, Value is redacted for the secrets
+type Setting struct {
+	Name  string
+	Value string
+	Set   bool
+}
+
+// Validate checks the config values set in the env, unlike the getters it reports all the invalid ones instead of
+// exiting on the first one
+func Validate() []error {
+	var errs []error
+	for _, s := range settings {
+		if err := s.validate(os.Getenv(s.name)); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.name, err))
+		}
+	}
+	return errs
+}
+
+// Summary lists all the config values, with the secrets redacted
+func Summary() []Setting {
+	summary := make([]Setting, 0, len(settings))
+	for _, s := range settings {
+		v, ok := os.LookupEnv(s.name)
+		if ok && s.secret {
+			v = redact(s.kind, v)
+		}
+		summary = append(summary, Setting{Name: s.name, Value: v, Set: ok})
+	}
+	return summary
+}
+
+func (s setting) validate(v string) error {
+	if v == "" {
+		if s.kind == dsnSetting {
+			return fmt.Errorf("must be set")
+		}
+		return nil
+	}
+
+	switch s.kind {
+	case dsnSetting:
+		if strings.Contains(v, "://") {
+			if _, err := url.Parse(v); err != nil {
+				return fmt.Errorf("invalid url")
+			}
+		}
+	case portSetting:
+		p, err := strconv.Atoi(v)
+		if err != nil || p < 0 || p > 65535 {
+			return fmt.Errorf("invalid port %s, must be between 0 and 65535", v)
+		}
+	case intSetting:
+		if _, err := strconv.Atoi(v); err != nil {
+			return fmt.Errorf("invalid integer %s", v)
+		}
+	case durationSetting:
+		if _, err := time.ParseDuration(v); err != nil {
+			return fmt.Errorf("invalid duration %s", v)
+		}
+	case boolSetting:
+		if _, err := strconv.ParseBool(v); err != nil {
+			return fmt.Errorf("invalid boolean %s", v)
+		}
+	case floatSetting:
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			return fmt.Errorf("invalid number %s", v)
+		}
+	case regexpSetting:
+		if _, err := regexp.Compile(v); err != nil {
+			return fmt.Errorf("invalid regular expression: %w", err)
+		}
+	}
+	return nil
+}
+
+// redact keeps what tells where a dsn points to, only its password is masked
+func redact(kind settingKind, v string) string {
+	if kind == dsnSetting && strings.Contains(v, "://") {
+		if u, err := url.Parse(v); err == nil {
+			return u.Redacted()
+		}
+	}
+	return "******"
+}
@@ -0,0 +1,37 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	t.Setenv("DATABASE_URL", "postgres://monitor:s3cret@db:5432/devices?sslmode=disable")
+	t.Setenv("ADMIN_API_TOKEN", "t0ken")
+	t.Setenv("GRPC_PORT", "50051")
+	t.Setenv("HEALTH_CHECK_TIMEOUT", "5s")
+	t.Setenv("POLL_ON_START", "false")
+	t.Setenv("DEVICE_ID_PATTERN", `[A-Z]{3}-\d{4}`)
+	assert.Empty(t, Validate())
+
+	summary := make(map[string]string)
+	for _, s := range Summary() {
+		summary[s.Name] = s.Value
+	}
+	assert.Equal(t, "postgres://monitor:xxxxx@db:5432/devices?sslmode=disable", summary["DATABASE_URL"])
+	assert.Equal(t, "******", summary["ADMIN_API_TOKEN"])
+	assert.Equal(t, "50051", summary["GRPC_PORT"])
+}
+
+func TestValidateInvalidConfig(t *testing.T) {
+	t.Setenv("DATABASE_URL", "")
+	t.Setenv("GRPC_PORT", "70000")
+	t.Setenv("HEALTH_CHECK_TIMEOUT", "5 seconds")
+
+	errs := Validate()
+	assert.Len(t, errs, 3)
+	assert.ErrorContains(t, errs[0], "DATABASE_URL: must be set")
+	assert.ErrorContains(t, errs[1], "GRPC_PORT: invalid port 70000, must be between 0 and 65535")
+	assert.ErrorContains(t, errs[2], "HEALTH_CHECK_TIMEOUT: invalid duration 5 seconds")
+}
@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestNewRepositoryRetriesConnecting(t *testing.T) {
+	t.Setenv("DB_CONNECT_RETRY_DELAY", "1ms")
+	defer func(open func(gorm.Dialector, ...gorm.Option) (*gorm.DB, error)) { openDB = open }(openDB)
+
+	// the database accepts connections on the third attempt
+	attempts := 0
+	openDB = func(gorm.Dialector, ...gorm.Option) (*gorm.DB, error) {
+		attempts++
+		if attempts < 3 {
+			return nil, fmt.Errorf("connection refused")
+		}
+		return &gorm.DB{}, nil
+	}
+
+	t.Setenv("DB_CONNECT_RETRIES", "5")
+	repo, err := NewRepository("postgres://localhost/db")
+	assert.NoError(t, err)
+	assert.NotNil(t, repo)
+	assert.Equal(t, 3, attempts)
+
+	// gives up once the retries are used up
+	attempts = 0
+	t.Setenv("DB_CONNECT_RETRIES", "1")
+	_, err = NewRepository("postgres://localhost/db")
+	assert.ErrorContains(t, err, "connection refused")
+	assert.Equal(t, 2, attempts)
+
+	// and fails right away by default
+	attempts = 0
+	t.Setenv("DB_CONNECT_RETRIES", "")
+	_, err = NewRepository("postgres://localhost/db")
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts)
+}
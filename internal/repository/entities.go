@@ -1,9 +1,14 @@
 package repository
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"slices"
 	"time"
 
 	"github.com/lib/pq"
+	"github.com/samber/lo"
 )
 
 type (
@@ -12,9 +17,12 @@ type (
 )
 
 var (
-	PollingDone       PollingStatus = "done"
-	PollingInProgress PollingStatus = "in_progress"
-	PollingCancelled  PollingStatus = "cancelled"
+	PollingDone          PollingStatus = "done"
+	PollingInProgress    PollingStatus = "in_progress"
+	PollingCancelled     PollingStatus = "cancelled"
+	PollingMisconfigured PollingStatus = "misconfigured"
+	// PollingDeferred is the status of a device whose retries are put off to a later polling cycle
+	PollingDeferred PollingStatus = "deferred"
 )
 
 const (
@@ -28,14 +36,25 @@ const (
 
 	REST = "rest"
 	GRPC = "grpc"
+	TCP  = "tcp"
 )
 
+var KnownDeviceTypes = []string{Router, Switch, Camera, DoorAccessSystem}
+
+// PollableProtocols are the protocols the polling worker can poll a device with
+var PollableProtocols = []string{REST, GRPC, TCP}
+
 type DeviceType struct {
-	ID          uint `gorm:"primaryKey"`
-	Name        string
-	Description *string
-	CreatedAt   time.Time `gorm:"autoCreateTime"`
-	DeletedAt   *time.Time
+	ID                uint `gorm:"primaryKey"`
+	Name              string
+	Description       *string
+	ExpectedFwVersion *string
+	Enabled           bool      `gorm:"default:true"`
+	CreatedAt         time.Time `gorm:"autoCreateTime"`
+	DeletedAt         *time.Time
+	// ShortestPollIntervalOverride is the shortest polling interval override of its devices, only read along with
+	// all the device types
+	ShortestPollIntervalOverride *time.Duration `gorm:"->"`
 }
 
 func (DeviceType) TableName() string {
@@ -51,16 +70,88 @@ type Device struct {
 	RestPort      *int
 	RestPath      *string
 	GrpcPort      *int
+	TcpPort       *int
+	Capabilities  Capabilities `gorm:"type:jsonb"`
 	PollingStatus *PollingStatus
-	CreatedAt     time.Time `gorm:"autoCreateTime"`
-	LastCheckedAt *time.Time
-	DeletedAt     *time.Time
+	// ExpectedFwVersion overrides the expected firmware version of the device type when set
+	ExpectedFwVersion *string
+	// InsecureSkipVerify skips the verification of the tls certificate of the device, e.g. a self-signed one
+	InsecureSkipVerify bool
+	// SuccessStreak is the number of the latest polls in a row which succeeded
+	SuccessStreak int
+	// TotalFailureCount is the number of the failed polls across the polling cycles, until the device succeeds
+	// long enough in a row
+	TotalFailureCount int
+	// Site is the physical site where the device is located
+	Site *string
+	// PollIntervalOverride overrides the polling interval of the device type when set, e.g. for a critical device
+	PollIntervalOverride *time.Duration
+	CreatedAt            time.Time `gorm:"autoCreateTime"`
+	LastCheckedAt        *time.Time
+	DeletedAt            *time.Time
+	DeletedReason        *string
+	DeletedBy            *string
 }
 
 func (Device) TableName() string {
 	return "devices"
 }
 
+// Capability returns how the device is polled with a protocol it supports. It is derived from the legacy columns for
+// the devices added before the capabilities were stored.
+func (d Device) Capability(protocol string) (Capability, bool) {
+	if len(d.Capabilities) > 0 {
+		return lo.Find(d.Capabilities, func(c Capability) bool { return c.Protocol == protocol })
+	}
+	if !slices.Contains(d.Protocols, protocol) {
+		return Capability{}, false
+	}
+
+	c := Capability{Protocol: protocol}
+	switch protocol {
+	case REST:
+		c.Port = d.RestPort
+		c.Path = d.RestPath
+	case GRPC:
+		c.Port = d.GrpcPort
+	case TCP:
+		c.Port = d.TcpPort
+	}
+	return c, true
+}
+
+// Capability is how a device is polled with a protocol, Extra holds the settings specific to the protocol
+type Capability struct {
+	Protocol string            `json:"protocol"`
+	Port     *int              `json:"port,omitempty"`
+	Path     *string           `json:"path,omitempty"`
+	Extra    map[string]string `json:"extra,omitempty"`
+}
+
+// Capabilities are stored as a json array
+type Capabilities []Capability
+
+func (c Capabilities) Value() (driver.Value, error) {
+	if c == nil {
+		return nil, nil
+	}
+	return json.Marshal(c)
+}
+
+func (c *Capabilities) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*c = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, c)
+	case string:
+		return json.Unmarshal([]byte(v), c)
+	default:
+		return fmt.Errorf("unsupported type %T of capabilities", src)
+	}
+}
+
 type PollingHistory struct {
 	ID             uint `gorm:"primaryKey"`
 	DeviceID       string
@@ -71,9 +162,40 @@ type PollingHistory struct {
 	DeviceChecksum *string
 	PollingResult  PollingResult
 	FailureReason  *string
+	GrpcCode       *int      // the status code of a failed poll over grpc, e.g. 14 for Unavailable
+	LatencyMs      *int      // how long the polling attempt took, in milliseconds
 	CreatedAt      time.Time `gorm:"autoCreateTime"`
 }
 
 func (PollingHistory) TableName() string {
 	return "polling_history"
 }
+
+// PollingHistoryRollup summarizes the polling history of a device within the hour starting at BucketStart, the polling
+// history is rolled up once it gets old
+type PollingHistoryRollup struct {
+	DeviceID       string    `gorm:"primaryKey"`
+	BucketStart    time.Time `gorm:"primaryKey"`
+	SucceededCount int
+	FailedCount    int
+	// the versions and the status of the latest successful poll within the hour, nil when all the polls failed
+	HwVersion    *string
+	SwVersion    *string
+	FwVersion    *string
+	DeviceStatus *string
+	LastPolledAt time.Time
+}
+
+func (PollingHistoryRollup) TableName() string {
+	return "polling_history_rollup"
+}
+
+type WorkerHeartbeat struct {
+	WorkerID  string    `gorm:"primaryKey"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+	UpdatedAt time.Time
+}
+
+func (WorkerHeartbeat) TableName() string {
+	return "worker_heartbeats"
+}
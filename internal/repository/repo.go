@@ -1,11 +1,16 @@
 package repository
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"example.poc/device-monitoring-system/internal/config"
+	"example.poc/device-monitoring-system/internal/util"
+	"github.com/rs/zerolog/log"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
@@ -17,16 +22,71 @@ var _ IRepository = &Repo{}
 var (
 	ErrRecordNotFound = fmt.Errorf("record not found")
 
+	openDB = gorm.Open
+
 	defaultDevicePollingOutdateGap = 30 * time.Minute
 )
 
 type SqlSelectionCondition string
 
+const (
+	// NeverPolledDevices selects devices that the polling worker has not checked yet
+	NeverPolledDevices SqlSelectionCondition = "last_checked_at is null"
+)
+
 type DevicePollingParameter struct {
 	DeviceType     string
 	Interval       time.Duration
 	OutdatedPeriod *time.Duration
 	Limit          int
+	// the devices which succeeded ColdStreak polls in a row are polled every ColdInterval instead, no cold tier when 0
+	ColdStreak   int
+	ColdInterval time.Duration
+	// the devices whose latest poll failed are claimed ahead of the others, which are still polled once they are due
+	PreferFailing bool
+}
+
+// DeviceSearch holds the criteria of searching devices, the criteria set are combined with 'and'
+type DeviceSearch struct {
+	DeviceType  string
+	Hostname    string // matches part of the hostname, case insensitively
+	NeverPolled bool
+	Deleted     bool   // searches the soft deleted devices instead of the active ones
+	SortBy      string // one of DeviceSortFields, 'id' by default
+	Descending  bool
+	Offset      int
+	Limit       int // no limit when 0
+}
+
+var DeviceSortFields = []string{"id", "device_id", "hostname", "created_at", "last_checked_at", "deleted_at"}
+
+// FlappingDevice is a device whose polling results switched between succeed and failed Transitions times
+type FlappingDevice struct {
+	DeviceID    string
+	Transitions int
+}
+
+// PollingCounts counts the successful and the failed polls
+type PollingCounts struct {
+	Succeeded int
+	Failed    int
+}
+
+// DeviceFreshness counts the devices of a device type, and among them, the ones polled recently
+type DeviceFreshness struct {
+	Total int
+	Fresh int
+}
+
+// DeviceFreshnessParameter tells how recently the devices of a device type must have been checked to be fresh: within
+// Intervals times the interval each of them is polled on, i.e. its own interval override if any, the ColdInterval once
+// it succeeded ColdStreak polls in a row, the Interval otherwise
+type DeviceFreshnessParameter struct {
+	DeviceType   string
+	Intervals    int
+	Interval     time.Duration
+	ColdStreak   int
+	ColdInterval time.Duration
 }
 
 type IRepository interface {
@@ -37,13 +97,33 @@ type IRepository interface {
 	CreatePollingHistories(histories []*PollingHistory) error
 	RestoreDeviceType(uint) error
 	UpdateDevice(device *Device) error
+	UpdateDeviceExpectedFwVersion(deviceID string, version *string) error
+	UpdateDevicePollIntervalOverride(deviceID string, interval *time.Duration) error
+	UpdateDeviceInsecureSkipVerify(deviceID string, insecureSkipVerify bool) error
+	UpdateDeviceSite(deviceID string, site *string) error
+	UpdateDeviceTypeExpectedFwVersion(name string, version *string) error
+	UpdateDeviceTypeEnabled(name string, enabled bool) error
 	RestoreDevice(uint) error
 	GetDeviceTypeByName(name string) (*DeviceType, error)
 	GetDeviceByID(deviceID string) (*Device, error)
-	GetDevicesByPage(page, size int, condition string) ([]Device, int, error)
+	GetDevicesByPage(page, size int, condition string, params map[string]any) ([]Device, int, error)
+	SearchDevices(search DeviceSearch) ([]Device, int, error)
 	GetAllDeviceTypes() ([]DeviceType, error)
 	GetDevicesByPollingParameter(DevicePollingParameter) ([]Device, error)
+	ResetDevicesPolling(deviceType string, checkpoint time.Time, limit int) (int64, error)
 	GetDevicePollingHistory(deviceID string, limit int) ([]PollingHistory, error)
+	GetSucceededPollingHistory(deviceID string, from, to *time.Time) ([]PollingHistory, error)
+	GetFlappingDevices(since time.Time, minTransitions int) ([]FlappingDevice, error)
+	GetDeviceFreshness(param DeviceFreshnessParameter) (DeviceFreshness, error)
+	CountDevicesByType() (map[string]int, error)
+	DeleteDeviceHistory(deviceID string, before *time.Time) (int64, error)
+	DeletePollingHistoryBefore(before time.Time) (int64, error)
+	RollupPollingHistory(before time.Time) (int64, error)
+	DeletePollingHistoryRollupsBefore(before time.Time) (int64, error)
+	GetPollingHistoryRollups(deviceID string, from, to *time.Time) ([]PollingHistoryRollup, error)
+	CountPollingHistory(deviceID string, from, to *time.Time) (PollingCounts, error)
+	SaveWorkerHeartbeat(workerID string) error
+	GetLatestWorkerHeartbeat() (*WorkerHeartbeat, error)
 }
 
 type Repo struct {
@@ -64,7 +144,21 @@ func NewRepository(dsn string) (*Repo, error) {
 		cfg.Logger = logger.Default.LogMode(logger.Info)
 	}
 
-	db, err := gorm.Open(postgres.Open(dsn), cfg)
+	// the database may not accept connections yet when it starts up along with the service
+	var db *gorm.DB
+	backoff := util.Backoff{
+		BaseDelay: config.DBConnectRetryDelay(),
+		Factor:    2,
+		MaxDelay:  30 * time.Second,
+	}
+	err := util.Retry(context.Background(), config.DBConnectRetries(), backoff, func(ctx context.Context) error {
+		var err error
+		db, err = openDB(postgres.Open(dsn), cfg)
+		if err != nil {
+			log.Warn().Err(err).Msg("failed to connect to the database")
+		}
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -107,7 +201,7 @@ func (repo *Repo) RestoreDevice(deviceID uint) error {
 	if deviceID <= 0 {
 		return fmt.Errorf("illegal argument: device ID must be greater than 0")
 	}
-	q := `update devices set deleted_at = null where id = ?`
+	q := `update devices set deleted_at = null, deleted_reason = null, deleted_by = null where id = ?`
 	if err := repo.db.Exec(q, deviceID).Error; err != nil {
 		return fmt.Errorf("failed to restore device with ID %d: %w", deviceID, err)
 	}
@@ -180,6 +274,80 @@ func (repo *Repo) UpdateDevice(device *Device) error {
 	return nil
 }
 
+// UpdateDevicePollIntervalOverride sets the polling interval of a device, a nil interval makes the device polled on the
+// interval of its device type
+func (repo *Repo) UpdateDevicePollIntervalOverride(deviceID string, interval *time.Duration) error {
+	result := repo.db.Model(&Device{}).Where("device_id = ? and deleted_at is null", deviceID).Update("poll_interval_override", interval)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update polling interval override of device %s: %w", deviceID, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// UpdateDeviceInsecureSkipVerify sets whether the tls certificate of a device polled over https is verified
+func (repo *Repo) UpdateDeviceInsecureSkipVerify(deviceID string, insecureSkipVerify bool) error {
+	result := repo.db.Model(&Device{}).Where("device_id = ? and deleted_at is null", deviceID).Update("insecure_skip_verify", insecureSkipVerify)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update insecure skip verify of device %s: %w", deviceID, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// UpdateDeviceSite sets the site of a device, a nil site clears it
+func (repo *Repo) UpdateDeviceSite(deviceID string, site *string) error {
+	result := repo.db.Model(&Device{}).Where("device_id = ? and deleted_at is null", deviceID).Update("site", site)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update site of device %s: %w", deviceID, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// UpdateDeviceExpectedFwVersion sets the expected firmware version of a device, a nil version makes the device inherit
+// the one of its device type
+func (repo *Repo) UpdateDeviceExpectedFwVersion(deviceID string, version *string) error {
+	result := repo.db.Model(&Device{}).Where("device_id = ? and deleted_at is null", deviceID).Update("expected_fw_version", version)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update expected firmware version of device %s: %w", deviceID, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// UpdateDeviceTypeExpectedFwVersion sets the expected firmware version inherited by the devices of a device type
+func (repo *Repo) UpdateDeviceTypeExpectedFwVersion(name string, version *string) error {
+	result := repo.db.Model(&DeviceType{}).Where("name = ? and deleted_at is null", name).Update("expected_fw_version", version)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update expected firmware version of device type %s: %w", name, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// UpdateDeviceTypeEnabled turns the polling of all the devices of a device type on or off
+func (repo *Repo) UpdateDeviceTypeEnabled(name string, enabled bool) error {
+	result := repo.db.Model(&DeviceType{}).Where("name = ? and deleted_at is null", name).Update("enabled", enabled)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update enabled flag of device type %s: %w", name, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
 func (repo *Repo) GetDeviceByID(deviceID string) (*Device, error) {
 	var device Device
 	if err := repo.db.Where("device_id = ?", deviceID).First(&device).Error; err != nil {
@@ -191,29 +359,84 @@ func (repo *Repo) GetDeviceByID(deviceID string) (*Device, error) {
 	return &device, nil
 }
 
-func (repo *Repo) GetDevicesByPage(page, size int, condition string) ([]Device, int, error) {
+// GetDevicesByPage returns a page of the devices matching the condition along with the total number of them, the
+// condition may refer to the named params, e.g. 'device_type in @device_types'
+func (repo *Repo) GetDevicesByPage(page, size int, condition string, params map[string]any) ([]Device, int, error) {
 	if page < 0 || size <= 0 {
 		return nil, 0, fmt.Errorf("illegal argument: invalid page or size")
 	}
 
+	var args []any
+	if len(params) > 0 {
+		args = append(args, params)
+	}
 	q := `select count(*) from devices where deleted_at is null`
 	if condition != "" {
 		q += " and " + condition
 	}
 	var count int
-	err := repo.db.Raw(q).Scan(&count).Error
+	err := repo.db.Raw(q, args...).Scan(&count).Error
 	if err != nil {
 		return nil, 0, err
 	}
 
 	var devices []Device
-	err = repo.db.Where(condition).Where("deleted_at is null").Offset(page * size).Limit(size).Order("id asc").Find(&devices).Error
+	err = repo.db.Where(condition, args...).Where("deleted_at is null").Offset(page * size).Limit(size).Order("id asc").Find(&devices).Error
 	if err != nil {
 		return nil, 0, err
 	}
 	return devices, count, nil
 }
 
+// SearchDevices returns the devices matching the search along with the total number of the matching devices
+func (repo *Repo) SearchDevices(search DeviceSearch) ([]Device, int, error) {
+	sortBy := search.SortBy
+	if sortBy == "" {
+		sortBy = "id"
+	}
+	if !slices.Contains(DeviceSortFields, sortBy) {
+		return nil, 0, fmt.Errorf("illegal argument: cannot sort devices by %s", sortBy)
+	}
+	if search.Offset < 0 || search.Limit < 0 {
+		return nil, 0, fmt.Errorf("illegal argument: invalid offset or limit")
+	}
+
+	db := repo.db.Model(&Device{}).Where("deleted_at is null")
+	if search.Deleted {
+		db = repo.db.Model(&Device{}).Where("deleted_at is not null")
+	}
+	if search.DeviceType != "" {
+		db = db.Where("device_type = ?", search.DeviceType)
+	}
+	if search.Hostname != "" {
+		escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(search.Hostname)
+		db = db.Where("hostname ilike ?", "%"+escaped+"%")
+	}
+	if search.NeverPolled {
+		db = db.Where(string(NeverPolledDevices))
+	}
+	db = db.Session(&gorm.Session{})
+
+	var count int64
+	if err := db.Count(&count).Error; err != nil {
+		return nil, 0, err
+	}
+
+	order := "asc"
+	if search.Descending {
+		order = "desc"
+	}
+	q := db.Order(fmt.Sprintf("%s %s nulls last", sortBy, order)).Order("id asc").Offset(search.Offset)
+	if search.Limit > 0 {
+		q = q.Limit(search.Limit)
+	}
+	var devices []Device
+	if err := q.Find(&devices).Error; err != nil {
+		return nil, 0, err
+	}
+	return devices, int(count), nil
+}
+
 func (repo *Repo) GetDeviceTypeByName(name string) (*DeviceType, error) {
 	var deviceType DeviceType
 	if err := repo.db.Where("name = ?", name).Find(&deviceType).Error; err != nil {
@@ -228,7 +451,9 @@ func (repo *Repo) GetDeviceTypeByName(name string) (*DeviceType, error) {
 
 func (repo *Repo) GetAllDeviceTypes() ([]DeviceType, error) {
 	var deviceTypes []DeviceType
-	err := repo.db.Where("deleted_at is null").Find(&deviceTypes).Error
+	err := repo.db.Select(`device_types.*, (select min(poll_interval_override) from devices
+			where devices.device_type = device_types.name and devices.deleted_at is null) as shortest_poll_interval_override`).
+		Where("deleted_at is null").Find(&deviceTypes).Error
 	return deviceTypes, err
 }
 
@@ -239,30 +464,112 @@ func (repo *Repo) GetDevicesByPollingParameter(param DevicePollingParameter) ([]
 
 	q := `update devices set polling_status = @status_in_progress where id in (
 		select id from devices where deleted_at is null and device_type = @device_type and
+			not exists (select 1 from device_types where name = devices.device_type and not enabled) and
 			(
-				((polling_status is null or polling_status != @status_in_progress) and (last_checked_at is null or last_checked_at < @recent_checkpoint)) 
+				((polling_status is null or polling_status != @status_in_progress) and
+					(last_checked_at is null or last_checked_at < coalesce(@now::timestamptz - make_interval(secs => poll_interval_override / 1e9::float8), @recent_checkpoint)) and
+					not (@cold_streak > 0 and poll_interval_override is null and success_streak >= @cold_streak and last_checked_at >= @cold_checkpoint)) 
 					or 
 				last_checked_at < @remote_checkpoint 
 					or 
 				(last_checked_at is null and created_at < @remote_checkpoint)
 			)
-		order by last_checked_at asc limit @limit
+		order by (@prefer_failing and last_checked_at is not null and success_streak = 0) desc, last_checked_at asc limit @limit
 	) returning *`
 
 	var devices []Device
-	recentCheckpoint := time.Now().Add(-param.Interval)
-	remoteCheckpoint := time.Now().Add(-*param.OutdatedPeriod)
+	now := time.Now()
+	recentCheckpoint := now.Add(-param.Interval)
+	remoteCheckpoint := now.Add(-*param.OutdatedPeriod)
 	err := repo.db.Raw(q, map[string]any{
 		"status_in_progress": PollingInProgress,
+		"now":                now,
 		"device_type":        param.DeviceType,
 		"recent_checkpoint":  recentCheckpoint,
 		"remote_checkpoint":  remoteCheckpoint,
+		"cold_streak":        param.ColdStreak,
+		"cold_checkpoint":    time.Now().Add(-param.ColdInterval),
+		"prefer_failing":     param.PreferFailing,
 		"limit":              param.Limit,
 	}).Scan(&devices).Error
 
 	return devices, err
 }
 
+// ResetDevicesPolling makes up to limit devices of a device type eligible for polling right away by moving their last
+// check back before the checkpoint, and before their own interval override if any. The devices never polled keep no
+// last check and the devices being polled are left untouched. It returns the number of the devices reset.
+func (repo *Repo) ResetDevicesPolling(deviceType string, checkpoint time.Time, limit int) (int64, error) {
+	if limit <= 0 {
+		return 0, fmt.Errorf("illegal argument: limit must be a positive integer")
+	}
+	if checkpoint.IsZero() {
+		return 0, fmt.Errorf("illegal argument: checkpoint cannot be zero")
+	}
+
+	q := `update devices set polling_status = null,
+		last_checked_at = case when last_checked_at is null then null
+			else least(last_checked_at, @checkpoint::timestamptz - make_interval(secs => coalesce(poll_interval_override, 0) / 1e9::float8)) end
+	where id in (
+		select id from devices where deleted_at is null and device_type = @device_type and
+			(polling_status is null or polling_status != @status_in_progress)
+		order by id asc limit @limit
+	)`
+	result := repo.db.Exec(q, map[string]any{
+		"status_in_progress": PollingInProgress,
+		"device_type":        deviceType,
+		"checkpoint":         checkpoint,
+		"limit":              limit,
+	})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to reset polling of devices of type %s: %w", deviceType, result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// GetDeviceFreshness counts the devices of a device type which are not deleted, and the ones of them checked recently
+// enough for the interval they are polled on
+func (repo *Repo) GetDeviceFreshness(param DeviceFreshnessParameter) (DeviceFreshness, error) {
+	q := `select count(*) as total, count(*) filter (where last_checked_at >= @now::timestamptz - make_interval(secs => @intervals::float8 *
+			(case when poll_interval_override is not null then poll_interval_override
+				when @cold_streak::int > 0 and success_streak >= @cold_streak::int then @cold_interval::bigint
+				else @interval::bigint end) / 1e9::float8)) as fresh
+		from devices where deleted_at is null and device_type = @device_type`
+
+	var freshness DeviceFreshness
+	err := repo.db.Raw(q, map[string]any{
+		"now":           time.Now(),
+		"device_type":   param.DeviceType,
+		"intervals":     param.Intervals,
+		"interval":      int64(param.Interval),
+		"cold_streak":   param.ColdStreak,
+		"cold_interval": int64(param.ColdInterval),
+	}).Scan(&freshness).Error
+	if err != nil {
+		return DeviceFreshness{}, fmt.Errorf("failed to count fresh devices of type %s: %w", param.DeviceType, err)
+	}
+	return freshness, nil
+}
+
+// CountDevicesByType counts the devices which are not deleted by device type, the device types without devices are left
+// out
+func (repo *Repo) CountDevicesByType() (map[string]int, error) {
+	q := `select device_type, count(*) as count from devices where deleted_at is null group by device_type`
+
+	var rows []struct {
+		DeviceType string
+		Count      int
+	}
+	if err := repo.db.Raw(q).Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to count devices by type: %w", err)
+	}
+	counts := make(map[string]int, len(rows))
+	for _, row := range rows {
+		counts[row.DeviceType] = row.Count
+	}
+	return counts, nil
+}
+
 func (repo *Repo) GetDevicePollingHistory(deviceID string, limit int) ([]PollingHistory, error) {
 	if limit <= 0 {
 		return nil, fmt.Errorf("illegal argument: limit must be a positive integer")
@@ -273,6 +580,233 @@ func (repo *Repo) GetDevicePollingHistory(deviceID string, limit int) ([]Polling
 	return histories, err
 }
 
+// GetSucceededPollingHistory returns the successful polling history of a device in ascending order of creation,
+// optionally bounded by the time window [from, to)
+func (repo *Repo) GetSucceededPollingHistory(deviceID string, from, to *time.Time) ([]PollingHistory, error) {
+	if from != nil && to != nil && !from.Before(*to) {
+		return nil, fmt.Errorf("illegal argument: from must be before to")
+	}
+
+	db := repo.db.Where("device_id = ? and polling_result = ?", deviceID, PollSucceed)
+	if from != nil {
+		db = db.Where("created_at >= ?", *from)
+	}
+	if to != nil {
+		db = db.Where("created_at < ?", *to)
+	}
+
+	var histories []PollingHistory
+	err := db.Order("created_at asc").Order("id asc").Find(&histories).Error
+	return histories, err
+}
+
+// GetFlappingDevices returns the devices whose polling results switched between succeed and failed at least
+// minTransitions times since the given time, the most flapping first
+func (repo *Repo) GetFlappingDevices(since time.Time, minTransitions int) ([]FlappingDevice, error) {
+	if minTransitions <= 0 {
+		return nil, fmt.Errorf("illegal argument: min transitions must be a positive integer")
+	}
+
+	q := `select device_id, count(*) as transitions from (
+		select h.device_id, h.polling_result,
+			lag(h.polling_result) over (partition by h.device_id order by h.created_at asc, h.id asc) as previous_result
+		from polling_history h join devices d on d.device_id = h.device_id
+		where d.deleted_at is null and h.created_at >= @since
+	) results
+	where previous_result is not null and previous_result != polling_result
+	group by device_id having count(*) >= @min_transitions
+	order by transitions desc, device_id asc`
+
+	var devices []FlappingDevice
+	err := repo.db.Raw(q, map[string]any{
+		"since":           since,
+		"min_transitions": minTransitions,
+	}).Scan(&devices).Error
+	return devices, err
+}
+
+// DeleteDeviceHistory removes the polling history of a device, only the records created before the given time
+// when it is not nil, and returns the number of removed records. The rollups of the hours ended before the given time
+// are removed along in the same transaction.
+func (repo *Repo) DeleteDeviceHistory(deviceID string, before *time.Time) (int64, error) {
+	if deviceID == "" {
+		return 0, fmt.Errorf("illegal argument: device ID cannot be empty")
+	}
+
+	var deleted int64
+	err := repo.db.Transaction(func(tx *gorm.DB) error {
+		history := tx.Where("device_id = ?", deviceID)
+		rollups := tx.Where("device_id = ?", deviceID)
+		if before != nil {
+			history = history.Where("created_at < ?", *before)
+			rollups = rollups.Where("bucket_start <= ?", before.Add(-time.Hour))
+		}
+		result := history.Delete(&PollingHistory{})
+		if result.Error != nil {
+			return result.Error
+		}
+		deleted = result.RowsAffected
+		return rollups.Delete(&PollingHistoryRollup{}).Error
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete polling history of device %s: %w", deviceID, err)
+	}
+	return deleted, nil
+}
+
+// DeletePollingHistoryBefore removes the polling history of all devices created before the given time, along with the
+// rollups of the hours ended before it in the same transaction, and returns the number of removed records
+func (repo *Repo) DeletePollingHistoryBefore(before time.Time) (int64, error) {
+	if before.IsZero() {
+		return 0, fmt.Errorf("illegal argument: before cannot be zero")
+	}
+
+	var deleted int64
+	err := repo.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("created_at < ?", before).Delete(&PollingHistory{})
+		if result.Error != nil {
+			return result.Error
+		}
+		deleted = result.RowsAffected
+		return tx.Where("bucket_start <= ?", before.Add(-time.Hour)).Delete(&PollingHistoryRollup{}).Error
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete polling history before %s: %w", before.Format(time.RFC3339), err)
+	}
+	return deleted, nil
+}
+
+// DeletePollingHistoryRollupsBefore removes the rollups of the hours started before the given time, and returns the
+// number of removed rollups
+func (repo *Repo) DeletePollingHistoryRollupsBefore(before time.Time) (int64, error) {
+	if before.IsZero() {
+		return 0, fmt.Errorf("illegal argument: before cannot be zero")
+	}
+
+	result := repo.db.Where("bucket_start < ?", before).Delete(&PollingHistoryRollup{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to delete polling history rollups before %s: %w", before.Format(time.RFC3339), result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// RollupPollingHistory summarizes the polling history created before the given time into hourly rollups, merged into
+// the rollups of the same hours if any, and then removes it. It returns the number of the removed records. Only one
+// rollup runs at a time, a concurrent one does nothing.
+func (repo *Repo) RollupPollingHistory(before time.Time) (int64, error) {
+	if before.IsZero() {
+		return 0, fmt.Errorf("illegal argument: before cannot be zero")
+	}
+
+	// the older polls are rolled up first, so the versions of a rollup are replaced by the ones of a later poll
+	q := `insert into polling_history_rollup (device_id, bucket_start, succeeded_count, failed_count,
+			hw_version, sw_version, fw_version, device_status, last_polled_at)
+		select device_id, date_trunc('hour', created_at),
+			count(*) filter (where polling_result = @succeed),
+			count(*) filter (where polling_result != @succeed),
+			(array_agg(hw_version order by created_at desc) filter (where polling_result = @succeed))[1],
+			(array_agg(sw_version order by created_at desc) filter (where polling_result = @succeed))[1],
+			(array_agg(fw_version order by created_at desc) filter (where polling_result = @succeed))[1],
+			(array_agg(device_status order by created_at desc) filter (where polling_result = @succeed))[1],
+			max(created_at)
+		from polling_history where created_at < @before
+		group by device_id, date_trunc('hour', created_at)
+		on conflict (device_id, bucket_start) do update set
+			succeeded_count = polling_history_rollup.succeeded_count + excluded.succeeded_count,
+			failed_count = polling_history_rollup.failed_count + excluded.failed_count,
+			hw_version = coalesce(excluded.hw_version, polling_history_rollup.hw_version),
+			sw_version = coalesce(excluded.sw_version, polling_history_rollup.sw_version),
+			fw_version = coalesce(excluded.fw_version, polling_history_rollup.fw_version),
+			device_status = coalesce(excluded.device_status, polling_history_rollup.device_status),
+			last_polled_at = greatest(excluded.last_polled_at, polling_history_rollup.last_polled_at)`
+
+	var deleted int64
+	err := repo.db.Transaction(func(tx *gorm.DB) error {
+		var locked bool
+		if err := tx.Raw(`select pg_try_advisory_xact_lock(hashtext('polling_history_rollup'))`).Scan(&locked).Error; err != nil {
+			return err
+		}
+		if !locked {
+			return nil
+		}
+		if err := tx.Exec(q, map[string]any{"succeed": PollSucceed, "before": before}).Error; err != nil {
+			return err
+		}
+		result := tx.Where("created_at < ?", before).Delete(&PollingHistory{})
+		deleted = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to roll up polling history before %s: %w", before.Format(time.RFC3339), err)
+	}
+	return deleted, nil
+}
+
+// GetPollingHistoryRollups returns the hourly rollups of the polling history of a device in ascending order of their
+// hours, optionally bounded by the time window [from, to) on the start of the hours
+func (repo *Repo) GetPollingHistoryRollups(deviceID string, from, to *time.Time) ([]PollingHistoryRollup, error) {
+	if from != nil && to != nil && !from.Before(*to) {
+		return nil, fmt.Errorf("illegal argument: from must be before to")
+	}
+
+	db := repo.db.Where("device_id = ?", deviceID)
+	if from != nil {
+		db = db.Where("bucket_start >= ?", *from)
+	}
+	if to != nil {
+		db = db.Where("bucket_start < ?", *to)
+	}
+
+	var rollups []PollingHistoryRollup
+	err := db.Order("bucket_start asc").Find(&rollups).Error
+	return rollups, err
+}
+
+// CountPollingHistory counts the successful and the failed polls of a device in the polling history, optionally
+// bounded by the time window [from, to)
+func (repo *Repo) CountPollingHistory(deviceID string, from, to *time.Time) (PollingCounts, error) {
+	if from != nil && to != nil && !from.Before(*to) {
+		return PollingCounts{}, fmt.Errorf("illegal argument: from must be before to")
+	}
+
+	db := repo.db.Model(&PollingHistory{}).
+		Select("count(*) filter (where polling_result = ?) as succeeded, count(*) filter (where polling_result != ?) as failed", PollSucceed, PollSucceed).
+		Where("device_id = ?", deviceID)
+	if from != nil {
+		db = db.Where("created_at >= ?", *from)
+	}
+	if to != nil {
+		db = db.Where("created_at < ?", *to)
+	}
+
+	var counts PollingCounts
+	err := db.Scan(&counts).Error
+	return counts, err
+}
+
+func (repo *Repo) SaveWorkerHeartbeat(workerID string) error {
+	if workerID == "" {
+		return fmt.Errorf("illegal argument: worker ID cannot be empty")
+	}
+	q := `insert into worker_heartbeats (worker_id, updated_at) values (?, ?)
+		on conflict (worker_id) do update set updated_at = excluded.updated_at`
+	if err := repo.db.Exec(q, workerID, time.Now()).Error; err != nil {
+		return fmt.Errorf("failed to save heartbeat of worker %s: %w", workerID, err)
+	}
+	return nil
+}
+
+func (repo *Repo) GetLatestWorkerHeartbeat() (*WorkerHeartbeat, error) {
+	var heartbeat WorkerHeartbeat
+	if err := repo.db.Order("updated_at desc").First(&heartbeat).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return &heartbeat, nil
+}
+
 func (param *DevicePollingParameter) validate() error {
 	if param.DeviceType == "" {
 		return fmt.Errorf("illegal argument: device type cannot be empty")
@@ -289,5 +823,8 @@ func (param *DevicePollingParameter) validate() error {
 	if *param.OutdatedPeriod <= 0 {
 		return fmt.Errorf("illegal argument: outdate gap must be a positive value")
 	}
+	if param.ColdStreak > 0 && param.ColdInterval <= param.Interval {
+		return fmt.Errorf("illegal argument: cold interval must be greater than the polling interval")
+	}
 	return nil
 }
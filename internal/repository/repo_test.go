@@ -79,6 +79,41 @@ func (s *dbTestSuite) TestGetDeviceByDID() {
 	s.Equal(deviceID, d.DeviceID)
 }
 
+func (s *dbTestSuite) TestDeviceCapabilities() {
+	capabilities := repository.Capabilities{
+		{Protocol: repository.REST, Port: lo.ToPtr(8080), Path: lo.ToPtr("/api/v1/device")},
+		{Protocol: "mqtt", Port: lo.ToPtr(1883), Extra: map[string]string{"topic": "devices/status"}},
+	}
+	device := repository.Device{
+		DeviceID:     "device1",
+		DeviceType:   repository.Router,
+		Hostname:     "localhost",
+		Protocols:    pq.StringArray([]string{repository.REST, "mqtt"}),
+		Capabilities: capabilities,
+	}
+	err := s.repo.CreateDevice(&device)
+	s.NoError(err)
+
+	d, err := s.repo.GetDeviceByID(device.DeviceID)
+	s.NoError(err)
+	s.Equal(capabilities, d.Capabilities)
+	c, ok := d.Capability("mqtt")
+	s.True(ok)
+	s.Equal("devices/status", c.Extra["topic"])
+	_, ok = d.Capability(repository.GRPC)
+	s.False(ok)
+
+	// the capabilities are claimed along with the device for polling
+	devices, err := s.repo.GetDevicesByPollingParameter(repository.DevicePollingParameter{
+		DeviceType: repository.Router,
+		Interval:   time.Minute,
+		Limit:      10,
+	})
+	s.NoError(err)
+	s.Require().Len(devices, 1)
+	s.Equal(capabilities, devices[0].Capabilities)
+}
+
 func (s *dbTestSuite) TestGetAllDeviceTypes() {
 	allTypes, err := s.repo.GetAllDeviceTypes()
 	s.NoError(err)
@@ -173,6 +208,250 @@ func (s *dbTestSuite) TestGetDevicesByPollingParameter() {
 	s.Len(devices, param.Limit)
 }
 
+func (s *dbTestSuite) TestGetDevicesByPollingParameterColdTier() {
+	pollingInterval := 10 * time.Second
+	param := repository.DevicePollingParameter{
+		DeviceType:   repository.Switch,
+		Interval:     pollingInterval,
+		Limit:        10,
+		ColdStreak:   5,
+		ColdInterval: time.Minute,
+	}
+
+	lastCheckedAt := lo.ToPtr(time.Now().Add(-2 * pollingInterval))
+	stable := repository.Device{
+		DeviceID:      uuid.NewString(),
+		DeviceType:    repository.Switch,
+		Hostname:      "localhost",
+		Protocols:     pq.StringArray{"grpc"},
+		PollingStatus: lo.ToPtr(repository.PollingDone),
+		LastCheckedAt: lastCheckedAt,
+		SuccessStreak: 5,
+	}
+	unstable := stable
+	unstable.DeviceID = uuid.NewString()
+	unstable.SuccessStreak = 4
+	err := s.repo.CreateDevices([]*repository.Device{&stable, &unstable})
+	s.NoError(err)
+
+	// the stable device is in the cold tier, not due before the cold interval
+	devices, err := s.repo.GetDevicesByPollingParameter(param)
+	s.NoError(err)
+	s.Equal([]string{unstable.DeviceID}, lo.Map(devices, func(d repository.Device, _ int) string { return d.DeviceID }))
+
+	stable.LastCheckedAt = lo.ToPtr(time.Now().Add(-param.ColdInterval - time.Second))
+	err = s.repo.UpdateDevice(&stable)
+	s.NoError(err)
+	devices, err = s.repo.GetDevicesByPollingParameter(param)
+	s.NoError(err)
+	s.Equal([]string{stable.DeviceID}, lo.Map(devices, func(d repository.Device, _ int) string { return d.DeviceID }))
+
+	// a failure promotes it back to the normal tier
+	stable.LastCheckedAt = lastCheckedAt
+	stable.PollingStatus = lo.ToPtr(repository.PollingDone)
+	stable.SuccessStreak = 0
+	err = s.repo.UpdateDevice(&stable)
+	s.NoError(err)
+	devices, err = s.repo.GetDevicesByPollingParameter(param)
+	s.NoError(err)
+	s.Equal([]string{stable.DeviceID}, lo.Map(devices, func(d repository.Device, _ int) string { return d.DeviceID }))
+
+	param.ColdInterval = pollingInterval
+	_, err = s.repo.GetDevicesByPollingParameter(param)
+	s.ErrorContains(err, "cold interval must be greater than the polling interval")
+}
+
+func (s *dbTestSuite) TestGetDeviceFreshness() {
+	param := repository.DeviceFreshnessParameter{
+		DeviceType:   repository.Camera,
+		Intervals:    2,
+		Interval:     10 * time.Second,
+		ColdStreak:   5,
+		ColdInterval: time.Minute,
+	}
+	checkedAgo := func(d time.Duration) *time.Time { return lo.ToPtr(time.Now().Add(-d)) }
+	var devices []*repository.Device
+	for _, d := range []struct {
+		lastCheckedAt *time.Time
+		successStreak int
+		override      *time.Duration
+	}{
+		{lastCheckedAt: checkedAgo(15 * time.Second)},
+		{lastCheckedAt: checkedAgo(30 * time.Second)},
+		// in the cold tier
+		{lastCheckedAt: checkedAgo(90 * time.Second), successStreak: 5},
+		// polled on its own shorter interval, whatever its streak
+		{lastCheckedAt: checkedAgo(15 * time.Second), successStreak: 5, override: lo.ToPtr(5 * time.Second)},
+		// never polled
+		{},
+	} {
+		devices = append(devices, &repository.Device{
+			DeviceID:             uuid.NewString(),
+			DeviceType:           repository.Camera,
+			Hostname:             "localhost",
+			Protocols:            pq.StringArray{"grpc"},
+			LastCheckedAt:        d.lastCheckedAt,
+			SuccessStreak:        d.successStreak,
+			PollIntervalOverride: d.override,
+		})
+	}
+	err := s.repo.CreateDevices(devices)
+	s.NoError(err)
+
+	freshness, err := s.repo.GetDeviceFreshness(param)
+	s.NoError(err)
+	s.Equal(repository.DeviceFreshness{Total: 5, Fresh: 2}, freshness)
+
+	// without a cold tier the stable device is stale too
+	param.ColdStreak = 0
+	freshness, err = s.repo.GetDeviceFreshness(param)
+	s.NoError(err)
+	s.Equal(repository.DeviceFreshness{Total: 5, Fresh: 1}, freshness)
+}
+
+func (s *dbTestSuite) TestGetDevicesByPollingParameterPreferFailing() {
+	pollingInterval := 10 * time.Second
+	newDevices := func(deviceType string) (healthy, failing []string) {
+		var devices []*repository.Device
+		for i := range 5 {
+			d := &repository.Device{
+				DeviceID:      uuid.NewString(),
+				DeviceType:    deviceType,
+				Hostname:      "localhost",
+				Protocols:     pq.StringArray{"grpc"},
+				PollingStatus: lo.ToPtr(repository.PollingDone),
+				// the healthy devices are due for longer
+				LastCheckedAt: lo.ToPtr(time.Now().Add(-time.Duration(10-i) * pollingInterval)),
+				SuccessStreak: 3,
+			}
+			if i >= 3 {
+				d.SuccessStreak = 0
+				failing = append(failing, d.DeviceID)
+			} else {
+				healthy = append(healthy, d.DeviceID)
+			}
+			devices = append(devices, d)
+		}
+		err := s.repo.CreateDevices(devices)
+		s.NoError(err)
+		return healthy, failing
+	}
+	deviceIDs := func(devices []repository.Device) []string {
+		return lo.Map(devices, func(d repository.Device, _ int) string { return d.DeviceID })
+	}
+
+	healthy, _ := newDevices(repository.Router)
+	devices, err := s.repo.GetDevicesByPollingParameter(repository.DevicePollingParameter{
+		DeviceType: repository.Router,
+		Interval:   pollingInterval,
+		Limit:      2,
+	})
+	s.NoError(err)
+	s.ElementsMatch(healthy[:2], deviceIDs(devices))
+
+	// the failing devices are claimed first with the bias on, the healthy ones are claimed afterwards
+	healthy, failing := newDevices(repository.Switch)
+	param := repository.DevicePollingParameter{
+		DeviceType:    repository.Switch,
+		Interval:      pollingInterval,
+		Limit:         2,
+		PreferFailing: true,
+	}
+	devices, err = s.repo.GetDevicesByPollingParameter(param)
+	s.NoError(err)
+	s.ElementsMatch(failing, deviceIDs(devices))
+
+	param.Limit = 10
+	devices, err = s.repo.GetDevicesByPollingParameter(param)
+	s.NoError(err)
+	s.ElementsMatch(healthy, deviceIDs(devices))
+}
+
+func (s *dbTestSuite) TestGetDevicesByPollingParameterIntervalOverride() {
+	pollingInterval := 10 * time.Second
+	newDevice := func(override *time.Duration) *repository.Device {
+		return &repository.Device{
+			DeviceID:             uuid.NewString(),
+			DeviceType:           repository.Camera,
+			Hostname:             "localhost",
+			Protocols:            pq.StringArray{"grpc"},
+			PollingStatus:        lo.ToPtr(repository.PollingDone),
+			LastCheckedAt:        lo.ToPtr(time.Now().Add(-3 * time.Second)),
+			PollIntervalOverride: override,
+		}
+	}
+	critical := newDevice(lo.ToPtr(2 * time.Second))
+	regular := newDevice(nil)
+	lazy := newDevice(lo.ToPtr(time.Minute))
+	err := s.repo.CreateDevices([]*repository.Device{critical, regular, lazy})
+	s.NoError(err)
+
+	dts, err := s.repo.GetAllDeviceTypes()
+	s.NoError(err)
+	dt, ok := lo.Find(dts, func(dt repository.DeviceType) bool { return dt.Name == repository.Camera })
+	s.Require().True(ok)
+	s.Equal(lo.ToPtr(2*time.Second), dt.ShortestPollIntervalOverride)
+	dt, ok = lo.Find(dts, func(dt repository.DeviceType) bool { return dt.Name == repository.Router })
+	s.Require().True(ok)
+	s.Nil(dt.ShortestPollIntervalOverride)
+
+	// only the device with the shorter override is due before the interval of its device type
+	param := repository.DevicePollingParameter{
+		DeviceType: repository.Camera,
+		Interval:   pollingInterval,
+		Limit:      10,
+	}
+	devices, err := s.repo.GetDevicesByPollingParameter(param)
+	s.NoError(err)
+	s.Equal([]string{critical.DeviceID}, lo.Map(devices, func(d repository.Device, _ int) string { return d.DeviceID }))
+
+	for _, d := range []*repository.Device{critical, regular, lazy} {
+		d.LastCheckedAt = lo.ToPtr(time.Now().Add(-2 * pollingInterval))
+		d.PollingStatus = lo.ToPtr(repository.PollingDone)
+		err = s.repo.UpdateDevice(d)
+		s.NoError(err)
+	}
+	devices, err = s.repo.GetDevicesByPollingParameter(param)
+	s.NoError(err)
+	s.ElementsMatch([]string{critical.DeviceID, regular.DeviceID}, lo.Map(devices, func(d repository.Device, _ int) string { return d.DeviceID }))
+
+	err = s.repo.UpdateDevicePollIntervalOverride(lazy.DeviceID, nil)
+	s.NoError(err)
+	devices, err = s.repo.GetDevicesByPollingParameter(param)
+	s.NoError(err)
+	s.Equal([]string{lazy.DeviceID}, lo.Map(devices, func(d repository.Device, _ int) string { return d.DeviceID }))
+
+	err = s.repo.UpdateDevicePollIntervalOverride("unknown", nil)
+	s.ErrorIs(err, repository.ErrRecordNotFound)
+}
+
+func (s *dbTestSuite) TestUpdateDeviceInsecureSkipVerifyAndSite() {
+	d := repository.Device{DeviceID: uuid.NewString(), DeviceType: repository.Router, Hostname: "localhost"}
+	err := s.repo.CreateDevice(&d)
+	s.NoError(err)
+
+	err = s.repo.UpdateDeviceInsecureSkipVerify(d.DeviceID, true)
+	s.NoError(err)
+	err = s.repo.UpdateDeviceSite(d.DeviceID, lo.ToPtr("o'hare"))
+	s.NoError(err)
+
+	device, err := s.repo.GetDeviceByID(d.DeviceID)
+	s.NoError(err)
+	s.True(device.InsecureSkipVerify)
+	s.Equal("o'hare", lo.FromPtr(device.Site))
+
+	err = s.repo.UpdateDeviceSite(d.DeviceID, nil)
+	s.NoError(err)
+	device, err = s.repo.GetDeviceByID(d.DeviceID)
+	s.NoError(err)
+	s.Nil(device.Site)
+
+	err = s.repo.UpdateDeviceInsecureSkipVerify("unknown", true)
+	s.ErrorIs(err, repository.ErrRecordNotFound)
+	err = s.repo.UpdateDeviceSite("unknown", nil)
+	s.ErrorIs(err, repository.ErrRecordNotFound)
+}
+
 func (s *dbTestSuite) TestFindAndRestoreDevice() {
 	typeName := repository.Router
 	dt, err := s.repo.GetDeviceTypeByName(typeName)
@@ -192,6 +471,28 @@ func (s *dbTestSuite) TestFindAndRestoreDevice() {
 	s.Nil(dt.DeletedAt)
 }
 
+func (s *dbTestSuite) TestRestoreDevice() {
+	d := repository.Device{
+		DeviceID:   uuid.NewString(),
+		DeviceType: repository.Camera,
+		Hostname:   "localhost",
+		DeletedAt:  lo.ToPtr(time.Now()),
+	}
+	err := s.repo.CreateDevice(&d)
+	s.NoError(err)
+
+	err = s.repo.RestoreDevice(0)
+	s.Error(err)
+
+	err = s.repo.RestoreDevice(d.ID)
+	s.NoError(err)
+
+	device, err := s.repo.GetDeviceByID(d.DeviceID)
+	s.NoError(err)
+	s.NotNil(device)
+	s.Nil(device.DeletedAt)
+}
+
 func (s *dbTestSuite) TestGetDevicesByPage() {
 	var devices []*repository.Device
 	for range 1000 {
@@ -209,7 +510,7 @@ func (s *dbTestSuite) TestGetDevicesByPage() {
 	page := 89
 	size := 10
 	condition := fmt.Sprintf("device_type = '%s'", repository.Router)
-	got, total, err := s.repo.GetDevicesByPage(page, size, condition)
+	got, total, err := s.repo.GetDevicesByPage(page, size, condition, nil)
 	s.NoError(err)
 	s.Len(got, size)
 	s.Equal(1000, total)
@@ -220,13 +521,270 @@ func (s *dbTestSuite) TestGetDevicesByPage() {
 	s.Equal(uint(891), got[0].ID)
 
 	size = 100
-	got, total, err = s.repo.GetDevicesByPage(page, size, condition)
+	got, total, err = s.repo.GetDevicesByPage(page, size, condition, nil)
 	s.NoError(err)
 	s.Len(got, 0)
 }
 
+func (s *dbTestSuite) TestGetDevicesByPageOfDeviceTypes() {
+	var devices []*repository.Device
+	for i, dt := range []string{repository.Router, repository.Switch, repository.Camera} {
+		for range i + 2 {
+			devices = append(devices, &repository.Device{
+				DeviceID:   uuid.NewString(),
+				DeviceType: dt,
+				Hostname:   "localhost",
+			})
+		}
+	}
+	err := s.repo.CreateDevices(devices)
+	s.NoError(err)
+
+	params := map[string]any{"device_types": []string{repository.Router, repository.Camera}}
+	got, total, err := s.repo.GetDevicesByPage(0, 100, "device_type in @device_types", params)
+	s.NoError(err)
+	s.Equal(6, total)
+	s.Len(got, 6)
+	for _, d := range got {
+		s.Contains([]string{repository.Router, repository.Camera}, d.DeviceType)
+	}
+}
+
+func (s *dbTestSuite) TestCountDevicesByType() {
+	devices := []*repository.Device{
+		{DeviceID: uuid.NewString(), DeviceType: repository.Router, Hostname: "localhost", Protocols: pq.StringArray{"http"}},
+		{DeviceID: uuid.NewString(), DeviceType: repository.Router, Hostname: "localhost", Protocols: pq.StringArray{"http"}},
+		{DeviceID: uuid.NewString(), DeviceType: repository.Switch, Hostname: "localhost", Protocols: pq.StringArray{"http"}, DeletedAt: lo.ToPtr(time.Now())},
+	}
+	err := s.repo.CreateDevices(devices)
+	s.NoError(err)
+
+	counts, err := s.repo.CountDevicesByType()
+	s.NoError(err)
+	s.Equal(map[string]int{repository.Router: 2}, counts)
+}
+
+func (s *dbTestSuite) TestSaveWorkerHeartbeat() {
+	_, err := s.repo.GetLatestWorkerHeartbeat()
+	s.ErrorIs(err, repository.ErrRecordNotFound)
+
+	err = s.repo.SaveWorkerHeartbeat("")
+	s.Error(err)
+
+	workerID := uuid.NewString()
+	err = s.repo.SaveWorkerHeartbeat(workerID)
+	s.NoError(err)
+
+	first, err := s.repo.GetLatestWorkerHeartbeat()
+	s.NoError(err)
+	s.Equal(workerID, first.WorkerID)
+
+	time.Sleep(10 * time.Millisecond)
+	err = s.repo.SaveWorkerHeartbeat(workerID)
+	s.NoError(err)
+
+	second, err := s.repo.GetLatestWorkerHeartbeat()
+	s.NoError(err)
+	s.Equal(workerID, second.WorkerID)
+	s.True(second.UpdatedAt.After(first.UpdatedAt))
+	s.Equal(first.CreatedAt, second.CreatedAt)
+}
+
+func (s *dbTestSuite) TestDeleteDeviceHistory() {
+	devices := []*repository.Device{
+		{DeviceID: uuid.NewString(), DeviceType: repository.Router, Hostname: "localhost"},
+		{DeviceID: uuid.NewString(), DeviceType: repository.Switch, Hostname: "localhost"},
+	}
+	err := s.repo.CreateDevices(devices)
+	s.NoError(err)
+
+	now := time.Now()
+	var histories []*repository.PollingHistory
+	for _, d := range devices {
+		for i := range 3 {
+			histories = append(histories, &repository.PollingHistory{
+				DeviceID:      d.DeviceID,
+				PollingResult: repository.PollSucceed,
+				CreatedAt:     now.Add(-time.Duration(3-i) * time.Hour),
+			})
+		}
+	}
+	err = s.repo.CreatePollingHistories(histories)
+	s.NoError(err)
+
+	_, err = s.repo.DeleteDeviceHistory("", nil)
+	s.Error(err)
+
+	deleted, err := s.repo.DeleteDeviceHistory(devices[0].DeviceID, lo.ToPtr(now.Add(-90*time.Minute)))
+	s.NoError(err)
+	s.Equal(int64(2), deleted)
+
+	deleted, err = s.repo.DeleteDeviceHistory(devices[0].DeviceID, nil)
+	s.NoError(err)
+	s.Equal(int64(1), deleted)
+
+	got, err := s.repo.GetDevicePollingHistory(devices[0].DeviceID, 10)
+	s.NoError(err)
+	s.Empty(got)
+
+	got, err = s.repo.GetDevicePollingHistory(devices[1].DeviceID, 10)
+	s.NoError(err)
+	s.Len(got, 3)
+
+	device, err := s.repo.GetDeviceByID(devices[0].DeviceID)
+	s.NoError(err)
+	s.NotNil(device)
+}
+
+func (s *dbTestSuite) TestDeletePollingHistoryBefore() {
+	devices := []*repository.Device{
+		{DeviceID: uuid.NewString(), DeviceType: repository.Router, Hostname: "localhost"},
+		{DeviceID: uuid.NewString(), DeviceType: repository.Switch, Hostname: "localhost"},
+	}
+	err := s.repo.CreateDevices(devices)
+	s.NoError(err)
+
+	now := time.Now()
+	var histories []*repository.PollingHistory
+	for _, d := range devices {
+		for i := range 3 {
+			histories = append(histories, &repository.PollingHistory{
+				DeviceID:      d.DeviceID,
+				PollingResult: repository.PollSucceed,
+				CreatedAt:     now.Add(-time.Duration(3-i) * time.Hour),
+			})
+		}
+	}
+	err = s.repo.CreatePollingHistories(histories)
+	s.NoError(err)
+
+	_, err = s.repo.DeletePollingHistoryBefore(time.Time{})
+	s.Error(err)
+
+	deleted, err := s.repo.DeletePollingHistoryBefore(now.Add(-90 * time.Minute))
+	s.NoError(err)
+	s.Equal(int64(4), deleted)
+
+	for _, d := range devices {
+		got, err := s.repo.GetDevicePollingHistory(d.DeviceID, 10)
+		s.NoError(err)
+		s.Len(got, 1)
+	}
+}
+
+func (s *dbTestSuite) TestRollupPollingHistory() {
+	device := &repository.Device{DeviceID: uuid.NewString(), DeviceType: repository.Router, Hostname: "localhost"}
+	err := s.repo.CreateDevices([]*repository.Device{device})
+	s.NoError(err)
+
+	hour := time.Now().Truncate(time.Hour).Add(-3 * time.Hour)
+	histories := []*repository.PollingHistory{
+		{DeviceID: device.DeviceID, PollingResult: repository.PollSucceed, HwVersion: lo.ToPtr("hw1"), CreatedAt: hour.Add(5 * time.Minute)},
+		{DeviceID: device.DeviceID, PollingResult: repository.PollSucceed, HwVersion: lo.ToPtr("hw2"), CreatedAt: hour.Add(20 * time.Minute)},
+		{DeviceID: device.DeviceID, PollingResult: repository.PollFailed, CreatedAt: hour.Add(40 * time.Minute)},
+		{DeviceID: device.DeviceID, PollingResult: repository.PollFailed, CreatedAt: hour.Add(70 * time.Minute)},
+		{DeviceID: device.DeviceID, PollingResult: repository.PollSucceed, HwVersion: lo.ToPtr("hw3"), CreatedAt: hour.Add(150 * time.Minute)},
+	}
+	err = s.repo.CreatePollingHistories(histories)
+	s.NoError(err)
+
+	_, err = s.repo.RollupPollingHistory(time.Time{})
+	s.Error(err)
+
+	rolled, err := s.repo.RollupPollingHistory(hour.Add(2 * time.Hour))
+	s.NoError(err)
+	s.Equal(int64(4), rolled)
+
+	rollups, err := s.repo.GetPollingHistoryRollups(device.DeviceID, nil, nil)
+	s.NoError(err)
+	s.Require().Len(rollups, 2)
+	s.True(hour.Equal(rollups[0].BucketStart))
+	s.Equal(2, rollups[0].SucceededCount)
+	s.Equal(1, rollups[0].FailedCount)
+	s.Equal("hw2", lo.FromPtr(rollups[0].HwVersion))
+	s.True(hour.Add(time.Hour).Equal(rollups[1].BucketStart))
+	s.Equal(0, rollups[1].SucceededCount)
+	s.Equal(1, rollups[1].FailedCount)
+	s.Nil(rollups[1].HwVersion)
+
+	// only the polling history not rolled up remains
+	counts, err := s.repo.CountPollingHistory(device.DeviceID, nil, nil)
+	s.NoError(err)
+	s.Equal(repository.PollingCounts{Succeeded: 1}, counts)
+
+	// a later rollup merges into the rollups of the same hours
+	err = s.repo.CreatePollingHistories([]*repository.PollingHistory{
+		{DeviceID: device.DeviceID, PollingResult: repository.PollSucceed, HwVersion: lo.ToPtr("hw4"), CreatedAt: hour.Add(30 * time.Minute)},
+	})
+	s.NoError(err)
+	rolled, err = s.repo.RollupPollingHistory(hour.Add(2 * time.Hour))
+	s.NoError(err)
+	s.Equal(int64(1), rolled)
+
+	rollups, err = s.repo.GetPollingHistoryRollups(device.DeviceID, &hour, lo.ToPtr(hour.Add(time.Hour)))
+	s.NoError(err)
+	s.Require().Len(rollups, 1)
+	s.Equal(3, rollups[0].SucceededCount)
+	s.Equal(1, rollups[0].FailedCount)
+	s.Equal("hw4", lo.FromPtr(rollups[0].HwVersion))
+}
+
+func (s *dbTestSuite) TestDeletePollingHistoryRollups() {
+	devices := []*repository.Device{
+		{DeviceID: uuid.NewString(), DeviceType: repository.Router, Hostname: "localhost"},
+		{DeviceID: uuid.NewString(), DeviceType: repository.Switch, Hostname: "localhost"},
+	}
+	err := s.repo.CreateDevices(devices)
+	s.NoError(err)
+
+	hour := time.Now().Truncate(time.Hour).Add(-5 * time.Hour)
+	var histories []*repository.PollingHistory
+	for _, d := range devices {
+		for i := range 4 {
+			histories = append(histories, &repository.PollingHistory{
+				DeviceID:      d.DeviceID,
+				PollingResult: repository.PollSucceed,
+				CreatedAt:     hour.Add(time.Duration(i)*time.Hour + 10*time.Minute),
+			})
+		}
+	}
+	err = s.repo.CreatePollingHistories(histories)
+	s.NoError(err)
+	_, err = s.repo.RollupPollingHistory(hour.Add(4 * time.Hour))
+	s.NoError(err)
+
+	countRollups := func(deviceID string) int {
+		rollups, err := s.repo.GetPollingHistoryRollups(deviceID, nil, nil)
+		s.NoError(err)
+		return len(rollups)
+	}
+	s.Equal(4, countRollups(devices[0].DeviceID))
+
+	// only the rollups of the hours ended before the given time are removed along with the polling history
+	_, err = s.repo.DeleteDeviceHistory(devices[0].DeviceID, lo.ToPtr(hour.Add(90*time.Minute)))
+	s.NoError(err)
+	s.Equal(3, countRollups(devices[0].DeviceID))
+	s.Equal(4, countRollups(devices[1].DeviceID))
+
+	_, err = s.repo.DeletePollingHistoryBefore(hour.Add(2 * time.Hour))
+	s.NoError(err)
+	s.Equal(2, countRollups(devices[0].DeviceID))
+	s.Equal(2, countRollups(devices[1].DeviceID))
+
+	_, err = s.repo.DeletePollingHistoryRollupsBefore(time.Time{})
+	s.Error(err)
+	removed, err := s.repo.DeletePollingHistoryRollupsBefore(hour.Add(3 * time.Hour))
+	s.NoError(err)
+	s.Equal(int64(2), removed)
+
+	_, err = s.repo.DeleteDeviceHistory(devices[0].DeviceID, nil)
+	s.NoError(err)
+	s.Zero(countRollups(devices[0].DeviceID))
+	s.Equal(1, countRollups(devices[1].DeviceID))
+}
+
 func clearDB(db *gorm.DB) error {
-	s := strings.Join([]string{"devices", "polling_history"}, ",")
+	s := strings.Join([]string{"devices", "polling_history", "polling_history_rollup", "worker_heartbeats"}, ",")
 	q := fmt.Sprintf("truncate table %s restart identity cascade", s)
 	return db.Exec(q).Error
 }
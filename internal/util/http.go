@@ -3,6 +3,10 @@ package util
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,7 +14,9 @@ import (
 	"net/http"
 	"net/url"
 	"reflect"
+	"strings"
 
+	"example.poc/device-monitoring-system/internal/config"
 	"github.com/rs/zerolog/log"
 )
 
@@ -21,18 +27,30 @@ const (
 	URLEncoded
 )
 
-var ErrEmptyResponseBody = fmt.Errorf("empty response body")
+var (
+	ErrEmptyResponseBody       = fmt.Errorf("empty response body")
+	ErrResponseBodyTooLarge    = fmt.Errorf("response body too large")
+	ErrUnsupportedEncodeSchema = fmt.Errorf("unsupported EncodeSchema")
+	ErrUnsupportedDecodeSchema = fmt.Errorf("unsupported DecodeSchema")
+)
 
 type HTTPRequestParams struct {
-	Method       string
-	RequestURL   string
-	Header       http.Header
-	URLParams    url.Values
-	RequestBody  any
-	EncodeFunc   func(any) ([]byte, error)
-	EncodeSchema *SerializationSchema
-	DecodeFunc   func([]byte) (any, error)
-	DecodeSchema *SerializationSchema
+	Method         string
+	RequestURL     string
+	Header         http.Header
+	URLParams      url.Values
+	RequestBody    any
+	EncodeFunc     func(any) ([]byte, error)
+	EncodeSchema   *SerializationSchema
+	DecodeFunc     func([]byte) (any, error)
+	DecodeSchema   *SerializationSchema
+	AcceptStatus   func(int) bool // optional, tells whether a response status code is a success, 2xx by default
+	AllowEmptyBody bool           // optional, decodes an empty response body to the zero value instead of failing with ErrEmptyResponseBody
+	MaxBodySize    int64          // optional, fails with ErrResponseBodyTooLarge on a larger response body, no limit when 0
+	// StreamDecode decodes an accepted response body with a json.Decoder as it is read, instead of reading it all
+	// before decoding, so that a large body is not held twice in memory. Only JSON decoding is supported, and the Body of
+	// the response is left empty, so it is not available for diagnosing the errors either.
+	StreamDecode bool
 }
 
 type HTTPResponse[T any] struct {
@@ -53,6 +71,28 @@ func (err HTTPResponseError) Error() string {
 	return fmt.Sprintf("unexpected http response, code: %d, body: '%s', cause: %v", err.Code, err.Body, err.Cause)
 }
 
+// InsecureClient returns a copy of the client which skips the verification of the tls certificates of the servers,
+// e.g. of devices with self-signed certificates. The transport of the client must be an *http.Transport, or nil.
+func InsecureClient(c *http.Client) (*http.Client, error) {
+	var transport *http.Transport
+	switch t := c.Transport.(type) {
+	case nil:
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		transport = t.Clone()
+	default:
+		return nil, fmt.Errorf("unsupported transport %T of the http client", c.Transport)
+	}
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	transport.TLSClientConfig.InsecureSkipVerify = true
+
+	insecure := *c
+	insecure.Transport = transport
+	return &insecure, nil
+}
+
 func IsErr(err, target error) bool {
 	if errors.Is(err, target) {
 		return true
@@ -78,22 +118,29 @@ func (params HTTPRequestParams) validate() error {
 		switch *params.EncodeSchema {
 		case JSON, URLEncoded:
 		default:
-			return fmt.Errorf("unsupported EncodeSchema: %v", *params.EncodeSchema)
+			return fmt.Errorf("%w: %v", ErrUnsupportedEncodeSchema, *params.EncodeSchema)
 		}
 	}
 	if params.DecodeSchema != nil && *params.DecodeSchema != JSON {
-		return fmt.Errorf("unsupported DecodeSchema: %v", *params.DecodeSchema)
+		return fmt.Errorf("%w: %v", ErrUnsupportedDecodeSchema, *params.DecodeSchema)
+	}
+	if params.StreamDecode && params.DecodeSchema == nil {
+		return fmt.Errorf("StreamDecode requires DecodeSchema JSON")
+	}
+	if params.MaxBodySize < 0 {
+		return fmt.Errorf("field MaxBodySize cannot be negative")
 	}
 
 	return nil
 }
 
 func SendHttpRequest[T any](ctx context.Context, client *http.Client, params HTTPRequestParams) (*HTTPResponse[T], error) {
+	ctx = NonNilContext(ctx, "SendHttpRequest")
 	if client == nil {
 		return nil, fmt.Errorf("http client cannot be nil")
 	}
 	if err := params.validate(); err != nil {
-		return nil, fmt.Errorf("invalid argument HTTPRequestParams: %v", err)
+		return nil, fmt.Errorf("invalid argument HTTPRequestParams: %w", err)
 	}
 
 	reqURL := params.RequestURL
@@ -110,7 +157,16 @@ func SendHttpRequest[T any](ctx context.Context, client *http.Client, params HTT
 	if err != nil {
 		return nil, err
 	}
-	req.Header = params.Header
+	req.Header = params.Header.Clone()
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", config.HTTPUserAgent())
+	}
+	if tp := TraceParent(ctx); tp != "" && req.Header.Get(TraceParentHeader) == "" {
+		req.Header.Set(TraceParentHeader, tp)
+	}
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -120,21 +176,33 @@ func SendHttpRequest[T any](ctx context.Context, client *http.Client, params HTT
 		_ = resp.Body.Close()
 	}()
 
-	body, err := io.ReadAll(resp.Body)
+	var respBody io.Reader = resp.Body
+	if params.MaxBodySize > 0 {
+		respBody = &limitedReader{r: resp.Body, n: params.MaxBodySize}
+	}
+	acceptStatus := params.AcceptStatus
+	if acceptStatus == nil {
+		acceptStatus = Is2xx
+	}
+	if params.StreamDecode && acceptStatus(resp.StatusCode) {
+		return streamDecode[T](resp, respBody, params.AllowEmptyBody)
+	}
+
+	body, err := io.ReadAll(respBody)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read from response body: %v", err)
+		return nil, fmt.Errorf("failed to read from response body: %w", err)
 	}
-	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+	if !acceptStatus(resp.StatusCode) {
 		return nil, HTTPResponseError{
 			Code:   resp.StatusCode,
 			Header: resp.Header,
 			Body:   body,
-			Cause:  fmt.Errorf("non 2xx response"),
+			Cause:  fmt.Errorf("unaccepted response status"),
 		}
 	}
 
 	var t T
-	if params.DecodeSchema != nil || params.DecodeFunc != nil {
+	if (params.DecodeSchema != nil || params.DecodeFunc != nil) && !(len(body) == 0 && params.AllowEmptyBody) {
 		if len(body) == 0 {
 			return nil, HTTPResponseError{
 				Code:   resp.StatusCode,
@@ -145,7 +213,7 @@ func SendHttpRequest[T any](ctx context.Context, client *http.Client, params HTT
 		}
 		if params.DecodeSchema != nil {
 			if *params.DecodeSchema != JSON {
-				return nil, fmt.Errorf("unsupported DecodeSchema: only JSON decoding is supported")
+				return nil, fmt.Errorf("%w: only JSON decoding is supported", ErrUnsupportedDecodeSchema)
 			}
 			if err := json.Unmarshal(body, &t); err != nil {
 				return nil, HTTPResponseError{
@@ -184,6 +252,62 @@ func SendHttpRequest[T any](ctx context.Context, client *http.Client, params HTT
 	}, nil
 }
 
+// streamDecode decodes the json of an accepted response body as it is read
+func streamDecode[T any](resp *http.Response, body io.Reader, allowEmptyBody bool) (*HTTPResponse[T], error) {
+	var t T
+	err := json.NewDecoder(body).Decode(&t)
+	switch {
+	case errors.Is(err, io.EOF):
+		if !allowEmptyBody {
+			return nil, HTTPResponseError{
+				Code:   resp.StatusCode,
+				Header: resp.Header,
+				Cause:  ErrEmptyResponseBody,
+			}
+		}
+	case errors.Is(err, ErrResponseBodyTooLarge):
+		return nil, fmt.Errorf("failed to read from response body: %w", err)
+	case err != nil:
+		return nil, HTTPResponseError{
+			Code:   resp.StatusCode,
+			Header: resp.Header,
+			Cause:  fmt.Errorf("failed to json decode response body: %v", err),
+		}
+	}
+
+	return &HTTPResponse[T]{
+		Code:         resp.StatusCode,
+		Header:       resp.Header,
+		DecodedValue: t,
+	}, nil
+}
+
+// limitedReader fails with ErrResponseBodyTooLarge once more than n bytes are read, unlike io.LimitReader which
+// silently stops at n bytes
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, ErrResponseBodyTooLarge
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n, ErrResponseBodyTooLarge
+	}
+	return n, err
+}
+
+func Is2xx(code int) bool {
+	return code >= 200 && code <= 299
+}
+
 func getRequestBody(params HTTPRequestParams) (io.Reader, error) {
 	if mayHaveRequestBody(params.Method) && !IsNil(params.RequestBody) {
 		switch {
@@ -202,7 +326,7 @@ func getRequestBody(params HTTPRequestParams) (io.Reader, error) {
 					return nil, fmt.Errorf("RequestBody is expected to be of type url.Values when EncodeSchema is URLEncoded")
 				}
 			default:
-				return nil, fmt.Errorf("unsupported EncodeSchema: %v", *params.EncodeSchema)
+				return nil, fmt.Errorf("%w: %v", ErrUnsupportedEncodeSchema, *params.EncodeSchema)
 			}
 		case params.EncodeFunc != nil:
 			if bs, err := params.EncodeFunc(params.RequestBody); err != nil {
@@ -233,6 +357,63 @@ func ResponseAsJSON(w http.ResponseWriter, status int, a any) {
 	}
 }
 
+// ResponseAsJSONWithETag responds like ResponseAsJSON along with an ETag computed from the response body, or with 304
+// and no body when the ETag matches the If-None-Match header of the request
+func ResponseAsJSONWithETag(w http.ResponseWriter, r *http.Request, status int, a any) {
+	body, err := json.Marshal(a)
+	if err != nil {
+		log.Err(err).Msg("json encoding error")
+		ResponseAsJSON(w, status, a)
+		return
+	}
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = w.Write(append(body, '\n'))
+}
+
+// etagMatches tells whether the ETag is in the If-None-Match header, compared weakly as RFC 9110 requires
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireAdminToken guards the endpoints with the bearer token of ADMIN_API_TOKEN
+func RequireAdminToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if AuthorizeAdmin(w, r) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// AuthorizeAdmin tells whether the request bears the token of ADMIN_API_TOKEN, answering with the error otherwise
+func AuthorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	token := config.AdminAPIToken()
+	if token == "" {
+		http.Error(w, "endpoint is disabled, no admin token is configured", http.StatusForbidden)
+		return false
+	}
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
 func mayHaveRequestBody(method string) bool {
 	return method == http.MethodPost || method == http.MethodPut || method == http.MethodPatch
 }
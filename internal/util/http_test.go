@@ -0,0 +1,270 @@
+package util_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"example.poc/device-monitoring-system/internal/util"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+)
+
+type testPayload struct {
+	Name string `json:"name"`
+}
+
+func TestAcceptStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		util.ResponseAsJSON(w, http.StatusNonAuthoritativeInfo, testPayload{Name: "quirky"})
+	}))
+	defer server.Close()
+
+	params := util.HTTPRequestParams{
+		Method:       http.MethodGet,
+		RequestURL:   server.URL,
+		DecodeSchema: lo.ToPtr(util.JSON),
+		AcceptStatus: func(code int) bool {
+			return code == http.StatusOK || code == http.StatusNonAuthoritativeInfo
+		},
+	}
+	resp, err := util.SendHttpRequest[testPayload](context.Background(), http.DefaultClient, params)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNonAuthoritativeInfo, resp.Code)
+	assert.Equal(t, "quirky", resp.DecodedValue.Name)
+
+	params.AcceptStatus = func(code int) bool {
+		return code == http.StatusOK
+	}
+	_, err = util.SendHttpRequest[testPayload](context.Background(), http.DefaultClient, params)
+	var httpErr util.HTTPResponseError
+	assert.ErrorAs(t, err, &httpErr)
+	assert.Equal(t, http.StatusNonAuthoritativeInfo, httpErr.Code)
+
+	// 2xx by default
+	params.AcceptStatus = nil
+	_, err = util.SendHttpRequest[testPayload](context.Background(), http.DefaultClient, params)
+	assert.NoError(t, err)
+}
+
+func TestTraceParentPropagation(t *testing.T) {
+	var received string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get(util.TraceParentHeader)
+	}))
+	defer server.Close()
+
+	params := util.HTTPRequestParams{
+		Method:     http.MethodGet,
+		RequestURL: server.URL,
+	}
+	_, err := util.SendHttpRequest[any](context.Background(), http.DefaultClient, params)
+	assert.NoError(t, err)
+	assert.Empty(t, received)
+
+	tp := util.NewTraceParent()
+	assert.True(t, util.IsValidTraceParent(tp))
+	_, err = util.SendHttpRequest[any](util.WithTraceParent(context.Background(), tp), http.DefaultClient, params)
+	assert.NoError(t, err)
+	assert.Equal(t, tp, received)
+
+	for _, invalid := range []string{"", "00-abc-def-01", "00-00000000000000000000000000000000-b7ad6b7169203331-01"} {
+		assert.False(t, util.IsValidTraceParent(invalid), invalid)
+	}
+}
+
+func TestAllowEmptyBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	params := util.HTTPRequestParams{
+		Method:       http.MethodGet,
+		RequestURL:   server.URL,
+		DecodeSchema: lo.ToPtr(util.JSON),
+	}
+	_, err := util.SendHttpRequest[testPayload](context.Background(), http.DefaultClient, params)
+	var hErr util.HTTPResponseError
+	assert.ErrorAs(t, err, &hErr)
+	assert.ErrorIs(t, hErr.Cause, util.ErrEmptyResponseBody)
+
+	params.AllowEmptyBody = true
+	resp, err := util.SendHttpRequest[testPayload](context.Background(), http.DefaultClient, params)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, testPayload{}, resp.DecodedValue)
+}
+
+func TestStreamDecode(t *testing.T) {
+	type capability struct {
+		Protocol string `json:"protocol"`
+		Port     int    `json:"port"`
+	}
+	type healthCheck struct {
+		Capabilities []capability `json:"capabilities"`
+	}
+	large := healthCheck{Capabilities: make([]capability, 50000)}
+	for i := range large.Capabilities {
+		large.Capabilities[i] = capability{Protocol: fmt.Sprintf("protocol-%d", i), Port: i}
+	}
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		util.ResponseAsJSON(w, status, large)
+	}))
+	defer server.Close()
+
+	params := util.HTTPRequestParams{
+		Method:       http.MethodGet,
+		RequestURL:   server.URL,
+		DecodeSchema: lo.ToPtr(util.JSON),
+		StreamDecode: true,
+	}
+	resp, err := util.SendHttpRequest[healthCheck](context.Background(), http.DefaultClient, params)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, large, resp.DecodedValue)
+	assert.Empty(t, resp.Body)
+
+	// the size limit applies on both paths
+	params.MaxBodySize = 1024
+	_, err = util.SendHttpRequest[healthCheck](context.Background(), http.DefaultClient, params)
+	assert.ErrorIs(t, err, util.ErrResponseBodyTooLarge)
+	params.StreamDecode = false
+	_, err = util.SendHttpRequest[healthCheck](context.Background(), http.DefaultClient, params)
+	assert.ErrorIs(t, err, util.ErrResponseBodyTooLarge)
+
+	// an unaccepted response is still read for diagnosing it
+	status = http.StatusBadGateway
+	params.MaxBodySize = 0
+	params.StreamDecode = true
+	_, err = util.SendHttpRequest[healthCheck](context.Background(), http.DefaultClient, params)
+	var hErr util.HTTPResponseError
+	assert.ErrorAs(t, err, &hErr)
+	assert.Equal(t, http.StatusBadGateway, hErr.Code)
+	assert.NotEmpty(t, hErr.Body)
+
+	params.DecodeSchema = nil
+	_, err = util.SendHttpRequest[healthCheck](context.Background(), http.DefaultClient, params)
+	assert.ErrorContains(t, err, "StreamDecode")
+}
+
+func TestStreamDecodeEmptyBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	params := util.HTTPRequestParams{
+		Method:       http.MethodGet,
+		RequestURL:   server.URL,
+		DecodeSchema: lo.ToPtr(util.JSON),
+		StreamDecode: true,
+	}
+	_, err := util.SendHttpRequest[testPayload](context.Background(), http.DefaultClient, params)
+	var hErr util.HTTPResponseError
+	assert.ErrorAs(t, err, &hErr)
+	assert.ErrorIs(t, hErr.Cause, util.ErrEmptyResponseBody)
+
+	params.AllowEmptyBody = true
+	resp, err := util.SendHttpRequest[testPayload](context.Background(), http.DefaultClient, params)
+	assert.NoError(t, err)
+	assert.Equal(t, testPayload{}, resp.DecodedValue)
+}
+
+func TestNilContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		util.ResponseAsJSON(w, http.StatusOK, testPayload{Name: "background"})
+	}))
+	defer server.Close()
+
+	params := util.HTTPRequestParams{
+		Method:       http.MethodGet,
+		RequestURL:   server.URL,
+		DecodeSchema: lo.ToPtr(util.JSON),
+	}
+	var ctx context.Context
+	assert.NotPanics(t, func() {
+		resp, err := util.SendHttpRequest[testPayload](ctx, http.DefaultClient, params)
+		assert.NoError(t, err)
+		assert.Equal(t, "background", resp.DecodedValue.Name)
+	})
+}
+
+func TestUnsupportedSchema(t *testing.T) {
+	var requested bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = true
+	}))
+	defer server.Close()
+
+	_, err := util.SendHttpRequest[testPayload](context.Background(), http.DefaultClient, util.HTTPRequestParams{
+		Method:       http.MethodPost,
+		RequestURL:   server.URL,
+		RequestBody:  testPayload{Name: "unsupported"},
+		EncodeSchema: lo.ToPtr(util.SerializationSchema(42)),
+	})
+	assert.ErrorIs(t, err, util.ErrUnsupportedEncodeSchema)
+	assert.NotErrorIs(t, err, util.ErrUnsupportedDecodeSchema)
+
+	_, err = util.SendHttpRequest[testPayload](context.Background(), http.DefaultClient, util.HTTPRequestParams{
+		Method:       http.MethodGet,
+		RequestURL:   server.URL,
+		DecodeSchema: lo.ToPtr(util.URLEncoded),
+	})
+	assert.ErrorIs(t, err, util.ErrUnsupportedDecodeSchema)
+	assert.False(t, requested)
+}
+
+func TestInsecureClient(t *testing.T) {
+	c := &http.Client{}
+	insecure, err := util.InsecureClient(c)
+	assert.NoError(t, err)
+	assert.True(t, insecure.Transport.(*http.Transport).TLSClientConfig.InsecureSkipVerify)
+	assert.Nil(t, c.Transport)
+
+	_, err = util.InsecureClient(&http.Client{Transport: roundTripperFunc(http.DefaultTransport.RoundTrip)})
+	assert.Error(t, err)
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestResponseAsJSONWithETag(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		util.ResponseAsJSONWithETag(w, r, http.StatusOK, testPayload{Name: r.URL.Query().Get("name")})
+	})
+	get := func(name, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/?name="+name, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("foo", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"name":"foo"}`, w.Body.String())
+	etag := w.Header().Get("ETag")
+	assert.Regexp(t, `^"[0-9a-f]{32}"$`, etag)
+	assert.Equal(t, etag, get("foo", "").Header().Get("ETag"))
+
+	for _, ifNoneMatch := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+		w = get("foo", ifNoneMatch)
+		assert.Equal(t, http.StatusNotModified, w.Code, ifNoneMatch)
+		assert.Empty(t, w.Body.String())
+		assert.Equal(t, etag, w.Header().Get("ETag"))
+	}
+
+	w = get("bar", etag)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"name":"bar"}`, w.Body.String())
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+}
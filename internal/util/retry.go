@@ -0,0 +1,52 @@
+package util
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff grows the delay between retries exponentially, from BaseDelay up to MaxDelay
+type Backoff struct {
+	BaseDelay time.Duration
+	Factor    float64
+	MaxDelay  time.Duration
+}
+
+// Next returns the delay following the given one
+func (b Backoff) Next(delay time.Duration) time.Duration {
+	if delay >= b.MaxDelay {
+		return b.MaxDelay
+	}
+	n := float64(delay) * b.Factor
+	return time.Duration(math.Min(n, float64(b.MaxDelay)))
+}
+
+// Jitter returns a random duration between 0 and the delay, got idea from
+// https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
+func Jitter(delay time.Duration) time.Duration {
+	if delay <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(delay)))
+}
+
+// Retry calls fn until it succeeds, the retries are used up or the context is done, sleeping a jittered backoff
+// between the attempts. The error of the last attempt is returned.
+func Retry(ctx context.Context, retries int, backoff Backoff, fn func(ctx context.Context) error) error {
+	delay := backoff.BaseDelay
+	for attempt := 0; ; attempt++ {
+		err := fn(ctx)
+		if err == nil || attempt >= retries {
+			return err
+		}
+
+		delay = backoff.Next(delay)
+		select {
+		case <-time.After(Jitter(delay)):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package util
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
+
+// TraceParentHeader is the W3C trace context header correlating a management API call with the device requests it makes
+const TraceParentHeader = "traceparent"
+
+var traceParentRegexp = regexp.MustCompile(`^[0-9a-f]{2}-[0-9a-f]{32}-[0-9a-f]{16}-[0-9a-f]{2}$`)
+
+type traceParentKey struct{}
+
+func WithTraceParent(ctx context.Context, traceParent string) context.Context {
+	return context.WithValue(ctx, traceParentKey{}, traceParent)
+}
+
+// TraceParent returns the trace parent carried by the context, empty if there is none
+func TraceParent(ctx context.Context) string {
+	tp, _ := ctx.Value(traceParentKey{}).(string)
+	return tp
+}
+
+// NewTraceParent generates a trace parent of a new sampled trace
+func NewTraceParent() string {
+	traceID := make([]byte, 16)
+	spanID := make([]byte, 8)
+	_, _ = rand.Read(traceID)
+	_, _ = rand.Read(spanID)
+	return "00-" + hex.EncodeToString(traceID) + "-" + hex.EncodeToString(spanID) + "-01"
+}
+
+// IsValidTraceParent checks the format of a trace parent, the all zero trace and span ids are invalid
+func IsValidTraceParent(traceParent string) bool {
+	if !traceParentRegexp.MatchString(traceParent) || strings.HasPrefix(traceParent, "ff") {
+		return false
+	}
+	parts := strings.Split(traceParent, "-")
+	return strings.Trim(parts[1], "0") != "" && strings.Trim(parts[2], "0") != ""
+}
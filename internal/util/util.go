@@ -1,7 +1,11 @@
 package util
 
 import (
+	"context"
 	"encoding/json"
+	"net"
+	"strconv"
+	"strings"
 
 	"github.com/rs/zerolog/log"
 )
@@ -32,3 +36,33 @@ func FormatPath(path string) string {
 	}
 	return path
 }
+
+// CanonicalPath is the form of the REST paths stored on the devices and requested, with a leading slash and without
+// any trailing one, e.g. 'data', '/data' and '/data/' are all '/data'
+func CanonicalPath(path string) string {
+	return "/" + strings.Trim(path, "/")
+}
+
+// HostPort joins a hostname and a port into an address, IPv6 literals are bracketed whether or not they already are
+func HostPort(hostname string, port int) string {
+	hostname = strings.TrimSuffix(strings.TrimPrefix(hostname, "["), "]")
+	return net.JoinHostPort(hostname, strconv.Itoa(port))
+}
+
+// MaskSecret masks all but the first and the last characters of a secret, e.g. a device checksum
+func MaskSecret(secret string) string {
+	if len(secret) <= 2 {
+		return secret
+	}
+	return secret[:1] + strings.Repeat("*", len(secret)-2) + secret[len(secret)-1:]
+}
+
+// NonNilContext guards against a nil context passed by mistake, which would panic deep in net/http or grpc, by
+// replacing it with the background context
+func NonNilContext(ctx context.Context, caller string) context.Context {
+	if ctx == nil {
+		log.Warn().Msgf("nil context passed to %s, using the background context instead", caller)
+		return context.Background()
+	}
+	return ctx
+}
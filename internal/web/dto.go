@@ -3,8 +3,11 @@ package web
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"example.poc/device-monitoring-system/internal/api"
+	"example.poc/device-monitoring-system/internal/config"
+	"example.poc/device-monitoring-system/internal/repository"
 )
 
 type addDevicesRequest struct {
@@ -16,10 +19,18 @@ type addDevicesResponse struct {
 }
 
 type deviceInfo struct {
-	DeviceID        string `json:"device_id"`
-	DeviceType      string `json:"device_type"`
-	Hostname        string `json:"hostname"`
-	HealthCheckPort int    `json:"health_check_port"`
+	DeviceID          string  `json:"device_id"`
+	DeviceType        string  `json:"device_type"`
+	Hostname          string  `json:"hostname"`
+	HealthCheckPort   int     `json:"health_check_port"`
+	ExpectedFwVersion *string `json:"expected_fw_version,omitempty"`
+	// InsecureSkipVerify skips the verification of the tls certificate of a device polled over https, e.g. a
+	// self-signed one
+	InsecureSkipVerify bool    `json:"insecure_skip_verify,omitempty"`
+	Site               *string `json:"site,omitempty"`
+	// PollIntervalOverride polls the device on its own interval instead of the one of its device type, e.g. "10s"
+	PollIntervalOverride string `json:"poll_interval_override,omitempty"`
+	pollInterval         *time.Duration
 }
 
 type deviceAddingResult struct {
@@ -27,7 +38,23 @@ type deviceAddingResult struct {
 	DeviceType string `json:"device_type"`
 	Hostname   string `json:"hostname"`
 	Code       int    `json:"code"`
+	Outcome    string `json:"outcome,omitempty"`
 	Error      string `json:"error,omitempty"`
+	Warning    string `json:"warning,omitempty"`
+}
+
+type validateDevicesResponse struct {
+	Results []deviceValidationResult `json:"results"`
+}
+
+type deviceValidationResult struct {
+	DeviceID     string                  `json:"device_id"`
+	DeviceType   string                  `json:"device_type"`
+	Hostname     string                  `json:"hostname"`
+	Reachable    bool                    `json:"reachable"`
+	Capabilities []api.PollingCapability `json:"capabilities,omitempty"`
+	Code         int                     `json:"code"`
+	Error        string                  `json:"error,omitempty"`
 }
 
 func (info *deviceInfo) normalize() error {
@@ -37,6 +64,9 @@ func (info *deviceInfo) normalize() error {
 	if info.DeviceID == "" {
 		return fmt.Errorf("device_id cannot be empty")
 	}
+	if re := config.DeviceIDPattern(); re != nil && !re.MatchString(info.DeviceID) {
+		return fmt.Errorf("device_id %s does not match the pattern %s", info.DeviceID, re.String())
+	}
 	if info.DeviceType == "" {
 		return fmt.Errorf("device_type cannot be empty")
 	}
@@ -46,13 +76,162 @@ func (info *deviceInfo) normalize() error {
 	if info.HealthCheckPort < 0 || info.HealthCheckPort > 65535 {
 		return fmt.Errorf("health_check_port must be between 0 and 65535")
 	}
+	if info.ExpectedFwVersion != nil && strings.TrimSpace(*info.ExpectedFwVersion) == "" {
+		info.ExpectedFwVersion = nil
+	}
 
 	return nil
 }
 
 type deviceListingResponse struct {
-	Page  int                      `json:"page"`
-	Size  int                      `json:"size"`
-	Total int                      `json:"total"`
-	Items []*api.DeviceDiagnostics `json:"items,omitempty"`
+	Page     int                            `json:"page"`
+	Size     int                            `json:"size"`
+	Total    int                            `json:"total"`
+	Items    []*api.DeviceDiagnostics       `json:"items,omitempty"`
+	Warnings []api.DeviceDiagnosticsFailure `json:"warnings,omitempty"`
+	CachedAt *api.Timestamp                 `json:"cached_at,omitempty"`
+}
+
+type deviceSummary struct {
+	DeviceID      string           `json:"device_id"`
+	Connectivity  api.Connectivity `json:"connectivity"`
+	LastCheckedAt *api.Timestamp   `json:"last_checked_at,omitempty"`
+}
+
+type deviceSummaryListingResponse struct {
+	Page     int                            `json:"page"`
+	Size     int                            `json:"size"`
+	Total    int                            `json:"total"`
+	Items    []deviceSummary                `json:"items,omitempty"`
+	Warnings []api.DeviceDiagnosticsFailure `json:"warnings,omitempty"`
+	CachedAt *api.Timestamp                 `json:"cached_at,omitempty"`
+}
+
+type workerStatusResponse struct {
+	Alive           bool           `json:"alive"`
+	WorkerID        string         `json:"worker_id,omitempty"`
+	LastHeartbeatAt *api.Timestamp `json:"last_heartbeat_at,omitempty"`
+}
+
+type deviceChangesResponse struct {
+	DeviceID string             `json:"device_id"`
+	From     *api.Timestamp     `json:"from,omitempty"`
+	To       *api.Timestamp     `json:"to,omitempty"`
+	Items    []api.DeviceChange `json:"items"`
+}
+
+type deviceStatsResponse struct {
+	DeviceID     string         `json:"device_id"`
+	From         *api.Timestamp `json:"from,omitempty"`
+	To           *api.Timestamp `json:"to,omitempty"`
+	Succeeded    int            `json:"succeeded"`
+	Failed       int            `json:"failed"`
+	RolledUp     int            `json:"rolled_up"`
+	SuccessRatio float64        `json:"success_ratio"`
+}
+
+type deleteDeviceHistoryResponse struct {
+	DeviceID string         `json:"device_id"`
+	Before   *api.Timestamp `json:"before,omitempty"`
+	Deleted  int64          `json:"deleted"`
+}
+
+type pruneHistoryResponse struct {
+	Before  *api.Timestamp `json:"before"`
+	Deleted int64          `json:"deleted"`
+}
+
+// an empty expected firmware version clears the expectation
+type expectedFwVersionRequest struct {
+	ExpectedFwVersion *string `json:"expected_fw_version"`
+}
+
+type patchDeviceRequest struct {
+	ExpectedFwVersion    *string `json:"expected_fw_version"`
+	PollIntervalOverride *string `json:"poll_interval_override"`
+}
+
+type pollNowResponse struct {
+	JobID      string `json:"job_id"`
+	DeviceType string `json:"device_type"`
+	MaxDevices int    `json:"max_devices"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type flappingDevicesResponse struct {
+	Window         string           `json:"window"`
+	MinTransitions int              `json:"min_transitions"`
+	Items          []flappingDevice `json:"items"`
+}
+
+type flappingDevice struct {
+	DeviceID    string `json:"device_id"`
+	Transitions int    `json:"transitions"`
+}
+
+type deletedDevicesResponse struct {
+	Page  int             `json:"page"`
+	Size  int             `json:"size"`
+	Total int             `json:"total"`
+	Items []deletedDevice `json:"items"`
+}
+
+type deletedDevice struct {
+	DeviceID      string        `json:"device_id"`
+	DeviceType    string        `json:"device_type"`
+	Hostname      string        `json:"hostname"`
+	DeletedAt     api.Timestamp `json:"deleted_at"`
+	DeletedReason *string       `json:"deleted_reason,omitempty"`
+	DeletedBy     *string       `json:"deleted_by,omitempty"`
+}
+
+type sloResponse struct {
+	Score       float64               `json:"score"`
+	Threshold   float64               `json:"threshold"`
+	Breached    bool                  `json:"breached"`
+	DeviceTypes []deviceTypeFreshness `json:"device_types"`
+}
+
+type deviceTypeFreshness struct {
+	DeviceType string  `json:"device_type"`
+	Total      int     `json:"total"`
+	Fresh      int     `json:"fresh"`
+	Score      float64 `json:"score"`
+}
+
+type devicesSummaryResponse struct {
+	Total       int                      `json:"total"`
+	DeviceTypes []deviceTypeConnectivity `json:"device_types"`
+}
+
+type deviceTypeConnectivity struct {
+	DeviceType   string                   `json:"device_type"`
+	Total        int                      `json:"total"`
+	Connectivity map[api.Connectivity]int `json:"connectivity"`
+}
+
+type siteDiagnosticsResponse struct {
+	Site         string                   `json:"site"`
+	Total        int                      `json:"total"`
+	Connectivity map[api.Connectivity]int `json:"connectivity"`
+	Items        []*api.DeviceDiagnostics `json:"items"`
+}
+
+// rawPollingRecord is a polling history as stored
+type rawPollingRecord struct {
+	ID             uint                     `json:"id"`
+	DeviceID       string                   `json:"device_id"`
+	HwVersion      *string                  `json:"hw_version"`
+	SwVersion      *string                  `json:"sw_version"`
+	FwVersion      *string                  `json:"fw_version"`
+	DeviceStatus   *string                  `json:"device_status"`
+	DeviceChecksum *string                  `json:"device_checksum"`
+	PollingResult  repository.PollingResult `json:"polling_result"`
+	FailureReason  *string                  `json:"failure_reason"`
+	GrpcCode       *int                     `json:"grpc_code"`
+	LatencyMs      *int                     `json:"latency_ms"`
+	CreatedAt      api.Timestamp            `json:"created_at"`
 }
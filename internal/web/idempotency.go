@@ -0,0 +1,192 @@
+package web
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	idempotencyKeyHeader      = "Idempotency-Key"
+	idempotentReplayedHeader  = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+	maxIdempotentRequestBytes = 10 << 20
+)
+
+var (
+	errIdempotencyKeyReused     = errors.New("idempotency key is already used by a different request")
+	errIdempotencyKeyInProgress = errors.New("a request with the same idempotency key is in progress")
+)
+
+// idempotencyCache remembers the successful responses of the requests made with an Idempotency-Key header for a
+// while, so that a retried request is answered with the same response instead of being processed again. At most
+// maxEntries keys are kept, the oldest ones are evicted to make room for the new ones.
+type idempotencyCache struct {
+	ttl        time.Duration
+	maxEntries int
+	lock       sync.Mutex
+	entries    map[string]*idempotentResponse
+}
+
+type idempotentResponse struct {
+	fingerprint string // the hash of the request body, the key cannot be reused for another request
+	done        bool   // whether the response is recorded, the request is in progress otherwise
+	status      int
+	contentType string
+	body        []byte
+	createdAt   time.Time
+	expiresAt   time.Time
+}
+
+func newIdempotencyCache(ttl time.Duration, maxEntries int) *idempotencyCache {
+	return &idempotencyCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*idempotentResponse),
+	}
+}
+
+// begin returns the response recorded for the key, or nil when the request is new, in which case the caller has to
+// either finish or abandon the key
+func (c *idempotencyCache) begin(key, fingerprint string) (*idempotentResponse, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	for k, e := range c.entries {
+		if e.done && now.After(e.expiresAt) {
+			delete(c.entries, k)
+		}
+	}
+
+	e, ok := c.entries[key]
+	if !ok {
+		if len(c.entries) >= c.maxEntries {
+			c.evictOldest()
+		}
+		c.entries[key] = &idempotentResponse{fingerprint: fingerprint, createdAt: now}
+		return nil, nil
+	}
+	if e.fingerprint != fingerprint {
+		return nil, errIdempotencyKeyReused
+	}
+	if !e.done {
+		return nil, errIdempotencyKeyInProgress
+	}
+	return e, nil
+}
+
+// evictOldest removes the oldest recorded response, or the oldest request in progress when none is recorded yet
+func (c *idempotencyCache) evictOldest() {
+	var oldest string
+	var oldestDone bool
+	var oldestAt time.Time
+	for k, e := range c.entries {
+		if oldest == "" || (e.done && !oldestDone) || (e.done == oldestDone && e.createdAt.Before(oldestAt)) {
+			oldest, oldestDone, oldestAt = k, e.done, e.createdAt
+		}
+	}
+	delete(c.entries, oldest)
+}
+
+func (c *idempotencyCache) finish(key string, status int, contentType string, body []byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		e.done = true
+		e.status = status
+		e.contentType = contentType
+		e.body = body
+		e.expiresAt = time.Now().Add(c.ttl)
+	}
+}
+
+// abandon forgets the key of a failed request, so that it can be retried with the same key
+func (c *idempotencyCache) abandon(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	delete(c.entries, key)
+}
+
+// middleware replays the recorded response of a request made again with the same Idempotency-Key header. The requests
+// without the header, or all of them when the cache is disabled, are passed through.
+func (c *idempotencyCache) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if c == nil || key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			http.Error(w, "idempotency key is too long", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentRequestBytes))
+		if err != nil {
+			http.Error(w, "failed to read request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(append([]byte(r.Method+" "+r.URL.Path+"\n"), body...))
+
+		recorded, err := c.begin(key, hex.EncodeToString(sum[:]))
+		if errors.Is(err, errIdempotencyKeyReused) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if errors.Is(err, errIdempotencyKeyInProgress) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if recorded != nil {
+			w.Header().Set("Content-Type", recorded.contentType)
+			w.Header().Set(idempotentReplayedHeader, "true")
+			w.WriteHeader(recorded.status)
+			_, _ = w.Write(recorded.body)
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		completed := false
+		defer func() {
+			// only the successful responses are replayed, a failed request is processed again when retried
+			if completed && rec.status >= 200 && rec.status < 300 {
+				c.finish(key, rec.status, rec.Header().Get("Content-Type"), rec.body.Bytes())
+			} else {
+				c.abandon(key)
+			}
+		}()
+		next.ServeHTTP(rec, r)
+		completed = true
+	})
+}
+
+// responseRecorder writes the response through while keeping a copy of its status and body
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	if !rr.wroteHeader {
+		rr.status = status
+		rr.wroteHeader = true
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	rr.wroteHeader = true
+	rr.body.Write(b)
+	return rr.ResponseWriter.Write(b)
+}
@@ -6,6 +6,8 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -17,22 +19,47 @@ import (
 	"example.poc/device-monitoring-system/internal/repository"
 	"example.poc/device-monitoring-system/internal/util"
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 	"github.com/rs/zerolog"
 	"github.com/samber/lo"
 )
 
-const defaultHistoryCheckingSize = 20
+const (
+	defaultHistoryCheckingSize = 20
+	maxDeletedReasonLength     = 500
+	// actorHeader names the user on whose behalf a request is made, as set by the authenticating proxy
+	actorHeader = "X-Actor"
+
+	viewFull    = "full"
+	viewSummary = "summary"
+
+	contentTypeNDJSON = "application/x-ndjson"
+	// ndjsonFlushSize is the number of devices streamed between flushes of the response
+	ndjsonFlushSize = 100
+)
 
 type Router struct {
 	httpClint *http.Client
-	repo      repository.IRepository
-	psy       api.IPollingStrategy
-	router    chi.Router
+	// insecureClint skips the verification of the tls certificates, for the devices added with insecure_skip_verify
+	insecureClint *http.Client
+	repo          repository.IRepository
+	psy           api.IPollingStrategy
+	cache         *business.DiagnosticsCache
+	idempotency   *idempotencyCache
+	router        chi.Router
 }
 
 type HTTPClientOptions func(*http.Client)
 
 func NewRouter(opts ...HTTPClientOptions) (*Router, error) {
+	psy, err := api.NewPollingStrategy(config.PollingStrategy())
+	if err != nil {
+		return nil, fmt.Errorf("invalid POLLING_STRATEGY: %w", err)
+	}
+	if err := api.ValidatePollingStrategy(psy, repository.KnownDeviceTypes); err != nil {
+		return nil, fmt.Errorf("invalid polling strategy: %w", err)
+	}
+
 	repo, err := repository.NewRepository(config.DatabaseURL())
 	if err != nil {
 		return nil, fmt.Errorf("failed to get db connection: %w", err)
@@ -43,10 +70,22 @@ func NewRouter(opts ...HTTPClientOptions) (*Router, error) {
 		opt(c)
 	}
 
+	insecure, err := util.InsecureClient(c)
+	if err != nil {
+		return nil, err
+	}
+
 	r := &Router{
-		repo:      repo,
-		psy:       &api.DefaultPollingStrategy{},
-		httpClint: c,
+		repo:          repo,
+		psy:           psy,
+		httpClint:     c,
+		insecureClint: insecure,
+	}
+	if config.DiagnosticsCacheInterval() > 0 {
+		r.cache = business.NewDiagnosticsCache()
+	}
+	if ttl := config.IdempotencyKeyTTL(); ttl > 0 {
+		r.idempotency = newIdempotencyCache(ttl, config.IdempotencyMaxEntries())
 	}
 	r.router = r.getHandler()
 
@@ -55,14 +94,71 @@ func NewRouter(opts ...HTTPClientOptions) (*Router, error) {
 
 func (ro *Router) getHandler() chi.Router {
 	mux := chi.NewRouter()
-	mux.Put("/devices", ro.handleAddDevices)
+	mux.Use(traceParent)
+	mux.With(ro.idempotency.middleware).Put("/devices", ro.handleAddDevices)
+	mux.Post("/devices:validate", ro.handleValidateDevices)
 	mux.Delete("/devices/{device_id}", ro.handleDeleteDevice)
+	mux.Post("/devices/{device_id}/restore", ro.handleRestoreDevice)
+	mux.Get("/devices/flapping", ro.handleGetFlappingDevices)
+	mux.Get("/devices/summary", ro.handleGetDevicesSummary)
+	mux.Get("/devices/search", ro.handleSearchDevices)
+	mux.Get("/devices/deleted", ro.handleListingDeletedDevices)
 	mux.Get("/devices/{device_id}", ro.handleGetDeviceByID)
+	mux.Patch("/devices/{device_id}", ro.handlePatchDevice)
+	mux.Get("/devices/{device_id}/changes", ro.handleGetDeviceChanges)
+	mux.Get("/devices/{device_id}/raw", ro.handleGetDeviceRawRecord)
+	mux.Get("/devices/{device_id}/stats", ro.handleGetDeviceStats)
+	mux.With(util.RequireAdminToken).Delete("/devices/{device_id}/history", ro.handleDeleteDeviceHistory)
 	mux.Get("/devices", ro.handleListingDevices)
+	mux.Patch("/device-types/{device_type}", ro.handlePatchDeviceType)
+	mux.Post("/device-types/{device_type}/poll-now", ro.handlePollNow)
+	mux.Post("/device-types/{device_type}/enable", ro.handleToggleDeviceType(true))
+	mux.Post("/device-types/{device_type}/disable", ro.handleToggleDeviceType(false))
+	mux.Get("/worker/status", ro.handleGetWorkerStatus)
+	mux.With(util.RequireAdminToken).Post("/admin/prune-history", ro.handlePruneHistory)
+	mux.Get("/sites/{site}/diagnostics", ro.handleGetSiteDiagnostics)
+	mux.Get("/slo", ro.handleGetSLO)
+	mux.NotFound(handleNotFound)
+	mux.MethodNotAllowed(handleMethodNotAllowed(mux))
 
 	return mux
 }
 
+func handleNotFound(w http.ResponseWriter, r *http.Request) {
+	util.ResponseAsJSON(w, http.StatusNotFound, errorResponse{Error: fmt.Sprintf("path %s not found", r.URL.Path)})
+}
+
+// handleMethodNotAllowed answers with the methods the path supports in the 'Allow' header
+func handleMethodNotAllowed(routes chi.Routes) http.HandlerFunc {
+	methods := []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+	return func(w http.ResponseWriter, r *http.Request) {
+		allowed := lo.Filter(methods, func(method string, _ int) bool {
+			return routes.Match(chi.NewRouteContext(), method, r.URL.Path)
+		})
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		util.ResponseAsJSON(w, http.StatusMethodNotAllowed, errorResponse{
+			Error: fmt.Sprintf("method %s is not allowed on path %s", r.Method, r.URL.Path),
+		})
+	}
+}
+
+// StartDiagnosticsReconciler refreshes the cached device diagnostics periodically until the context is cancelled,
+// it does nothing when the cache is disabled
+func (ro *Router) StartDiagnosticsReconciler(ctx context.Context) {
+	if ro.cache == nil {
+		return
+	}
+	go ro.cache.Run(ctx, config.DiagnosticsCacheInterval(), ro.repo, defaultHistoryCheckingSize, ro.psy)
+}
+
+// client returns the http client to check the health of a device with
+func (ro *Router) client(device deviceInfo) *http.Client {
+	if device.InsecureSkipVerify && ro.insecureClint != nil {
+		return ro.insecureClint
+	}
+	return ro.httpClint
+}
+
 func (ro *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ro.router.ServeHTTP(w, r)
 }
@@ -73,6 +169,10 @@ func (ro *Router) handleGetDeviceByID(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "device_id is required", http.StatusBadRequest)
 		return
 	}
+	unmask, ok := parseUnmask(w, r)
+	if !ok {
+		return
+	}
 
 	deviceId = strings.ReplaceAll(deviceId, " ", "")
 	device, err := ro.repo.GetDeviceByID(deviceId)
@@ -85,60 +185,546 @@ func (ro *Router) handleGetDeviceByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	dia, err := business.GetDeviceDiagnostic(ro.repo, *device, defaultHistoryCheckingSize, ro.psy)
+	dt, err := ro.repo.GetDeviceTypeByName(device.DeviceType)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to get device type: %v", err), http.StatusInternalServerError)
+		return
+	}
+	dia, err := business.GetDeviceDiagnostic(ro.repo, *device, dt, defaultHistoryCheckingSize, ro.psy)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("failed to get device diagnostics: %v", err), http.StatusInternalServerError)
 		return
 	}
+	if !unmask {
+		dia = maskChecksum(dia)
+	}
+
+	util.ResponseAsJSONWithETag(w, r, http.StatusOK, *dia)
+}
+
+// handleGetDeviceRawRecord returns the latest polling history of a device as stored, for debugging. The checksum is
+// masked unless it is asked with unmask=true along with the admin token.
+func (ro *Router) handleGetDeviceRawRecord(w http.ResponseWriter, r *http.Request) {
+	deviceId := chi.URLParam(r, "device_id")
+	unmask, ok := parseUnmask(w, r)
+	if !ok {
+		return
+	}
+
+	device, err := ro.repo.GetDeviceByID(deviceId)
+	if errors.Is(err, repository.ErrRecordNotFound) || device == nil {
+		http.Error(w, "device not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to get device: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	histories, err := ro.repo.GetDevicePollingHistory(deviceId, 1)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to get device polling history: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if len(histories) == 0 {
+		http.Error(w, "device has no polling history", http.StatusNotFound)
+		return
+	}
+
+	h := histories[0]
+	if !unmask && h.DeviceChecksum != nil {
+		h.DeviceChecksum = lo.ToPtr(util.MaskSecret(*h.DeviceChecksum))
+	}
+	util.ResponseAsJSON(w, http.StatusOK, rawPollingRecord{
+		ID:             h.ID,
+		DeviceID:       h.DeviceID,
+		HwVersion:      h.HwVersion,
+		SwVersion:      h.SwVersion,
+		FwVersion:      h.FwVersion,
+		DeviceStatus:   h.DeviceStatus,
+		DeviceChecksum: h.DeviceChecksum,
+		PollingResult:  h.PollingResult,
+		FailureReason:  h.FailureReason,
+		GrpcCode:       h.GrpcCode,
+		LatencyMs:      h.LatencyMs,
+		CreatedAt:      api.Timestamp{Time: h.CreatedAt},
+	})
+}
+
+// handleGetDeviceChanges returns the changes of a device between its successful polls. The checksum changes are
+// masked unless they are asked with unmask=true along with the admin token.
+func (ro *Router) handleGetDeviceChanges(w http.ResponseWriter, r *http.Request) {
+	deviceId := chi.URLParam(r, "device_id")
+	if deviceId == "" {
+		http.Error(w, "device_id is required", http.StatusBadRequest)
+		return
+	}
+	unmask, ok := parseUnmask(w, r)
+	if !ok {
+		return
+	}
+
+	q := r.URL.Query()
+	from, err := parseTimeParam(q, "from")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := parseTimeParam(q, "to")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if from != nil && to != nil && !from.Before(*to) {
+		http.Error(w, "from must be before to", http.StatusBadRequest)
+		return
+	}
+
+	deviceId = strings.ReplaceAll(deviceId, " ", "")
+	device, err := ro.repo.GetDeviceByID(deviceId)
+	if errors.Is(err, repository.ErrRecordNotFound) || device == nil {
+		http.Error(w, "device not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to get device: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	changes, err := business.GetDeviceChanges(ro.repo, device.DeviceID, from, to)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to get device changes: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !unmask {
+		changes = maskChecksumChanges(changes)
+	}
+
+	util.ResponseAsJSON(w, http.StatusOK, deviceChangesResponse{
+		DeviceID: device.DeviceID,
+		From:     api.NewTimestamp(from),
+		To:       api.NewTimestamp(to),
+		Items:    changes,
+	})
+}
+
+func (ro *Router) handleGetDeviceStats(w http.ResponseWriter, r *http.Request) {
+	deviceId := chi.URLParam(r, "device_id")
+	if deviceId == "" {
+		http.Error(w, "device_id is required", http.StatusBadRequest)
+		return
+	}
+
+	q := r.URL.Query()
+	from, err := parseTimeParam(q, "from")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := parseTimeParam(q, "to")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if from != nil && to != nil && !from.Before(*to) {
+		http.Error(w, "from must be before to", http.StatusBadRequest)
+		return
+	}
+
+	deviceId = strings.ReplaceAll(deviceId, " ", "")
+	device, err := ro.repo.GetDeviceByID(deviceId)
+	if errors.Is(err, repository.ErrRecordNotFound) || device == nil {
+		http.Error(w, "device not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to get device: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	stats, err := business.GetDevicePollingStats(ro.repo, device.DeviceID, from, to)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to get device stats: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	util.ResponseAsJSON(w, http.StatusOK, deviceStatsResponse{
+		DeviceID:     device.DeviceID,
+		From:         api.NewTimestamp(from),
+		To:           api.NewTimestamp(to),
+		Succeeded:    stats.Succeeded,
+		Failed:       stats.Failed,
+		RolledUp:     stats.RolledUp,
+		SuccessRatio: stats.SuccessRatio(),
+	})
+}
+
+func (ro *Router) handleGetFlappingDevices(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	window, err := parseDurationParam(q, "window", time.Hour)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	minTransitions := 4
+	if v := q.Get("min_transitions"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid min_transitions, expecting a positive integer", http.StatusBadRequest)
+			return
+		}
+		minTransitions = n
+	}
+
+	devices, err := ro.repo.GetFlappingDevices(time.Now().Add(-window), minTransitions)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to get flapping devices: %v", err), http.StatusInternalServerError)
+		return
+	}
 
-	util.ResponseAsJSON(w, http.StatusOK, *dia)
+	util.ResponseAsJSON(w, http.StatusOK, flappingDevicesResponse{
+		Window:         window.String(),
+		MinTransitions: minTransitions,
+		Items: lo.Map(devices, func(d repository.FlappingDevice, _ int) flappingDevice {
+			return flappingDevice{
+				DeviceID:    d.DeviceID,
+				Transitions: d.Transitions,
+			}
+		}),
+	})
 }
 
 func (ro *Router) handleListingDevices(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query()
-	paramPage := q.Get("page")
-	paramSize := q.Get("size")
 	paramDt := q.Get("device_type")
+	paramNeverPolled := q.Get("never_polled")
+	paramFresh := q.Get("fresh")
+	paramView := q.Get("view")
 
-	var page, size int
-	var err error
-	if paramPage == "" {
-		page = 0
-	} else {
-		page, err = strconv.Atoi(paramPage)
-		if err != nil || page < 0 {
-			http.Error(w, "invalid page number", http.StatusBadRequest)
+	page, size, err := parsePagination(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if paramView != "" && paramView != viewFull && paramView != viewSummary {
+		http.Error(w, fmt.Sprintf("invalid view value, must be one of: %s, %s", viewFull, viewSummary), http.StatusBadRequest)
+		return
+	}
+	unmask, ok := parseUnmask(w, r)
+	if !ok {
+		return
+	}
+
+	filter := business.DeviceFilter{}
+	if paramDt != "" {
+		// a comma separated list of device types, e.g. router,switch
+		for _, dt := range strings.Split(paramDt, ",") {
+			if dt = strings.TrimSpace(dt); dt == "" {
+				http.Error(w, "invalid device_type value, expecting comma separated non-empty device types", http.StatusBadRequest)
+				return
+			}
+			filter.DeviceTypes = append(filter.DeviceTypes, dt)
+		}
+	}
+	if paramNeverPolled != "" {
+		filter.NeverPolled, err = strconv.ParseBool(paramNeverPolled)
+		if err != nil {
+			http.Error(w, "invalid never_polled value", http.StatusBadRequest)
 			return
 		}
 	}
 
-	if paramSize == "" {
-		size = 30
-	} else {
-		size, err = strconv.Atoi(paramSize)
-		if err != nil || size <= 0 {
-			http.Error(w, "invalid size number", http.StatusBadRequest)
+	cache := ro.cache
+	if paramFresh != "" {
+		fresh, err := strconv.ParseBool(paramFresh)
+		if err != nil {
+			http.Error(w, "invalid fresh value", http.StatusBadRequest)
 			return
 		}
-		if size > 1000 {
-			http.Error(w, "size number is too large", http.StatusBadRequest)
-			return
+		if fresh {
+			cache = nil
 		}
 	}
 
-	dias, total, err := business.GetListOfDevicesDiagnostics(r.Context(), ro.repo, defaultHistoryCheckingSize, ro.psy, page, size, paramDt)
+	if acceptsNDJSON(r) {
+		ro.streamDevicesDiagnostics(w, r, cache, filter, unmask)
+		return
+	}
+
+	dias, failures, total, err := business.GetListOfDevicesDiagnostics(r.Context(), ro.repo, cache, defaultHistoryCheckingSize, ro.psy, page, size, filter)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("failed to get devices diagnostics: %v", err), http.StatusInternalServerError)
 		return
 	}
+	if !unmask {
+		dias = lo.Map(dias, func(d *api.DeviceDiagnostics, _ int) *api.DeviceDiagnostics { return maskChecksum(d) })
+	}
+
+	if paramView == viewSummary {
+		util.ResponseAsJSON(w, http.StatusOK, deviceSummaryListingResponse{
+			Page:  page,
+			Size:  size,
+			Total: total,
+			Items: lo.Map(dias, func(d *api.DeviceDiagnostics, _ int) deviceSummary {
+				return deviceSummary{DeviceID: d.DeviceID, Connectivity: d.Connectivity, LastCheckedAt: d.LastCheckedAt}
+			}),
+			Warnings: failures,
+			CachedAt: api.NewTimestamp(cache.RefreshedAt()),
+		})
+		return
+	}
 
 	resp := deviceListingResponse{
+		Page:     page,
+		Size:     size,
+		Total:    total,
+		Items:    dias,
+		Warnings: failures,
+		CachedAt: api.NewTimestamp(cache.RefreshedAt()),
+	}
+	util.ResponseAsJSON(w, http.StatusOK, resp)
+}
+
+// streamDevicesDiagnostics writes the diagnostics of all the devices matching the filter as newline delimited json,
+// regardless of the pagination, flushing them batch by batch as they are computed
+func (ro *Router) streamDevicesDiagnostics(w http.ResponseWriter, r *http.Request, cache *business.DiagnosticsCache, filter business.DeviceFilter, unmask bool) {
+	w.Header().Set("Content-Type", contentTypeNDJSON)
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	streamed := 0
+	err := business.StreamDevicesDiagnostics(r.Context(), ro.repo, cache, defaultHistoryCheckingSize, ro.psy, filter, func(dia *api.DeviceDiagnostics) error {
+		if !unmask {
+			dia = maskChecksum(dia)
+		}
+		if err := enc.Encode(dia); err != nil {
+			return err
+		}
+		if streamed++; streamed%ndjsonFlushSize == 0 {
+			return rc.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		// the status is already sent, the client sees a truncated stream
+		zerolog.Ctx(r.Context()).Err(err).Msgf("failed to stream devices diagnostics after %d devices", streamed)
+		return
+	}
+	_ = rc.Flush()
+}
+
+func acceptsNDJSON(r *http.Request) bool {
+	return slices.ContainsFunc(strings.Split(r.Header.Get("Accept"), ","), func(mediaType string) bool {
+		mediaType, _, _ = strings.Cut(mediaType, ";")
+		return strings.TrimSpace(mediaType) == contentTypeNDJSON
+	})
+}
+
+// handleSearchDevices lists the devices matching the combined filters. The device type, hostname and never polled
+// filters as well as the sorting are applied by the database, while the connectivity and status filters are applied
+// on the computed diagnostics.
+func (ro *Router) handleSearchDevices(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	page, size, err := parsePagination(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.Has("label") {
+		http.Error(w, "label filter is not supported, devices have no labels", http.StatusBadRequest)
+		return
+	}
+	unmask, ok := parseUnmask(w, r)
+	if !ok {
+		return
+	}
+
+	criteria := business.DeviceSearchCriteria{
+		DeviceSearch: repository.DeviceSearch{
+			DeviceType: q.Get("device_type"),
+			Hostname:   q.Get("hostname"),
+			SortBy:     q.Get("sort"),
+		},
+		Connectivity: api.Connectivity(q.Get("connectivity")),
+		Status:       q.Get("status"),
+	}
+	if v := q.Get("never_polled"); v != "" {
+		criteria.NeverPolled, err = strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, "invalid never_polled value", http.StatusBadRequest)
+			return
+		}
+	}
+	if criteria.SortBy != "" && !slices.Contains(repository.DeviceSortFields, criteria.SortBy) {
+		http.Error(w, fmt.Sprintf("invalid sort field, expecting one of %s", strings.Join(repository.DeviceSortFields, ", ")), http.StatusBadRequest)
+		return
+	}
+	switch q.Get("order") {
+	case "", "asc":
+	case "desc":
+		criteria.Descending = true
+	default:
+		http.Error(w, "invalid order, expecting asc or desc", http.StatusBadRequest)
+		return
+	}
+	if criteria.Connectivity != "" && !slices.Contains(api.Connectivities, criteria.Connectivity) {
+		http.Error(w, "invalid connectivity value", http.StatusBadRequest)
+		return
+	}
+
+	dias, failures, total, err := business.SearchDevicesDiagnostics(r.Context(), ro.repo, ro.cache, defaultHistoryCheckingSize, ro.psy, criteria, page, size)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to search devices: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !unmask {
+		dias = lo.Map(dias, func(d *api.DeviceDiagnostics, _ int) *api.DeviceDiagnostics { return maskChecksum(d) })
+	}
+
+	util.ResponseAsJSON(w, http.StatusOK, deviceListingResponse{
+		Page:     page,
+		Size:     size,
+		Total:    total,
+		Items:    dias,
+		Warnings: failures,
+		CachedAt: api.NewTimestamp(ro.cache.RefreshedAt()),
+	})
+}
+
+// handleGetDevicesSummary counts the devices of every device type by connectivity
+func (ro *Router) handleGetDevicesSummary(w http.ResponseWriter, r *http.Request) {
+	summary, total, err := business.SummarizeConnectivity(r.Context(), ro.repo, ro.cache, defaultHistoryCheckingSize, ro.psy)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to summarize devices connectivity: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	util.ResponseAsJSON(w, http.StatusOK, devicesSummaryResponse{
+		Total: total,
+		DeviceTypes: lo.Map(summary, func(c business.DeviceTypeConnectivity, _ int) deviceTypeConnectivity {
+			return deviceTypeConnectivity{DeviceType: c.DeviceType, Total: c.Total, Connectivity: c.Counts}
+		}),
+	})
+}
+
+func (ro *Router) handleGetSiteDiagnostics(w http.ResponseWriter, r *http.Request) {
+	site := strings.TrimSpace(chi.URLParam(r, "site"))
+	if site == "" {
+		http.Error(w, "site is required", http.StatusBadRequest)
+		return
+	}
+	unmask, ok := parseUnmask(w, r)
+	if !ok {
+		return
+	}
+
+	sd, err := business.DiagnoseSite(r.Context(), ro.repo, ro.cache, defaultHistoryCheckingSize, ro.psy, site)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to get site diagnostics: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !unmask {
+		sd.Devices = lo.Map(sd.Devices, func(d *api.DeviceDiagnostics, _ int) *api.DeviceDiagnostics { return maskChecksum(d) })
+	}
+
+	util.ResponseAsJSON(w, http.StatusOK, siteDiagnosticsResponse{
+		Site:         sd.Site,
+		Total:        sd.Total,
+		Connectivity: sd.Counts,
+		Items:        sd.Devices,
+	})
+}
+
+func (ro *Router) handleListingDeletedDevices(w http.ResponseWriter, r *http.Request) {
+	page, size, err := parsePagination(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	devices, total, err := ro.repo.SearchDevices(repository.DeviceSearch{
+		Deleted:    true,
+		SortBy:     "deleted_at",
+		Descending: true,
+		Offset:     page * size,
+		Limit:      size,
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to get deleted devices: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	util.ResponseAsJSON(w, http.StatusOK, deletedDevicesResponse{
 		Page:  page,
 		Size:  size,
 		Total: total,
-		Items: dias,
+		Items: lo.Map(devices, func(d repository.Device, _ int) deletedDevice {
+			return deletedDevice{
+				DeviceID:      d.DeviceID,
+				DeviceType:    d.DeviceType,
+				Hostname:      d.Hostname,
+				DeletedAt:     api.Timestamp{Time: lo.FromPtr(d.DeletedAt)},
+				DeletedReason: d.DeletedReason,
+				DeletedBy:     d.DeletedBy,
+			}
+		}),
+	})
+}
+
+func (ro *Router) handleGetWorkerStatus(w http.ResponseWriter, r *http.Request) {
+	heartbeat, err := ro.repo.GetLatestWorkerHeartbeat()
+	if err != nil && !errors.Is(err, repository.ErrRecordNotFound) {
+		http.Error(w, fmt.Sprintf("failed to get worker heartbeat: %v", err), http.StatusInternalServerError)
+		return
 	}
-	util.ResponseAsJSON(w, http.StatusOK, resp)
+
+	resp := workerStatusResponse{}
+	if heartbeat != nil {
+		resp.WorkerID = heartbeat.WorkerID
+		resp.LastHeartbeatAt = api.NewTimestamp(&heartbeat.UpdatedAt)
+		resp.Alive = heartbeat.UpdatedAt.After(time.Now().Add(-config.WorkerHeartbeatMaxAge()))
+	}
+
+	status := http.StatusOK
+	if !resp.Alive {
+		status = http.StatusServiceUnavailable
+	}
+	util.ResponseAsJSON(w, status, resp)
+}
+
+// handleGetSLO reports the percentage of the devices polled in time, answering with 503 when it is under the threshold
+// so that it can be alerted on
+func (ro *Router) handleGetSLO(w http.ResponseWriter, r *http.Request) {
+	threshold := config.SLOFreshnessThreshold()
+	if v := r.URL.Query().Get("threshold"); v != "" {
+		t, err := strconv.ParseFloat(v, 64)
+		if err != nil || t < 0 || t > 100 {
+			http.Error(w, "invalid threshold, expecting a percentage between 0 and 100", http.StatusBadRequest)
+			return
+		}
+		threshold = t
+	}
+
+	freshness, score, err := business.GetFreshness(ro.repo, ro.psy)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to compute devices freshness: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	resp := sloResponse{
+		Score:     score,
+		Threshold: threshold,
+		Breached:  score < threshold,
+		DeviceTypes: lo.Map(freshness, func(f business.DeviceTypeFreshness, _ int) deviceTypeFreshness {
+			return deviceTypeFreshness{DeviceType: f.DeviceType, Total: f.Total, Fresh: f.Fresh, Score: f.Score()}
+		}),
+	}
+	status := http.StatusOK
+	if resp.Breached {
+		status = http.StatusServiceUnavailable
+	}
+	util.ResponseAsJSON(w, status, resp)
 }
 
 func (ro *Router) handleDeleteDevice(w http.ResponseWriter, r *http.Request) {
@@ -159,13 +745,288 @@ func (ro *Router) handleDeleteDevice(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	reason := strings.TrimSpace(r.URL.Query().Get("reason"))
+	if len(reason) > maxDeletedReasonLength {
+		http.Error(w, fmt.Sprintf("reason must not be longer than %d characters", maxDeletedReasonLength), http.StatusBadRequest)
+		return
+	}
+
 	device.DeletedAt = lo.ToPtr(time.Now())
+	device.DeletedReason = lo.EmptyableToPtr(reason)
+	device.DeletedBy = lo.EmptyableToPtr(strings.TrimSpace(r.Header.Get(actorHeader)))
 	if err := ro.repo.UpdateDevice(device); err != nil {
 		http.Error(w, fmt.Sprintf("failed to delete device: %v", err), http.StatusInternalServerError)
 		return
 	}
 }
 
+func (ro *Router) handleRestoreDevice(w http.ResponseWriter, r *http.Request) {
+	deviceId := strings.ReplaceAll(chi.URLParam(r, "device_id"), " ", "")
+	if deviceId == "" {
+		http.Error(w, "device_id is required", http.StatusBadRequest)
+		return
+	}
+
+	device, err := ro.repo.GetDeviceByID(deviceId)
+	if errors.Is(err, repository.ErrRecordNotFound) || device == nil {
+		http.Error(w, "device not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to find device: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if device.DeletedAt == nil {
+		http.Error(w, "device is not deleted", http.StatusConflict)
+		return
+	}
+
+	if err = ro.repo.RestoreDevice(device.ID); err != nil {
+		http.Error(w, fmt.Sprintf("failed to restore device: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (ro *Router) handlePatchDevice(w http.ResponseWriter, r *http.Request) {
+	deviceId := strings.ReplaceAll(chi.URLParam(r, "device_id"), " ", "")
+	if deviceId == "" {
+		http.Error(w, "device_id is required", http.StatusBadRequest)
+		return
+	}
+
+	var req patchDeviceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("failed to json decode request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.ExpectedFwVersion == nil && req.PollIntervalOverride == nil {
+		http.Error(w, "expected_fw_version or poll_interval_override is required, an empty value clears it", http.StatusBadRequest)
+		return
+	}
+
+	device, err := ro.repo.GetDeviceByID(deviceId)
+	if errors.Is(err, repository.ErrRecordNotFound) || device == nil {
+		http.Error(w, "device not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to get device: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var interval *time.Duration
+	if req.PollIntervalOverride != nil {
+		if interval, err = ro.parsePollIntervalOverride(device.DeviceType, *req.PollIntervalOverride); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = ro.repo.UpdateDevicePollIntervalOverride(deviceId, interval)
+	}
+	if err == nil && req.ExpectedFwVersion != nil {
+		var version *string
+		if strings.TrimSpace(*req.ExpectedFwVersion) != "" {
+			version = req.ExpectedFwVersion
+		}
+		err = ro.repo.UpdateDeviceExpectedFwVersion(deviceId, version)
+	}
+	if errors.Is(err, repository.ErrRecordNotFound) {
+		http.Error(w, "device not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to update device: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+// parsePollIntervalOverride parses the polling interval overriding the one of a device type, nil when it is empty
+func (ro *Router) parsePollIntervalOverride(deviceType, value string) (*time.Duration, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid poll_interval_override %s, expecting a duration like 10s", value)
+	}
+	cfg, err := ro.psy.GetPollingConfigByDeviceType(deviceType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get polling config of device type %s: %w", deviceType, err)
+	}
+	if err = cfg.ValidateIntervalOverride(interval); err != nil {
+		return nil, err
+	}
+	return &interval, nil
+}
+
+func (ro *Router) handlePatchDeviceType(w http.ResponseWriter, r *http.Request) {
+	deviceType := strings.ReplaceAll(chi.URLParam(r, "device_type"), " ", "")
+	if deviceType == "" {
+		http.Error(w, "device_type is required", http.StatusBadRequest)
+		return
+	}
+
+	version, err := decodeExpectedFwVersion(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = ro.repo.UpdateDeviceTypeExpectedFwVersion(deviceType, version)
+	if errors.Is(err, repository.ErrRecordNotFound) {
+		http.Error(w, "device type not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to update device type: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleToggleDeviceType turns the polling of all the devices of a device type on or off, the polling worker picks up
+// the change on its next tick
+func (ro *Router) handleToggleDeviceType(enabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		deviceType := strings.ReplaceAll(chi.URLParam(r, "device_type"), " ", "")
+		if deviceType == "" {
+			http.Error(w, "device_type is required", http.StatusBadRequest)
+			return
+		}
+
+		err := ro.repo.UpdateDeviceTypeEnabled(deviceType, enabled)
+		if errors.Is(err, repository.ErrRecordNotFound) {
+			http.Error(w, "device type not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to update device type: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// handlePollNow makes the devices of a device type eligible for polling on the next tick of the polling worker, the
+// devices are reset in the background and the request is acknowledged with a job id to look up in the logs
+func (ro *Router) handlePollNow(w http.ResponseWriter, r *http.Request) {
+	deviceType := strings.ReplaceAll(chi.URLParam(r, "device_type"), " ", "")
+	if deviceType == "" {
+		http.Error(w, "device_type is required", http.StatusBadRequest)
+		return
+	}
+
+	dt, err := ro.repo.GetDeviceTypeByName(deviceType)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to find device type: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if dt == nil || dt.DeletedAt != nil {
+		http.Error(w, "device type not found", http.StatusNotFound)
+		return
+	}
+	cfg, err := ro.psy.GetPollingConfigByDeviceType(deviceType)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to get polling config of device type %s: %v", deviceType, err), http.StatusInternalServerError)
+		return
+	}
+	// older than the checkpoints of both the hot and the cold tiers
+	checkpoint := time.Now().Add(-max(cfg.Interval, cfg.ColdInterval) - time.Second)
+
+	resp := pollNowResponse{
+		JobID:      uuid.NewString(),
+		DeviceType: deviceType,
+		MaxDevices: config.PollNowMaxDevices(),
+	}
+	logger := zerolog.Ctx(r.Context()).With().Str("job_id", resp.JobID).Str("device_type", deviceType).Logger()
+	go func() {
+		n, err := ro.repo.ResetDevicesPolling(deviceType, checkpoint, resp.MaxDevices)
+		if err != nil {
+			logger.Err(err).Msg("failed to schedule devices for polling")
+			return
+		}
+		logger.Info().Int64("devices", n).Msg("scheduled devices for polling")
+	}()
+
+	util.ResponseAsJSON(w, http.StatusAccepted, resp)
+}
+
+func decodeExpectedFwVersion(r *http.Request) (*string, error) {
+	var req expectedFwVersionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, fmt.Errorf("failed to json decode request: %w", err)
+	}
+	if req.ExpectedFwVersion == nil {
+		return nil, fmt.Errorf("expected_fw_version is required, an empty value clears it")
+	}
+	if strings.TrimSpace(*req.ExpectedFwVersion) == "" {
+		return nil, nil
+	}
+	return req.ExpectedFwVersion, nil
+}
+
+func (ro *Router) handleDeleteDeviceHistory(w http.ResponseWriter, r *http.Request) {
+	deviceId := chi.URLParam(r, "device_id")
+	if deviceId == "" {
+		http.Error(w, "device_id is required", http.StatusBadRequest)
+		return
+	}
+
+	before, err := parseTimeParam(r.URL.Query(), "before")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	deviceId = strings.ReplaceAll(deviceId, " ", "")
+	device, err := ro.repo.GetDeviceByID(deviceId)
+	if errors.Is(err, repository.ErrRecordNotFound) || device == nil {
+		http.Error(w, "device not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to find device: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	deleted, err := ro.repo.DeleteDeviceHistory(device.DeviceID, before)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to delete device history: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	util.ResponseAsJSON(w, http.StatusOK, deleteDeviceHistoryResponse{
+		DeviceID: device.DeviceID,
+		Before:   api.NewTimestamp(before),
+		Deleted:  deleted,
+	})
+}
+
+func (ro *Router) handlePruneHistory(w http.ResponseWriter, r *http.Request) {
+	before, err := parseTimeParam(r.URL.Query(), "before")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if before == nil {
+		http.Error(w, "before is required", http.StatusBadRequest)
+		return
+	}
+	if !before.Before(time.Now()) {
+		http.Error(w, "before must be in the past", http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := ro.repo.DeletePollingHistoryBefore(*before)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to prune polling history: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	zerolog.Ctx(r.Context()).Info().Time("before", *before).Int64("deleted", deleted).Msg("polling history pruned")
+	util.ResponseAsJSON(w, http.StatusOK, pruneHistoryResponse{
+		Before:  api.NewTimestamp(before),
+		Deleted: deleted,
+	})
+}
+
 func (ro *Router) handleAddDevices(w http.ResponseWriter, r *http.Request) {
 	var req addDevicesRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -177,49 +1038,268 @@ func (ro *Router) handleAddDevices(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	m := make(map[string]deviceInfo)
-	for _, device := range req.Devices {
-		if err := device.normalize(); err != nil {
-			http.Error(w, fmt.Sprintf("request validation error for item %+v: %v", device, err), http.StatusBadRequest)
+	devices, err := normalizeDevices(req.Devices)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for i, device := range devices {
+		if devices[i].pollInterval, err = ro.parsePollIntervalOverride(device.DeviceType, device.PollIntervalOverride); err != nil {
+			http.Error(w, fmt.Sprintf("request validation error for device %s: %v", device.DeviceID, err), http.StatusBadRequest)
 			return
 		}
-		m[device.DeviceID] = device
 	}
 
-	// get error code by error, simplified logic
-	fnErrCode := func(err error) int {
-		if errors.Is(err, context.DeadlineExceeded) {
-			return 1
+	conflicts := portConflicts(devices)
+	if len(conflicts) > 0 && config.RejectPortConflicts() {
+		messages := lo.MapToSlice(conflicts, func(hostPort string, ids []string) string {
+			return fmt.Sprintf("%s (%s)", hostPort, strings.Join(ids, ", "))
+		})
+		slices.Sort(messages)
+		http.Error(w, fmt.Sprintf("devices sharing the same hostname and health check port in request: %s", strings.Join(messages, "; ")), http.StatusBadRequest)
+		return
+	}
+
+	results := make([]deviceAddingResult, len(devices))
+	fanOut(devices, config.AddDevicesConcurrency(), func(idx int, device deviceInfo) {
+		ctx, cancel := context.WithTimeout(r.Context(), config.HealthCheckTimeout())
+		defer cancel()
+
+		result := deviceAddingResult{
+			DeviceID:   device.DeviceID,
+			DeviceType: device.DeviceType,
+			Hostname:   device.Hostname,
+		}
+		outcome, err := business.AddDevice(ctx, ro.repo, ro.client(device), business.AddDeviceParameter{
+			DeviceID:             device.DeviceID,
+			DeviceType:           device.DeviceType,
+			Hostname:             device.Hostname,
+			HealthCheckPort:      device.HealthCheckPort,
+			ExpectedFwVersion:    device.ExpectedFwVersion,
+			InsecureSkipVerify:   device.InsecureSkipVerify,
+			Site:                 device.Site,
+			PollIntervalOverride: device.pollInterval,
+		})
+		if err != nil {
+			deviceInfo := util.JSONMarshalIgnoreErr(device)
+			zerolog.Ctx(r.Context()).Err(err).RawJSON("device_info", deviceInfo).Msgf("failed to add device")
+			result.Code = deviceErrorCode(err)
+			result.Error = err.Error()
+		}
+		result.Outcome = string(outcome)
+		if ids, ok := conflicts[util.HostPort(device.Hostname, device.HealthCheckPort)]; ok {
+			result.Warning = fmt.Sprintf("shares the hostname and health check port with the devices %s", strings.Join(lo.Without(ids, device.DeviceID), ", "))
 		}
-		return 2
+		results[idx] = result
+	})
+
+	util.ResponseAsJSON(w, http.StatusOK, addDevicesResponse{Results: results})
+}
+
+// handleValidateDevices dry-runs the health checks of the devices to add, telling which ones are reachable and
+// with which capabilities, without adding any of them
+func (ro *Router) handleValidateDevices(w http.ResponseWriter, r *http.Request) {
+	var req addDevicesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("failed to json decode request: %v", err), http.StatusBadRequest)
+		return
 	}
+	devices, err := normalizeDevices(req.Devices)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	results := make([]deviceValidationResult, len(devices))
+	fanOut(devices, config.AddDevicesConcurrency(), func(idx int, device deviceInfo) {
+		ctx, cancel := context.WithTimeout(r.Context(), config.HealthCheckTimeout())
+		defer cancel()
+
+		result := deviceValidationResult{
+			DeviceID:   device.DeviceID,
+			DeviceType: device.DeviceType,
+			Hostname:   device.Hostname,
+		}
+		resp, err := business.CheckDeviceHealth(ctx, ro.client(device), device.DeviceID, device.DeviceType, device.Hostname, device.HealthCheckPort)
+		if err != nil {
+			result.Code = deviceErrorCode(err)
+			result.Error = err.Error()
+		} else {
+			result.Reachable = true
+			result.Capabilities = resp.Capabilities
+		}
+		results[idx] = result
+	})
 
+	util.ResponseAsJSON(w, http.StatusOK, validateDevicesResponse{Results: results})
+}
+
+// normalizeDevices normalizes the devices of a request in their order, rejecting the request on an invalid or a
+// duplicate device
+func normalizeDevices(devices []deviceInfo) ([]deviceInfo, error) {
+	normalized := make([]deviceInfo, 0, len(devices))
+	seen := make(map[string]bool)
+	var duplicates []string
+	for _, device := range devices {
+		if err := device.normalize(); err != nil {
+			return nil, fmt.Errorf("request validation error for item %+v: %v", device, err)
+		}
+		if seen[device.DeviceID] {
+			if !slices.Contains(duplicates, device.DeviceID) {
+				duplicates = append(duplicates, device.DeviceID)
+			}
+			continue
+		}
+		seen[device.DeviceID] = true
+		normalized = append(normalized, device)
+	}
+	if len(duplicates) > 0 {
+		return nil, fmt.Errorf("duplicate device ids in request: %s", strings.Join(duplicates, ", "))
+	}
+	return normalized, nil
+}
+
+// portConflicts groups the ids of the devices sharing the same hostname and health check port by the host and port,
+// which is likely a mistake, unless the health check path tells the devices behind a shared gateway apart
+func portConflicts(devices []deviceInfo) map[string][]string {
+	if strings.Contains(config.HealthCheckPath(), api.DeviceIDPlaceholder) {
+		return nil
+	}
+	byHostPort := lo.GroupBy(devices, func(d deviceInfo) string {
+		return util.HostPort(d.Hostname, d.HealthCheckPort)
+	})
+	conflicts := make(map[string][]string)
+	for hostPort, group := range byHostPort {
+		if len(group) > 1 {
+			conflicts[hostPort] = lo.Map(group, func(d deviceInfo, _ int) string { return d.DeviceID })
+		}
+	}
+	return conflicts
+}
+
+// fanOut calls fn with the index of every device, at most limit calls at the same time, and waits for all of them
+func fanOut(devices []deviceInfo, limit int, fn func(idx int, device deviceInfo)) {
 	var wg sync.WaitGroup
-	results := make([]deviceAddingResult, len(m))
-	i := 0
-	for _, device := range m {
+	slots := make(chan struct{}, max(limit, 1))
+	for i, device := range devices {
+		slots <- struct{}{}
 		wg.Add(1)
-		i++
-		go func(idx int) {
-			defer wg.Done()
-			ctx, cancel := context.WithTimeout(r.Context(), config.HealthCheckTimeout())
-			defer cancel()
-
-			result := deviceAddingResult{
-				DeviceID:   device.DeviceID,
-				DeviceType: device.DeviceType,
-				Hostname:   device.Hostname,
-			}
-			if err := business.AddDevice(ctx, ro.repo, ro.httpClint, device.DeviceID, device.DeviceType, device.Hostname, device.HealthCheckPort); err != nil {
-				deviceInfo := util.JSONMarshalIgnoreErr(device)
-				zerolog.Ctx(r.Context()).Err(err).RawJSON("device_info", deviceInfo).Msgf("failed to add device")
-				result.Code = fnErrCode(err)
-				result.Error = err.Error()
-			}
-			results[idx] = result
-		}(i - 1)
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			fn(i, device)
+		}()
 	}
 	wg.Wait()
+}
 
-	util.ResponseAsJSON(w, http.StatusOK, addDevicesResponse{Results: results})
+// deviceErrorCode gets error code by error, simplified logic
+func deviceErrorCode(err error) int {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return 1
+	}
+	return 2
+}
+
+// traceParent carries the trace parent of a request, or a new one when it has none or an invalid one, into the
+// request context, so that it is forwarded to the devices
+func traceParent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tp := r.Header.Get(util.TraceParentHeader)
+		if !util.IsValidTraceParent(tp) {
+			tp = util.NewTraceParent()
+		}
+		w.Header().Set(util.TraceParentHeader, tp)
+		next.ServeHTTP(w, r.WithContext(util.WithTraceParent(r.Context(), tp)))
+	})
+}
+
+// parseUnmask tells whether the checksums are asked unmasked with unmask=true, which requires the admin token. It
+// answers with the error and returns false when the request cannot proceed.
+func parseUnmask(w http.ResponseWriter, r *http.Request) (unmask bool, ok bool) {
+	if paramUnmask := r.URL.Query().Get("unmask"); paramUnmask != "" {
+		var err error
+		if unmask, err = strconv.ParseBool(paramUnmask); err != nil {
+			http.Error(w, "invalid unmask value", http.StatusBadRequest)
+			return false, false
+		}
+	}
+	if unmask && !util.AuthorizeAdmin(w, r) {
+		return false, false
+	}
+	return unmask, true
+}
+
+// maskChecksum returns a copy of the diagnostics with the checksum masked, the diagnostics may be shared by the cache
+func maskChecksum(dia *api.DeviceDiagnostics) *api.DeviceDiagnostics {
+	if dia == nil {
+		return nil
+	}
+	masked := *dia
+	masked.Checksum = util.MaskSecret(dia.Checksum)
+	return &masked
+}
+
+// maskChecksumChanges masks the checksums of the checksum changes in place
+func maskChecksumChanges(changes []api.DeviceChange) []api.DeviceChange {
+	for _, change := range changes {
+		for i, fc := range change.Changes {
+			if fc.Field == business.ChecksumField {
+				change.Changes[i].Previous = util.MaskSecret(fc.Previous)
+				change.Changes[i].Current = util.MaskSecret(fc.Current)
+			}
+		}
+	}
+	return changes
+}
+
+func parsePagination(q url.Values) (page, size int, err error) {
+	paramPage := q.Get("page")
+	paramSize := q.Get("size")
+
+	if paramPage != "" {
+		page, err = strconv.Atoi(paramPage)
+		if err != nil || page < 0 {
+			return 0, 0, fmt.Errorf("invalid page number")
+		}
+	}
+
+	size = 30
+	if paramSize != "" {
+		size, err = strconv.Atoi(paramSize)
+		if err != nil || size <= 0 {
+			return 0, 0, fmt.Errorf("invalid size number")
+		}
+		if size > 1000 {
+			return 0, 0, fmt.Errorf("size number is too large")
+		}
+	}
+	return page, size, nil
+}
+
+func parseTimeParam(q url.Values, name string) (*time.Time, error) {
+	v := q.Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s time, expecting RFC3339 format", name)
+	}
+	return &t, nil
+}
+
+// parseDurationParam parses a positive Go duration, e.g. 1h or 5m, from the query, def is returned when it is absent
+func parseDurationParam(q url.Values, key string, def time.Duration) (time.Duration, error) {
+	v := q.Get(key)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid %s, expecting a positive duration, e.g. 1h", key)
+	}
+	return d, nil
 }
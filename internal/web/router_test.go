@@ -2,6 +2,7 @@ package web
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,10 +11,12 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"example.poc/device-monitoring-system/internal/api"
+	"example.poc/device-monitoring-system/internal/business"
 	"example.poc/device-monitoring-system/internal/config"
 	"example.poc/device-monitoring-system/internal/repository"
 	"example.poc/device-monitoring-system/internal/util"
@@ -21,6 +24,7 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/lib/pq"
 	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
@@ -104,7 +108,11 @@ func (s *routerTestSuite) TestGetDeviceByID() {
 	var diagnostics api.DeviceDiagnostics
 	s.helper.MustDecodeJSON(w.Body.Bytes(), &diagnostics)
 	s.Equal(d.DeviceID, diagnostics.DeviceID)
-	s.Equal(api.Unknown, diagnostics.Connectivity)
+	s.Equal(api.Onboarding, diagnostics.Connectivity)
+	s.Equal([]string{"http", "grpc"}, diagnostics.Protocols)
+	s.Equal(8999, *diagnostics.RestPort)
+	s.Equal(50051, *diagnostics.GrpcPort)
+	s.Nil(diagnostics.RestPath)
 
 	// insert polling history data, make it looks connected
 	ph := repository.PollingHistory{
@@ -129,6 +137,715 @@ func (s *routerTestSuite) TestGetDeviceByID() {
 	s.Equal(api.Connected, diagnostics.Connectivity)
 }
 
+func (s *routerTestSuite) TestChecksumMasking() {
+	d := repository.Device{DeviceID: "device1", DeviceType: repository.Router, Hostname: "localhost"}
+	err := s.repo.CreateDevice(&d)
+	s.NoError(err)
+	err = s.repo.CreatePollingHistory(&repository.PollingHistory{
+		DeviceID:       d.DeviceID,
+		FwVersion:      lo.ToPtr("1.0.0"),
+		DeviceChecksum: lo.ToPtr("abcdef"),
+		DeviceStatus:   lo.ToPtr("running"),
+		PollingResult:  repository.PollSucceed,
+	})
+	s.NoError(err)
+
+	token := helper.RandomString(16)
+	s.T().Setenv("ADMIN_API_TOKEN", token)
+	get := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, req)
+		return w
+	}
+
+	// the checksum is masked by default
+	w := get("/devices/device1", "")
+	s.Equal(http.StatusOK, w.Code)
+	var dia api.DeviceDiagnostics
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &dia)
+	s.Equal("a****f", dia.Checksum)
+
+	w = get("/devices", "")
+	s.Equal(http.StatusOK, w.Code)
+	var listingResp deviceListingResponse
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &listingResp)
+	s.Require().Len(listingResp.Items, 1)
+	s.Equal("a****f", listingResp.Items[0].Checksum)
+
+	// the checksum is unmasked for the admins only
+	for _, path := range []string{"/devices/device1?unmask=true", "/devices?unmask=true"} {
+		s.Equal(http.StatusUnauthorized, get(path, "").Code, path)
+	}
+	s.Equal(http.StatusBadRequest, get("/devices/device1?unmask=maybe", token).Code)
+
+	w = get("/devices/device1?unmask=true", token)
+	s.Equal(http.StatusOK, w.Code)
+	dia = api.DeviceDiagnostics{}
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &dia)
+	s.Equal("abcdef", dia.Checksum)
+
+	w = get("/devices?unmask=true", token)
+	s.Equal(http.StatusOK, w.Code)
+	listingResp = deviceListingResponse{}
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &listingResp)
+	s.Require().Len(listingResp.Items, 1)
+	s.Equal("abcdef", listingResp.Items[0].Checksum)
+}
+
+func (s *routerTestSuite) TestGetDeviceETag() {
+	d := repository.Device{DeviceID: "device1", DeviceType: repository.Router, Hostname: "localhost"}
+	err := s.repo.CreateDevice(&d)
+	s.NoError(err)
+	poll := func(fwVersion string) {
+		err := s.repo.CreatePollingHistory(&repository.PollingHistory{
+			DeviceID:       d.DeviceID,
+			FwVersion:      lo.ToPtr(fwVersion),
+			DeviceChecksum: lo.ToPtr("abcdef"),
+			DeviceStatus:   lo.ToPtr("running"),
+			PollingResult:  repository.PollSucceed,
+		})
+		s.NoError(err)
+	}
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/devices/device1", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, req)
+		return w
+	}
+	poll("1.0.0")
+
+	w := get("")
+	s.Equal(http.StatusOK, w.Code)
+	etag := w.Header().Get("ETag")
+	s.NotEmpty(etag)
+
+	w = get(etag)
+	s.Equal(http.StatusNotModified, w.Code)
+	s.Empty(w.Body.String())
+	s.Equal(etag, w.Header().Get("ETag"))
+
+	// the diagnostics change with a new poll of the device
+	poll("1.0.1")
+	w = get(etag)
+	s.Equal(http.StatusOK, w.Code)
+	s.NotEqual(etag, w.Header().Get("ETag"))
+	var dia api.DeviceDiagnostics
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &dia)
+	s.Equal("1.0.1", dia.FwVersion)
+}
+
+func (s *routerTestSuite) TestGetDegradedDevice() {
+	d := repository.Device{
+		DeviceID:   "device1",
+		DeviceType: repository.Camera,
+		Hostname:   "localhost",
+		Protocols:  pq.StringArray([]string{"grpc"}),
+		GrpcPort:   lo.ToPtr(50051),
+	}
+	err := s.repo.CreateDevice(&d)
+	s.NoError(err)
+
+	cfg, err := s.router.psy.GetPollingConfigByDeviceType(d.DeviceType)
+	s.NoError(err)
+	s.NotEmpty(cfg.UnhealthyStatuses)
+
+	// the poll succeeded, but the device reports an unhealthy status
+	ph := repository.PollingHistory{
+		DeviceID:       d.DeviceID,
+		HwVersion:      lo.ToPtr(helper.RandomString(10)),
+		SwVersion:      lo.ToPtr(helper.RandomString(10)),
+		FwVersion:      lo.ToPtr(helper.RandomString(10)),
+		DeviceChecksum: lo.ToPtr(helper.RandomString(32)),
+		DeviceStatus:   lo.ToPtr(cfg.UnhealthyStatuses[0]),
+		PollingResult:  repository.PollSucceed,
+	}
+	err = s.repo.CreatePollingHistory(&ph)
+	s.NoError(err)
+
+	req := httptest.NewRequest(http.MethodGet, "/devices/device1", nil)
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusOK, w.Code)
+
+	var diagnostics api.DeviceDiagnostics
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &diagnostics)
+	s.Equal(api.Degraded, diagnostics.Connectivity)
+	s.Equal(cfg.UnhealthyStatuses[0], diagnostics.Status)
+}
+
+func (s *routerTestSuite) TestNextPollEstimate() {
+	lastCheckedAt := time.Now().Add(-10 * time.Second)
+	devices := []*repository.Device{
+		{
+			DeviceID:      "device1",
+			DeviceType:    repository.Router,
+			Hostname:      "localhost",
+			Protocols:     pq.StringArray([]string{"grpc"}),
+			PollingStatus: lo.ToPtr(repository.PollingDone),
+			LastCheckedAt: &lastCheckedAt,
+		},
+		{
+			DeviceID:      "device2",
+			DeviceType:    repository.Router,
+			Hostname:      "localhost",
+			Protocols:     pq.StringArray([]string{"grpc"}),
+			PollingStatus: lo.ToPtr(repository.PollingInProgress),
+			LastCheckedAt: &lastCheckedAt,
+		},
+		{
+			DeviceID:   "device3",
+			DeviceType: repository.Router,
+			Hostname:   "localhost",
+			Protocols:  pq.StringArray([]string{"grpc"}),
+		},
+	}
+	err := s.repo.CreateDevices(devices)
+	s.NoError(err)
+
+	cfg, err := s.router.psy.GetPollingConfigByDeviceType(repository.Router)
+	s.NoError(err)
+
+	getDiagnostics := func(deviceID string) api.DeviceDiagnostics {
+		req := httptest.NewRequest(http.MethodGet, "/devices/"+deviceID, nil)
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, req)
+		s.Equal(http.StatusOK, w.Code)
+
+		var diagnostics api.DeviceDiagnostics
+		s.helper.MustDecodeJSON(w.Body.Bytes(), &diagnostics)
+		return diagnostics
+	}
+
+	diagnostics := getDiagnostics("device1")
+	s.NotNil(diagnostics.NextPollEstimate)
+	s.WithinDuration(lastCheckedAt.Add(cfg.Interval), diagnostics.NextPollEstimate.Time, time.Millisecond)
+
+	// being polled
+	diagnostics = getDiagnostics("device2")
+	s.Nil(diagnostics.NextPollEstimate)
+
+	// never polled
+	diagnostics = getDiagnostics("device3")
+	s.NotNil(diagnostics.NextPollEstimate)
+	s.WithinDuration(time.Now(), diagnostics.NextPollEstimate.Time, time.Second)
+}
+
+func (s *routerTestSuite) TestFwCompliance() {
+	devices := []*repository.Device{
+		{
+			DeviceID:   "device1",
+			DeviceType: repository.Router,
+			Hostname:   "localhost",
+			Protocols:  pq.StringArray([]string{"grpc"}),
+		},
+		{
+			DeviceID:   "device2",
+			DeviceType: repository.Router,
+			Hostname:   "localhost",
+			Protocols:  pq.StringArray([]string{"grpc"}),
+		},
+	}
+	err := s.repo.CreateDevices(devices)
+	s.NoError(err)
+	for _, d := range devices {
+		err = s.repo.CreatePollingHistory(&repository.PollingHistory{
+			DeviceID:      d.DeviceID,
+			HwVersion:     lo.ToPtr("hw1"),
+			SwVersion:     lo.ToPtr("sw1"),
+			FwVersion:     lo.ToPtr("fw1"),
+			DeviceStatus:  lo.ToPtr("ok"),
+			PollingResult: repository.PollSucceed,
+		})
+		s.NoError(err)
+	}
+
+	getFwCompliant := func(deviceID string) *bool {
+		req := httptest.NewRequest(http.MethodGet, "/devices/"+deviceID, nil)
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, req)
+		s.Equal(http.StatusOK, w.Code)
+
+		var diagnostics api.DeviceDiagnostics
+		s.helper.MustDecodeJSON(w.Body.Bytes(), &diagnostics)
+		s.Equal("fw1", diagnostics.FwVersion)
+		return diagnostics.FwCompliant
+	}
+	patch := func(target, version string) int {
+		req := httptest.NewRequest(http.MethodPatch, target, strings.NewReader(fmt.Sprintf(`{"expected_fw_version":%q}`, version)))
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, req)
+		return w.Code
+	}
+	defer func() {
+		s.NoError(s.repo.UpdateDeviceTypeExpectedFwVersion(repository.Router, nil))
+	}()
+
+	// no expectation
+	s.Nil(getFwCompliant("device1"))
+
+	// the devices inherit the expectation of the device type
+	s.Equal(http.StatusOK, patch("/device-types/"+repository.Router, "fw1"))
+	s.Equal(lo.ToPtr(true), getFwCompliant("device1"))
+	s.Equal(lo.ToPtr(true), getFwCompliant("device2"))
+
+	// a device overrides the expectation of its device type
+	s.Equal(http.StatusOK, patch("/devices/device2", "fw2"))
+	s.Equal(lo.ToPtr(true), getFwCompliant("device1"))
+	s.Equal(lo.ToPtr(false), getFwCompliant("device2"))
+
+	// clearing the expectation of the device type
+	s.Equal(http.StatusOK, patch("/device-types/"+repository.Router, ""))
+	s.Nil(getFwCompliant("device1"))
+	s.Equal(lo.ToPtr(false), getFwCompliant("device2"))
+
+	s.Equal(http.StatusNotFound, patch("/devices/device3", "fw1"))
+	s.Equal(http.StatusNotFound, patch("/device-types/unknown", "fw1"))
+
+	req := httptest.NewRequest(http.MethodPatch, "/devices/device1", strings.NewReader(`{}`))
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusBadRequest, w.Code)
+}
+
+func (s *routerTestSuite) TestPollIntervalOverride() {
+	d := repository.Device{DeviceID: "device1", DeviceType: repository.Router, Hostname: "localhost"}
+	err := s.repo.CreateDevice(&d)
+	s.NoError(err)
+
+	patch := func(body string) int {
+		req := httptest.NewRequest(http.MethodPatch, "/devices/device1", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, req)
+		return w.Code
+	}
+	getOverride := func() string {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/devices/device1", nil))
+		s.Equal(http.StatusOK, w.Code)
+		var dia api.DeviceDiagnostics
+		s.helper.MustDecodeJSON(w.Body.Bytes(), &dia)
+		return dia.PollIntervalOverride
+	}
+
+	// the polling timeout of the device type must fit in the override
+	for _, body := range []string{`{"poll_interval_override":"fast"}`, `{"poll_interval_override":"-1s"}`, `{"poll_interval_override":"100ms"}`} {
+		s.Equal(http.StatusBadRequest, patch(body), body)
+	}
+	s.Empty(getOverride())
+
+	s.Equal(http.StatusOK, patch(`{"poll_interval_override":"10s"}`))
+	s.Equal("10s", getOverride())
+	device, err := s.repo.GetDeviceByID("device1")
+	s.NoError(err)
+	s.Equal(lo.ToPtr(10*time.Second), device.PollIntervalOverride)
+
+	// the expected firmware version is left untouched
+	s.Equal(http.StatusOK, patch(`{"expected_fw_version":"1.0.0"}`))
+	s.Equal("10s", getOverride())
+
+	s.Equal(http.StatusOK, patch(`{"poll_interval_override":""}`))
+	s.Empty(getOverride())
+	device, err = s.repo.GetDeviceByID("device1")
+	s.NoError(err)
+	s.Equal(lo.ToPtr("1.0.0"), device.ExpectedFwVersion)
+
+	req := httptest.NewRequest(http.MethodPut, "/devices", getReader(addDevicesRequest{
+		Devices: []deviceInfo{{
+			DeviceID:             "device2",
+			DeviceType:           repository.Router,
+			Hostname:             "localhost",
+			HealthCheckPort:      8080,
+			PollIntervalOverride: "100ms",
+		}},
+	}))
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusBadRequest, w.Code)
+	s.Contains(w.Body.String(), "poll")
+}
+
+func (s *routerTestSuite) TestToggleDeviceType() {
+	device := repository.Device{
+		DeviceID:   "device1",
+		DeviceType: repository.Camera,
+		Hostname:   "localhost",
+		Protocols:  pq.StringArray([]string{"grpc"}),
+	}
+	err := s.repo.CreateDevice(&device)
+	s.NoError(err)
+
+	toggle := func(path string) int {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, req)
+		return w.Code
+	}
+	claim := func() []repository.Device {
+		devices, err := s.repo.GetDevicesByPollingParameter(repository.DevicePollingParameter{
+			DeviceType: repository.Camera,
+			Interval:   time.Hour,
+			Limit:      10,
+		})
+		s.NoError(err)
+		return devices
+	}
+	// device types are kept across tests
+	defer func() {
+		s.Equal(http.StatusOK, toggle("/device-types/"+repository.Camera+"/enable"))
+	}()
+
+	s.Equal(http.StatusNotFound, toggle("/device-types/unknown/disable"))
+	s.Equal(http.StatusOK, toggle("/device-types/"+repository.Camera+"/disable"))
+	dt, err := s.repo.GetDeviceTypeByName(repository.Camera)
+	s.NoError(err)
+	s.False(dt.Enabled)
+	s.Empty(claim())
+
+	s.Equal(http.StatusOK, toggle("/device-types/"+repository.Camera+"/enable"))
+	devices := claim()
+	s.Len(devices, 1)
+	s.Equal(device.DeviceID, devices[0].DeviceID)
+}
+
+func (s *routerTestSuite) TestPollNow() {
+	s.T().Setenv("POLL_NOW_MAX_DEVICES", "3")
+
+	lastCheckedAt := time.Now()
+	var devices []*repository.Device
+	for i := range 5 {
+		device := &repository.Device{
+			DeviceID:      fmt.Sprintf("device%d", i+1),
+			DeviceType:    repository.Router,
+			Hostname:      "localhost",
+			Protocols:     pq.StringArray([]string{"grpc"}),
+			PollingStatus: lo.ToPtr(repository.PollingDone),
+			LastCheckedAt: &lastCheckedAt,
+		}
+		switch i {
+		case 0:
+			device.PollingStatus = lo.ToPtr(repository.PollingInProgress)
+		case 1:
+			// never polled
+			device.PollingStatus = nil
+			device.LastCheckedAt = nil
+		}
+		devices = append(devices, device)
+	}
+	err := s.repo.CreateDevices(devices)
+	s.NoError(err)
+
+	cfg, err := s.router.psy.GetPollingConfigByDeviceType(repository.Router)
+	s.NoError(err)
+	param := repository.DevicePollingParameter{
+		DeviceType:     repository.Router,
+		Interval:       cfg.Interval,
+		OutdatedPeriod: lo.ToPtr(24 * time.Hour),
+		Limit:          10,
+	}
+	pollable, err := s.repo.GetDevicesByPollingParameter(param)
+	s.NoError(err)
+	s.Equal([]string{"device2"}, lo.Map(pollable, func(d repository.Device, _ int) string {
+		return d.DeviceID
+	}))
+	// release the device never polled claimed by the check above
+	err = s.repo.UpdateDevice(devices[1])
+	s.NoError(err)
+
+	req := httptest.NewRequest(http.MethodPost, "/device-types/unknown/poll-now", nil)
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusNotFound, w.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/device-types/"+repository.Router+"/poll-now", nil)
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusAccepted, w.Code)
+
+	var resp pollNowResponse
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &resp)
+	s.NotEmpty(resp.JobID)
+	s.Equal(repository.Router, resp.DeviceType)
+	s.Equal(3, resp.MaxDevices)
+
+	// the device being polled is left untouched, and no more devices than the cap are reset
+	s.Eventually(func() bool {
+		pollable, err = s.repo.GetDevicesByPollingParameter(param)
+		s.NoError(err)
+		return len(pollable) > 1
+	}, time.Second, 10*time.Millisecond)
+	s.ElementsMatch([]string{"device2", "device3", "device4"}, lo.Map(pollable, func(d repository.Device, _ int) string {
+		return d.DeviceID
+	}))
+
+	// the devices reset keep a last check, moved back before the polling checkpoint, and the one never polled keeps none
+	for _, d := range pollable {
+		if d.DeviceID == "device2" {
+			s.Nil(d.LastCheckedAt)
+			continue
+		}
+		s.Require().NotNil(d.LastCheckedAt)
+		s.True(d.LastCheckedAt.Before(lastCheckedAt.Add(-param.Interval)))
+	}
+}
+
+func (s *routerTestSuite) TestUnroutedRequests() {
+	req := httptest.NewRequest(http.MethodPost, "/devices/device1", nil)
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusMethodNotAllowed, w.Code)
+	s.Equal("GET, PATCH, DELETE", w.Header().Get("Allow"))
+	s.Contains(w.Header().Get("Content-Type"), "application/json")
+
+	var resp errorResponse
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &resp)
+	s.Equal("method POST is not allowed on path /devices/device1", resp.Error)
+
+	req = httptest.NewRequest(http.MethodGet, "/unknown", nil)
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusNotFound, w.Code)
+	s.Contains(w.Header().Get("Content-Type"), "application/json")
+
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &resp)
+	s.Equal("path /unknown not found", resp.Error)
+}
+
+func (s *routerTestSuite) TestFlappingDevices() {
+	devices := []*repository.Device{
+		{DeviceID: "flapping", DeviceType: repository.Router, Hostname: "localhost", Protocols: pq.StringArray([]string{"grpc"})},
+		{DeviceID: "stable", DeviceType: repository.Router, Hostname: "localhost", Protocols: pq.StringArray([]string{"grpc"})},
+		{DeviceID: "recovered", DeviceType: repository.Router, Hostname: "localhost", Protocols: pq.StringArray([]string{"grpc"})},
+	}
+	err := s.repo.CreateDevices(devices)
+	s.NoError(err)
+
+	results := map[string][]repository.PollingResult{
+		"flapping":  {repository.PollSucceed, repository.PollFailed, repository.PollSucceed, repository.PollFailed, repository.PollSucceed, repository.PollFailed},
+		"stable":    {repository.PollSucceed, repository.PollSucceed, repository.PollSucceed, repository.PollSucceed, repository.PollSucceed, repository.PollSucceed},
+		"recovered": {repository.PollFailed, repository.PollFailed, repository.PollFailed, repository.PollSucceed, repository.PollSucceed, repository.PollSucceed},
+	}
+	now := time.Now()
+	var histories []*repository.PollingHistory
+	for deviceID, rs := range results {
+		for i, result := range rs {
+			histories = append(histories, &repository.PollingHistory{
+				DeviceID:      deviceID,
+				PollingResult: result,
+				CreatedAt:     now.Add(time.Duration(i-len(rs)) * time.Minute),
+			})
+		}
+	}
+	// transitions out of the window do not count
+	histories = append(histories, &repository.PollingHistory{
+		DeviceID:      "recovered",
+		PollingResult: repository.PollSucceed,
+		CreatedAt:     now.Add(-2 * time.Hour),
+	})
+	err = s.repo.CreatePollingHistories(histories)
+	s.NoError(err)
+
+	getFlapping := func(query string) (int, flappingDevicesResponse) {
+		req := httptest.NewRequest(http.MethodGet, "/devices/flapping"+query, nil)
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, req)
+
+		var resp flappingDevicesResponse
+		if w.Code == http.StatusOK {
+			s.helper.MustDecodeJSON(w.Body.Bytes(), &resp)
+		}
+		return w.Code, resp
+	}
+
+	code, resp := getFlapping("?window=1h&min_transitions=4")
+	s.Equal(http.StatusOK, code)
+	s.Equal([]flappingDevice{{DeviceID: "flapping", Transitions: 5}}, resp.Items)
+
+	code, resp = getFlapping("?window=3h&min_transitions=1")
+	s.Equal(http.StatusOK, code)
+	s.Equal([]flappingDevice{{DeviceID: "flapping", Transitions: 5}, {DeviceID: "recovered", Transitions: 2}}, resp.Items)
+
+	code, _ = getFlapping("?window=-1h")
+	s.Equal(http.StatusBadRequest, code)
+	code, _ = getFlapping("?min_transitions=0")
+	s.Equal(http.StatusBadRequest, code)
+}
+
+func (s *routerTestSuite) TestSearchDevices() {
+	devices := []*repository.Device{
+		{DeviceID: "device1", DeviceType: repository.Router, Hostname: "edge-router-1.lab", Protocols: pq.StringArray([]string{"grpc"})},
+		{DeviceID: "device2", DeviceType: repository.Router, Hostname: "core-router-2.lab", Protocols: pq.StringArray([]string{"grpc"})},
+		{DeviceID: "device3", DeviceType: repository.Router, Hostname: "edge-router-3.lab", Protocols: pq.StringArray([]string{"grpc"})},
+		{DeviceID: "device4", DeviceType: repository.Camera, Hostname: "edge-camera-4.lab", Protocols: pq.StringArray([]string{"rest"})},
+	}
+	err := s.repo.CreateDevices(devices)
+	s.NoError(err)
+	err = s.repo.CreatePollingHistory(&repository.PollingHistory{
+		DeviceID:      "device3",
+		HwVersion:     lo.ToPtr("hw"),
+		SwVersion:     lo.ToPtr("sw"),
+		FwVersion:     lo.ToPtr("fw"),
+		DeviceStatus:  lo.ToPtr("operating"),
+		PollingResult: repository.PollSucceed,
+	})
+	s.NoError(err)
+
+	search := func(query string) (int, deviceListingResponse) {
+		req := httptest.NewRequest(http.MethodGet, "/devices/search"+query, nil)
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, req)
+
+		var resp deviceListingResponse
+		if w.Code == http.StatusOK {
+			s.helper.MustDecodeJSON(w.Body.Bytes(), &resp)
+		}
+		return w.Code, resp
+	}
+	deviceIDs := func(resp deviceListingResponse) []string {
+		return lo.Map(resp.Items, func(d *api.DeviceDiagnostics, _ int) string {
+			return d.DeviceID
+		})
+	}
+
+	code, resp := search("?device_type=router&hostname=EDGE")
+	s.Equal(http.StatusOK, code)
+	s.Equal(2, resp.Total)
+	s.Equal([]string{"device1", "device3"}, deviceIDs(resp))
+
+	code, resp = search("?device_type=router&hostname=edge&sort=device_id&order=desc&size=1")
+	s.Equal(http.StatusOK, code)
+	s.Equal(2, resp.Total)
+	s.Equal([]string{"device3"}, deviceIDs(resp))
+
+	// computed filters
+	code, resp = search("?hostname=edge&connectivity=connected")
+	s.Equal(http.StatusOK, code)
+	s.Equal(1, resp.Total)
+	s.Equal([]string{"device3"}, deviceIDs(resp))
+
+	code, resp = search("?device_type=router&connectivity=onboarding&never_polled=true")
+	s.Equal(http.StatusOK, code)
+	s.Equal(2, resp.Total)
+	s.Equal([]string{"device1", "device2"}, deviceIDs(resp))
+
+	for _, query := range []string{"?label=lab", "?sort=checksum", "?order=up", "?connectivity=online", "?never_polled=maybe"} {
+		code, _ = search(query)
+		s.Equal(http.StatusBadRequest, code, query)
+	}
+}
+
+func (s *routerTestSuite) TestDeleteDeviceWithReason() {
+	devices := []*repository.Device{
+		{DeviceID: "device1", DeviceType: repository.Router, Hostname: "localhost", Protocols: pq.StringArray([]string{"grpc"})},
+		{DeviceID: "device2", DeviceType: repository.Router, Hostname: "localhost", Protocols: pq.StringArray([]string{"grpc"})},
+		{DeviceID: "device3", DeviceType: repository.Router, Hostname: "localhost", Protocols: pq.StringArray([]string{"grpc"})},
+	}
+	err := s.repo.CreateDevices(devices)
+	s.NoError(err)
+
+	req := httptest.NewRequest(http.MethodDelete, "/devices/device1?reason="+url.QueryEscape("decommissioned rack 4"), nil)
+	req.Header.Set("X-Actor", "alice")
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusOK, w.Code)
+
+	req = httptest.NewRequest(http.MethodDelete, "/devices/device2", nil)
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusOK, w.Code)
+
+	req = httptest.NewRequest(http.MethodDelete, "/devices/device3?reason="+strings.Repeat("x", 501), nil)
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusBadRequest, w.Code)
+
+	device, err := s.repo.GetDeviceByID("device1")
+	s.NoError(err)
+	s.NotNil(device.DeletedAt)
+	s.Equal("decommissioned rack 4", lo.FromPtr(device.DeletedReason))
+	s.Equal("alice", lo.FromPtr(device.DeletedBy))
+
+	req = httptest.NewRequest(http.MethodGet, "/devices/deleted", nil)
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusOK, w.Code)
+
+	var resp deletedDevicesResponse
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &resp)
+	s.Equal(2, resp.Total)
+	s.Require().Len(resp.Items, 2)
+	// the most recently deleted first
+	s.Equal("device2", resp.Items[0].DeviceID)
+	s.Nil(resp.Items[0].DeletedReason)
+	s.Nil(resp.Items[0].DeletedBy)
+	s.Equal("device1", resp.Items[1].DeviceID)
+	s.Equal("decommissioned rack 4", lo.FromPtr(resp.Items[1].DeletedReason))
+	s.Equal("alice", lo.FromPtr(resp.Items[1].DeletedBy))
+
+	// the reason is cleared once the device is restored
+	err = s.repo.RestoreDevice(device.ID)
+	s.NoError(err)
+	device, err = s.repo.GetDeviceByID("device1")
+	s.NoError(err)
+	s.Nil(device.DeletedReason)
+	s.Nil(device.DeletedBy)
+}
+
+func (s *routerTestSuite) TestRestoreDeletedDevice() {
+	device := repository.Device{
+		DeviceID:   "device1",
+		DeviceType: repository.Router,
+		Hostname:   "localhost",
+		Protocols:  pq.StringArray([]string{"grpc"}),
+	}
+	err := s.repo.CreateDevice(&device)
+	s.NoError(err)
+
+	restore := func(deviceID string) int {
+		req := httptest.NewRequest(http.MethodPost, "/devices/"+deviceID+"/restore", nil)
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, req)
+		return w.Code
+	}
+	listDeleted := func() deletedDevicesResponse {
+		req := httptest.NewRequest(http.MethodGet, "/devices/deleted", nil)
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, req)
+		s.Equal(http.StatusOK, w.Code)
+
+		var resp deletedDevicesResponse
+		s.helper.MustDecodeJSON(w.Body.Bytes(), &resp)
+		return resp
+	}
+
+	s.Equal(http.StatusConflict, restore("device1"))
+	s.Equal(http.StatusNotFound, restore("device2"))
+
+	req := httptest.NewRequest(http.MethodDelete, "/devices/device1", nil)
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusOK, w.Code)
+	resp := listDeleted()
+	s.Equal(1, resp.Total)
+	s.Equal("device1", resp.Items[0].DeviceID)
+
+	s.Equal(http.StatusOK, restore("device1"))
+	resp = listDeleted()
+	s.Zero(resp.Total)
+	s.Empty(resp.Items)
+
+	req = httptest.NewRequest(http.MethodGet, "/devices/device1", nil)
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusOK, w.Code)
+}
+
 func (s *routerTestSuite) TestListingDevices() {
 	d1 := repository.Device{
 		DeviceID:   "device1",
@@ -136,115 +853,919 @@ func (s *routerTestSuite) TestListingDevices() {
 		Hostname:   "localhost1",
 		Protocols:  pq.StringArray([]string{"http", "grpc"}),
 	}
-	d2 := repository.Device{
-		DeviceID:   "device2",
-		DeviceType: repository.Switch,
-		Hostname:   "localhost2",
-		Protocols:  pq.StringArray([]string{"http", "grpc"}),
+	d2 := repository.Device{
+		DeviceID:   "device2",
+		DeviceType: repository.Switch,
+		Hostname:   "localhost2",
+		Protocols:  pq.StringArray([]string{"http", "grpc"}),
+	}
+	d3 := repository.Device{
+		DeviceID:   "device3",
+		DeviceType: repository.DoorAccessSystem,
+		Hostname:   "localhost3",
+		Protocols:  pq.StringArray([]string{"http", "grpc"}),
+	}
+	err := s.repo.CreateDevices([]*repository.Device{&d1, &d2, &d3})
+	s.NoError(err)
+
+	d1Interval, err := s.router.psy.GetPollingConfigByDeviceType(d1.DeviceType)
+	s.NoError(err)
+
+	d2Interval, err := s.router.psy.GetPollingConfigByDeviceType(d1.DeviceType)
+	s.NoError(err)
+
+	d3Interval, err := s.router.psy.GetPollingConfigByDeviceType(d1.DeviceType)
+	s.NoError(err)
+
+	d1Histories := []*repository.PollingHistory{
+		{
+			DeviceID:       d1.DeviceID,
+			HwVersion:      lo.ToPtr(helper.RandomString(10)),
+			SwVersion:      lo.ToPtr(helper.RandomString(10)),
+			FwVersion:      lo.ToPtr(helper.RandomString(10)),
+			DeviceChecksum: lo.ToPtr(helper.RandomString(32)),
+			DeviceStatus:   lo.ToPtr("running"),
+			PollingResult:  repository.PollSucceed,
+			CreatedAt:      time.Now(),
+		},
+		{
+			DeviceID:      d1.DeviceID,
+			PollingResult: repository.PollFailed,
+			CreatedAt:     time.Now().Add(-3 * d1Interval.Interval),
+		},
+	}
+	err = s.repo.CreatePollingHistories(d1Histories)
+	s.NoError(err)
+
+	var d2Histories []*repository.PollingHistory
+	for i := range 20 {
+		d2History := repository.PollingHistory{
+			DeviceID:      d2.DeviceID,
+			PollingResult: repository.PollFailed,
+			CreatedAt:     time.Now().Add(-time.Duration(i) * d2Interval.Interval),
+		}
+		d2Histories = append(d2Histories, &d2History)
+	}
+	err = s.repo.CreatePollingHistories(d2Histories)
+	s.NoError(err)
+
+	var d3Histories []*repository.PollingHistory
+	for i := range 20 {
+		var r repository.PollingResult
+		if i%2 == 0 {
+			r = repository.PollFailed
+		} else {
+			r = repository.PollSucceed
+		}
+
+		d3History := repository.PollingHistory{
+			DeviceID:      d3.DeviceID,
+			PollingResult: r,
+			CreatedAt:     time.Now().Add(-time.Duration(i) * d3Interval.Interval),
+		}
+		d3Histories = append(d3Histories, &d3History)
+	}
+	err = s.repo.CreatePollingHistories(d3Histories)
+	s.NoError(err)
+
+	req := httptest.NewRequest(http.MethodGet, "/devices", nil)
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusOK, w.Code)
+
+	var listingResp deviceListingResponse
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &listingResp)
+	s.Equal(3, listingResp.Total)
+	s.Equal(3, len(listingResp.Items))
+
+	for _, item := range listingResp.Items {
+		if item.DeviceID == d1.DeviceID {
+			s.Equal(api.Connected, item.Connectivity)
+			continue
+		}
+		if item.DeviceID == d2.DeviceID {
+			s.Equal(api.Disconnected, item.Connectivity)
+			continue
+		}
+		if item.DeviceID == d3.DeviceID {
+			s.Equal(api.Connecting, item.Connectivity)
+			continue
+		}
+	}
+}
+
+func (s *routerTestSuite) TestListingCachedDevices() {
+	s.router.cache = business.NewDiagnosticsCache()
+	defer func() {
+		s.router.cache = nil
+	}()
+
+	d := repository.Device{
+		DeviceID:   "device1",
+		DeviceType: repository.Router,
+		Hostname:   "localhost",
+		Protocols:  pq.StringArray([]string{"grpc"}),
+	}
+	err := s.repo.CreateDevice(&d)
+	s.NoError(err)
+
+	newHistory := func(status string) *repository.PollingHistory {
+		return &repository.PollingHistory{
+			DeviceID:       d.DeviceID,
+			HwVersion:      lo.ToPtr(helper.RandomString(10)),
+			SwVersion:      lo.ToPtr(helper.RandomString(10)),
+			FwVersion:      lo.ToPtr(helper.RandomString(10)),
+			DeviceChecksum: lo.ToPtr(helper.RandomString(32)),
+			DeviceStatus:   lo.ToPtr(status),
+			PollingResult:  repository.PollSucceed,
+		}
+	}
+	err = s.repo.CreatePollingHistory(newHistory("running"))
+	s.NoError(err)
+
+	err = s.router.cache.Reconcile(context.Background(), s.repo, defaultHistoryCheckingSize, s.router.psy)
+	s.NoError(err)
+	s.NotNil(s.router.cache.RefreshedAt())
+
+	// a newer poll is not seen until the cache is reconciled again
+	time.Sleep(10 * time.Millisecond)
+	err = s.repo.CreatePollingHistory(newHistory("rebooting"))
+	s.NoError(err)
+
+	listDevices := func(path string) deviceListingResponse {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, req)
+		s.Equal(http.StatusOK, w.Code)
+
+		var resp deviceListingResponse
+		s.helper.MustDecodeJSON(w.Body.Bytes(), &resp)
+		s.Len(resp.Items, 1)
+		return resp
+	}
+
+	resp := listDevices("/devices")
+	s.Equal("running", resp.Items[0].Status)
+	s.NotNil(resp.CachedAt)
+
+	resp = listDevices("/devices?fresh=true")
+	s.Equal("rebooting", resp.Items[0].Status)
+	s.Nil(resp.CachedAt)
+
+	err = s.router.cache.Reconcile(context.Background(), s.repo, defaultHistoryCheckingSize, s.router.psy)
+	s.NoError(err)
+
+	resp = listDevices("/devices")
+	s.Equal("rebooting", resp.Items[0].Status)
+
+	req := httptest.NewRequest(http.MethodGet, "/devices?fresh=maybe", nil)
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusBadRequest, w.Code)
+}
+
+func (s *routerTestSuite) TestListingNeverPolledDevices() {
+	polled := repository.Device{
+		DeviceID:      "device1",
+		DeviceType:    repository.Router,
+		Hostname:      "localhost1",
+		Protocols:     pq.StringArray([]string{"grpc"}),
+		LastCheckedAt: lo.ToPtr(time.Now()),
+		PollingStatus: lo.ToPtr(repository.PollingDone),
+	}
+	neverPolledRouter := repository.Device{
+		DeviceID:   "device2",
+		DeviceType: repository.Router,
+		Hostname:   "localhost2",
+		Protocols:  pq.StringArray([]string{"grpc"}),
+	}
+	neverPolledSwitch := repository.Device{
+		DeviceID:   "device3",
+		DeviceType: repository.Switch,
+		Hostname:   "localhost3",
+		Protocols:  pq.StringArray([]string{"rest"}),
+	}
+	err := s.repo.CreateDevices([]*repository.Device{&polled, &neverPolledRouter, &neverPolledSwitch})
+	s.NoError(err)
+
+	req := httptest.NewRequest(http.MethodGet, "/devices?never_polled=true", nil)
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusOK, w.Code)
+
+	var listingResp deviceListingResponse
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &listingResp)
+	s.Equal(2, listingResp.Total)
+	s.ElementsMatch(
+		[]string{neverPolledRouter.DeviceID, neverPolledSwitch.DeviceID},
+		lo.Map(listingResp.Items, func(d *api.DeviceDiagnostics, _ int) string { return d.DeviceID }),
+	)
+
+	// combined with the device type filter
+	req = httptest.NewRequest(http.MethodGet, "/devices?never_polled=true&device_type="+repository.Router, nil)
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusOK, w.Code)
+
+	listingResp = deviceListingResponse{}
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &listingResp)
+	s.Equal(1, listingResp.Total)
+	s.Len(listingResp.Items, 1)
+	s.Equal(neverPolledRouter.DeviceID, listingResp.Items[0].DeviceID)
+
+	req = httptest.NewRequest(http.MethodGet, "/devices?never_polled=maybe", nil)
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusBadRequest, w.Code)
+}
+
+func (s *routerTestSuite) TestListingDevicesOfDeviceTypes() {
+	var devices []*repository.Device
+	for i, dt := range []string{repository.Router, repository.Switch, repository.Camera} {
+		for j := range i + 2 {
+			devices = append(devices, &repository.Device{
+				DeviceID:   fmt.Sprintf("%s%d", dt, j),
+				DeviceType: dt,
+				Hostname:   fmt.Sprintf("localhost-%s%d", dt, j),
+			})
+		}
+	}
+	err := s.repo.CreateDevices(devices)
+	s.NoError(err)
+
+	req := httptest.NewRequest(http.MethodGet, "/devices?size=2&device_type=router,%20camera", nil)
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusOK, w.Code)
+
+	var listingResp deviceListingResponse
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &listingResp)
+	s.Equal(6, listingResp.Total)
+	s.Len(listingResp.Items, 2)
+
+	req = httptest.NewRequest(http.MethodGet, "/devices?size=100&device_type=router,camera", nil)
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusOK, w.Code)
+
+	listingResp = deviceListingResponse{}
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &listingResp)
+	s.Equal(6, listingResp.Total)
+	s.ElementsMatch([]string{repository.Router, repository.Camera}, lo.Uniq(lo.Map(listingResp.Items, func(d *api.DeviceDiagnostics, _ int) string {
+		return d.DeviceType
+	})))
+
+	for _, invalid := range []string{",", "router,,camera", "router,%20"} {
+		req = httptest.NewRequest(http.MethodGet, "/devices?device_type="+invalid, nil)
+		w = httptest.NewRecorder()
+		s.router.ServeHTTP(w, req)
+		s.Equal(http.StatusBadRequest, w.Code, invalid)
+	}
+}
+
+func (s *routerTestSuite) TestListingDevicesSummaryView() {
+	device := repository.Device{
+		DeviceID:      "device1",
+		DeviceType:    repository.Router,
+		Hostname:      "localhost1",
+		Protocols:     pq.StringArray([]string{"grpc"}),
+		LastCheckedAt: lo.ToPtr(time.Now()),
+		PollingStatus: lo.ToPtr(repository.PollingDone),
 	}
-	d3 := repository.Device{
-		DeviceID:   "device3",
-		DeviceType: repository.DoorAccessSystem,
-		Hostname:   "localhost3",
-		Protocols:  pq.StringArray([]string{"http", "grpc"}),
+	err := s.repo.CreateDevices([]*repository.Device{&device})
+	s.NoError(err)
+
+	req := httptest.NewRequest(http.MethodGet, "/devices?view=summary", nil)
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusOK, w.Code)
+
+	var raw struct {
+		Total int                      `json:"total"`
+		Items []map[string]interface{} `json:"items"`
 	}
-	err := s.repo.CreateDevices([]*repository.Device{&d1, &d2, &d3})
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &raw)
+	s.Equal(1, raw.Total)
+	s.Require().Len(raw.Items, 1)
+	item := raw.Items[0]
+	s.Equal(device.DeviceID, item["device_id"])
+	s.Contains(item, "connectivity")
+	s.Contains(item, "last_checked_at")
+	for _, heavy := range []string{"device_type", "device_host", "protocols", "hw_version", "sw_version", "fw_version", "status", "checksum"} {
+		s.NotContains(item, heavy)
+	}
+
+	// the full view is the default
+	req = httptest.NewRequest(http.MethodGet, "/devices?view=full", nil)
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusOK, w.Code)
+
+	var listingResp deviceListingResponse
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &listingResp)
+	s.Require().Len(listingResp.Items, 1)
+	s.Equal(device.Hostname, listingResp.Items[0].DeviceHost)
+
+	req = httptest.NewRequest(http.MethodGet, "/devices?view=tiny", nil)
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusBadRequest, w.Code)
+}
+
+func (s *routerTestSuite) TestListingDevicesAsNDJSON() {
+	var devices []*repository.Device
+	for i := range 3 {
+		devices = append(devices, &repository.Device{
+			DeviceID:   fmt.Sprintf("device%d", i+1),
+			DeviceType: repository.Router,
+			Hostname:   fmt.Sprintf("localhost%d", i+1),
+		})
+	}
+	err := s.repo.CreateDevices(devices)
 	s.NoError(err)
 
-	d1Interval, err := s.router.psy.GetPollingConfigByDeviceType(d1.DeviceType)
+	// the pagination does not apply to the stream
+	req := httptest.NewRequest(http.MethodGet, "/devices?size=1", nil)
+	req.Header.Set("Accept", "application/x-ndjson")
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("application/x-ndjson", w.Header().Get("Content-Type"))
+
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	s.Require().Len(lines, len(devices))
+	for i, line := range lines {
+		var dia api.DeviceDiagnostics
+		s.helper.MustDecodeJSON([]byte(line), &dia)
+		s.Equal(devices[i].DeviceID, dia.DeviceID)
+		s.Equal(devices[i].Hostname, dia.DeviceHost)
+	}
+}
+
+func (s *routerTestSuite) TestTimestampsInUTC() {
+	lastCheckedAt := time.Now().In(time.FixedZone("CEST", 2*60*60))
+	device := repository.Device{
+		DeviceID:      "device1",
+		DeviceType:    repository.Router,
+		Hostname:      "localhost",
+		Protocols:     pq.StringArray([]string{"grpc"}),
+		LastCheckedAt: &lastCheckedAt,
+		PollingStatus: lo.ToPtr(repository.PollingDone),
+	}
+	err := s.repo.CreateDevice(&device)
 	s.NoError(err)
 
-	d2Interval, err := s.router.psy.GetPollingConfigByDeviceType(d1.DeviceType)
+	req := httptest.NewRequest(http.MethodGet, "/devices?view=summary", nil)
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusOK, w.Code)
+
+	var raw struct {
+		Items []map[string]string `json:"items"`
+	}
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &raw)
+	s.Require().Len(raw.Items, 1)
+	value := raw.Items[0]["last_checked_at"]
+	s.True(strings.HasSuffix(value, "Z"), value)
+	parsed, err := time.Parse(api.TimestampFormat, value)
 	s.NoError(err)
+	s.WithinDuration(lastCheckedAt, parsed, time.Millisecond)
+}
 
-	d3Interval, err := s.router.psy.GetPollingConfigByDeviceType(d1.DeviceType)
+func (s *routerTestSuite) TestFreshnessSLO() {
+	fresh := time.Now()
+	stale := time.Now().Add(-30 * 24 * time.Hour)
+	var devices []*repository.Device
+	for i, lastCheckedAt := range []*time.Time{&fresh, &fresh, &stale, nil} {
+		devices = append(devices, &repository.Device{
+			DeviceID:      fmt.Sprintf("device%d", i+1),
+			DeviceType:    repository.Router,
+			Hostname:      "localhost",
+			Protocols:     pq.StringArray([]string{"grpc"}),
+			LastCheckedAt: lastCheckedAt,
+		})
+	}
+	err := s.repo.CreateDevices(devices)
 	s.NoError(err)
 
-	d1Histories := []*repository.PollingHistory{
-		{
-			DeviceID:       d1.DeviceID,
-			HwVersion:      lo.ToPtr(helper.RandomString(10)),
-			SwVersion:      lo.ToPtr(helper.RandomString(10)),
-			FwVersion:      lo.ToPtr(helper.RandomString(10)),
-			DeviceChecksum: lo.ToPtr(helper.RandomString(32)),
+	getSLO := func(path string) (int, sloResponse) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, req)
+		var resp sloResponse
+		if w.Code == http.StatusOK || w.Code == http.StatusServiceUnavailable {
+			s.helper.MustDecodeJSON(w.Body.Bytes(), &resp)
+		}
+		return w.Code, resp
+	}
+
+	code, resp := getSLO("/slo")
+	s.Equal(http.StatusOK, code)
+	s.Equal(50.0, resp.Score)
+	s.False(resp.Breached)
+	router, ok := lo.Find(resp.DeviceTypes, func(f deviceTypeFreshness) bool { return f.DeviceType == repository.Router })
+	s.True(ok)
+	s.Equal(4, router.Total)
+	s.Equal(2, router.Fresh)
+	s.Equal(50.0, router.Score)
+
+	code, resp = getSLO("/slo?threshold=90")
+	s.Equal(http.StatusServiceUnavailable, code)
+	s.True(resp.Breached)
+	s.Equal(90.0, resp.Threshold)
+
+	s.T().Setenv("SLO_FRESHNESS_THRESHOLD", "40")
+	code, _ = getSLO("/slo")
+	s.Equal(http.StatusOK, code)
+
+	code, _ = getSLO("/slo?threshold=120")
+	s.Equal(http.StatusBadRequest, code)
+}
+
+func (s *routerTestSuite) TestGetDevicesSummary() {
+	devices := []*repository.Device{
+		{DeviceID: "router1", DeviceType: repository.Router, Hostname: "localhost1"},
+		{DeviceID: "router2", DeviceType: repository.Router, Hostname: "localhost2", PollingStatus: lo.ToPtr(repository.PollingMisconfigured)},
+		{DeviceID: "switch1", DeviceType: repository.Switch, Hostname: "localhost3"},
+	}
+	err := s.repo.CreateDevices(devices)
+	s.NoError(err)
+
+	req := httptest.NewRequest(http.MethodGet, "/devices/summary", nil)
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusOK, w.Code)
+
+	var resp devicesSummaryResponse
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &resp)
+	s.Equal(len(devices), resp.Total)
+	s.Require().Len(resp.DeviceTypes, 2)
+	s.Equal(repository.Router, resp.DeviceTypes[0].DeviceType)
+	s.Equal(1, resp.DeviceTypes[0].Connectivity[api.Onboarding])
+	s.Equal(1, resp.DeviceTypes[0].Connectivity[api.Misconfigured])
+	s.Equal(0, resp.DeviceTypes[0].Connectivity[api.Connected])
+	s.Equal(repository.Switch, resp.DeviceTypes[1].DeviceType)
+	s.Equal(1, resp.DeviceTypes[1].Connectivity[api.Onboarding])
+	s.Equal(resp.Total, lo.SumBy(resp.DeviceTypes, func(dt deviceTypeConnectivity) int { return dt.Total }))
+}
+
+func (s *routerTestSuite) TestGetSiteDiagnostics() {
+	devices := []*repository.Device{
+		{DeviceID: "router1", DeviceType: repository.Router, Hostname: "localhost1", Site: lo.ToPtr("north")},
+		{DeviceID: "router2", DeviceType: repository.Router, Hostname: "localhost2", Site: lo.ToPtr("north"), PollingStatus: lo.ToPtr(repository.PollingMisconfigured)},
+		{DeviceID: "switch1", DeviceType: repository.Switch, Hostname: "localhost3", Site: lo.ToPtr("south")},
+		{DeviceID: "switch2", DeviceType: repository.Switch, Hostname: "localhost4"},
+	}
+	err := s.repo.CreateDevices(devices)
+	s.NoError(err)
+
+	req := httptest.NewRequest(http.MethodGet, "/sites/north/diagnostics", nil)
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusOK, w.Code)
+
+	var resp siteDiagnosticsResponse
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &resp)
+	s.Equal("north", resp.Site)
+	s.Equal(2, resp.Total)
+	s.Equal(1, resp.Connectivity[api.Onboarding])
+	s.Equal(1, resp.Connectivity[api.Misconfigured])
+	s.ElementsMatch([]string{"router1", "router2"}, lo.Map(resp.Items, func(d *api.DeviceDiagnostics, _ int) string {
+		return d.DeviceID
+	}))
+
+	req = httptest.NewRequest(http.MethodGet, "/sites/west/diagnostics", nil)
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusOK, w.Code)
+
+	resp = siteDiagnosticsResponse{}
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &resp)
+	s.Equal(0, resp.Total)
+	s.Empty(resp.Items)
+}
+
+func (s *routerTestSuite) TestGetDeviceChanges() {
+	req := httptest.NewRequest(http.MethodGet, "/devices/device1/changes", nil)
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusNotFound, w.Code)
+
+	d := repository.Device{
+		DeviceID:   "device1",
+		DeviceType: repository.Router,
+		Hostname:   "localhost",
+		Protocols:  pq.StringArray([]string{"grpc"}),
+	}
+	err := s.repo.CreateDevice(&d)
+	s.NoError(err)
+
+	hw, sw, checksum := helper.RandomString(10), helper.RandomString(10), helper.RandomString(32)
+	oldFw, newFw := "1.0.0", "1.1.0"
+	start := time.Now().Add(-time.Hour)
+	newHistory := func(i int, fw string) *repository.PollingHistory {
+		return &repository.PollingHistory{
+			DeviceID:       d.DeviceID,
+			HwVersion:      &hw,
+			SwVersion:      &sw,
+			FwVersion:      lo.ToPtr(fw),
+			DeviceChecksum: &checksum,
 			DeviceStatus:   lo.ToPtr("running"),
 			PollingResult:  repository.PollSucceed,
-			CreatedAt:      time.Now(),
-		},
+			CreatedAt:      start.Add(time.Duration(i) * time.Minute),
+		}
+	}
+	histories := []*repository.PollingHistory{
+		newHistory(0, oldFw),
+		newHistory(1, oldFw),
 		{
-			DeviceID:      d1.DeviceID,
+			DeviceID:      d.DeviceID,
 			PollingResult: repository.PollFailed,
-			CreatedAt:     time.Now().Add(-3 * d1Interval.Interval),
+			CreatedAt:     start.Add(2 * time.Minute),
+		},
+		newHistory(3, newFw),
+		newHistory(4, newFw),
+	}
+	err = s.repo.CreatePollingHistories(histories)
+	s.NoError(err)
+
+	req = httptest.NewRequest(http.MethodGet, "/devices/device1/changes", nil)
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusOK, w.Code)
+
+	var resp deviceChangesResponse
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &resp)
+	s.Equal(d.DeviceID, resp.DeviceID)
+	s.Len(resp.Items, 1)
+	s.WithinDuration(histories[3].CreatedAt, resp.Items[0].ChangedAt.Time, time.Millisecond)
+	s.Equal([]api.FieldChange{{Field: "fw_version", Previous: oldFw, Current: newFw}}, resp.Items[0].Changes)
+
+	// the time window excludes the firmware bump
+	to := start.Add(2 * time.Minute).Format(time.RFC3339)
+	req = httptest.NewRequest(http.MethodGet, "/devices/device1/changes?to="+url.QueryEscape(to), nil)
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusOK, w.Code)
+
+	resp = deviceChangesResponse{}
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &resp)
+	s.Len(resp.Items, 0)
+
+	req = httptest.NewRequest(http.MethodGet, "/devices/device1/changes?from=yesterday", nil)
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusBadRequest, w.Code)
+
+	// the checksum changes are masked by default
+	newChecksum := helper.RandomString(32)
+	err = s.repo.CreatePollingHistories([]*repository.PollingHistory{
+		{
+			DeviceID:       d.DeviceID,
+			HwVersion:      &hw,
+			SwVersion:      &sw,
+			FwVersion:      lo.ToPtr(newFw),
+			DeviceChecksum: &newChecksum,
+			PollingResult:  repository.PollSucceed,
+			CreatedAt:      start.Add(5 * time.Minute),
 		},
+	})
+	s.NoError(err)
+
+	req = httptest.NewRequest(http.MethodGet, "/devices/device1/changes", nil)
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusOK, w.Code)
+
+	resp = deviceChangesResponse{}
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &resp)
+	s.Require().Len(resp.Items, 2)
+	s.Equal([]api.FieldChange{{
+		Field:    business.ChecksumField,
+		Previous: util.MaskSecret(checksum),
+		Current:  util.MaskSecret(newChecksum),
+	}}, resp.Items[1].Changes)
+	s.NotContains(w.Body.String(), checksum)
+	s.NotContains(w.Body.String(), newChecksum)
+
+	// and unmasked only with the admin token
+	s.T().Setenv("ADMIN_API_TOKEN", "test-token")
+	req = httptest.NewRequest(http.MethodGet, "/devices/device1/changes?unmask=true", nil)
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusUnauthorized, w.Code)
+
+	req.Header.Set("Authorization", "Bearer test-token")
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusOK, w.Code)
+
+	resp = deviceChangesResponse{}
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &resp)
+	s.Require().Len(resp.Items, 2)
+	s.Equal([]api.FieldChange{{Field: business.ChecksumField, Previous: checksum, Current: newChecksum}}, resp.Items[1].Changes)
+}
+
+func (s *routerTestSuite) TestGetDeviceStats() {
+	req := httptest.NewRequest(http.MethodGet, "/devices/device1/stats", nil)
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusNotFound, w.Code)
+
+	d := repository.Device{DeviceID: "device1", DeviceType: repository.Router, Hostname: "localhost"}
+	err := s.repo.CreateDevice(&d)
+	s.NoError(err)
+
+	hour := time.Now().Truncate(time.Hour).Add(-3 * time.Hour)
+	histories := []*repository.PollingHistory{
+		{DeviceID: d.DeviceID, PollingResult: repository.PollSucceed, CreatedAt: hour.Add(10 * time.Minute)},
+		{DeviceID: d.DeviceID, PollingResult: repository.PollFailed, CreatedAt: hour.Add(20 * time.Minute)},
+		{DeviceID: d.DeviceID, PollingResult: repository.PollSucceed, CreatedAt: hour.Add(150 * time.Minute)},
+		{DeviceID: d.DeviceID, PollingResult: repository.PollSucceed, CreatedAt: hour.Add(160 * time.Minute)},
+	}
+	err = s.repo.CreatePollingHistories(histories)
+	s.NoError(err)
+	_, err = s.repo.RollupPollingHistory(hour.Add(time.Hour))
+	s.NoError(err)
+
+	// the old window is read from the rollups and the recent one from the polling history
+	req = httptest.NewRequest(http.MethodGet, "/devices/device1/stats", nil)
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusOK, w.Code)
+
+	var resp deviceStatsResponse
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &resp)
+	s.Equal(d.DeviceID, resp.DeviceID)
+	s.Equal(3, resp.Succeeded)
+	s.Equal(1, resp.Failed)
+	s.Equal(2, resp.RolledUp)
+	s.InDelta(0.75, resp.SuccessRatio, 1e-9)
+
+	from := hour.Add(time.Hour).Format(time.RFC3339)
+	req = httptest.NewRequest(http.MethodGet, "/devices/device1/stats?from="+url.QueryEscape(from), nil)
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusOK, w.Code)
+
+	resp = deviceStatsResponse{}
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &resp)
+	s.Equal(2, resp.Succeeded)
+	s.Equal(0, resp.Failed)
+	s.Equal(0, resp.RolledUp)
+
+	for _, path := range []string{
+		"/devices/device1/stats?from=yesterday",
+		"/devices/device1/stats?from=" + url.QueryEscape(from) + "&to=" + url.QueryEscape(from),
+	} {
+		req = httptest.NewRequest(http.MethodGet, path, nil)
+		w = httptest.NewRecorder()
+		s.router.ServeHTTP(w, req)
+		s.Equal(http.StatusBadRequest, w.Code, path)
+	}
+}
+
+func (s *routerTestSuite) TestGetDeviceRawRecord() {
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/devices/device1/raw", nil))
+	s.Equal(http.StatusNotFound, w.Code)
+	s.Contains(w.Body.String(), "device not found")
+
+	err := s.repo.CreateDevices([]*repository.Device{{DeviceID: "device1", DeviceType: repository.Router, Hostname: "localhost"}})
+	s.NoError(err)
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/devices/device1/raw", nil))
+	s.Equal(http.StatusNotFound, w.Code)
+	s.Contains(w.Body.String(), "device has no polling history")
+
+	err = s.repo.CreatePollingHistories([]*repository.PollingHistory{
+		{DeviceID: "device1", PollingResult: repository.PollSucceed, DeviceChecksum: lo.ToPtr("abcdef"), CreatedAt: time.Now().Add(-time.Minute)},
+		{DeviceID: "device1", PollingResult: repository.PollFailed, FailureReason: lo.ToPtr(`{"error":"rebooting","count":2}`), GrpcCode: lo.ToPtr(14), DeviceChecksum: lo.ToPtr("123456"), CreatedAt: time.Now()},
+	})
+	s.NoError(err)
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/devices/device1/raw", nil))
+	s.Equal(http.StatusOK, w.Code)
+
+	var record map[string]any
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &record)
+	s.Equal("device1", record["device_id"])
+	s.Equal(string(repository.PollFailed), record["polling_result"])
+	s.Equal(`{"error":"rebooting","count":2}`, record["failure_reason"])
+	s.Equal(float64(14), record["grpc_code"])
+	s.Equal("1****6", record["device_checksum"])
+	s.Contains(record, "created_at")
+
+	// the checksum is unmasked for the admins only
+	token := helper.RandomString(16)
+	s.T().Setenv("ADMIN_API_TOKEN", token)
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/devices/device1/raw?unmask=true", nil))
+	s.Equal(http.StatusUnauthorized, w.Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/devices/device1/raw?unmask=true", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusOK, w.Code)
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &record)
+	s.Equal("123456", record["device_checksum"])
+}
+
+func (s *routerTestSuite) TestDeleteDeviceHistory() {
+	token := helper.RandomString(16)
+	newRequest := func(path, token string) *http.Request {
+		req := httptest.NewRequest(http.MethodDelete, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return req
+	}
+
+	// disabled without a configured token
+	s.T().Setenv("ADMIN_API_TOKEN", "")
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, newRequest("/devices/device1/history", token))
+	s.Equal(http.StatusForbidden, w.Code)
+
+	s.T().Setenv("ADMIN_API_TOKEN", token)
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, newRequest("/devices/device1/history", ""))
+	s.Equal(http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, newRequest("/devices/device1/history", "wrong-token"))
+	s.Equal(http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, newRequest("/devices/device1/history", token))
+	s.Equal(http.StatusNotFound, w.Code)
+
+	devices := []*repository.Device{
+		{DeviceID: "device1", DeviceType: repository.Router, Hostname: "localhost"},
+		{DeviceID: "device2", DeviceType: repository.Router, Hostname: "localhost"},
+	}
+	err := s.repo.CreateDevices(devices)
+	s.NoError(err)
+
+	start := time.Now().Add(-time.Hour)
+	var histories []*repository.PollingHistory
+	for _, d := range devices {
+		for i := range 4 {
+			histories = append(histories, &repository.PollingHistory{
+				DeviceID:      d.DeviceID,
+				PollingResult: repository.PollSucceed,
+				CreatedAt:     start.Add(time.Duration(i) * time.Minute),
+			})
+		}
+	}
+	err = s.repo.CreatePollingHistories(histories)
+	s.NoError(err)
+
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, newRequest("/devices/device1/history?before=yesterday", token))
+	s.Equal(http.StatusBadRequest, w.Code)
+
+	before := start.Add(2 * time.Minute).Format(time.RFC3339)
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, newRequest("/devices/device1/history?before="+url.QueryEscape(before), token))
+	s.Equal(http.StatusOK, w.Code)
+
+	var resp deleteDeviceHistoryResponse
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &resp)
+	s.Equal("device1", resp.DeviceID)
+	s.Equal(int64(2), resp.Deleted)
+
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, newRequest("/devices/device1/history", token))
+	s.Equal(http.StatusOK, w.Code)
+
+	resp = deleteDeviceHistoryResponse{}
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &resp)
+	s.Equal(int64(2), resp.Deleted)
+
+	got, err := s.repo.GetDevicePollingHistory("device1", 10)
+	s.NoError(err)
+	s.Empty(got)
+
+	got, err = s.repo.GetDevicePollingHistory("device2", 10)
+	s.NoError(err)
+	s.Len(got, 4)
+
+	device, err := s.repo.GetDeviceByID("device1")
+	s.NoError(err)
+	s.NotNil(device)
+}
+
+func (s *routerTestSuite) TestPruneHistory() {
+	token := helper.RandomString(16)
+	newRequest := func(path, token string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return req
+	}
+
+	s.T().Setenv("ADMIN_API_TOKEN", token)
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, newRequest("/admin/prune-history", ""))
+	s.Equal(http.StatusUnauthorized, w.Code)
+
+	devices := []*repository.Device{
+		{DeviceID: "device1", DeviceType: repository.Router, Hostname: "localhost"},
+		{DeviceID: "device2", DeviceType: repository.Router, Hostname: "localhost"},
 	}
-	err = s.repo.CreatePollingHistories(d1Histories)
+	err := s.repo.CreateDevices(devices)
 	s.NoError(err)
 
-	var d2Histories []*repository.PollingHistory
-	for i := range 20 {
-		d2History := repository.PollingHistory{
-			DeviceID:      d2.DeviceID,
-			PollingResult: repository.PollFailed,
-			CreatedAt:     time.Now().Add(-time.Duration(i) * d2Interval.Interval),
+	start := time.Now().Add(-time.Hour)
+	var histories []*repository.PollingHistory
+	for _, d := range devices {
+		for i := range 4 {
+			histories = append(histories, &repository.PollingHistory{
+				DeviceID:      d.DeviceID,
+				PollingResult: repository.PollSucceed,
+				CreatedAt:     start.Add(time.Duration(i) * time.Minute),
+			})
 		}
-		d2Histories = append(d2Histories, &d2History)
 	}
-	err = s.repo.CreatePollingHistories(d2Histories)
+	err = s.repo.CreatePollingHistories(histories)
 	s.NoError(err)
 
-	var d3Histories []*repository.PollingHistory
-	for i := range 20 {
-		var r repository.PollingResult
-		if i%2 == 0 {
-			r = repository.PollFailed
-		} else {
-			r = repository.PollSucceed
-		}
+	for _, path := range []string{
+		"/admin/prune-history",
+		"/admin/prune-history?before=yesterday",
+		"/admin/prune-history?before=" + url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339)),
+	} {
+		w = httptest.NewRecorder()
+		s.router.ServeHTTP(w, newRequest(path, token))
+		s.Equal(http.StatusBadRequest, w.Code, path)
+	}
 
-		d3History := repository.PollingHistory{
-			DeviceID:      d3.DeviceID,
-			PollingResult: r,
-			CreatedAt:     time.Now().Add(-time.Duration(i) * d3Interval.Interval),
-		}
-		d3Histories = append(d3Histories, &d3History)
+	before := start.Add(2 * time.Minute).Format(time.RFC3339)
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, newRequest("/admin/prune-history?before="+url.QueryEscape(before), token))
+	s.Equal(http.StatusOK, w.Code)
+
+	var resp pruneHistoryResponse
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &resp)
+	s.Equal(int64(4), resp.Deleted)
+
+	for _, d := range devices {
+		got, err := s.repo.GetDevicePollingHistory(d.DeviceID, 10)
+		s.NoError(err)
+		s.Len(got, 2)
 	}
-	err = s.repo.CreatePollingHistories(d3Histories)
-	s.NoError(err)
+}
 
-	req := httptest.NewRequest(http.MethodGet, "/devices", nil)
+func (s *routerTestSuite) TestGetWorkerStatus() {
+	// no heartbeat at all
+	req := httptest.NewRequest(http.MethodGet, "/worker/status", nil)
 	w := httptest.NewRecorder()
 	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusServiceUnavailable, w.Code)
+
+	var status workerStatusResponse
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &status)
+	s.False(status.Alive)
+
+	// recent heartbeat
+	err := s.repo.SaveWorkerHeartbeat("worker1")
+	s.NoError(err)
+
+	req = httptest.NewRequest(http.MethodGet, "/worker/status", nil)
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
 	s.Equal(http.StatusOK, w.Code)
 
-	var listingResp deviceListingResponse
-	s.helper.MustDecodeJSON(w.Body.Bytes(), &listingResp)
-	s.Equal(3, listingResp.Total)
-	s.Equal(3, len(listingResp.Items))
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &status)
+	s.True(status.Alive)
+	s.Equal("worker1", status.WorkerID)
+	s.NotNil(status.LastHeartbeatAt)
 
-	for _, item := range listingResp.Items {
-		if item.DeviceID == d1.DeviceID {
-			s.Equal(api.Connected, item.Connectivity)
-			continue
-		}
-		if item.DeviceID == d2.DeviceID {
-			s.Equal(api.Disconnected, item.Connectivity)
-			continue
-		}
-		if item.DeviceID == d3.DeviceID {
-			s.Equal(api.Connecting, item.Connectivity)
-			continue
-		}
-	}
+	// stale heartbeat
+	err = s.repo.Conn().Exec("update worker_heartbeats set updated_at = ?", time.Now().Add(-2*config.WorkerHeartbeatMaxAge())).Error
+	s.NoError(err)
+
+	req = httptest.NewRequest(http.MethodGet, "/worker/status", nil)
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusServiceUnavailable, w.Code)
+
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &status)
+	s.False(status.Alive)
 }
 
 func clearDB(db *gorm.DB) error {
-	s := strings.Join([]string{"devices", "polling_history"}, ",")
+	s := strings.Join([]string{"devices", "polling_history", "polling_history_rollup", "worker_heartbeats"}, ",")
 	q := fmt.Sprintf("truncate table %s restart identity cascade", s)
 	return db.Exec(q).Error
 }
 
 func (s *routerTestSuite) TestAddDevice() {
 	s.Run("bad_case_invalid_input", s.addDeviceInvalidInput)
+	s.Run("bad_case_duplicate_device_ids", s.addDeviceDuplicateIDs)
 	s.Run("add_3_devices_with_one_succeed", s.add3DevicesWithOneSucceed)
 }
 
@@ -272,6 +1793,39 @@ func (s *routerTestSuite) addDeviceInvalidInput() {
 	s.T().Logf("expected error: %s", w.Body.String())
 }
 
+func (s *routerTestSuite) addDeviceDuplicateIDs() {
+	reqObj := addDevicesRequest{
+		Devices: []deviceInfo{
+			{
+				DeviceID:   "device1",
+				DeviceType: "router",
+				Hostname:   "localhost1",
+			},
+			{
+				DeviceID:   "device2",
+				DeviceType: "switch",
+				Hostname:   "localhost2",
+			},
+			{
+				DeviceID:   " device1 ",
+				DeviceType: "camera",
+				Hostname:   "localhost3",
+			},
+		},
+	}
+
+	reqBody := getReader(reqObj)
+	req := httptest.NewRequest(http.MethodPut, "/devices", reqBody)
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusBadRequest, w.Code)
+	s.Contains(w.Body.String(), "duplicate device ids in request: device1")
+
+	device, err := s.repo.GetDeviceByID("device2")
+	s.ErrorIs(err, repository.ErrRecordNotFound)
+	s.Nil(device)
+}
+
 func (s *routerTestSuite) add3DevicesWithOneSucceed() {
 	s.T().Setenv("HEALTH_CHECK_TIMEOUT", "100ms")
 	healthCheckPath := config.HealthCheckPath()
@@ -360,6 +1914,7 @@ func (s *routerTestSuite) add3DevicesWithOneSucceed() {
 		if result.DeviceID == "device3" {
 			s.Equal(0, result.Code)
 			s.Equal("", result.Error)
+			s.Equal(string(business.DeviceCreated), result.Outcome)
 		} else {
 			s.NotEqual(0, result.Code)
 			s.T().Logf("expected error for device %s: %s", result.DeviceID, result.Error)
@@ -372,6 +1927,446 @@ func (s *routerTestSuite) add3DevicesWithOneSucceed() {
 	s.Equal(repository.DoorAccessSystem, device.DeviceType)
 	s.Equal(restPort, *device.RestPort)
 	s.Equal(grpcPort, *device.GrpcPort)
+
+	// adding the same device again changes nothing
+	reqBody = getReader(addDevicesRequest{Devices: reqObj.Devices[2:]})
+	req = httptest.NewRequest(http.MethodPut, "/devices", reqBody)
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusOK, w.Code)
+
+	resp = addDevicesResponse{}
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &resp)
+	s.Len(resp.Results, 1)
+	s.Equal(0, resp.Results[0].Code)
+	s.Equal(string(business.DeviceExisted), resp.Results[0].Outcome)
+}
+
+func (s *routerTestSuite) TestAddDeviceHealthCheckRetry() {
+	s.T().Setenv("HEALTH_CHECK_RETRY_DELAY", "10ms")
+
+	var calls atomic.Int32
+	h := chi.NewRouter()
+	h.Get(config.HealthCheckPath(), func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		util.ResponseAsJSON(w, http.StatusOK, api.DeviceHealthCheckResponse{
+			DeviceID:     "device1",
+			DeviceType:   repository.Router,
+			Capabilities: []api.PollingCapability{{Protocol: repository.GRPC, Port: lo.ToPtr(50055)}},
+		})
+	})
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(u.Port())
+	req := httptest.NewRequest(http.MethodPut, "/devices", getReader(addDevicesRequest{
+		Devices: []deviceInfo{
+			{
+				DeviceID:        "device1",
+				DeviceType:      repository.Router,
+				Hostname:        u.Hostname(),
+				HealthCheckPort: port,
+			},
+		},
+	}))
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusOK, w.Code)
+	s.EqualValues(2, calls.Load())
+
+	var resp addDevicesResponse
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &resp)
+	s.Require().Len(resp.Results, 1)
+	s.Empty(resp.Results[0].Error)
+	s.Equal(string(business.DeviceCreated), resp.Results[0].Outcome)
+
+	device, err := s.repo.GetDeviceByID("device1")
+	s.NoError(err)
+	s.NotNil(device)
+}
+
+func (s *routerTestSuite) TestAddDevicesIdempotencyKey() {
+	var calls atomic.Int32
+	h := chi.NewRouter()
+	h.Get(config.HealthCheckPath(), func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		util.ResponseAsJSON(w, http.StatusOK, api.DeviceHealthCheckResponse{
+			DeviceID:     "device1",
+			DeviceType:   repository.Router,
+			Capabilities: []api.PollingCapability{{Protocol: repository.GRPC, Port: lo.ToPtr(50055)}},
+		})
+	})
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(u.Port())
+	device := deviceInfo{
+		DeviceID:        "device1",
+		DeviceType:      repository.Router,
+		Hostname:        u.Hostname(),
+		HealthCheckPort: port,
+	}
+	key := helper.RandomString(16)
+	put := func(key string, devices ...deviceInfo) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/devices", getReader(addDevicesRequest{Devices: devices}))
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := put(key, device)
+	s.Equal(http.StatusOK, w.Code)
+	var first addDevicesResponse
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &first)
+	s.Require().Len(first.Results, 1)
+	s.Equal(string(business.DeviceCreated), first.Results[0].Outcome)
+
+	// the retry is answered with the recorded response, without checking the health of the device again
+	w = put(key, device)
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("true", w.Header().Get("Idempotent-Replayed"))
+	var second addDevicesResponse
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &second)
+	s.Equal(first, second)
+	s.EqualValues(1, calls.Load())
+
+	// the key cannot be reused for another request
+	other := device
+	other.DeviceID = "device2"
+	w = put(key, other)
+	s.Equal(http.StatusUnprocessableEntity, w.Code)
+	s.EqualValues(1, calls.Load())
+
+	// the request without the key is processed again
+	w = put("", device)
+	s.Equal(http.StatusOK, w.Code)
+	s.Empty(w.Header().Get("Idempotent-Replayed"))
+	s.EqualValues(2, calls.Load())
+}
+
+func (s *routerTestSuite) TestValidateDevices() {
+	s.T().Setenv("HEALTH_CHECK_RETRIES", "0")
+
+	h := chi.NewRouter()
+	h.Get(config.HealthCheckPath(), func(w http.ResponseWriter, r *http.Request) {
+		util.ResponseAsJSON(w, http.StatusOK, api.DeviceHealthCheckResponse{
+			DeviceID:     "device1",
+			DeviceType:   repository.Router,
+			Capabilities: []api.PollingCapability{{Protocol: repository.GRPC, Port: lo.ToPtr(50055)}},
+		})
+	})
+	reachable := httptest.NewServer(h)
+	defer reachable.Close()
+	unreachable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unreachable.Close()
+
+	hostPort := func(server *httptest.Server) (string, int) {
+		u, _ := url.Parse(server.URL)
+		port, _ := strconv.Atoi(u.Port())
+		return u.Hostname(), port
+	}
+	host1, port1 := hostPort(reachable)
+	host2, port2 := hostPort(unreachable)
+	req := httptest.NewRequest(http.MethodPost, "/devices:validate", getReader(addDevicesRequest{
+		Devices: []deviceInfo{
+			{DeviceID: "device1", DeviceType: repository.Router, Hostname: host1, HealthCheckPort: port1},
+			{DeviceID: "device2", DeviceType: repository.Router, Hostname: host2, HealthCheckPort: port2},
+		},
+	}))
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusOK, w.Code)
+
+	var resp validateDevicesResponse
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &resp)
+	s.Require().Len(resp.Results, 2)
+	s.Equal("device1", resp.Results[0].DeviceID)
+	s.True(resp.Results[0].Reachable)
+	s.Empty(resp.Results[0].Error)
+	s.Equal([]api.PollingCapability{{Protocol: repository.GRPC, Port: lo.ToPtr(50055)}}, resp.Results[0].Capabilities)
+	s.Equal("device2", resp.Results[1].DeviceID)
+	s.False(resp.Results[1].Reachable)
+	s.NotEmpty(resp.Results[1].Error)
+	s.Empty(resp.Results[1].Capabilities)
+
+	// nothing is persisted
+	for _, id := range []string{"device1", "device2"} {
+		device, err := s.repo.GetDeviceByID(id)
+		s.ErrorIs(err, repository.ErrRecordNotFound)
+		s.Nil(device)
+	}
+}
+
+func (s *routerTestSuite) TestAddDevicesConcurrency() {
+	s.T().Setenv("ADD_DEVICES_CONCURRENCY", "4")
+	s.T().Setenv("HEALTH_CHECK_RETRIES", "0")
+
+	var inFlight, maxInFlight atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(u.Port())
+	var devices []deviceInfo
+	for i := range 40 {
+		devices = append(devices, deviceInfo{
+			DeviceID:        fmt.Sprintf("device%d", i+1),
+			DeviceType:      repository.Router,
+			Hostname:        u.Hostname(),
+			HealthCheckPort: port,
+		})
+	}
+	req := httptest.NewRequest(http.MethodPut, "/devices", getReader(addDevicesRequest{Devices: devices}))
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusOK, w.Code)
+
+	var resp addDevicesResponse
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &resp)
+	s.Equal(
+		lo.Map(devices, func(d deviceInfo, _ int) string { return d.DeviceID }),
+		lo.Map(resp.Results, func(r deviceAddingResult, _ int) string { return r.DeviceID }),
+	)
+	s.LessOrEqual(maxInFlight.Load(), int32(4))
+	s.Greater(maxInFlight.Load(), int32(1))
+}
+
+func (s *routerTestSuite) TestAddDevicesPortConflicts() {
+	s.T().Setenv("HEALTH_CHECK_RETRIES", "0")
+	devices := []deviceInfo{
+		{DeviceID: "conflict1", DeviceType: repository.Router, Hostname: "127.0.0.1", HealthCheckPort: 1},
+		{DeviceID: "conflict2", DeviceType: repository.Router, Hostname: "127.0.0.1", HealthCheckPort: 1},
+		{DeviceID: "conflict3", DeviceType: repository.Router, Hostname: "127.0.0.1", HealthCheckPort: 2},
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/devices", getReader(addDevicesRequest{Devices: devices}))
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusOK, w.Code)
+
+	var resp addDevicesResponse
+	s.helper.MustDecodeJSON(w.Body.Bytes(), &resp)
+	s.Require().Len(resp.Results, 3)
+	s.Contains(resp.Results[0].Warning, "conflict2")
+	s.Contains(resp.Results[1].Warning, "conflict1")
+	s.Empty(resp.Results[2].Warning)
+
+	s.T().Setenv("REJECT_PORT_CONFLICTS", "true")
+	req = httptest.NewRequest(http.MethodPut, "/devices", getReader(addDevicesRequest{Devices: devices}))
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusBadRequest, w.Code)
+	s.Contains(w.Body.String(), "127.0.0.1:1 (conflict1, conflict2)")
+}
+
+func (s *routerTestSuite) TestAddDeviceWithCapabilities() {
+	capabilities := []api.PollingCapability{
+		{Protocol: repository.REST, Port: lo.ToPtr(8080), Path: lo.ToPtr("/api/v1/device")},
+		{Protocol: repository.TCP, Port: lo.ToPtr(7000), Extra: map[string]string{"framing": "line"}},
+	}
+	h := chi.NewRouter()
+	h.Get(config.HealthCheckPath(), func(w http.ResponseWriter, r *http.Request) {
+		util.ResponseAsJSON(w, http.StatusOK, api.DeviceHealthCheckResponse{
+			DeviceID:     "device1",
+			DeviceType:   repository.Router,
+			Capabilities: capabilities,
+		})
+	})
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(u.Port())
+	req := httptest.NewRequest(http.MethodPut, "/devices", getReader(addDevicesRequest{
+		Devices: []deviceInfo{{DeviceID: "device1", DeviceType: repository.Router, Hostname: u.Hostname(), HealthCheckPort: port}},
+	}))
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusOK, w.Code)
+
+	device, err := s.repo.GetDeviceByID("device1")
+	s.NoError(err)
+	s.Equal(repository.Capabilities{
+		{Protocol: repository.REST, Port: lo.ToPtr(8080), Path: lo.ToPtr("/api/v1/device")},
+		{Protocol: repository.TCP, Port: lo.ToPtr(7000), Extra: map[string]string{"framing": "line"}},
+	}, device.Capabilities)
+	// the legacy columns are still filled
+	s.Equal(pq.StringArray{repository.REST, repository.TCP}, device.Protocols)
+	s.Equal(lo.ToPtr(8080), device.RestPort)
+	s.Equal(lo.ToPtr("/api/v1/device"), device.RestPath)
+	s.Equal(lo.ToPtr(7000), device.TcpPort)
+}
+
+func (s *routerTestSuite) TestTraceParentPropagation() {
+	var received string
+	h := chi.NewRouter()
+	h.Get(config.HealthCheckPath(), func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get(util.TraceParentHeader)
+		util.ResponseAsJSON(w, http.StatusOK, api.DeviceHealthCheckResponse{
+			DeviceID:     "device1",
+			DeviceType:   repository.Router,
+			Capabilities: []api.PollingCapability{{Protocol: repository.GRPC, Port: lo.ToPtr(50055)}},
+		})
+	})
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(u.Port())
+	tp := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req := httptest.NewRequest(http.MethodPut, "/devices", getReader(addDevicesRequest{
+		Devices: []deviceInfo{
+			{
+				DeviceID:        "device1",
+				DeviceType:      repository.Router,
+				Hostname:        u.Hostname(),
+				HealthCheckPort: port,
+			},
+		},
+	}))
+	req.Header.Set(util.TraceParentHeader, tp)
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal(tp, received)
+	s.Equal(tp, w.Header().Get(util.TraceParentHeader))
+
+	// a trace parent is generated when the request has none
+	req = httptest.NewRequest(http.MethodGet, "/devices", nil)
+	w = httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	s.True(util.IsValidTraceParent(w.Header().Get(util.TraceParentHeader)))
+}
+
+func (s *routerTestSuite) TestRestoreDevice() {
+	var healthy atomic.Bool
+	var checks atomic.Int32
+	h := chi.NewRouter()
+	h.Get(config.HealthCheckPath(), func(w http.ResponseWriter, r *http.Request) {
+		checks.Add(1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		util.ResponseAsJSON(w, http.StatusOK, api.DeviceHealthCheckResponse{
+			DeviceID:     "device1",
+			DeviceType:   repository.Router,
+			Capabilities: []api.PollingCapability{{Protocol: repository.GRPC, Port: lo.ToPtr(50055)}},
+		})
+	})
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(u.Port())
+	d := repository.Device{
+		DeviceID:      "device1",
+		DeviceType:    repository.Router,
+		Hostname:      u.Hostname(),
+		Protocols:     pq.StringArray([]string{"grpc"}),
+		LastCheckedAt: lo.ToPtr(time.Now().Add(-2 * config.RestoreHealthCheckMaxAge())),
+		DeletedAt:     lo.ToPtr(time.Now().Add(-time.Hour)),
+	}
+	err := s.repo.CreateDevice(&d)
+	s.NoError(err)
+
+	var site *string
+	var insecureSkipVerify bool
+	addDevice := func() deviceAddingResult {
+		reqBody := getReader(addDevicesRequest{
+			Devices: []deviceInfo{
+				{
+					DeviceID:           d.DeviceID,
+					DeviceType:         d.DeviceType,
+					Hostname:           u.Hostname(),
+					HealthCheckPort:    port,
+					InsecureSkipVerify: insecureSkipVerify,
+					Site:               site,
+				},
+			},
+		})
+		req := httptest.NewRequest(http.MethodPut, "/devices", reqBody)
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, req)
+		s.Equal(http.StatusOK, w.Code)
+
+		var resp addDevicesResponse
+		s.helper.MustDecodeJSON(w.Body.Bytes(), &resp)
+		s.Len(resp.Results, 1)
+		return resp.Results[0]
+	}
+
+	// the device has not been polled for long and is unreachable now
+	result := addDevice()
+	s.NotEqual(0, result.Code)
+	s.Contains(result.Error, "failed to check device health")
+	s.Equal(int32(1), checks.Load())
+
+	device, err := s.repo.GetDeviceByID(d.DeviceID)
+	s.NoError(err)
+	s.NotNil(device.DeletedAt)
+
+	healthy.Store(true)
+	result = addDevice()
+	s.Equal(0, result.Code)
+	s.Equal(string(business.DeviceRestored), result.Outcome)
+	s.Equal(int32(2), checks.Load())
+
+	device, err = s.repo.GetDeviceByID(d.DeviceID)
+	s.NoError(err)
+	s.Nil(device.DeletedAt)
+
+	// a recently polled device is restored without checking its health again
+	device.DeletedAt = lo.ToPtr(time.Now())
+	device.LastCheckedAt = lo.ToPtr(time.Now())
+	err = s.repo.UpdateDevice(device)
+	s.NoError(err)
+
+	healthy.Store(false)
+	result = addDevice()
+	s.Equal(0, result.Code)
+	s.Equal(string(business.DeviceRestored), result.Outcome)
+	s.Equal(int32(2), checks.Load())
+
+	device, err = s.repo.GetDeviceByID(d.DeviceID)
+	s.NoError(err)
+	s.Nil(device.DeletedAt)
+
+	// the settings of the request apply to the restored device
+	device.DeletedAt = lo.ToPtr(time.Now())
+	err = s.repo.UpdateDevice(device)
+	s.NoError(err)
+	site, insecureSkipVerify = lo.ToPtr("o'hare"), true
+	result = addDevice()
+	s.Equal(0, result.Code)
+	s.Equal(string(business.DeviceRestored), result.Outcome)
+
+	device, err = s.repo.GetDeviceByID(d.DeviceID)
+	s.NoError(err)
+	s.Nil(device.DeletedAt)
+	s.True(device.InsecureSkipVerify)
+	s.Equal("o'hare", lo.FromPtr(device.Site))
 }
 
 func getReader(a any) io.Reader {
@@ -384,3 +2379,146 @@ func getReader(a any) io.Reader {
 	}
 	return bytes.NewBuffer(bs)
 }
+
+func TestParseDurationParam(t *testing.T) {
+	d, err := parseDurationParam(url.Values{"window": {"90m"}}, "window", time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, 90*time.Minute, d)
+
+	d, err = parseDurationParam(url.Values{}, "window", time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Hour, d)
+
+	for _, v := range []string{"1 hour", "5", "-5m", "0s"} {
+		_, err = parseDurationParam(url.Values{"older_than": {v}}, "older_than", time.Hour)
+		assert.ErrorContains(t, err, "invalid older_than", v)
+	}
+}
+
+func TestDeviceIDPattern(t *testing.T) {
+	info := deviceInfo{DeviceID: "any id", DeviceType: repository.Router, Hostname: "localhost"}
+	assert.NoError(t, info.normalize())
+
+	t.Setenv("DEVICE_ID_PATTERN", `[A-Z]{3}-[A-Z]+-\d{4}`)
+	for _, id := range []string{"HEL-ROUTER-0001", " OUL-CAMERA-1234 "} {
+		info = deviceInfo{DeviceID: id, DeviceType: repository.Router, Hostname: "localhost"}
+		assert.NoError(t, info.normalize(), id)
+	}
+	for _, id := range []string{"hel-router-0001", "HEL-ROUTER-01", "XHEL-ROUTER-0001", "HEL-ROUTER-00012"} {
+		info = deviceInfo{DeviceID: id, DeviceType: repository.Router, Hostname: "localhost"}
+		assert.ErrorContains(t, info.normalize(), "does not match the pattern", id)
+	}
+}
+
+func TestFanOut(t *testing.T) {
+	devices := make([]deviceInfo, 30)
+	for i := range devices {
+		devices[i].DeviceID = strconv.Itoa(i)
+	}
+
+	var inFlight, maxInFlight atomic.Int32
+	results := make([]string, len(devices))
+	fanOut(devices, 3, func(idx int, device deviceInfo) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		results[idx] = device.DeviceID
+	})
+	assert.LessOrEqual(t, maxInFlight.Load(), int32(3))
+	assert.Equal(t, lo.Map(devices, func(d deviceInfo, _ int) string { return d.DeviceID }), results)
+}
+
+func TestIdempotencyCache(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	c := newIdempotencyCache(time.Minute, 10)
+	h := c.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		if r.URL.Query().Has("block") {
+			<-release
+		}
+		if n == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		util.ResponseAsJSON(w, http.StatusCreated, map[string]any{"call": n, "body": string(body)})
+	}))
+	send := func(path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, path, strings.NewReader(body))
+		req.Header.Set("Idempotency-Key", key)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	// a failed request is processed again when retried
+	assert.Equal(t, http.StatusServiceUnavailable, send("/", "key1", "a").Code)
+	w := send("/", "key1", "a")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.JSONEq(t, `{"call":2,"body":"a"}`, w.Body.String())
+
+	w = send("/", "key1", "a")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"call":2,"body":"a"}`, w.Body.String())
+	assert.EqualValues(t, 2, calls.Load())
+
+	// a retry while the request is in progress is rejected
+	done := make(chan int)
+	go func() {
+		done <- send("/?block", "key2", "b").Code
+	}()
+	assert.Eventually(t, func() bool { return calls.Load() == 3 }, time.Second, time.Millisecond)
+	assert.Equal(t, http.StatusConflict, send("/?block", "key2", "b").Code)
+	close(release)
+	assert.Equal(t, http.StatusCreated, <-done)
+
+	assert.Equal(t, http.StatusBadRequest, send("/", strings.Repeat("k", 256), "c").Code)
+}
+
+func TestIdempotencyCacheEvictsOldest(t *testing.T) {
+	c := newIdempotencyCache(time.Minute, 2)
+
+	_, err := c.begin("key1", "a")
+	assert.NoError(t, err)
+	c.finish("key1", http.StatusCreated, "", nil)
+	_, err = c.begin("key2", "b")
+	assert.NoError(t, err)
+	c.finish("key2", http.StatusCreated, "", nil)
+	_, err = c.begin("key3", "c")
+	assert.NoError(t, err)
+	assert.Len(t, c.entries, 2)
+	assert.NotContains(t, c.entries, "key1")
+
+	// the recorded responses are evicted before the requests in progress
+	_, err = c.begin("key4", "d")
+	assert.NoError(t, err)
+	assert.Len(t, c.entries, 2)
+	assert.Contains(t, c.entries, "key3")
+	assert.Contains(t, c.entries, "key4")
+}
+
+func TestPortConflicts(t *testing.T) {
+	conflicts := portConflicts([]deviceInfo{
+		{DeviceID: "a", Hostname: "10.0.0.1", HealthCheckPort: 80},
+		{DeviceID: "b", Hostname: "10.0.0.1", HealthCheckPort: 80},
+		{DeviceID: "c", Hostname: "10.0.0.1", HealthCheckPort: 81},
+		{DeviceID: "d", Hostname: "10.0.0.2", HealthCheckPort: 80},
+	})
+	assert.Equal(t, map[string][]string{"10.0.0.1:80": {"a", "b"}}, conflicts)
+
+	// the devices behind a gateway share its hostname and port
+	t.Setenv("HEALTH_CHECK_PATH", "/devices/{device_id}/health")
+	conflicts = portConflicts([]deviceInfo{
+		{DeviceID: "a", Hostname: "10.0.0.1", HealthCheckPort: 80},
+		{DeviceID: "b", Hostname: "10.0.0.1", HealthCheckPort: 80},
+	})
+	assert.Empty(t, conflicts)
+}
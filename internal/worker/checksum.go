@@ -0,0 +1,45 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"example.poc/device-monitoring-system/internal/repository"
+	"example.poc/device-monitoring-system/pkg"
+	"github.com/rs/zerolog"
+)
+
+// ErrChecksumMismatch is recorded as the failure of a poll when the checksum a device reports is not the expected one
+var ErrChecksumMismatch = errors.New("checksum_mismatch")
+
+// checksumFunc computes the checksum a device is expected to report
+type checksumFunc func(device repository.Device) (string, error)
+
+// externalChecksum computes the expected checksum of a device with the external checksum generator, which is passed
+// the id and the type of the device
+func externalChecksum(device repository.Device) (string, error) {
+	bs, err := pkg.ExecuteExternalChecksumGenerator(device.DeviceID, device.DeviceType)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(bs)), nil
+}
+
+// verifyChecksum compares the reported checksum with the expected one, the checksum is not verified when the device
+// reports none, e.g. over tcp, or when the expected one cannot be computed
+func (rm *RetryWrapperMonitor) verifyChecksum(ctx context.Context, device repository.Device, checksum string) error {
+	if rm.checksum == nil || checksum == "" {
+		return nil
+	}
+	expected, err := rm.checksum(device)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("failed to compute the expected checksum of device, skip verifying it")
+		return nil
+	}
+	if checksum != expected {
+		return fmt.Errorf("%w: the reported checksum differs from the expected one", ErrChecksumMismatch)
+	}
+	return nil
+}
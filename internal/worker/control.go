@@ -0,0 +1,87 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"example.poc/device-monitoring-system/internal/util"
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog"
+)
+
+const (
+	controlReadTimeout     = 5 * time.Second
+	controlWriteTimeout    = 10 * time.Second
+	controlShutdownTimeout = 5 * time.Second
+)
+
+type controlStatusResponse struct {
+	WorkerID           string             `json:"worker_id"`
+	Paused             bool               `json:"paused"`
+	PreferFailing      bool               `json:"prefer_failing"`
+	PollBatchFillRatio map[string]float64 `json:"poll_batch_fill_ratio"`
+}
+
+// NewControlHandler exposes the endpoints to pause and resume the polling worker at runtime, and to make it prefer the
+// failing devices or not with enabled=true|false. The endpoints require the bearer token of ADMIN_API_TOKEN, as the
+// admin endpoints of the web service do.
+func NewControlHandler(w *PollingWorker) http.Handler {
+	mux := chi.NewRouter()
+	mux.Use(util.RequireAdminToken)
+	mux.Post("/control/pause", func(rw http.ResponseWriter, r *http.Request) {
+		w.Pause()
+		util.ResponseAsJSON(rw, http.StatusOK, w.controlStatus())
+	})
+	mux.Post("/control/resume", func(rw http.ResponseWriter, r *http.Request) {
+		w.Resume()
+		util.ResponseAsJSON(rw, http.StatusOK, w.controlStatus())
+	})
+	mux.Post("/control/prefer-failing", func(rw http.ResponseWriter, r *http.Request) {
+		enabled, err := strconv.ParseBool(r.URL.Query().Get("enabled"))
+		if err != nil {
+			http.Error(rw, "invalid enabled value, expecting true or false", http.StatusBadRequest)
+			return
+		}
+		w.PreferFailing(enabled)
+		util.ResponseAsJSON(rw, http.StatusOK, w.controlStatus())
+	})
+	mux.Get("/control/status", func(rw http.ResponseWriter, r *http.Request) {
+		util.ResponseAsJSON(rw, http.StatusOK, w.controlStatus())
+	})
+	return mux
+}
+
+func (w *PollingWorker) controlStatus() controlStatusResponse {
+	return controlStatusResponse{
+		WorkerID:           w.id,
+		Paused:             w.Paused(),
+		PreferFailing:      w.PrefersFailing(),
+		PollBatchFillRatio: w.BatchFillRatios(),
+	}
+}
+
+// ServeControl serves the control endpoints on the port until the context is done, then shuts the server down
+func ServeControl(ctx context.Context, w *PollingWorker, port int) error {
+	server := &http.Server{
+		Addr:         fmt.Sprintf(":%d", port),
+		Handler:      NewControlHandler(w),
+		ReadTimeout:  controlReadTimeout,
+		WriteTimeout: controlWriteTimeout,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), controlShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			zerolog.Ctx(ctx).Err(err).Msg("failed to shut down the worker control server")
+		}
+	}()
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"example.poc/device-monitoring-system/internal/repository"
+	"github.com/rs/zerolog"
+)
+
+// bufferedHistoryWriter collects polling histories in memory and saves them in batches,
+// either when the buffer is full or when the flush interval elapses, whichever comes first.
+type bufferedHistoryWriter struct {
+	repo          repository.IRepository
+	size          int
+	flushInterval time.Duration
+	lock          sync.Mutex
+	buffer        []*repository.PollingHistory
+}
+
+func newBufferedHistoryWriter(repo repository.IRepository, size int, flushInterval time.Duration) *bufferedHistoryWriter {
+	return &bufferedHistoryWriter{
+		repo:          repo,
+		size:          size,
+		flushInterval: flushInterval,
+		buffer:        make([]*repository.PollingHistory, 0, size),
+	}
+}
+
+func (bw *bufferedHistoryWriter) add(ctx context.Context, history *repository.PollingHistory) {
+	if history == nil {
+		return
+	}
+
+	bw.lock.Lock()
+	bw.buffer = append(bw.buffer, history)
+	full := len(bw.buffer) >= bw.size
+	bw.lock.Unlock()
+
+	if full {
+		bw.flush(ctx)
+	}
+}
+
+func (bw *bufferedHistoryWriter) flush(ctx context.Context) {
+	bw.lock.Lock()
+	if len(bw.buffer) == 0 {
+		bw.lock.Unlock()
+		return
+	}
+	histories := bw.buffer
+	bw.buffer = make([]*repository.PollingHistory, 0, bw.size)
+	bw.lock.Unlock()
+
+	if err := bw.repo.CreatePollingHistories(histories); err != nil {
+		zerolog.Ctx(ctx).Err(err).Int("count", len(histories)).Msg("db error: failed to save buffered device polling results")
+	}
+}
+
+// run flushes the buffer periodically until the context is cancelled, the remaining histories are flushed before it returns
+func (bw *bufferedHistoryWriter) run(ctx context.Context) {
+	ticker := time.NewTicker(bw.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			bw.flush(ctx)
+		case <-ctx.Done():
+			bw.flush(ctx)
+			return
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"example.poc/device-monitoring-system/internal/api"
+	"example.poc/device-monitoring-system/internal/repository"
+	"example.poc/device-monitoring-system/test/helper"
+	"example.poc/device-monitoring-system/test/mocks"
+	"github.com/lib/pq"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+)
+
+type bufferedHistoryWriterTestSuite struct {
+	suite.Suite
+	mockRepo    *mocks.MockIRepository
+	mockMonitor *mocks.MockIDeviceMonitor
+	lock        sync.Mutex
+	inserts     int
+	saved       int
+}
+
+func TestBufferedHistoryWriter(t *testing.T) {
+	suite.Run(t, new(bufferedHistoryWriterTestSuite))
+}
+
+func (s *bufferedHistoryWriterTestSuite) SetupTest() {
+	s.mockRepo = mocks.NewMockIRepository(s.T())
+	s.mockMonitor = mocks.NewMockIDeviceMonitor(s.T())
+	s.inserts = 0
+	s.saved = 0
+	s.mockRepo.EXPECT().CreatePollingHistories(mock.Anything).Return(nil).Run(func(histories []*repository.PollingHistory) {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.inserts++
+		s.saved += len(histories)
+	}).Maybe()
+}
+
+func (s *bufferedHistoryWriterTestSuite) counts() (int, int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.inserts, s.saved
+}
+
+func (s *bufferedHistoryWriterTestSuite) TestFewerInsertsThanPolls() {
+	numOfPolls := 12
+	bw := newBufferedHistoryWriter(s.mockRepo, 5, time.Hour)
+	rm := &RetryWrapperMonitor{
+		monitor: s.mockMonitor,
+		repo:    s.mockRepo,
+		history: bw,
+		timeout: time.Second,
+	}
+
+	s.mockMonitor.EXPECT().PollDevice(mock.Anything, mock.Anything).Return(&api.PollDeviceResponse{
+		Hw:       "hw",
+		Sw:       "sw",
+		Fw:       "fw",
+		Status:   "running",
+		Checksum: "checksum",
+	}, nil).Times(numOfPolls)
+	// device records are still updated right after every poll
+	s.mockRepo.EXPECT().UpdateDevice(mock.Anything).Return(nil).Times(numOfPolls)
+
+	for i := range numOfPolls {
+		device := repository.Device{
+			ID:            uint(i + 1),
+			DeviceID:      helper.RandomString(8),
+			Protocols:     pq.StringArray{repository.GRPC},
+			PollingStatus: lo.ToPtr(repository.PollingInProgress),
+		}
+		rm.pollDeviceWithBackoff(context.TODO(), &device, api.PollDeviceRequest{Hostname: "some.faked.host"})
+	}
+
+	inserts, saved := s.counts()
+	s.Equal(2, inserts)
+	s.Equal(10, saved)
+
+	bw.flush(context.TODO())
+	inserts, saved = s.counts()
+	s.Equal(3, inserts)
+	s.Equal(numOfPolls, saved)
+	s.Less(inserts, numOfPolls)
+}
+
+func (s *bufferedHistoryWriterTestSuite) TestFlushOnIntervalAndCancel() {
+	flushInterval := 50 * time.Millisecond
+	bw := newBufferedHistoryWriter(s.mockRepo, 100, flushInterval)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	done := make(chan struct{})
+	go func() {
+		bw.run(ctx)
+		close(done)
+	}()
+
+	for range 3 {
+		bw.add(ctx, &repository.PollingHistory{DeviceID: "device1", PollingResult: repository.PollSucceed})
+	}
+	time.Sleep(3 * flushInterval)
+	inserts, saved := s.counts()
+	s.Equal(1, inserts)
+	s.Equal(3, saved)
+
+	// remaining histories are flushed when the writer stops
+	bw.add(ctx, &repository.PollingHistory{DeviceID: "device1", PollingResult: repository.PollFailed})
+	cancel()
+	<-done
+	inserts, saved = s.counts()
+	s.Equal(2, inserts)
+	s.Equal(4, saved)
+}
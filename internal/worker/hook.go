@@ -0,0 +1,29 @@
+package worker
+
+import (
+	"context"
+
+	"example.poc/device-monitoring-system/internal/api"
+	"example.poc/device-monitoring-system/internal/repository"
+	"github.com/rs/zerolog"
+)
+
+// PollResultHook is notified of the result of every polling attempt once it is persisted, with either the response
+// or the error of the attempt
+type PollResultHook func(ctx context.Context, device repository.Device, resp *api.PollDeviceResponse, err error)
+
+// notify runs the hook in its own goroutine, so that a slow hook does not hold up the polling and a panicking one
+// does not bring the worker down
+func (hook PollResultHook) notify(ctx context.Context, device repository.Device, resp *api.PollDeviceResponse, err error) {
+	if hook == nil {
+		return
+	}
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				zerolog.Ctx(ctx).Error().Msgf("poll result hook panicked on device %s: %v", device.DeviceID, r)
+			}
+		}()
+		hook(ctx, device, resp, err)
+	}()
+}
@@ -0,0 +1,62 @@
+package worker
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"example.poc/device-monitoring-system/internal/repository"
+	"github.com/rs/zerolog"
+)
+
+// failureLogLimiter bounds the logs of repetitive polling failures, so that a large fleet of dead devices
+// does not flood the logs. The first failure of a device in a row is always logged, the following ones are
+// let through by a burst sampler, and the number of failures is summarized periodically. A nil limiter
+// does not limit anything.
+type failureLogLimiter struct {
+	sampler  zerolog.Sampler
+	failures atomic.Int64
+}
+
+func newFailureLogLimiter(burst int, period time.Duration, sampleRate int) *failureLogLimiter {
+	return &failureLogLimiter{
+		sampler: &zerolog.BurstSampler{
+			Burst:       uint32(burst),
+			Period:      period,
+			NextSampler: &zerolog.BasicSampler{N: uint32(sampleRate)},
+		},
+	}
+}
+
+// onFailure counts a polling failure and returns the logger to report it with
+func (l *failureLogLimiter) onFailure(ctx context.Context, first bool) *zerolog.Logger {
+	if l != nil {
+		l.failures.Add(1)
+	}
+	return l.logger(ctx, first)
+}
+
+// logger returns the logger for a repetitive message, the message is sampled unless it is the first one in a row
+func (l *failureLogLimiter) logger(ctx context.Context, first bool) *zerolog.Logger {
+	if l == nil || first {
+		return zerolog.Ctx(ctx)
+	}
+	lg := zerolog.Ctx(ctx).Sample(l.sampler)
+	return &lg
+}
+
+// failingInARow tells whether the latest poll saved on a device failed, in which case its next failure is not the first
+// one in a row
+func failingInARow(device repository.Device) bool {
+	return device.SuccessStreak == 0 && device.TotalFailureCount > 0
+}
+
+// summarize logs the number of failures since the last summary
+func (l *failureLogLimiter) summarize(ctx context.Context) {
+	if l == nil {
+		return
+	}
+	if n := l.failures.Swap(0); n > 0 {
+		zerolog.Ctx(ctx).Warn().Int64("failures", n).Msg("device polling failures since last summary")
+	}
+}
@@ -3,22 +3,50 @@ package worker
 import (
 	"context"
 	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"example.poc/device-monitoring-system/internal/api"
 	"example.poc/device-monitoring-system/internal/config"
 	"example.poc/device-monitoring-system/internal/repository"
+	"example.poc/device-monitoring-system/internal/util"
 	"github.com/rs/zerolog"
+	"github.com/samber/lo"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
 type PollingWorker struct {
-	repo     repository.IRepository
-	rest     api.IDeviceMonitor
-	grpc     api.IDeviceMonitor
-	psy      api.IPollingStrategy
-	interval time.Duration
+	id          string
+	repo        repository.IRepository
+	history     *bufferedHistoryWriter
+	rollup      *historyRollup
+	logs        *failureLogLimiter
+	slots       chan struct{}
+	paused      atomic.Bool
+	preferFail  atomic.Bool // claims the devices whose latest poll failed ahead of the others
+	batchFill   sync.Map    // device type -> the ratio of the last claimed devices to the batch size
+	disabled    sync.Map    // device type -> whether the polling of its devices is turned off
+	retries     sync.Map    // device type -> the retry budget of its devices, see retryBudget
+	overrides   sync.Map    // device type -> the shortest polling interval override of its devices
+	rest        api.IDeviceMonitor
+	grpc        api.IDeviceMonitor
+	tcp         api.IDeviceMonitor
+	psy         api.IPollingStrategy
+	hook        PollResultHook
+	interval    time.Duration
+	pollOnStart bool
+	maxFailures int           // gives up after failing to get the device types this many times in a row, never when 0
+	maxRetries  int           // the devices of a device type waiting to retry at the same time, no limit when 0
+	resetStreak int           // resets the total failure count of a device after this many successes in a row, never when 0
+	infoSuccess bool          // whether the routine successful polls are logged at info level
+	idleTimeout time.Duration // stops polling a device type without devices for this long, never when 0
+	deviceTypes []string      // the device types polled by the worker, all of them when empty
+	warmup      int           // the gRPC devices connected to at the same time on start, no warmup when 0
+	checksum    checksumFunc
+	stats       *pollingStats
 }
 
 func NewPollingWorker(pollingStrategy api.IPollingStrategy, interval time.Duration) (*PollingWorker, error) {
@@ -26,13 +54,25 @@ func NewPollingWorker(pollingStrategy api.IPollingStrategy, interval time.Durati
 		return nil, fmt.Errorf("invalid interval: %v", interval)
 	}
 
+	if pollingStrategy == nil {
+		psy, err := api.NewPollingStrategy(config.PollingStrategy())
+		if err != nil {
+			return nil, fmt.Errorf("invalid POLLING_STRATEGY: %w", err)
+		}
+		pollingStrategy = psy
+	}
+	if err := api.ValidatePollingStrategy(pollingStrategy, repository.KnownDeviceTypes); err != nil {
+		return nil, fmt.Errorf("invalid polling strategy: %w", err)
+	}
+
 	repo, err := repository.NewRepository(config.DatabaseURL())
 	if err != nil {
 		return nil, fmt.Errorf("failed to get database connection: %w", err)
 	}
 
-	if pollingStrategy == nil {
-		pollingStrategy = &api.DefaultPollingStrategy{}
+	rules, err := api.ParseResponseValidationConfig(config.ResponseValidationRules())
+	if err != nil {
+		return nil, fmt.Errorf("invalid RESPONSE_VALIDATION_RULES: %w", err)
 	}
 
 	opts := make([]grpc.DialOption, 0)
@@ -42,29 +82,167 @@ func NewPollingWorker(pollingStrategy api.IPollingStrategy, interval time.Durati
 		opts = append(opts, opt)
 	}
 
-	return &PollingWorker{
-		repo:     repo,
-		rest:     api.NewRESTDeviceMonitor(),
-		grpc:     api.NewGrpcDeviceMonitor(opts...),
-		psy:      pollingStrategy,
-		interval: interval,
-	}, nil
+	rest := api.NewRESTDeviceMonitor()
+	rest.SetResponseValidation(rules)
+	gm := api.NewGrpcDeviceMonitor(opts...)
+	gm.SetResponseValidation(rules)
+
+	return NewPollingWorkerWithDeps(repo, rest, gm, pollingStrategy, interval, WithTCPMonitor(api.NewTCPDeviceMonitor()))
+}
+
+// PollingWorkerOptions customizes a polling worker built by NewPollingWorkerWithDeps
+type PollingWorkerOptions func(*PollingWorker)
+
+// WithTCPMonitor sets the monitor of the devices polled over tcp, which are otherwise left unpolled
+func WithTCPMonitor(tcp api.IDeviceMonitor) PollingWorkerOptions {
+	return func(w *PollingWorker) {
+		w.tcp = tcp
+	}
+}
+
+// NewPollingWorkerWithDeps builds a polling worker on the given repository, device monitors and polling strategy, so
+// that they can be provided by the embedding code, e.g. with other implementations. The rest of the worker is
+// configured from the env as by NewPollingWorker.
+func NewPollingWorkerWithDeps(repo repository.IRepository, rest, grpc api.IDeviceMonitor, psy api.IPollingStrategy, interval time.Duration, opts ...PollingWorkerOptions) (*PollingWorker, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid interval: %v", interval)
+	}
+	if repo == nil {
+		return nil, fmt.Errorf("illegal argument: repository cannot be nil")
+	}
+	if psy == nil {
+		return nil, fmt.Errorf("illegal argument: polling strategy cannot be nil")
+	}
+
+	w := &PollingWorker{
+		id:          config.WorkerID(),
+		repo:        repo,
+		rest:        rest,
+		grpc:        grpc,
+		psy:         psy,
+		interval:    interval,
+		logs:        newFailureLogLimiter(config.FailureLogBurst(), config.FailureLogPeriod(), config.FailureLogSampleRate()),
+		pollOnStart: config.PollOnStart(),
+		maxFailures: config.MaxDeviceTypeFailures(),
+		maxRetries:  config.RetryBudgetPerDeviceType(),
+		resetStreak: config.FailureCountResetStreak(),
+		infoSuccess: config.LogSuccessfulPollsAtInfo(),
+		idleTimeout: config.IdleDeviceTypeTimeout(),
+		deviceTypes: config.WorkerDeviceTypes(),
+		warmup:      config.GrpcWarmupConcurrency(),
+		stats:       &pollingStats{},
+	}
+	w.preferFail.Store(config.PreferFailingDevices())
+	for _, opt := range opts {
+		opt(w)
+	}
+	if config.VerifyChecksum() {
+		w.checksum = externalChecksum
+	}
+	if limit := config.GlobalMaxConcurrentPolls(); limit > 0 {
+		w.slots = make(chan struct{}, limit)
+	}
+	if size := config.PollingHistoryBufferSize(); size > 0 {
+		w.history = newBufferedHistoryWriter(repo, size, config.PollingHistoryFlushInterval())
+	}
+	if after := config.PollingHistoryRollupAfter(); after > 0 {
+		w.rollup = newHistoryRollup(repo, after, config.PollingHistoryRollupRetention(), config.PollingHistoryRollupInterval())
+	}
+
+	return w, nil
+}
+
+// SetPollResultHook registers a hook notified of the result of every polling attempt, it must be set before the
+// worker is started
+func (w *PollingWorker) SetPollResultHook(hook PollResultHook) {
+	w.hook = hook
+}
+
+// Pause stops the worker from issuing new polls, the polls in flight are left to finish
+func (w *PollingWorker) Pause() {
+	w.paused.Store(true)
+}
+
+func (w *PollingWorker) Resume() {
+	w.paused.Store(false)
+}
+
+func (w *PollingWorker) Paused() bool {
+	return w.paused.Load()
+}
+
+// PreferFailing makes the worker claim the devices whose latest poll failed ahead of the healthy ones, or stop doing so
+func (w *PollingWorker) PreferFailing(prefer bool) {
+	w.preferFail.Store(prefer)
+}
+
+func (w *PollingWorker) PrefersFailing() bool {
+	return w.preferFail.Load()
+}
+
+// Summary sums up the polling activity of the worker, e.g. to be logged on shutdown
+func (w *PollingWorker) Summary() PollingSummary {
+	return w.stats.summary()
+}
+
+// BatchFillRatios returns the ratio of the devices claimed on the last polling cycle to the batch size by device type
+func (w *PollingWorker) BatchFillRatios() map[string]float64 {
+	ratios := make(map[string]float64)
+	w.batchFill.Range(func(key, value any) bool {
+		ratios[key.(string)] = value.(float64)
+		return true
+	})
+	return ratios
 }
 
 func (w *PollingWorker) Start(ctx context.Context) error {
 	ticker := time.NewTicker(w.interval)
 	defer ticker.Stop()
 
-	deviceTypeMap := make(map[string]bool)
+	if w.history != nil {
+		go w.history.run(ctx)
+	}
+	if w.rollup != nil {
+		go w.rollup.run(ctx)
+	}
+
+	if len(w.deviceTypes) > 0 {
+		zerolog.Ctx(ctx).Info().Strs("device_types", w.deviceTypes).Msg("polling only the devices of the given device types")
+	}
+	w.warmupGrpc(ctx)
+
+	pollers := make(map[string]*deviceTypePoller)
+	failures := 0
 	for {
+		if err := w.repo.SaveWorkerHeartbeat(w.id); err != nil {
+			zerolog.Ctx(ctx).Err(err).Msg("db error: failed to save worker heartbeat")
+		}
+		w.logs.summarize(ctx)
+
 		dts, err := w.repo.GetAllDeviceTypes()
 		if err != nil {
-			return fmt.Errorf("failed to get all device types: %w", err)
+			failures++
+			if w.maxFailures > 0 && failures >= w.maxFailures {
+				return fmt.Errorf("failed to get all device types %d times in a row: %w", failures, err)
+			}
+			zerolog.Ctx(ctx).Err(err).Int("consecutive_failures", failures).Msg("db error: failed to get all device types, retry on the next tick")
+		} else {
+			failures = 0
+		}
+		if len(w.deviceTypes) > 0 {
+			dts = lo.Filter(dts, func(dt repository.DeviceType, _ int) bool {
+				return slices.Contains(w.deviceTypes, dt.Name)
+			})
 		}
+		counts := w.countDevicesByType(ctx)
 		if len(dts) > 0 {
 			for _, dt := range dts {
-				if _, ok := deviceTypeMap[dt.Name]; !ok {
-					deviceTypeMap[dt.Name] = true
+				w.disabled.Store(dt.Name, !dt.Enabled)
+				w.overrides.Store(dt.Name, lo.FromPtr(dt.ShortestPollIntervalOverride))
+				if counts != nil && w.stopIdlePolling(ctx, pollers, dt.Name, counts[dt.Name]) {
+					continue
+				}
+				if _, ok := pollers[dt.Name]; !ok {
 					cfg, err := w.psy.GetPollingConfigByDeviceType(dt.Name)
 					if err != nil {
 						return fmt.Errorf("failed to get polling config for device type %s: %v", dt.Name, err)
@@ -80,7 +258,10 @@ func (w *PollingWorker) Start(ctx context.Context) error {
 						Str("backoff_base_delay", cfg.Backoff.BaseDelay.String()).
 						Str("backoff_max_delay", cfg.Backoff.MaxDelay.String()).
 						Float64("backoff_factor", cfg.Backoff.Factor).
-						Int("polling_batch_size", cfg.BatchSize).Logger().WithContext(ctx)
+						Int("polling_batch_size", cfg.BatchSize).
+						Str("polling_schedule", cfg.Schedule).Logger().WithContext(ctx)
+					subCtx, cancel := context.WithCancel(subCtx)
+					pollers[dt.Name] = &deviceTypePoller{cancel: cancel}
 					go w.startPollingDevicesByType(subCtx, dt.Name, cfg)
 				}
 			}
@@ -96,48 +277,76 @@ func (w *PollingWorker) Start(ctx context.Context) error {
 	}
 }
 
+// deviceTypePoller tracks the polling of the devices of a device type
+type deviceTypePoller struct {
+	cancel    context.CancelFunc
+	idleSince time.Time // since when the device type has no devices, zero while it has some
+}
+
+// countDevicesByType counts the devices of every device type to find the idle ones, it returns nil when the idle device
+// types are never stopped or the devices cannot be counted, so that nothing is stopped
+func (w *PollingWorker) countDevicesByType(ctx context.Context) map[string]int {
+	if w.idleTimeout <= 0 {
+		return nil
+	}
+	counts, err := w.repo.CountDevicesByType()
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("db error: failed to count devices by type, keep polling all device types")
+		return nil
+	}
+	return counts
+}
+
+// stopIdlePolling stops polling a device type once it has had no devices for the idle timeout, it tells whether the
+// device type has no devices, so that its polling is not started, and is started again when devices of it reappear
+func (w *PollingWorker) stopIdlePolling(ctx context.Context, pollers map[string]*deviceTypePoller, deviceType string, count int) bool {
+	p, ok := pollers[deviceType]
+	if count > 0 {
+		if ok {
+			p.idleSince = time.Time{}
+		}
+		return false
+	}
+	if !ok {
+		return true
+	}
+
+	if p.idleSince.IsZero() {
+		p.idleSince = time.Now()
+	}
+	if time.Since(p.idleSince) >= w.idleTimeout {
+		zerolog.Ctx(ctx).Info().Msgf("stopping polling devices of type %s, no devices for %s", deviceType, w.idleTimeout)
+		p.cancel()
+		delete(pollers, deviceType)
+		w.batchFill.Delete(deviceType)
+	}
+	return true
+}
+
 func (w *PollingWorker) startPollingDevicesByType(ctx context.Context, deviceType string, cfg api.PollingConfig) {
-	ticker := time.NewTicker(cfg.Interval)
+	interval := w.tickInterval(deviceType, cfg)
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
+	next := func() <-chan time.Time { return ticker.C }
+
+	// the schedule is validated along with the polling config
+	schedule, _ := cfg.ParseSchedule()
+	if schedule != nil {
+		ticker.Stop()
+		next = func() <-chan time.Time { return time.After(time.Until(schedule.Next(time.Now()))) }
+	}
 
+	// a scheduled device type is only polled on its schedule, even when the worker starts
+	if w.pollOnStart && schedule == nil {
+		w.pollDevicesByType(ctx, deviceType, cfg)
+	}
 	for {
 		select {
-		case <-ticker.C:
-			devices, err := w.repo.GetDevicesByPollingParameter(repository.DevicePollingParameter{
-				DeviceType: deviceType,
-				Interval:   cfg.Interval,
-				Limit:      cfg.BatchSize,
-			})
-			if err != nil {
-				zerolog.Ctx(ctx).Error().Err(err).Msgf("failed to get devices for type %s", deviceType)
-				continue
-			}
-
-			if len(devices) == 0 {
-				zerolog.Ctx(ctx).Info().Msgf("no devices found for type %s", deviceType)
-				continue
-			}
-
-			for _, device := range devices {
-				zCtx := zerolog.Ctx(ctx).With().
-					Str("device_id", device.DeviceID).
-					Str("hostname", device.Hostname).
-					Str("protocols", fmt.Sprintf("%v", device.Protocols))
-				if device.RestPort != nil {
-					zCtx.Int("rest_port", *device.RestPort)
-				}
-				if device.GrpcPort != nil {
-					zCtx.Int("grpc_port", *device.GrpcPort)
-				}
-				if device.RestPath != nil && len(*device.RestPath) > 0 {
-					zCtx.Str("rest_path", *device.RestPath)
-				}
-
-				subCtx := zCtx.Logger().WithContext(ctx)
-				if err := w.pollDevice(subCtx, device, cfg); err != nil {
-					zerolog.Ctx(subCtx).Err(err).Msgf("failed to poll device %s", device.DeviceID)
-					continue
-				}
+		case <-next():
+			w.pollDevicesByType(ctx, deviceType, cfg)
+			if i := w.tickInterval(deviceType, cfg); schedule == nil && i != interval {
+				interval = i
+				ticker.Reset(interval)
 			}
 		case <-ctx.Done():
 			zerolog.Ctx(ctx).Info().Msgf("stopping polling devices of type %s, context cancelled", deviceType)
@@ -146,43 +355,174 @@ func (w *PollingWorker) startPollingDevicesByType(ctx context.Context, deviceTyp
 	}
 }
 
-func (w *PollingWorker) pollDevice(ctx context.Context, device repository.Device, cfg api.PollingConfig) error {
-	var port *int
-	var path *string
-	var inner api.IDeviceMonitor
+// tickInterval is how often the devices of a device type are checked for polling, the polling interval of the device
+// type unless one of its devices overrides it with a shorter one
+func (w *PollingWorker) tickInterval(deviceType string, cfg api.PollingConfig) time.Duration {
+	if v, ok := w.overrides.Load(deviceType); ok {
+		if override := v.(time.Duration); override > 0 && override < cfg.Interval {
+			return override
+		}
+	}
+	return cfg.Interval
+}
+
+func (w *PollingWorker) pollDevicesByType(ctx context.Context, deviceType string, cfg api.PollingConfig) {
+	if w.Paused() {
+		zerolog.Ctx(ctx).Debug().Msgf("polling worker is paused, skip polling devices of type %s", deviceType)
+		return
+	}
+	if disabled, ok := w.disabled.Load(deviceType); ok && disabled.(bool) {
+		zerolog.Ctx(ctx).Debug().Msgf("device type %s is disabled, skip polling its devices", deviceType)
+		return
+	}
 
+	devices, err := w.repo.GetDevicesByPollingParameter(repository.DevicePollingParameter{
+		DeviceType:    deviceType,
+		Interval:      cfg.Interval,
+		Limit:         cfg.BatchSize,
+		ColdStreak:    cfg.ColdStreak,
+		ColdInterval:  cfg.ColdInterval,
+		PreferFailing: w.PrefersFailing(),
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msgf("failed to get devices for type %s", deviceType)
+		return
+	}
+
+	// a batch filled up on every cycle tells the worker is falling behind
+	if cfg.BatchSize > 0 {
+		w.batchFill.Store(deviceType, float64(len(devices))/float64(cfg.BatchSize))
+	}
+	if len(devices) == 0 {
+		zerolog.Ctx(ctx).Info().Int("claimed_count", 0).Int("batch_size", cfg.BatchSize).Msgf("no devices found for type %s", deviceType)
+		return
+	}
+	zerolog.Ctx(ctx).Info().Int("claimed_count", len(devices)).Int("batch_size", cfg.BatchSize).Msgf("claimed devices of type %s for polling", deviceType)
+
+	for _, device := range devices {
+		zCtx := zerolog.Ctx(ctx).With().
+			Str("device_id", device.DeviceID).
+			Str("hostname", device.Hostname).
+			Str("protocols", fmt.Sprintf("%v", device.Protocols))
+		if device.RestPort != nil {
+			zCtx.Int("rest_port", *device.RestPort)
+		}
+		if device.GrpcPort != nil {
+			zCtx.Int("grpc_port", *device.GrpcPort)
+		}
+		if device.TcpPort != nil {
+			zCtx.Int("tcp_port", *device.TcpPort)
+		}
+		if device.RestPath != nil && len(*device.RestPath) > 0 {
+			zCtx.Str("rest_path", *device.RestPath)
+		}
+
+		subCtx := zCtx.Logger().WithContext(ctx)
+		first := !failingInARow(device)
+		if err := w.pollDevice(subCtx, device, cfg); err != nil {
+			w.logs.onFailure(subCtx, first).Err(err).Msgf("failed to poll device %s", device.DeviceID)
+			continue
+		}
+	}
+}
+
+// monitorOf returns the monitor of the first protocol of a device which can be polled, along with the port and the
+// path to poll it on, nil if there is none
+func (w *PollingWorker) monitorOf(ctx context.Context, device repository.Device) (inner api.IDeviceMonitor, port *int, path *string) {
 	for _, protocol := range device.Protocols {
 		switch protocol {
 		case repository.REST:
 			inner = w.rest
-			port = device.RestPort
-			path = device.RestPath
 		case repository.GRPC:
 			inner = w.grpc
-			port = device.GrpcPort
+		case repository.TCP:
+			inner = w.tcp
 		default:
 			zerolog.Ctx(ctx).Warn().Msgf("unsupported protocol %s of device %s", protocol, device.DeviceID)
 		}
 		if inner != nil {
-			break
+			capability, _ := device.Capability(protocol)
+			return inner, capability.Port, capability.Path
 		}
 	}
+	return nil, nil, nil
+}
+
+func (w *PollingWorker) pollDevice(ctx context.Context, device repository.Device, cfg api.PollingConfig) error {
+	inner, port, path := w.monitorOf(ctx, device)
 	if inner == nil {
-		return fmt.Errorf("no supported protocol found for device %s", device.DeviceID)
+		err := fmt.Errorf("no supported protocol found for device %s, protocols: %v", device.DeviceID, device.Protocols)
+		w.markDeviceMisconfigured(ctx, device, err)
+		return err
+	}
+	// the devices polled over tcp report no checksum to verify
+	checksum := w.checksum
+	if inner == w.tcp {
+		checksum = nil
 	}
 
 	retry := &RetryWrapperMonitor{
-		monitor: inner,
-		repo:    w.repo,
-		timeout: cfg.Timeout,
-		backoff: *cfg.Backoff,
+		monitor:     inner,
+		repo:        w.repo,
+		history:     w.history,
+		logs:        w.logs,
+		slots:       w.slots,
+		hook:        w.hook,
+		checksum:    checksum,
+		stats:       w.stats,
+		retries:     w.retryBudget(device.DeviceType),
+		timeout:     cfg.Timeout,
+		backoff:     *cfg.Backoff,
+		resetStreak: w.resetStreak,
+		infoSuccess: w.infoSuccess,
 	}
 
+	if device.InsecureSkipVerify && inner == w.rest && config.RESTSchema() == "https" {
+		zerolog.Ctx(ctx).Warn().Msgf("polling device %s without verifying its tls certificate", device.DeviceID)
+	}
 	go retry.pollDeviceWithBackoff(ctx, &device, api.PollDeviceRequest{
-		Hostname: device.Hostname,
-		Port:     port,
-		Path:     path,
+		DeviceID:           device.DeviceID,
+		DeviceType:         device.DeviceType,
+		Hostname:           device.Hostname,
+		Port:               port,
+		Path:               path,
+		InsecureSkipVerify: device.InsecureSkipVerify,
 	})
 
 	return nil
 }
+
+// retryBudget returns the slots of the devices of a device type waiting to retry, so that the failing devices of a
+// device type do not crowd out the other device types. It is nil when there is no limit.
+func (w *PollingWorker) retryBudget(deviceType string) chan struct{} {
+	if w.maxRetries <= 0 {
+		return nil
+	}
+	budget, _ := w.retries.LoadOrStore(deviceType, make(chan struct{}, w.maxRetries))
+	return budget.(chan struct{})
+}
+
+// markDeviceMisconfigured records a failed polling history and flags the device as misconfigured,
+// so that it surfaces in diagnostics instead of being skipped silently on every polling cycle.
+func (w *PollingWorker) markDeviceMisconfigured(ctx context.Context, device repository.Device, cause error) {
+	reason := failureReason{
+		Error: cause.Error(),
+		Count: 1,
+	}
+	history := &repository.PollingHistory{
+		DeviceID:      device.DeviceID,
+		PollingResult: repository.PollFailed,
+		FailureReason: lo.ToPtr(string(util.JSONMarshalIgnoreErr(reason))),
+	}
+	if err := w.repo.CreatePollingHistory(history); err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("db error: failed to save device polling result")
+	}
+
+	device.PollingStatus = lo.ToPtr(repository.PollingMisconfigured)
+	device.LastCheckedAt = lo.ToPtr(time.Now())
+	device.SuccessStreak = 0
+	device.TotalFailureCount++
+	if err := w.repo.UpdateDevice(&device); err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("db error: failed to update device polling status to 'misconfigured'")
+	}
+}
@@ -2,9 +2,17 @@ package worker
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -15,6 +23,7 @@ import (
 	"example.poc/device-monitoring-system/test/mocks"
 	"github.com/lib/pq"
 	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
 )
@@ -56,6 +65,7 @@ func (s *pollingWorkerTestSuite) SetupSuite() {
 	s.repo = repo
 	s.tp = &testPollingStrategy{configMap: make(map[string]api.PollingConfig)}
 	s.worker = &PollingWorker{
+		id:       "test-worker",
 		repo:     repo,
 		interval: 3 * time.Second,
 	}
@@ -86,7 +96,7 @@ func (s *pollingWorkerTestSuite) TestMockReliableDevices() {
 	devicePollingInterval := 100 * time.Millisecond
 	cfg := api.PollingConfig{
 		Interval:  devicePollingInterval,
-		Timeout:   1 * time.Second,
+		Timeout:   50 * time.Millisecond,
 		BatchSize: 10,
 		Backoff: &api.BackoffConfig{
 			BaseDelay: 1 * time.Second,
@@ -143,7 +153,7 @@ func (s *pollingWorkerTestSuite) TestMockUnReliableDevices() {
 	s.NoError(err)
 
 	pollingInterval := 100 * time.Millisecond
-	pollingTimeout := 100 * time.Millisecond
+	pollingTimeout := 50 * time.Millisecond
 	cfg := api.PollingConfig{
 		Interval:  pollingInterval,
 		Timeout:   pollingTimeout,
@@ -226,6 +236,581 @@ func (s *pollingWorkerTestSuite) TestMockUnReliableDevices() {
 	// }
 }
 
+func (s *pollingWorkerTestSuite) TestHeartbeatAdvancesAcrossTicks() {
+	dts, err := s.repo.GetAllDeviceTypes()
+	s.NoError(err)
+
+	// a polling interval long enough that no device gets polled during the test
+	tp := &testPollingStrategy{configMap: make(map[string]api.PollingConfig)}
+	for _, dt := range dts {
+		tp.configMap[dt.Name] = api.PollingConfig{
+			Interval:  time.Hour,
+			Timeout:   time.Second,
+			BatchSize: 10,
+			Backoff: &api.BackoffConfig{
+				BaseDelay: 1 * time.Second,
+				Factor:    2.0,
+				MaxDelay:  60 * time.Second,
+			},
+		}
+	}
+
+	workerInterval := 100 * time.Millisecond
+	w := &PollingWorker{
+		id:       "heartbeat-worker",
+		repo:     s.repo,
+		rest:     s.mockRest,
+		grpc:     s.mockGrpc,
+		psy:      tp,
+		interval: workerInterval,
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+	go func() {
+		_ = w.Start(ctx)
+	}()
+
+	time.Sleep(workerInterval / 2)
+	first, err := s.repo.GetLatestWorkerHeartbeat()
+	s.NoError(err)
+	s.Equal(w.id, first.WorkerID)
+
+	time.Sleep(3 * workerInterval)
+	second, err := s.repo.GetLatestWorkerHeartbeat()
+	s.NoError(err)
+	s.Equal(w.id, second.WorkerID)
+	s.True(second.UpdatedAt.After(first.UpdatedAt))
+}
+
+func TestNewPollingWorkerWithInvalidStrategy(t *testing.T) {
+	cfg := api.PollingConfig{
+		Interval:  time.Second,
+		Timeout:   500 * time.Millisecond,
+		BatchSize: 10,
+		Backoff: &api.BackoffConfig{
+			BaseDelay: 1 * time.Second,
+			Factor:    2.0,
+			MaxDelay:  60 * time.Second,
+		},
+	}
+	tp := &testPollingStrategy{configMap: make(map[string]api.PollingConfig)}
+	for _, dt := range repository.KnownDeviceTypes {
+		tp.configMap[dt] = cfg
+	}
+	assert.NoError(t, api.ValidatePollingStrategy(tp, repository.KnownDeviceTypes))
+	assert.NoError(t, api.ValidatePollingStrategy(&api.DefaultPollingStrategy{}, repository.KnownDeviceTypes))
+
+	// intentionally broken: base delay exceeds max delay
+	broken := cfg
+	broken.Backoff = &api.BackoffConfig{
+		BaseDelay: 10 * time.Second,
+		Factor:    2.0,
+		MaxDelay:  5 * time.Second,
+	}
+	tp.configMap[repository.Camera] = broken
+
+	_, err := NewPollingWorker(tp, time.Second)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid polling config for device type camera")
+
+	// missing config of a known device type
+	delete(tp.configMap, repository.Camera)
+	_, err = NewPollingWorker(tp, time.Second)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to get polling config for device type camera")
+}
+
+func TestNewPollingWorkerWithDeps(t *testing.T) {
+	t.Setenv("WORKER_ID", "test-worker")
+	mockRepo := mocks.NewMockIRepository(t)
+	mockRest := mocks.NewMockIDeviceMonitor(t)
+	cfg := api.PollingConfig{
+		Interval:  20 * time.Millisecond,
+		Timeout:   10 * time.Millisecond,
+		BatchSize: 1,
+		Backoff:   &api.BackoffConfig{BaseDelay: 10 * time.Millisecond, Factor: 2, MaxDelay: 100 * time.Millisecond},
+	}
+	psy := &testPollingStrategy{configMap: map[string]api.PollingConfig{repository.Router: cfg}}
+
+	_, err := NewPollingWorkerWithDeps(nil, mockRest, nil, psy, time.Second)
+	assert.ErrorContains(t, err, "repository cannot be nil")
+	_, err = NewPollingWorkerWithDeps(mockRepo, mockRest, nil, nil, time.Second)
+	assert.ErrorContains(t, err, "polling strategy cannot be nil")
+	_, err = NewPollingWorkerWithDeps(mockRepo, mockRest, nil, psy, 0)
+	assert.ErrorContains(t, err, "invalid interval")
+
+	mockTcp := mocks.NewMockIDeviceMonitor(t)
+	w, err := NewPollingWorkerWithDeps(mockRepo, mockRest, nil, psy, 10*time.Millisecond, WithTCPMonitor(mockTcp))
+	assert.NoError(t, err)
+	assert.Equal(t, "test-worker", w.id)
+	assert.Same(t, mockTcp, w.tcp)
+
+	device := repository.Device{
+		ID:            1,
+		DeviceID:      helper.RandomString(8),
+		DeviceType:    repository.Router,
+		Hostname:      "some.faked.host",
+		Protocols:     pq.StringArray{repository.REST},
+		RestPort:      lo.ToPtr(8080),
+		PollingStatus: lo.ToPtr(repository.PollingInProgress),
+	}
+	var calls atomic.Int32
+	mockRepo.EXPECT().SaveWorkerHeartbeat("test-worker").Return(nil)
+	mockRepo.EXPECT().GetAllDeviceTypes().Return([]repository.DeviceType{{ID: 1, Name: repository.Router, Enabled: true}}, nil)
+	mockRepo.EXPECT().GetDevicesByPollingParameter(mock.Anything).Return([]repository.Device{device}, nil)
+	mockRest.EXPECT().PollDevice(mock.Anything, mock.Anything).RunAndReturn(func(ctx context.Context, req api.PollDeviceRequest) (*api.PollDeviceResponse, error) {
+		calls.Add(1)
+		return getMockDeviceDataResp(req), nil
+	})
+	mockRepo.EXPECT().CreatePollingHistory(mock.Anything).Return(nil)
+	mockRepo.EXPECT().UpdateDevice(mock.Anything).Return(nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- w.Start(ctx)
+	}()
+	assert.Eventually(t, func() bool { return calls.Load() > 0 }, time.Second, 5*time.Millisecond)
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestPollDeviceWithoutProtocols(t *testing.T) {
+	mockRepo := mocks.NewMockIRepository(t)
+	w := &PollingWorker{
+		repo: mockRepo,
+		rest: mocks.NewMockIDeviceMonitor(t),
+		grpc: mocks.NewMockIDeviceMonitor(t),
+	}
+
+	for _, protocols := range []pq.StringArray{{}, {"mqtt"}} {
+		device := repository.Device{
+			ID:            1,
+			DeviceID:      helper.RandomString(8),
+			DeviceType:    repository.Router,
+			Hostname:      "some.faked.host",
+			Protocols:     protocols,
+			PollingStatus: lo.ToPtr(repository.PollingInProgress),
+		}
+
+		mockRepo.EXPECT().CreatePollingHistory(mock.Anything).Return(nil).Run(func(history *repository.PollingHistory) {
+			assert.Equal(t, device.DeviceID, history.DeviceID)
+			assert.Equal(t, repository.PollFailed, history.PollingResult)
+			assert.NotNil(t, history.FailureReason)
+			assert.Contains(t, *history.FailureReason, "no supported protocol found")
+		}).Once()
+		mockRepo.EXPECT().UpdateDevice(mock.Anything).Return(nil).Run(func(d *repository.Device) {
+			assert.Equal(t, device.DeviceID, d.DeviceID)
+			assert.Equal(t, repository.PollingMisconfigured, *d.PollingStatus)
+			assert.NotNil(t, d.LastCheckedAt)
+			assert.Equal(t, 1, d.TotalFailureCount)
+		}).Once()
+
+		err := w.pollDevice(context.Background(), device, api.PollingConfig{})
+		assert.Error(t, err)
+	}
+}
+
+func TestPollDeviceWithCapabilities(t *testing.T) {
+	mockRepo := mocks.NewMockIRepository(t)
+	mockGrpc := mocks.NewMockIDeviceMonitor(t)
+	mockTcp := mocks.NewMockIDeviceMonitor(t)
+	w := &PollingWorker{
+		repo: mockRepo,
+		grpc: mockGrpc,
+		tcp:  mockTcp,
+	}
+	cfg := api.PollingConfig{
+		Timeout: time.Second,
+		Backoff: &api.BackoffConfig{BaseDelay: 10 * time.Millisecond, Factor: 2, MaxDelay: 100 * time.Millisecond},
+	}
+	mockRepo.EXPECT().CreatePollingHistory(mock.Anything).Return(nil)
+	mockRepo.EXPECT().UpdateDevice(mock.Anything).Return(nil)
+
+	// the port is taken from the capabilities
+	polled := make(chan api.PollDeviceRequest, 1)
+	mockTcp.EXPECT().PollDevice(mock.Anything, mock.Anything).RunAndReturn(func(ctx context.Context, req api.PollDeviceRequest) (*api.PollDeviceResponse, error) {
+		polled <- req
+		return getMockDeviceDataResp(req), nil
+	}).Once()
+	device := repository.Device{
+		ID:           1,
+		DeviceID:     helper.RandomString(8),
+		DeviceType:   repository.Router,
+		Hostname:     "some.faked.host",
+		Protocols:    pq.StringArray{repository.TCP},
+		Capabilities: repository.Capabilities{{Protocol: repository.TCP, Port: lo.ToPtr(7000), Extra: map[string]string{"framing": "line"}}},
+	}
+	assert.NoError(t, w.pollDevice(context.Background(), device, cfg))
+	req := <-polled
+	assert.Equal(t, device.Hostname, req.Hostname)
+	assert.Equal(t, lo.ToPtr(7000), req.Port)
+
+	// and derived from the legacy columns for a device without capabilities
+	mockGrpc.EXPECT().PollDevice(mock.Anything, mock.Anything).RunAndReturn(func(ctx context.Context, req api.PollDeviceRequest) (*api.PollDeviceResponse, error) {
+		polled <- req
+		return getMockDeviceDataResp(req), nil
+	}).Once()
+	device = repository.Device{
+		ID:         2,
+		DeviceID:   helper.RandomString(8),
+		DeviceType: repository.Router,
+		Hostname:   "some.faked.host",
+		Protocols:  pq.StringArray{repository.GRPC},
+		GrpcPort:   lo.ToPtr(50051),
+	}
+	assert.NoError(t, w.pollDevice(context.Background(), device, cfg))
+	req = <-polled
+	assert.Equal(t, device.DeviceID, req.DeviceID)
+	assert.Equal(t, repository.Router, req.DeviceType)
+	assert.Equal(t, lo.ToPtr(50051), req.Port)
+	assert.Nil(t, req.Path)
+}
+
+func TestTcpDeviceChecksumNotVerified(t *testing.T) {
+	mockRepo := mocks.NewMockIRepository(t)
+	mockTcp := mocks.NewMockIDeviceMonitor(t)
+	w := &PollingWorker{
+		repo: mockRepo,
+		tcp:  mockTcp,
+		// the random checksum of the mocked response is not the expected one
+		checksum: func(repository.Device) (string, error) { return "expected checksum", nil },
+	}
+	cfg := api.PollingConfig{
+		Timeout: time.Second,
+		Backoff: &api.BackoffConfig{BaseDelay: 10 * time.Millisecond, Factor: 2, MaxDelay: 100 * time.Millisecond},
+	}
+	histories := make(chan *repository.PollingHistory, 1)
+	mockRepo.EXPECT().CreatePollingHistory(mock.Anything).RunAndReturn(func(h *repository.PollingHistory) error {
+		histories <- h
+		return nil
+	}).Once()
+	updated := make(chan struct{})
+	mockRepo.EXPECT().UpdateDevice(mock.Anything).Return(nil).Run(func(*repository.Device) { close(updated) }).Once()
+	mockTcp.EXPECT().PollDevice(mock.Anything, mock.Anything).RunAndReturn(func(ctx context.Context, req api.PollDeviceRequest) (*api.PollDeviceResponse, error) {
+		return getMockDeviceDataResp(req), nil
+	}).Once()
+
+	device := repository.Device{
+		ID:         1,
+		DeviceID:   helper.RandomString(8),
+		DeviceType: repository.Router,
+		Hostname:   "some.faked.host",
+		Protocols:  pq.StringArray{repository.TCP},
+		TcpPort:    lo.ToPtr(7000),
+	}
+	assert.NoError(t, w.pollDevice(context.Background(), device, cfg))
+	h := <-histories
+	<-updated
+	assert.Equal(t, repository.PollSucceed, h.PollingResult)
+	assert.Nil(t, h.FailureReason)
+}
+
+func TestWorkerConfigReadOnce(t *testing.T) {
+	t.Setenv("FAILURE_COUNT_RESET_STREAK", "2")
+	t.Setenv("LOG_SUCCESSFUL_POLLS_AT_INFO", "true")
+	mockRepo := mocks.NewMockIRepository(t)
+	mockRest := mocks.NewMockIDeviceMonitor(t)
+	psy, err := api.NewPollingStrategy(api.DefaultPollingStrategyName)
+	assert.NoError(t, err)
+	w, err := NewPollingWorkerWithDeps(mockRepo, mockRest, nil, psy, time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, w.resetStreak)
+	assert.True(t, w.infoSuccess)
+
+	// a change of the env is not picked up by the polls of the worker
+	t.Setenv("FAILURE_COUNT_RESET_STREAK", "0")
+	updated := make(chan repository.Device, 1)
+	mockRepo.EXPECT().CreatePollingHistory(mock.Anything).Return(nil).Once()
+	mockRepo.EXPECT().UpdateDevice(mock.Anything).RunAndReturn(func(d *repository.Device) error {
+		updated <- *d
+		return nil
+	}).Once()
+	mockRest.EXPECT().PollDevice(mock.Anything, mock.Anything).RunAndReturn(func(ctx context.Context, req api.PollDeviceRequest) (*api.PollDeviceResponse, error) {
+		return getMockDeviceDataResp(req), nil
+	}).Once()
+
+	cfg := api.PollingConfig{
+		Timeout: time.Second,
+		Backoff: &api.BackoffConfig{BaseDelay: 10 * time.Millisecond, Factor: 2, MaxDelay: 100 * time.Millisecond},
+	}
+	device := repository.Device{
+		ID:                1,
+		DeviceID:          helper.RandomString(8),
+		DeviceType:        repository.Router,
+		Hostname:          "some.faked.host",
+		Protocols:         pq.StringArray{repository.REST},
+		RestPort:          lo.ToPtr(8080),
+		SuccessStreak:     1,
+		TotalFailureCount: 5,
+	}
+	assert.NoError(t, w.pollDevice(context.Background(), device, cfg))
+	d := <-updated
+	assert.Equal(t, 2, d.SuccessStreak)
+	assert.Zero(t, d.TotalFailureCount)
+}
+
+func TestGlobalMaxConcurrentPolls(t *testing.T) {
+	limit := 3
+	mockRepo := mocks.NewMockIRepository(t)
+	mockRest := mocks.NewMockIDeviceMonitor(t)
+	mockGrpc := mocks.NewMockIDeviceMonitor(t)
+	w := &PollingWorker{
+		repo:  mockRepo,
+		rest:  mockRest,
+		grpc:  mockGrpc,
+		slots: make(chan struct{}, limit),
+	}
+
+	var current, peak atomic.Int32
+	var wg sync.WaitGroup
+	poll := func(ctx context.Context, req api.PollDeviceRequest) (*api.PollDeviceResponse, error) {
+		n := current.Add(1)
+		defer current.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		return getMockDeviceDataResp(req), nil
+	}
+	mockRest.EXPECT().PollDevice(mock.Anything, mock.Anything).RunAndReturn(poll)
+	mockGrpc.EXPECT().PollDevice(mock.Anything, mock.Anything).RunAndReturn(poll)
+	mockRepo.EXPECT().CreatePollingHistory(mock.Anything).Return(nil)
+	mockRepo.EXPECT().UpdateDevice(mock.Anything).Return(nil).Run(func(*repository.Device) {
+		wg.Done()
+	})
+
+	cfg := api.PollingConfig{
+		Timeout: time.Second,
+		Backoff: &api.BackoffConfig{BaseDelay: 10 * time.Millisecond, Factor: 2, MaxDelay: 100 * time.Millisecond},
+	}
+	types := []string{repository.Router, repository.Switch, repository.Camera, repository.DoorAccessSystem}
+	for i := range 20 {
+		protocol := repository.REST
+		if i%2 == 1 {
+			protocol = repository.GRPC
+		}
+		device := repository.Device{
+			ID:            uint(i + 1),
+			DeviceID:      helper.RandomString(8),
+			DeviceType:    types[i%len(types)],
+			Hostname:      "some.faked.host",
+			Protocols:     pq.StringArray{protocol},
+			RestPort:      lo.ToPtr(8080),
+			GrpcPort:      lo.ToPtr(50051),
+			PollingStatus: lo.ToPtr(repository.PollingInProgress),
+		}
+		wg.Add(1)
+		err := w.pollDevice(context.Background(), device, cfg)
+		assert.NoError(t, err)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(limit), peak.Load())
+}
+
+func TestPauseAndResume(t *testing.T) {
+	mockRepo := mocks.NewMockIRepository(t)
+	mockRest := mocks.NewMockIDeviceMonitor(t)
+	w := &PollingWorker{
+		id:   "test-worker",
+		repo: mockRepo,
+		rest: mockRest,
+	}
+	t.Setenv("ADMIN_API_TOKEN", "test-token")
+	control := NewControlHandler(w)
+	sendControl := func(path string) controlStatusResponse {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.Header.Set("Authorization", "Bearer test-token")
+		rec := httptest.NewRecorder()
+		control.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var status controlStatusResponse
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+		return status
+	}
+
+	device := repository.Device{
+		ID:            1,
+		DeviceID:      helper.RandomString(8),
+		DeviceType:    repository.Router,
+		Hostname:      "some.faked.host",
+		Protocols:     pq.StringArray{repository.REST},
+		PollingStatus: lo.ToPtr(repository.PollingInProgress),
+	}
+	var calls atomic.Int32
+	mockRepo.EXPECT().GetDevicesByPollingParameter(mock.Anything).Return([]repository.Device{device}, nil).Maybe()
+	mockRest.EXPECT().PollDevice(mock.Anything, mock.Anything).RunAndReturn(func(ctx context.Context, req api.PollDeviceRequest) (*api.PollDeviceResponse, error) {
+		calls.Add(1)
+		return getMockDeviceDataResp(req), nil
+	}).Maybe()
+	mockRepo.EXPECT().CreatePollingHistory(mock.Anything).Return(nil).Maybe()
+	mockRepo.EXPECT().UpdateDevice(mock.Anything).Return(nil).Maybe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := api.PollingConfig{
+		Interval: 10 * time.Millisecond,
+		Timeout:  time.Second,
+		Backoff:  &api.BackoffConfig{BaseDelay: 10 * time.Millisecond, Factor: 2, MaxDelay: 100 * time.Millisecond},
+	}
+	go w.startPollingDevicesByType(ctx, repository.Router, cfg)
+
+	assert.Eventually(t, func() bool { return calls.Load() > 0 }, time.Second, 5*time.Millisecond)
+
+	status := sendControl("/control/pause")
+	assert.True(t, status.Paused)
+	assert.Equal(t, "test-worker", status.WorkerID)
+
+	// let the polls in flight finish
+	time.Sleep(5 * cfg.Interval)
+	n := calls.Load()
+	time.Sleep(10 * cfg.Interval)
+	assert.Equal(t, n, calls.Load())
+
+	status = sendControl("/control/resume")
+	assert.False(t, status.Paused)
+	assert.Eventually(t, func() bool { return calls.Load() > n }, time.Second, 5*time.Millisecond)
+}
+
+func TestPollIntervalOverride(t *testing.T) {
+	mockRepo := mocks.NewMockIRepository(t)
+	cfg := api.PollingConfig{
+		Interval:  100 * time.Millisecond,
+		Timeout:   10 * time.Millisecond,
+		BatchSize: 1,
+		Backoff:   &api.BackoffConfig{BaseDelay: 10 * time.Millisecond, Factor: 2, MaxDelay: 100 * time.Millisecond},
+	}
+	w := &PollingWorker{
+		id:   "test-worker",
+		repo: mockRepo,
+		psy: &testPollingStrategy{configMap: map[string]api.PollingConfig{
+			repository.Router: cfg,
+			repository.Switch: cfg,
+		}},
+		interval: 10 * time.Millisecond,
+	}
+
+	var mu sync.Mutex
+	polled := make(map[string]int)
+	mockRepo.EXPECT().SaveWorkerHeartbeat("test-worker").Return(nil)
+	mockRepo.EXPECT().GetAllDeviceTypes().Return([]repository.DeviceType{
+		{ID: 1, Name: repository.Router, Enabled: true, ShortestPollIntervalOverride: lo.ToPtr(20 * time.Millisecond)},
+		{ID: 2, Name: repository.Switch, Enabled: true},
+	}, nil)
+	mockRepo.EXPECT().GetDevicesByPollingParameter(mock.Anything).RunAndReturn(func(param repository.DevicePollingParameter) ([]repository.Device, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		polled[param.DeviceType]++
+		// the devices without override are still due on the interval of their device type
+		assert.Equal(t, cfg.Interval, param.Interval)
+		return nil, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- w.Start(ctx)
+	}()
+	time.Sleep(500 * time.Millisecond)
+	cancel()
+	assert.NoError(t, <-done)
+
+	// the devices of the router type are checked on the shorter interval of the override
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Greater(t, polled[repository.Switch], 2)
+	assert.Greater(t, polled[repository.Router], 2*polled[repository.Switch])
+}
+
+func TestControlRequiresAdminToken(t *testing.T) {
+	w := &PollingWorker{id: "test-worker"}
+	control := NewControlHandler(w)
+	sendControl := func(token string) int {
+		req := httptest.NewRequest(http.MethodPost, "/control/pause", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		control.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// disabled when no token is configured
+	t.Setenv("ADMIN_API_TOKEN", "")
+	assert.Equal(t, http.StatusForbidden, sendControl("test-token"))
+
+	t.Setenv("ADMIN_API_TOKEN", "test-token")
+	assert.Equal(t, http.StatusUnauthorized, sendControl(""))
+	assert.Equal(t, http.StatusUnauthorized, sendControl("wrong-token"))
+	assert.False(t, w.Paused())
+
+	assert.Equal(t, http.StatusOK, sendControl("test-token"))
+	assert.True(t, w.Paused())
+}
+
+func TestServeControlShutsDownWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- ServeControl(ctx, &PollingWorker{id: "test-worker"}, 0)
+	}()
+	cancel()
+	select {
+	case err := <-served:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the control server is not shut down with the context")
+	}
+}
+
+func TestPreferFailingDevices(t *testing.T) {
+	mockRepo := mocks.NewMockIRepository(t)
+	w := &PollingWorker{
+		id:   "test-worker",
+		repo: mockRepo,
+	}
+	t.Setenv("ADMIN_API_TOKEN", "test-token")
+	control := NewControlHandler(w)
+	sendControl := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.Header.Set("Authorization", "Bearer test-token")
+		rec := httptest.NewRecorder()
+		control.ServeHTTP(rec, req)
+		return rec
+	}
+
+	var preferred []bool
+	mockRepo.EXPECT().GetDevicesByPollingParameter(mock.Anything).RunAndReturn(func(param repository.DevicePollingParameter) ([]repository.Device, error) {
+		preferred = append(preferred, param.PreferFailing)
+		return nil, nil
+	})
+
+	cfg := api.PollingConfig{Interval: time.Second, BatchSize: 10}
+	w.pollDevicesByType(context.Background(), repository.Router, cfg)
+
+	rec := sendControl("/control/prefer-failing?enabled=true")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var status controlStatusResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+	assert.True(t, status.PreferFailing)
+	w.pollDevicesByType(context.Background(), repository.Router, cfg)
+
+	assert.Equal(t, http.StatusBadRequest, sendControl("/control/prefer-failing?enabled=maybe").Code)
+	assert.True(t, w.PrefersFailing())
+
+	rec = sendControl("/control/prefer-failing?enabled=false")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	w.pollDevicesByType(context.Background(), repository.Router, cfg)
+
+	assert.Equal(t, []bool{false, true, false}, preferred)
+}
+
 func getMockDeviceDataResp(req api.PollDeviceRequest) *api.PollDeviceResponse {
 	return &api.PollDeviceResponse{
 		Hw:       helper.RandomString(10),
@@ -276,3 +861,600 @@ func initTestDB(repo *repository.Repo) error {
 
 	return nil
 }
+
+func TestPollOnStart(t *testing.T) {
+	for _, pollOnStart := range []bool{true, false} {
+		mockRepo := mocks.NewMockIRepository(t)
+		mockRest := mocks.NewMockIDeviceMonitor(t)
+		w := &PollingWorker{
+			repo:        mockRepo,
+			rest:        mockRest,
+			pollOnStart: pollOnStart,
+		}
+
+		device := repository.Device{
+			ID:            1,
+			DeviceID:      helper.RandomString(8),
+			DeviceType:    repository.Router,
+			Hostname:      "some.faked.host",
+			Protocols:     pq.StringArray{repository.REST},
+			PollingStatus: lo.ToPtr(repository.PollingInProgress),
+		}
+		var calls atomic.Int32
+		mockRepo.EXPECT().GetDevicesByPollingParameter(mock.Anything).Return([]repository.Device{device}, nil).Maybe()
+		mockRest.EXPECT().PollDevice(mock.Anything, mock.Anything).RunAndReturn(func(ctx context.Context, req api.PollDeviceRequest) (*api.PollDeviceResponse, error) {
+			calls.Add(1)
+			return getMockDeviceDataResp(req), nil
+		}).Maybe()
+		mockRepo.EXPECT().CreatePollingHistory(mock.Anything).Return(nil).Maybe()
+		mockRepo.EXPECT().UpdateDevice(mock.Anything).Return(nil).Maybe()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cfg := api.PollingConfig{
+			Interval: time.Minute,
+			Timeout:  time.Second,
+			Backoff:  &api.BackoffConfig{BaseDelay: 10 * time.Millisecond, Factor: 2, MaxDelay: 100 * time.Millisecond},
+		}
+		go w.startPollingDevicesByType(ctx, repository.Router, cfg)
+
+		if pollOnStart {
+			assert.Eventually(t, func() bool { return calls.Load() > 0 }, time.Second, 5*time.Millisecond)
+		} else {
+			time.Sleep(100 * time.Millisecond)
+			assert.Zero(t, calls.Load())
+		}
+		cancel()
+	}
+}
+
+func TestPollOnStartWithSchedule(t *testing.T) {
+	mockRepo := mocks.NewMockIRepository(t)
+	w := &PollingWorker{
+		repo:        mockRepo,
+		pollOnStart: true,
+	}
+
+	var calls atomic.Int32
+	mockRepo.EXPECT().GetDevicesByPollingParameter(mock.Anything).RunAndReturn(func(param repository.DevicePollingParameter) ([]repository.Device, error) {
+		calls.Add(1)
+		return nil, nil
+	}).Maybe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := api.PollingConfig{
+		Interval: time.Minute,
+		Timeout:  time.Second,
+		Backoff:  &api.BackoffConfig{BaseDelay: 10 * time.Millisecond, Factor: 2, MaxDelay: 100 * time.Millisecond},
+		// never due during the test
+		Schedule: "0 0 1 1 *",
+	}
+	go w.startPollingDevicesByType(ctx, repository.Router, cfg)
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Zero(t, calls.Load())
+}
+
+func TestBatchFillRatio(t *testing.T) {
+	tl := helper.NewTestLogger()
+	ctx := tl.ZeroLogger().WithContext(context.Background())
+	mockRepo := mocks.NewMockIRepository(t)
+	mockRest := mocks.NewMockIDeviceMonitor(t)
+	w := &PollingWorker{
+		id:   "test-worker",
+		repo: mockRepo,
+		rest: mockRest,
+	}
+
+	var devices []repository.Device
+	for i := range 3 {
+		devices = append(devices, repository.Device{
+			ID:            uint(i + 1),
+			DeviceID:      helper.RandomString(8),
+			DeviceType:    repository.Router,
+			Hostname:      "some.faked.host",
+			Protocols:     pq.StringArray{repository.REST},
+			PollingStatus: lo.ToPtr(repository.PollingInProgress),
+		})
+	}
+	var calls atomic.Int32
+	mockRepo.EXPECT().GetDevicesByPollingParameter(mock.Anything).Return(devices, nil).Once()
+	mockRest.EXPECT().PollDevice(mock.Anything, mock.Anything).RunAndReturn(func(ctx context.Context, req api.PollDeviceRequest) (*api.PollDeviceResponse, error) {
+		return getMockDeviceDataResp(req), nil
+	}).Times(3)
+	mockRepo.EXPECT().CreatePollingHistory(mock.Anything).Return(nil).Times(3)
+	mockRepo.EXPECT().UpdateDevice(mock.Anything).RunAndReturn(func(*repository.Device) error {
+		calls.Add(1)
+		return nil
+	}).Times(3)
+
+	cfg := api.PollingConfig{
+		Interval:  time.Minute,
+		Timeout:   time.Second,
+		BatchSize: 5,
+		Backoff:   &api.BackoffConfig{BaseDelay: 10 * time.Millisecond, Factor: 2, MaxDelay: 100 * time.Millisecond},
+	}
+	w.pollDevicesByType(ctx, repository.Router, cfg)
+	assert.Eventually(t, func() bool { return calls.Load() == 3 }, time.Second, 5*time.Millisecond)
+
+	claimed := lo.Filter(tl.GetLogLines(), func(line string, _ int) bool {
+		return strings.Contains(line, "claimed devices of type router")
+	})
+	assert.Len(t, claimed, 1)
+	assert.Contains(t, claimed[0], `"claimed_count":3`)
+	assert.Contains(t, claimed[0], `"batch_size":5`)
+	assert.Equal(t, map[string]float64{repository.Router: 0.6}, w.controlStatus().PollBatchFillRatio)
+}
+
+func TestDeviceTypesFailTransiently(t *testing.T) {
+	mockRepo := mocks.NewMockIRepository(t)
+	mockRest := mocks.NewMockIDeviceMonitor(t)
+	cfg := api.PollingConfig{
+		Interval:  20 * time.Millisecond,
+		Timeout:   10 * time.Millisecond,
+		BatchSize: 1,
+		Backoff:   &api.BackoffConfig{BaseDelay: 10 * time.Millisecond, Factor: 2, MaxDelay: 100 * time.Millisecond},
+	}
+	w := &PollingWorker{
+		id:          "test-worker",
+		repo:        mockRepo,
+		rest:        mockRest,
+		psy:         &testPollingStrategy{configMap: map[string]api.PollingConfig{repository.Router: cfg}},
+		interval:    10 * time.Millisecond,
+		maxFailures: 3,
+	}
+
+	device := repository.Device{
+		ID:            1,
+		DeviceID:      helper.RandomString(8),
+		DeviceType:    repository.Router,
+		Hostname:      "some.faked.host",
+		Protocols:     pq.StringArray{repository.REST},
+		PollingStatus: lo.ToPtr(repository.PollingInProgress),
+	}
+	var calls atomic.Int32
+	mockRepo.EXPECT().SaveWorkerHeartbeat("test-worker").Return(nil)
+	mockRepo.EXPECT().GetAllDeviceTypes().Return(nil, fmt.Errorf("connection reset")).Twice()
+	mockRepo.EXPECT().GetAllDeviceTypes().Return([]repository.DeviceType{{ID: 1, Name: repository.Router, Enabled: true}}, nil)
+	mockRepo.EXPECT().GetDevicesByPollingParameter(mock.Anything).Return([]repository.Device{device}, nil).Maybe()
+	mockRest.EXPECT().PollDevice(mock.Anything, mock.Anything).RunAndReturn(func(ctx context.Context, req api.PollDeviceRequest) (*api.PollDeviceResponse, error) {
+		calls.Add(1)
+		return getMockDeviceDataResp(req), nil
+	}).Maybe()
+	mockRepo.EXPECT().CreatePollingHistory(mock.Anything).Return(nil).Maybe()
+	mockRepo.EXPECT().UpdateDevice(mock.Anything).Return(nil).Maybe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- w.Start(ctx)
+	}()
+	assert.Eventually(t, func() bool { return calls.Load() > 0 }, time.Second, 5*time.Millisecond)
+	cancel()
+	assert.NoError(t, <-done)
+
+	// the worker gives up once the failures in a row reach the threshold
+	mockRepo = mocks.NewMockIRepository(t)
+	mockRepo.EXPECT().SaveWorkerHeartbeat("test-worker").Return(nil)
+	mockRepo.EXPECT().GetAllDeviceTypes().Return(nil, fmt.Errorf("connection reset")).Times(3)
+	w.repo = mockRepo
+	err := w.Start(context.Background())
+	assert.ErrorContains(t, err, "failed to get all device types 3 times in a row")
+}
+
+func TestDisableDeviceTypeMidRun(t *testing.T) {
+	mockRepo := mocks.NewMockIRepository(t)
+	mockRest := mocks.NewMockIDeviceMonitor(t)
+	cfg := api.PollingConfig{
+		Interval:  20 * time.Millisecond,
+		Timeout:   10 * time.Millisecond,
+		BatchSize: 1,
+		Backoff:   &api.BackoffConfig{BaseDelay: 10 * time.Millisecond, Factor: 2, MaxDelay: 100 * time.Millisecond},
+	}
+	w := &PollingWorker{
+		id:   "test-worker",
+		repo: mockRepo,
+		rest: mockRest,
+		psy: &testPollingStrategy{configMap: map[string]api.PollingConfig{
+			repository.Router: cfg,
+			repository.Switch: cfg,
+		}},
+		interval:    10 * time.Millisecond,
+		pollOnStart: true,
+	}
+
+	var routerEnabled atomic.Bool
+	routerEnabled.Store(true)
+	var routerPolls, switchPolls atomic.Int32
+	mockRepo.EXPECT().SaveWorkerHeartbeat("test-worker").Return(nil)
+	mockRepo.EXPECT().GetAllDeviceTypes().RunAndReturn(func() ([]repository.DeviceType, error) {
+		return []repository.DeviceType{
+			{ID: 1, Name: repository.Router, Enabled: routerEnabled.Load()},
+			{ID: 2, Name: repository.Switch, Enabled: true},
+		}, nil
+	})
+	mockRepo.EXPECT().GetDevicesByPollingParameter(mock.Anything).RunAndReturn(func(param repository.DevicePollingParameter) ([]repository.Device, error) {
+		return []repository.Device{{
+			ID:            1,
+			DeviceID:      param.DeviceType + "-1",
+			DeviceType:    param.DeviceType,
+			Hostname:      param.DeviceType + ".faked.host",
+			Protocols:     pq.StringArray{repository.REST},
+			PollingStatus: lo.ToPtr(repository.PollingInProgress),
+		}}, nil
+	})
+	mockRest.EXPECT().PollDevice(mock.Anything, mock.Anything).RunAndReturn(func(ctx context.Context, req api.PollDeviceRequest) (*api.PollDeviceResponse, error) {
+		if strings.HasPrefix(req.Hostname, repository.Router) {
+			routerPolls.Add(1)
+		} else {
+			switchPolls.Add(1)
+		}
+		return getMockDeviceDataResp(req), nil
+	})
+	mockRepo.EXPECT().CreatePollingHistory(mock.Anything).Return(nil)
+	mockRepo.EXPECT().UpdateDevice(mock.Anything).Return(nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- w.Start(ctx)
+	}()
+	assert.Eventually(t, func() bool { return routerPolls.Load() > 0 && switchPolls.Load() > 0 }, time.Second, 5*time.Millisecond)
+
+	routerEnabled.Store(false)
+	// let the worker pick up the change and the polls in flight finish
+	time.Sleep(50 * time.Millisecond)
+	stoppedAt := routerPolls.Load()
+	switchedAt := switchPolls.Load()
+	assert.Eventually(t, func() bool { return switchPolls.Load() > switchedAt+3 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, stoppedAt, routerPolls.Load())
+
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestPollingSummary(t *testing.T) {
+	mockRepo := mocks.NewMockIRepository(t)
+	mockRest := mocks.NewMockIDeviceMonitor(t)
+	cfg := api.PollingConfig{
+		Interval:  20 * time.Millisecond,
+		Timeout:   10 * time.Millisecond,
+		BatchSize: 1,
+		Backoff:   &api.BackoffConfig{BaseDelay: time.Second, Factor: 2, MaxDelay: 10 * time.Second},
+	}
+	w := &PollingWorker{
+		id:   "test-worker",
+		repo: mockRepo,
+		rest: mockRest,
+		psy: &testPollingStrategy{configMap: map[string]api.PollingConfig{
+			repository.Router: cfg,
+			repository.Switch: cfg,
+		}},
+		interval:    10 * time.Millisecond,
+		pollOnStart: true,
+		stats:       &pollingStats{},
+	}
+
+	var routerPolls, switchPolls atomic.Int64
+	mockRepo.EXPECT().SaveWorkerHeartbeat("test-worker").Return(nil)
+	mockRepo.EXPECT().GetAllDeviceTypes().Return([]repository.DeviceType{
+		{ID: 1, Name: repository.Router, Enabled: true},
+		{ID: 2, Name: repository.Switch, Enabled: true},
+	}, nil)
+	mockRepo.EXPECT().GetDevicesByPollingParameter(mock.Anything).RunAndReturn(func(param repository.DevicePollingParameter) ([]repository.Device, error) {
+		// a single switch is polled, retrying until the session ends
+		if param.DeviceType == repository.Switch && switchPolls.Load() > 0 {
+			return nil, nil
+		}
+		return []repository.Device{{
+			ID:         1,
+			DeviceID:   param.DeviceType + "-1",
+			DeviceType: param.DeviceType,
+			Hostname:   param.DeviceType + ".faked.host",
+			Protocols:  pq.StringArray{repository.REST},
+		}}, nil
+	})
+	mockRest.EXPECT().PollDevice(mock.Anything, mock.Anything).RunAndReturn(func(ctx context.Context, req api.PollDeviceRequest) (*api.PollDeviceResponse, error) {
+		if strings.HasPrefix(req.Hostname, repository.Switch) {
+			switchPolls.Add(1)
+			return nil, errors.New("connection refused")
+		}
+		routerPolls.Add(1)
+		return getMockDeviceDataResp(req), nil
+	})
+	mockRepo.EXPECT().CreatePollingHistory(mock.Anything).Return(nil)
+	mockRepo.EXPECT().UpdateDevice(mock.Anything).Return(nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- w.Start(ctx)
+	}()
+	assert.Eventually(t, func() bool { return routerPolls.Load() > 2 && switchPolls.Load() > 0 }, time.Second, 5*time.Millisecond)
+
+	summary := w.Summary()
+	assert.GreaterOrEqual(t, summary.InProgress, int64(1))
+
+	cancel()
+	assert.NoError(t, <-done)
+	assert.Eventually(t, func() bool { return w.Summary().InProgress == 0 }, time.Second, 5*time.Millisecond)
+
+	summary = w.Summary()
+	assert.Equal(t, routerPolls.Load(), summary.Succeeded)
+	assert.Equal(t, switchPolls.Load(), summary.FailedAttempts)
+	assert.Zero(t, summary.Failed)
+	assert.GreaterOrEqual(t, summary.Cancelled, int64(1))
+	assert.Equal(t, summary.Polled, summary.Succeeded+summary.Failed+summary.Cancelled)
+}
+
+func TestPollOnSchedule(t *testing.T) {
+	mockRepo := mocks.NewMockIRepository(t)
+	mockRest := mocks.NewMockIDeviceMonitor(t)
+	w := &PollingWorker{
+		id:   "test-worker",
+		repo: mockRepo,
+		rest: mockRest,
+		// the interval alone would not poll the devices during the test
+		psy:      filePollingStrategy(t, `{"router": {"interval": "1h", "request_timeout": "10ms", "batch_size": 1, "schedule": "@every 1s"}}`),
+		interval: time.Hour,
+	}
+
+	var polledAt []time.Time
+	var mu sync.Mutex
+	mockRepo.EXPECT().SaveWorkerHeartbeat("test-worker").Return(nil)
+	mockRepo.EXPECT().GetAllDeviceTypes().Return([]repository.DeviceType{{ID: 1, Name: repository.Router, Enabled: true}}, nil)
+	mockRepo.EXPECT().GetDevicesByPollingParameter(mock.Anything).RunAndReturn(func(param repository.DevicePollingParameter) ([]repository.Device, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		polledAt = append(polledAt, time.Now())
+		return nil, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	start := time.Now()
+	done := make(chan error)
+	go func() {
+		done <- w.Start(ctx)
+	}()
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(polledAt) >= 2
+	}, 3*time.Second, 10*time.Millisecond)
+	cancel()
+	assert.NoError(t, <-done)
+
+	mu.Lock()
+	defer mu.Unlock()
+	// a poll on every whole second of the schedule
+	assert.Less(t, polledAt[0].Sub(start), 1100*time.Millisecond)
+	assert.InDelta(t, time.Second, polledAt[1].Sub(polledAt[0]), float64(200*time.Millisecond))
+}
+
+func TestRetryBudgetPerDeviceType(t *testing.T) {
+	mockRepo := mocks.NewMockIRepository(t)
+	mockRest := mocks.NewMockIDeviceMonitor(t)
+	cfg := api.PollingConfig{
+		Interval:  20 * time.Millisecond,
+		Timeout:   10 * time.Millisecond,
+		BatchSize: 10,
+		Backoff:   &api.BackoffConfig{BaseDelay: time.Second, Factor: 2, MaxDelay: 10 * time.Second},
+	}
+	w := &PollingWorker{
+		id:   "test-worker",
+		repo: mockRepo,
+		rest: mockRest,
+		psy: &testPollingStrategy{configMap: map[string]api.PollingConfig{
+			repository.Router: cfg,
+			repository.Switch: cfg,
+		}},
+		interval:    10 * time.Millisecond,
+		pollOnStart: true,
+		maxRetries:  3,
+	}
+
+	var routerPolls, switchPolls, deferred atomic.Int32
+	mockRepo.EXPECT().SaveWorkerHeartbeat("test-worker").Return(nil)
+	mockRepo.EXPECT().GetAllDeviceTypes().Return([]repository.DeviceType{
+		{ID: 1, Name: repository.Router, Enabled: true},
+		{ID: 2, Name: repository.Switch, Enabled: true},
+	}, nil)
+	mockRepo.EXPECT().GetDevicesByPollingParameter(mock.Anything).RunAndReturn(func(param repository.DevicePollingParameter) ([]repository.Device, error) {
+		devices := make([]repository.Device, param.Limit)
+		for i := range devices {
+			devices[i] = repository.Device{
+				ID:         uint(i + 1),
+				DeviceID:   fmt.Sprintf("%s-%d", param.DeviceType, i+1),
+				DeviceType: param.DeviceType,
+				Hostname:   param.DeviceType + ".faked.host",
+				Protocols:  pq.StringArray{repository.REST},
+			}
+		}
+		return devices, nil
+	})
+	mockRest.EXPECT().PollDevice(mock.Anything, mock.Anything).RunAndReturn(func(ctx context.Context, req api.PollDeviceRequest) (*api.PollDeviceResponse, error) {
+		if strings.HasPrefix(req.Hostname, repository.Switch) {
+			switchPolls.Add(1)
+			return nil, errors.New("connection refused")
+		}
+		routerPolls.Add(1)
+		return getMockDeviceDataResp(req), nil
+	})
+	mockRepo.EXPECT().CreatePollingHistory(mock.Anything).Return(nil)
+	mockRepo.EXPECT().UpdateDevice(mock.Anything).RunAndReturn(func(device *repository.Device) error {
+		if lo.FromPtr(device.PollingStatus) == repository.PollingDeferred {
+			deferred.Add(1)
+		}
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- w.Start(ctx)
+	}()
+
+	budget := w.retryBudget(repository.Switch)
+	deadline := time.Now().Add(300 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		assert.LessOrEqual(t, len(budget), 3)
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.Equal(t, 3, len(budget))
+	assert.Greater(t, deferred.Load(), int32(0))
+	assert.Greater(t, switchPolls.Load(), int32(3))
+
+	// the routers are still polled on every cycle
+	routerPolled := routerPolls.Load()
+	assert.Eventually(t, func() bool { return routerPolls.Load() > routerPolled+int32(cfg.BatchSize) }, time.Second, 5*time.Millisecond)
+
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestStopPollingIdleDeviceTypes(t *testing.T) {
+	mockRepo := mocks.NewMockIRepository(t)
+	cfg := api.PollingConfig{
+		Interval:  20 * time.Millisecond,
+		Timeout:   10 * time.Millisecond,
+		BatchSize: 1,
+		Backoff:   &api.BackoffConfig{BaseDelay: 10 * time.Millisecond, Factor: 2, MaxDelay: 100 * time.Millisecond},
+	}
+	w := &PollingWorker{
+		id:   "test-worker",
+		repo: mockRepo,
+		psy: &testPollingStrategy{configMap: map[string]api.PollingConfig{
+			repository.Router: cfg,
+			repository.Switch: cfg,
+		}},
+		interval:    10 * time.Millisecond,
+		idleTimeout: 50 * time.Millisecond,
+	}
+
+	var switches atomic.Int32
+	var routerPolls, switchPolls atomic.Int32
+	mockRepo.EXPECT().SaveWorkerHeartbeat("test-worker").Return(nil)
+	mockRepo.EXPECT().GetAllDeviceTypes().Return([]repository.DeviceType{
+		{ID: 1, Name: repository.Router, Enabled: true},
+		{ID: 2, Name: repository.Switch, Enabled: true},
+	}, nil)
+	mockRepo.EXPECT().CountDevicesByType().RunAndReturn(func() (map[string]int, error) {
+		return map[string]int{repository.Router: 1, repository.Switch: int(switches.Load())}, nil
+	})
+	mockRepo.EXPECT().GetDevicesByPollingParameter(mock.Anything).RunAndReturn(func(param repository.DevicePollingParameter) ([]repository.Device, error) {
+		if param.DeviceType == repository.Switch {
+			switchPolls.Add(1)
+		} else {
+			routerPolls.Add(1)
+		}
+		return nil, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- w.Start(ctx)
+	}()
+
+	// a device type without devices is not polled
+	assert.Eventually(t, func() bool { return routerPolls.Load() > 3 }, time.Second, 5*time.Millisecond)
+	assert.Zero(t, switchPolls.Load())
+
+	// devices of it are added
+	switches.Store(2)
+	assert.Eventually(t, func() bool { return switchPolls.Load() > 3 }, time.Second, 5*time.Millisecond)
+
+	// all of them are removed, it is still polled until the idle timeout, and then stopped
+	switches.Store(0)
+	time.Sleep(w.idleTimeout + 50*time.Millisecond)
+	stoppedAt := switchPolls.Load()
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, stoppedAt, switchPolls.Load())
+
+	// they reappear
+	routerPolled := routerPolls.Load()
+	switches.Store(1)
+	assert.Eventually(t, func() bool { return switchPolls.Load() > stoppedAt+3 }, time.Second, 5*time.Millisecond)
+	assert.Greater(t, routerPolls.Load(), routerPolled)
+
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestPollOnlyWorkerDeviceTypes(t *testing.T) {
+	mockRepo := mocks.NewMockIRepository(t)
+	cfg := api.PollingConfig{
+		Interval:  20 * time.Millisecond,
+		Timeout:   10 * time.Millisecond,
+		BatchSize: 1,
+		Backoff:   &api.BackoffConfig{BaseDelay: 10 * time.Millisecond, Factor: 2, MaxDelay: 100 * time.Millisecond},
+	}
+	w := &PollingWorker{
+		id:   "test-worker",
+		repo: mockRepo,
+		psy: &testPollingStrategy{configMap: map[string]api.PollingConfig{
+			repository.Router: cfg,
+			repository.Switch: cfg,
+			repository.Camera: cfg,
+		}},
+		interval:    10 * time.Millisecond,
+		deviceTypes: []string{repository.Router, repository.Camera},
+	}
+
+	var mu sync.Mutex
+	polled := make(map[string]int)
+	mockRepo.EXPECT().SaveWorkerHeartbeat("test-worker").Return(nil)
+	mockRepo.EXPECT().GetAllDeviceTypes().Return([]repository.DeviceType{
+		{ID: 1, Name: repository.Router, Enabled: true},
+		{ID: 2, Name: repository.Switch, Enabled: true},
+		{ID: 3, Name: repository.Camera, Enabled: true},
+	}, nil)
+	mockRepo.EXPECT().GetDevicesByPollingParameter(mock.Anything).RunAndReturn(func(param repository.DevicePollingParameter) ([]repository.Device, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		polled[param.DeviceType]++
+		return nil, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- w.Start(ctx)
+	}()
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return polled[repository.Router] > 3 && polled[repository.Camera] > 3
+	}, time.Second, 5*time.Millisecond)
+	cancel()
+	assert.NoError(t, <-done)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.NotContains(t, polled, repository.Switch)
+}
+
+// filePollingStrategy loads the polling configs of the json content with the file polling strategy
+func filePollingStrategy(t *testing.T, content string) *api.FilePollingStrategy {
+	path := filepath.Join(t.TempDir(), "polling.json")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	psy, err := api.NewFilePollingStrategy(path)
+	assert.NoError(t, err)
+	return psy
+}
+
+func TestColdTierOfPollingConfigFile(t *testing.T) {
+	mockRepo := mocks.NewMockIRepository(t)
+	w := &PollingWorker{repo: mockRepo}
+	cfg, err := filePollingStrategy(t, `{"router": {"cold_streak": 5, "cold_interval": "10m"}}`).GetPollingConfigByDeviceType(repository.Router)
+	assert.NoError(t, err)
+
+	// the devices with a long success streak are claimed on the cold interval of the file
+	mockRepo.EXPECT().GetDevicesByPollingParameter(mock.Anything).RunAndReturn(func(param repository.DevicePollingParameter) ([]repository.Device, error) {
+		assert.Equal(t, 5, param.ColdStreak)
+		assert.Equal(t, 10*time.Minute, param.ColdInterval)
+		return nil, nil
+	}).Once()
+	w.pollDevicesByType(context.Background(), repository.Router, cfg)
+}
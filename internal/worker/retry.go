@@ -1,10 +1,9 @@
 package worker
 
 import (
+	"cmp"
 	"context"
-	"math"
-	"math/rand"
-	"strings"
+	"fmt"
 	"time"
 
 	"example.poc/device-monitoring-system/internal/api"
@@ -12,14 +11,23 @@ import (
 	"example.poc/device-monitoring-system/internal/util"
 	"github.com/rs/zerolog"
 	"github.com/samber/lo"
+	"google.golang.org/grpc/status"
 )
 
 type RetryWrapperMonitor struct {
-	failCount int
-	monitor   api.IDeviceMonitor
-	repo      repository.IRepository
-	timeout   time.Duration
-	backoff   api.BackoffConfig
+	monitor     api.IDeviceMonitor
+	repo        repository.IRepository
+	history     *bufferedHistoryWriter // optional, polling histories are saved one by one when nil
+	logs        *failureLogLimiter     // optional, failures are all logged when nil
+	slots       chan struct{}          // optional, bounds the attempts in flight at the same time across all device types
+	hook        PollResultHook         // optional
+	checksum    checksumFunc           // optional, the reported checksums are not verified when nil
+	stats       *pollingStats          // optional
+	retries     chan struct{}          // optional, bounds the devices of the device type waiting to retry at the same time
+	timeout     time.Duration
+	backoff     api.BackoffConfig
+	resetStreak int  // the total failure count of a device is reset after this many successful polls in a row, never when 0
+	infoSuccess bool // whether the routine successful polls are logged at info level, they are logged at debug level otherwise
 }
 
 type failureReason struct {
@@ -27,35 +35,103 @@ type failureReason struct {
 	Count int    `json:"count"`
 }
 
+// pollDeviceWithBackoff polls a device until it succeeds or the context is done. A sequence of attempts ends on the
+// first success, so there is no success in the middle of it: every sequence, i.e. every polling cycle, starts over
+// from the first attempt and the base delay, however many times the device failed in the previous cycles.
 func (rm *RetryWrapperMonitor) pollDeviceWithBackoff(ctx context.Context, device *repository.Device, pollReq api.PollDeviceRequest) {
+	rm.stats.begin()
+	outcome := pollCancelled
+	defer func() { rm.stats.end(outcome) }()
+
+	// a device holds a slot of the retry budget from its first retry on, until its polling is over
+	retrying := false
+	defer func() {
+		if retrying {
+			<-rm.retries
+		}
+	}()
+
 	start := time.Now()
-	delay := rm.backoff.BaseDelay
+	backoff := util.Backoff(rm.backoff)
+	delay := backoff.BaseDelay
+	failCount := 0
+	// only the first failure of a device in a row across the polling cycles is logged for sure
+	failing := failingInARow(*device)
 
 	for {
-		reqCtx, cancel := context.WithTimeout(ctx, rm.timeout)
+		// a slot is only held during an attempt, so that the devices sleeping between retries leave it to the others
+		if !rm.acquireSlot(ctx) {
+			rm.cancelPolling(ctx, device)
+			return
+		}
+		reqCtx, cancel := rm.attemptContext(ctx)
+		attemptStart := time.Now()
 		resp, err := rm.monitor.PollDevice(reqCtx, pollReq)
+		latency := lo.ToPtr(int(time.Since(attemptStart).Milliseconds()))
 		cancel()
+		rm.releaseSlot()
+		// a buggy monitor answering with neither a response nor an error fails the attempt, so that it is backed off
+		if err == nil && resp == nil {
+			err = fmt.Errorf("%w: response from device monitor is nil", api.ErrInvalidResponse)
+		}
+
+		var mismatch error
+		if err == nil {
+			mismatch = rm.verifyChecksum(ctx, *device, resp.Checksum)
+		}
 
 		device.LastCheckedAt = lo.ToPtr(time.Now())
 		var history *repository.PollingHistory
 		if err != nil {
-			zerolog.Ctx(ctx).Err(err).Msgf("failed to poll device data on attempt %d", rm.failCount+1)
+			device.SuccessStreak = 0
+			device.TotalFailureCount++
+			rm.stats.failedAttempt()
+			rm.logs.onFailure(ctx, failCount == 0 && !failing).Err(err).Msgf("failed to poll device data on attempt %d", failCount+1)
 			reason := failureReason{
 				Error: err.Error(),
-				Count: rm.failCount + 1,
+				Count: failCount + 1,
 			}
 			reasonJSON := util.JSONMarshalIgnoreErr(reason)
 			history = &repository.PollingHistory{
 				DeviceID:      device.DeviceID,
 				PollingResult: repository.PollFailed,
 				FailureReason: lo.ToPtr(string(reasonJSON)),
+				GrpcCode:      grpcCode(err),
+				LatencyMs:     latency,
+			}
+		} else if mismatch != nil {
+			// a mismatch is not transient, so the polling is over without retrying
+			zerolog.Ctx(ctx).Warn().Err(mismatch).Msgf("polled device data on attempt %d, but its checksum is not the expected one", failCount+1)
+			outcome = pollFailed
+			device.SuccessStreak = 0
+			device.TotalFailureCount++
+			device.PollingStatus = lo.ToPtr(repository.PollingDone)
+			reasonJSON := util.JSONMarshalIgnoreErr(failureReason{
+				Error: mismatch.Error(),
+				Count: failCount + 1,
+			})
+			history = &repository.PollingHistory{
+				DeviceID:      device.DeviceID,
+				PollingResult: repository.PollFailed,
+				FailureReason: lo.ToPtr(string(reasonJSON)),
+				LatencyMs:     latency,
+			}
+		} else {
+			// the first success after failures is a recovery, which is worth an info log like the failures
+			level := zerolog.DebugLevel
+			if rm.infoSuccess || failCount > 0 || failing {
+				level = zerolog.InfoLevel
 			}
-		} else if resp != nil {
 			data := jsonizePollingResult(*resp)
-			zerolog.Ctx(ctx).Info().
+			zerolog.Ctx(ctx).WithLevel(level).
 				RawJSON("device_data", data).
 				Str("duration", time.Since(start).String()).
-				Msgf("successfully polled device data on attempt %d", rm.failCount+1)
+				Msgf("successfully polled device data on attempt %d", failCount+1)
+			outcome = pollSucceeded
+			device.SuccessStreak++
+			if rm.resetStreak > 0 && device.SuccessStreak >= rm.resetStreak {
+				device.TotalFailureCount = 0
+			}
 			device.PollingStatus = lo.ToPtr(repository.PollingDone)
 			history = &repository.PollingHistory{
 				DeviceID:       device.DeviceID,
@@ -65,58 +141,111 @@ func (rm *RetryWrapperMonitor) pollDeviceWithBackoff(ctx context.Context, device
 				DeviceStatus:   &resp.Status,
 				DeviceChecksum: &resp.Checksum,
 				PollingResult:  repository.PollSucceed,
+				LatencyMs:      latency,
 			}
-		} else {
-			zerolog.Ctx(ctx).Error().Msg("inconsistency state: response from device monitor is nil, will abort polling")
 		}
 
-		if cErr := rm.repo.CreatePollingHistory(history); cErr != nil {
+		if rm.history != nil {
+			rm.history.add(ctx, history)
+		} else if cErr := rm.repo.CreatePollingHistory(history); cErr != nil {
 			zerolog.Ctx(ctx).Err(cErr).Msg("db error: failed to save device polling result")
 		}
 
 		if uErr := rm.repo.UpdateDevice(device); uErr != nil {
 			zerolog.Ctx(ctx).Err(uErr).Msg("db error: failed to update device database record")
 		}
+		rm.hook.notify(ctx, *device, resp, cmp.Or(err, mismatch))
 
 		if err == nil {
 			break
 		}
 
-		// backoff time with jitter, got idea from https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
-		rm.failCount++
-		if delay < rm.backoff.MaxDelay {
-			n := float64(delay) * rm.backoff.Factor
-			n = math.Min(n, float64(rm.backoff.MaxDelay))
-			delay = time.Duration(n)
-		} else {
-			delay = rm.backoff.MaxDelay
+		failCount++
+		if !retrying && rm.retries != nil {
+			select {
+			case rm.retries <- struct{}{}:
+				retrying = true
+			default:
+				outcome = pollFailed
+				rm.deferPolling(ctx, device)
+				return
+			}
 		}
-
-		sleep := time.Duration(rand.Int63n(int64(delay)))
+		delay = backoff.Next(delay)
+		sleep := util.Jitter(delay)
 		select {
 		case <-time.After(sleep):
-			zerolog.Ctx(ctx).Info().Int("retry_count", rm.failCount).Msgf("retry polling device %s after sleeping %s", device.DeviceID, sleep.String())
+			rm.logs.logger(ctx, failCount == 1 && !failing).Info().Int("retry_count", failCount).Msgf("retry polling device %s after sleeping %s", device.DeviceID, sleep.String())
 			continue
 
 		case <-ctx.Done():
-			zerolog.Ctx(ctx).Info().Msgf("stop polling device %s, context cancelled", device.DeviceID)
-			// Update device's polling status to cancelled
-			device.PollingStatus = lo.ToPtr(repository.PollingCancelled)
-			if uErr := rm.repo.UpdateDevice(device); uErr != nil {
-				zerolog.Ctx(ctx).Err(uErr).Msg("db error: failed to update device polling status to 'cancelled'")
-			}
+			rm.cancelPolling(ctx, device)
 			return
 		}
 	}
 }
 
+// acquireSlot waits for a slot of the attempts in flight, false when the context is done first
+func (rm *RetryWrapperMonitor) acquireSlot(ctx context.Context) bool {
+	if rm.slots == nil {
+		return true
+	}
+	select {
+	case rm.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (rm *RetryWrapperMonitor) releaseSlot() {
+	if rm.slots != nil {
+		<-rm.slots
+	}
+}
+
+func (rm *RetryWrapperMonitor) cancelPolling(ctx context.Context, device *repository.Device) {
+	zerolog.Ctx(ctx).Info().Msgf("stop polling device %s, context cancelled", device.DeviceID)
+	// Update device's polling status to cancelled
+	device.PollingStatus = lo.ToPtr(repository.PollingCancelled)
+	if uErr := rm.repo.UpdateDevice(device); uErr != nil {
+		zerolog.Ctx(ctx).Err(uErr).Msg("db error: failed to update device polling status to 'cancelled'")
+	}
+}
+
+// deferPolling puts off the retries of a device to a later polling cycle when the retry budget of its device type is
+// used up
+func (rm *RetryWrapperMonitor) deferPolling(ctx context.Context, device *repository.Device) {
+	zerolog.Ctx(ctx).Info().Msgf("retry budget used up, defer polling device %s to a later cycle", device.DeviceID)
+	device.PollingStatus = lo.ToPtr(repository.PollingDeferred)
+	if uErr := rm.repo.UpdateDevice(device); uErr != nil {
+		zerolog.Ctx(ctx).Err(uErr).Msg("db error: failed to update device polling status to 'deferred'")
+	}
+}
+
+// attemptContext bounds a single polling attempt. The timeout of the polling config takes precedence when set,
+// otherwise the attempt is bound by the deadline of the caller's context, and in absence of it, by the default timeout
+// of the device monitor.
+func (rm *RetryWrapperMonitor) attemptContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if rm.timeout > 0 {
+		return context.WithTimeout(ctx, rm.timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// grpcCode returns the status code of an error of a grpc call, nil for any other error
+func grpcCode(err error) *int {
+	s, ok := status.FromError(err)
+	if !ok {
+		return nil
+	}
+	return lo.ToPtr(int(s.Code()))
+}
+
 func jsonizePollingResult(resp api.PollDeviceResponse) []byte {
 	copy := resp
 	// Mask the device checksum for security reasons
-	if len(copy.Checksum) > 2 {
-		blur := strings.Repeat("*", len(copy.Checksum)-2)
-		copy.Checksum = copy.Checksum[:1] + blur + copy.Checksum[len(copy.Checksum)-1:]
-	}
+	copy.Checksum = util.MaskSecret(copy.Checksum)
 
 	return util.JSONMarshalIgnoreErr(copy)
 }
@@ -2,20 +2,34 @@ package worker
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"example.poc/device-monitoring-system/internal/api"
 	"example.poc/device-monitoring-system/internal/repository"
+	"example.poc/device-monitoring-system/internal/util"
+	"example.poc/device-monitoring-system/proto"
 	"example.poc/device-monitoring-system/test/helper"
 	"example.poc/device-monitoring-system/test/mocks"
 	"github.com/lib/pq"
+	"github.com/rs/zerolog"
 	"github.com/samber/lo"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 	"gorm.io/gorm"
 )
 
@@ -219,6 +233,286 @@ func (s *retryWrapperMonitorTestSuite) TestContextCancelled() {
 	s.Equal(repository.PollingCancelled, *device.PollingStatus)
 }
 
+func (s *retryWrapperMonitorTestSuite) TestPollingTimeoutIsBindingConstraint() {
+	timeout := 100 * time.Millisecond
+	testDto := randTestDeviceDto("running", "type-1", "localhost")
+
+	var count atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if count.Add(1) == 1 {
+			// much slower than the polling timeout, yet faster than the default timeout of the monitor
+			select {
+			case <-time.After(5 * time.Second):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		util.ResponseAsJSON(w, http.StatusOK, api.RestPollDeviceResponse{
+			Id:       testDto.deviceID,
+			Type:     testDto.deviceType,
+			Hw:       testDto.hwVersion,
+			Sw:       testDto.swVersion,
+			Fw:       testDto.fwVersion,
+			Status:   testDto.status,
+			Checksum: testDto.checksum,
+		})
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(u.Port())
+	rm := &RetryWrapperMonitor{
+		monitor: api.NewRESTDeviceMonitor(),
+		repo:    s.mockRepo,
+		timeout: timeout,
+		backoff: api.BackoffConfig{
+			BaseDelay: 10 * time.Millisecond,
+			Factor:    2,
+			MaxDelay:  100 * time.Millisecond,
+		},
+	}
+	device := repository.Device{
+		ID:            1,
+		DeviceID:      testDto.deviceID,
+		DeviceType:    testDto.deviceType,
+		Hostname:      u.Hostname(),
+		RestPort:      &port,
+		PollingStatus: lo.ToPtr(repository.PollingInProgress),
+		Protocols:     pq.StringArray([]string{"rest"}),
+	}
+
+	s.mockRepo.EXPECT().CreatePollingHistory(mock.Anything).Return(nil).Run(func(history *repository.PollingHistory) {
+		s.Equal(repository.PollFailed, history.PollingResult)
+		s.Contains(*history.FailureReason, "context deadline exceeded")
+	}).Once()
+	s.mockRepo.EXPECT().CreatePollingHistory(mock.Anything).Return(nil).Run(func(history *repository.PollingHistory) {
+		s.Equal(repository.PollSucceed, history.PollingResult)
+	}).Once()
+	s.mockRepo.EXPECT().UpdateDevice(mock.Anything).Return(nil).Twice()
+
+	start := time.Now()
+	rm.pollDeviceWithBackoff(context.TODO(), &device, api.PollDeviceRequest{
+		Hostname: device.Hostname,
+		Port:     device.RestPort,
+		Path:     lo.ToPtr("/"),
+	})
+	s.Less(time.Since(start), time.Second)
+	s.Equal(int32(2), count.Load())
+}
+
+func (s *retryWrapperMonitorTestSuite) TestFailureLogsAreBounded() {
+	deviceCount := 20
+	cycles := 50
+	burst := 5
+	tl := helper.NewTestLogger()
+	ctx := tl.ZeroLogger().WithContext(context.TODO())
+
+	// the retry budget is used up, so that every polling cycle of a dead device is a single failed attempt
+	retries := make(chan struct{}, 1)
+	retries <- struct{}{}
+	rm := &RetryWrapperMonitor{
+		monitor: s.mockMonitor,
+		repo:    s.mockRepo,
+		logs:    newFailureLogLimiter(burst, time.Hour, 0),
+		retries: retries,
+		timeout: 30 * time.Second,
+	}
+	var devices []*repository.Device
+	for i := range deviceCount {
+		devices = append(devices, &repository.Device{
+			ID:            uint(i + 1),
+			DeviceID:      fmt.Sprintf("device%d", i+1),
+			DeviceType:    "type-1",
+			Hostname:      "some.faked.host",
+			PollingStatus: lo.ToPtr(repository.PollingInProgress),
+			Protocols:     pq.StringArray([]string{"rest"}),
+		})
+	}
+
+	s.mockMonitor.EXPECT().PollDevice(mock.Anything, mock.Anything).Return(nil, fmt.Errorf("fake error")).Times(deviceCount * cycles)
+	s.mockRepo.EXPECT().CreatePollingHistory(mock.Anything).Return(nil)
+	s.mockRepo.EXPECT().UpdateDevice(mock.Anything).Return(nil)
+
+	for range cycles {
+		for _, device := range devices {
+			device.PollingStatus = lo.ToPtr(repository.PollingInProgress)
+			rm.pollDeviceWithBackoff(ctx, device, api.PollDeviceRequest{DeviceID: device.DeviceID})
+		}
+	}
+	rm.logs.summarize(ctx)
+
+	failureLines := 0
+	lines := tl.GetLogLines()
+	for _, line := range lines {
+		if strings.Contains(line, "failed to poll device data") {
+			failureLines++
+		}
+	}
+	// the first failure of every device is logged, the failures of the later cycles share the burst
+	s.Equal(deviceCount+burst, failureLines)
+	s.Contains(lines[len(lines)-1], fmt.Sprintf(`"failures":%d`, deviceCount*cycles))
+}
+
+func (s *retryWrapperMonitorTestSuite) TestPollResultHook() {
+	type pollResult struct {
+		device repository.Device
+		resp   *api.PollDeviceResponse
+		err    error
+	}
+	results := make(chan pollResult, 2)
+	rm := &RetryWrapperMonitor{
+		monitor: s.mockMonitor,
+		repo:    s.mockRepo,
+		timeout: 30 * time.Second,
+		backoff: api.BackoffConfig{
+			BaseDelay: 10 * time.Millisecond,
+			Factor:    2,
+			MaxDelay:  100 * time.Millisecond,
+		},
+		hook: func(_ context.Context, device repository.Device, resp *api.PollDeviceResponse, err error) {
+			results <- pollResult{device, resp, err}
+			panic("a panicking hook does not break polling")
+		},
+	}
+	testDto := randTestDeviceDto("running", "type-1", "some.faked.host")
+	device := repository.Device{
+		ID:            1,
+		DeviceID:      testDto.deviceID,
+		DeviceType:    testDto.deviceType,
+		Hostname:      testDto.deviceHost,
+		PollingStatus: lo.ToPtr(repository.PollingInProgress),
+		Protocols:     pq.StringArray([]string{"rest"}),
+	}
+	resp := &api.PollDeviceResponse{
+		Id:       device.DeviceID,
+		Type:     device.DeviceType,
+		Fw:       testDto.fwVersion,
+		Status:   testDto.status,
+		Checksum: testDto.checksum,
+	}
+
+	s.mockMonitor.EXPECT().PollDevice(mock.Anything, mock.Anything).Return(nil, fmt.Errorf("fake error")).Once()
+	s.mockMonitor.EXPECT().PollDevice(mock.Anything, mock.Anything).Return(resp, nil).Once()
+	s.mockRepo.EXPECT().CreatePollingHistory(mock.Anything).Return(nil)
+	s.mockRepo.EXPECT().UpdateDevice(mock.Anything).Return(nil)
+
+	rm.pollDeviceWithBackoff(context.TODO(), &device, api.PollDeviceRequest{})
+
+	// the hook runs asynchronously, the results are not necessarily received in order
+	var failure, success *pollResult
+	for range 2 {
+		select {
+		case <-time.After(time.Second):
+			s.T().Fatal("test timed out")
+		case r := <-results:
+			s.Equal(device.DeviceID, r.device.DeviceID)
+			if r.err != nil {
+				failure = &r
+			} else {
+				success = &r
+			}
+		}
+	}
+	s.Require().NotNil(failure)
+	s.Nil(failure.resp)
+	s.ErrorContains(failure.err, "fake error")
+	s.Equal(repository.PollingInProgress, *failure.device.PollingStatus)
+	s.Require().NotNil(success)
+	s.Equal(resp, success.resp)
+	s.Equal(repository.PollingDone, *success.device.PollingStatus)
+}
+
+func (s *retryWrapperMonitorTestSuite) TestChecksumVerification() {
+	testDto := randTestDeviceDto("running", "type-1", "some.faked.host")
+	expected := testDto.checksum
+	rm := &RetryWrapperMonitor{
+		monitor: s.mockMonitor,
+		repo:    s.mockRepo,
+		timeout: 30 * time.Second,
+		backoff: api.BackoffConfig{
+			BaseDelay: 10 * time.Millisecond,
+			Factor:    2,
+			MaxDelay:  100 * time.Millisecond,
+		},
+		checksum: func(device repository.Device) (string, error) {
+			s.Equal(testDto.deviceID, device.DeviceID)
+			return expected, nil
+		},
+	}
+	device := repository.Device{
+		ID:            1,
+		DeviceID:      testDto.deviceID,
+		DeviceType:    testDto.deviceType,
+		Hostname:      testDto.deviceHost,
+		PollingStatus: lo.ToPtr(repository.PollingInProgress),
+		Protocols:     pq.StringArray([]string{"rest"}),
+	}
+	s.mockMonitor.EXPECT().PollDevice(mock.Anything, mock.Anything).Return(&api.PollDeviceResponse{
+		Id:       device.DeviceID,
+		Type:     device.DeviceType,
+		Status:   testDto.status,
+		Checksum: testDto.checksum,
+	}, nil).Twice()
+
+	// matching checksum
+	s.mockRepo.EXPECT().CreatePollingHistory(mock.Anything).Return(nil).Run(func(history *repository.PollingHistory) {
+		s.Equal(repository.PollSucceed, history.PollingResult)
+		s.Equal(testDto.checksum, *history.DeviceChecksum)
+	}).Once()
+	s.mockRepo.EXPECT().UpdateDevice(mock.Anything).Return(nil).Once()
+	rm.pollDeviceWithBackoff(context.TODO(), &device, api.PollDeviceRequest{})
+
+	// mismatching checksum, the device is polled only once
+	expected = "another checksum"
+	device.PollingStatus = lo.ToPtr(repository.PollingInProgress)
+	s.mockRepo.EXPECT().CreatePollingHistory(mock.Anything).Return(nil).Run(func(history *repository.PollingHistory) {
+		s.Equal(repository.PollFailed, history.PollingResult)
+		s.Require().NotNil(history.FailureReason)
+		s.Contains(*history.FailureReason, "checksum_mismatch")
+	}).Once()
+	s.mockRepo.EXPECT().UpdateDevice(mock.Anything).Return(nil).Run(func(device *repository.Device) {
+		s.Equal(repository.PollingDone, *device.PollingStatus)
+	}).Once()
+	rm.pollDeviceWithBackoff(context.TODO(), &device, api.PollDeviceRequest{})
+}
+
+func (s *retryWrapperMonitorTestSuite) TestChecksumVerificationOfTcpDevice() {
+	rm := &RetryWrapperMonitor{
+		monitor: s.mockMonitor,
+		repo:    s.mockRepo,
+		timeout: time.Second,
+		backoff: api.BackoffConfig{
+			BaseDelay: 10 * time.Millisecond,
+			Factor:    2,
+			MaxDelay:  100 * time.Millisecond,
+		},
+		checksum: func(device repository.Device) (string, error) {
+			return "expected checksum", nil
+		},
+	}
+	device := repository.Device{
+		ID:            1,
+		DeviceID:      helper.RandomString(8),
+		Hostname:      "some.faked.host",
+		PollingStatus: lo.ToPtr(repository.PollingInProgress),
+		Protocols:     pq.StringArray([]string{repository.TCP}),
+		TcpPort:       lo.ToPtr(7000),
+	}
+
+	// a tcp device reports no checksum, which is not a mismatch
+	s.mockMonitor.EXPECT().PollDevice(mock.Anything, mock.Anything).Return(&api.PollDeviceResponse{Hw: "hw", Sw: "sw", Fw: "fw", Status: "running"}, nil).Twice()
+	s.mockRepo.EXPECT().CreatePollingHistory(mock.Anything).Return(nil).Run(func(history *repository.PollingHistory) {
+		s.Equal(repository.PollSucceed, history.PollingResult)
+		s.Nil(history.FailureReason)
+	}).Twice()
+	s.mockRepo.EXPECT().UpdateDevice(mock.Anything).Return(nil).Twice()
+	for range 2 {
+		rm.pollDeviceWithBackoff(context.TODO(), &device, api.PollDeviceRequest{Hostname: device.Hostname, Port: device.TcpPort})
+	}
+	s.Equal(2, device.SuccessStreak)
+	s.Zero(device.TotalFailureCount)
+}
+
 func randTestDeviceDto(status, deviceType, host string) testDeviceDto {
 	return testDeviceDto{
 		deviceID:   helper.RandomString(8),
@@ -236,7 +530,392 @@ func randTestDeviceDto(status, deviceType, host string) testDeviceDto {
 }
 
 func clearDB(db *gorm.DB) error {
-	s := strings.Join([]string{"device_types", "devices", "polling_history"}, ",")
+	s := strings.Join([]string{"device_types", "devices", "polling_history", "worker_heartbeats"}, ",")
 	q := fmt.Sprintf("truncate table %s restart identity cascade", s)
 	return db.Exec(q).Error
 }
+
+func (s *retryWrapperMonitorTestSuite) TestBackoffStartsOverOnEverySequence() {
+	rm := &RetryWrapperMonitor{
+		monitor: s.mockMonitor,
+		repo:    s.mockRepo,
+		timeout: time.Second,
+		backoff: api.BackoffConfig{
+			BaseDelay: time.Millisecond,
+			Factor:    2,
+			MaxDelay:  10 * time.Millisecond,
+		},
+	}
+	device := repository.Device{
+		ID:            1,
+		DeviceID:      helper.RandomString(8),
+		Hostname:      "some.faked.host",
+		PollingStatus: lo.ToPtr(repository.PollingInProgress),
+		Protocols:     pq.StringArray([]string{"rest"}),
+	}
+	resp := &api.PollDeviceResponse{Hw: "hw", Sw: "sw", Fw: "fw", Checksum: "checksum", Status: "running"}
+
+	var counts []int
+	s.mockRepo.EXPECT().CreatePollingHistory(mock.Anything).RunAndReturn(func(history *repository.PollingHistory) error {
+		count := 0
+		if history.PollingResult == repository.PollFailed {
+			var reason failureReason
+			s.NoError(json.Unmarshal([]byte(*history.FailureReason), &reason))
+			count = reason.Count
+		}
+		counts = append(counts, count)
+		return nil
+	})
+	s.mockRepo.EXPECT().UpdateDevice(mock.Anything).Return(nil)
+
+	// the device recovers after two failures in the first sequence
+	s.mockMonitor.EXPECT().PollDevice(mock.Anything, mock.Anything).Return(nil, fmt.Errorf("connection refused")).Twice()
+	s.mockMonitor.EXPECT().PollDevice(mock.Anything, mock.Anything).Return(resp, nil).Once()
+	rm.pollDeviceWithBackoff(context.TODO(), &device, api.PollDeviceRequest{Hostname: device.Hostname})
+	s.Equal([]int{1, 2, 0}, counts)
+
+	// and fails again in the next one, which starts over from the first attempt with the same monitor
+	counts = nil
+	s.mockMonitor.EXPECT().PollDevice(mock.Anything, mock.Anything).Return(nil, fmt.Errorf("connection refused")).Once()
+	s.mockMonitor.EXPECT().PollDevice(mock.Anything, mock.Anything).Return(resp, nil).Once()
+	rm.pollDeviceWithBackoff(context.TODO(), &device, api.PollDeviceRequest{Hostname: device.Hostname})
+	s.Equal([]int{1, 0}, counts)
+}
+
+func (s *retryWrapperMonitorTestSuite) TestGrpcCodeOfFailures() {
+	lis, err := net.Listen("tcp", "localhost:0")
+	s.Require().NoError(err)
+	sdms := &helper.SimpleDeviceMonitorServer{}
+	sdms.SetError(status.Error(codes.Unavailable, "device is rebooting"))
+	gs := grpc.NewServer()
+	proto.RegisterDeviceMonitorServer(gs, sdms)
+	go func() {
+		_ = gs.Serve(lis)
+	}()
+	defer gs.Stop()
+
+	rm := &RetryWrapperMonitor{
+		monitor: api.NewGrpcDeviceMonitor(grpc.WithTransportCredentials(insecure.NewCredentials())),
+		repo:    s.mockRepo,
+		timeout: time.Second,
+		backoff: api.BackoffConfig{
+			BaseDelay: 10 * time.Millisecond,
+			Factor:    2,
+			MaxDelay:  100 * time.Millisecond,
+		},
+	}
+	device := repository.Device{
+		ID:            1,
+		DeviceID:      helper.RandomString(8),
+		Hostname:      "localhost",
+		PollingStatus: lo.ToPtr(repository.PollingInProgress),
+		Protocols:     pq.StringArray([]string{"grpc"}),
+	}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	histories := make(chan *repository.PollingHistory, 10)
+	s.mockRepo.EXPECT().CreatePollingHistory(mock.Anything).RunAndReturn(func(history *repository.PollingHistory) error {
+		histories <- history
+		cancel()
+		return nil
+	})
+	s.mockRepo.EXPECT().UpdateDevice(mock.Anything).Return(nil)
+
+	port := lis.Addr().(*net.TCPAddr).Port
+	rm.pollDeviceWithBackoff(ctx, &device, api.PollDeviceRequest{Hostname: device.Hostname, Port: &port})
+	history := <-histories
+	s.Equal(repository.PollFailed, history.PollingResult)
+	s.Require().NotNil(history.GrpcCode)
+	s.Equal(int(codes.Unavailable), *history.GrpcCode)
+
+	// the failures of the other protocols have no grpc code
+	s.Nil(grpcCode(fmt.Errorf("connection refused")))
+}
+
+func (s *retryWrapperMonitorTestSuite) TestSuccessStreak() {
+	rm := &RetryWrapperMonitor{
+		monitor: s.mockMonitor,
+		repo:    s.mockRepo,
+		timeout: time.Second,
+		backoff: api.BackoffConfig{
+			BaseDelay: time.Millisecond,
+			Factor:    2,
+			MaxDelay:  10 * time.Millisecond,
+		},
+	}
+	device := repository.Device{
+		ID:            1,
+		DeviceID:      helper.RandomString(8),
+		Hostname:      "some.faked.host",
+		PollingStatus: lo.ToPtr(repository.PollingInProgress),
+		Protocols:     pq.StringArray([]string{"rest"}),
+	}
+	resp := &api.PollDeviceResponse{Hw: "hw", Sw: "sw", Fw: "fw", Checksum: "checksum", Status: "running"}
+	s.mockRepo.EXPECT().CreatePollingHistory(mock.Anything).Return(nil)
+	s.mockRepo.EXPECT().UpdateDevice(mock.Anything).Return(nil)
+
+	// the streak grows on every successful poll, demoting the device to the cold tier after enough of them
+	s.mockMonitor.EXPECT().PollDevice(mock.Anything, mock.Anything).Return(resp, nil).Times(3)
+	for range 3 {
+		rm.pollDeviceWithBackoff(context.TODO(), &device, api.PollDeviceRequest{Hostname: device.Hostname})
+	}
+	s.Equal(3, device.SuccessStreak)
+	cfg := api.PollingConfig{Interval: time.Second, ColdStreak: 3, ColdInterval: time.Minute}
+	s.Equal(cfg.ColdInterval, cfg.IntervalOf(device.SuccessStreak))
+
+	// and any failure promotes it back
+	s.mockMonitor.EXPECT().PollDevice(mock.Anything, mock.Anything).Return(nil, fmt.Errorf("connection refused")).Once()
+	s.mockMonitor.EXPECT().PollDevice(mock.Anything, mock.Anything).Return(resp, nil).Once()
+	rm.pollDeviceWithBackoff(context.TODO(), &device, api.PollDeviceRequest{Hostname: device.Hostname})
+	s.Equal(1, device.SuccessStreak)
+	s.Equal(cfg.Interval, cfg.IntervalOf(device.SuccessStreak))
+}
+
+func (s *retryWrapperMonitorTestSuite) TestTotalFailureCount() {
+	rm := &RetryWrapperMonitor{
+		monitor: s.mockMonitor,
+		repo:    s.mockRepo,
+		timeout: time.Second,
+		backoff: api.BackoffConfig{
+			BaseDelay: time.Millisecond,
+			Factor:    2,
+			MaxDelay:  10 * time.Millisecond,
+		},
+		resetStreak: 3,
+	}
+	device := repository.Device{
+		ID:            1,
+		DeviceID:      helper.RandomString(8),
+		Hostname:      "some.faked.host",
+		PollingStatus: lo.ToPtr(repository.PollingInProgress),
+		Protocols:     pq.StringArray([]string{"rest"}),
+	}
+	resp := &api.PollDeviceResponse{Hw: "hw", Sw: "sw", Fw: "fw", Checksum: "checksum", Status: "running"}
+	s.mockRepo.EXPECT().CreatePollingHistory(mock.Anything).Return(nil)
+	s.mockRepo.EXPECT().UpdateDevice(mock.Anything).Return(nil)
+
+	// the failures accumulate across the polling cycles, unlike the count in the failure reason
+	for range 2 {
+		s.mockMonitor.EXPECT().PollDevice(mock.Anything, mock.Anything).Return(nil, fmt.Errorf("connection refused")).Twice()
+		s.mockMonitor.EXPECT().PollDevice(mock.Anything, mock.Anything).Return(resp, nil).Once()
+		rm.pollDeviceWithBackoff(context.TODO(), &device, api.PollDeviceRequest{Hostname: device.Hostname})
+	}
+	s.Equal(4, device.TotalFailureCount)
+
+	// a success which is not sustained yet keeps the count
+	s.mockMonitor.EXPECT().PollDevice(mock.Anything, mock.Anything).Return(resp, nil).Once()
+	rm.pollDeviceWithBackoff(context.TODO(), &device, api.PollDeviceRequest{Hostname: device.Hostname})
+	s.Equal(2, device.SuccessStreak)
+	s.Equal(4, device.TotalFailureCount)
+
+	s.mockMonitor.EXPECT().PollDevice(mock.Anything, mock.Anything).Return(resp, nil).Once()
+	rm.pollDeviceWithBackoff(context.TODO(), &device, api.PollDeviceRequest{Hostname: device.Hostname})
+	s.Equal(0, device.TotalFailureCount)
+}
+
+func (s *retryWrapperMonitorTestSuite) TestLatencyOfAttempts() {
+	rm := &RetryWrapperMonitor{
+		monitor: s.mockMonitor,
+		repo:    s.mockRepo,
+		timeout: time.Second,
+		backoff: api.BackoffConfig{
+			BaseDelay: time.Millisecond,
+			Factor:    2,
+			MaxDelay:  10 * time.Millisecond,
+		},
+	}
+	device := repository.Device{
+		ID:            1,
+		DeviceID:      helper.RandomString(8),
+		Hostname:      "some.faked.host",
+		PollingStatus: lo.ToPtr(repository.PollingInProgress),
+		Protocols:     pq.StringArray([]string{"rest"}),
+	}
+	resp := &api.PollDeviceResponse{Hw: "hw", Sw: "sw", Fw: "fw", Checksum: "checksum", Status: "running"}
+	var histories []*repository.PollingHistory
+	s.mockRepo.EXPECT().CreatePollingHistory(mock.Anything).RunAndReturn(func(h *repository.PollingHistory) error {
+		histories = append(histories, h)
+		return nil
+	})
+	s.mockRepo.EXPECT().UpdateDevice(mock.Anything).Return(nil)
+	s.mockMonitor.EXPECT().PollDevice(mock.Anything, mock.Anything).Return(nil, fmt.Errorf("connection refused")).Once()
+	s.mockMonitor.EXPECT().PollDevice(mock.Anything, mock.Anything).RunAndReturn(func(context.Context, api.PollDeviceRequest) (*api.PollDeviceResponse, error) {
+		time.Sleep(30 * time.Millisecond)
+		return resp, nil
+	}).Once()
+
+	rm.pollDeviceWithBackoff(context.TODO(), &device, api.PollDeviceRequest{Hostname: device.Hostname})
+	s.Require().Len(histories, 2)
+	for _, h := range histories {
+		s.NotNil(h.LatencyMs)
+	}
+	s.GreaterOrEqual(*histories[1].LatencyMs, 30)
+}
+
+func (s *retryWrapperMonitorTestSuite) TestSlotReleasedBetweenRetries() {
+	rm := &RetryWrapperMonitor{
+		monitor: s.mockMonitor,
+		repo:    s.mockRepo,
+		slots:   make(chan struct{}, 1),
+		timeout: time.Second,
+		backoff: api.BackoffConfig{
+			BaseDelay: 50 * time.Millisecond,
+			Factor:    2,
+			MaxDelay:  200 * time.Millisecond,
+		},
+	}
+	failing := repository.Device{ID: 1, DeviceID: helper.RandomString(8), Hostname: "failing.host"}
+	healthy := repository.Device{ID: 2, DeviceID: helper.RandomString(8), Hostname: "healthy.host"}
+	s.mockRepo.EXPECT().CreatePollingHistory(mock.Anything).Return(nil)
+	s.mockRepo.EXPECT().UpdateDevice(mock.Anything).Return(nil)
+	var attempts atomic.Int32
+	s.mockMonitor.EXPECT().PollDevice(mock.Anything, mock.MatchedBy(func(req api.PollDeviceRequest) bool {
+		return req.Hostname == failing.Hostname
+	})).RunAndReturn(func(context.Context, api.PollDeviceRequest) (*api.PollDeviceResponse, error) {
+		attempts.Add(1)
+		return nil, fmt.Errorf("connection refused")
+	})
+	s.mockMonitor.EXPECT().PollDevice(mock.Anything, mock.MatchedBy(func(req api.PollDeviceRequest) bool {
+		return req.Hostname == healthy.Hostname
+	})).Return(&api.PollDeviceResponse{Hw: "hw", Sw: "sw", Fw: "fw", Checksum: "checksum", Status: "running"}, nil).Once()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	retried := make(chan struct{})
+	go func() {
+		rm.pollDeviceWithBackoff(ctx, &failing, api.PollDeviceRequest{Hostname: failing.Hostname})
+		close(retried)
+	}()
+	s.Eventually(func() bool { return attempts.Load() > 0 }, time.Second, time.Millisecond)
+
+	// the only slot is not held by the failing device while it sleeps before a retry
+	polled := make(chan struct{})
+	go func() {
+		rm.pollDeviceWithBackoff(context.Background(), &healthy, api.PollDeviceRequest{Hostname: healthy.Hostname})
+		close(polled)
+	}()
+	select {
+	case <-polled:
+	case <-time.After(2 * time.Second):
+		s.Fail("the healthy device is not polled while the failing device retries")
+	}
+	s.Equal(repository.PollingDone, lo.FromPtr(healthy.PollingStatus))
+
+	cancel()
+	<-retried
+	s.Empty(rm.slots)
+}
+
+func (s *retryWrapperMonitorTestSuite) TestNilResponseWithoutError() {
+	rm := &RetryWrapperMonitor{
+		monitor: s.mockMonitor,
+		repo:    s.mockRepo,
+		timeout: time.Second,
+		backoff: api.BackoffConfig{
+			BaseDelay: 10 * time.Millisecond,
+			Factor:    2,
+			MaxDelay:  20 * time.Millisecond,
+		},
+	}
+	device := repository.Device{
+		ID:            1,
+		DeviceID:      helper.RandomString(8),
+		Hostname:      "some.faked.host",
+		PollingStatus: lo.ToPtr(repository.PollingInProgress),
+		Protocols:     pq.StringArray([]string{"rest"}),
+	}
+	var histories []*repository.PollingHistory
+	s.mockRepo.EXPECT().CreatePollingHistory(mock.Anything).RunAndReturn(func(h *repository.PollingHistory) error {
+		s.Require().NotNil(h)
+		histories = append(histories, h)
+		return nil
+	})
+	s.mockRepo.EXPECT().UpdateDevice(mock.Anything).Return(nil)
+	s.mockMonitor.EXPECT().PollDevice(mock.Anything, mock.Anything).Return(nil, nil).Twice()
+	s.mockMonitor.EXPECT().PollDevice(mock.Anything, mock.Anything).Return(&api.PollDeviceResponse{Hw: "hw", Sw: "sw", Fw: "fw", Checksum: "checksum", Status: "running"}, nil).Once()
+
+	rm.pollDeviceWithBackoff(context.TODO(), &device, api.PollDeviceRequest{Hostname: device.Hostname})
+	s.Require().Len(histories, 3)
+	for _, h := range histories[:2] {
+		s.Equal(repository.PollFailed, h.PollingResult)
+		s.Contains(*h.FailureReason, api.ErrInvalidResponse.Error())
+	}
+	s.Equal(repository.PollSucceed, histories[2].PollingResult)
+	s.Equal(2, device.TotalFailureCount)
+
+	// the monitor is not polled again in a tight loop, but after the backoff delay
+	rm.backoff = api.BackoffConfig{BaseDelay: time.Hour, Factor: 2, MaxDelay: 2 * time.Hour}
+	s.mockMonitor.EXPECT().PollDevice(mock.Anything, mock.Anything).Return(nil, nil).Once()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	rm.pollDeviceWithBackoff(ctx, &device, api.PollDeviceRequest{Hostname: device.Hostname})
+	s.Len(histories, 4)
+	s.Equal(repository.PollingCancelled, *device.PollingStatus)
+}
+
+func (s *retryWrapperMonitorTestSuite) TestSuccessLogLevel() {
+	level := zerolog.GlobalLevel()
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	defer zerolog.SetGlobalLevel(level)
+
+	tl := helper.NewTestLogger()
+	ctx := tl.ZeroLogger().WithContext(context.TODO())
+	rm := &RetryWrapperMonitor{
+		monitor: s.mockMonitor,
+		repo:    s.mockRepo,
+		timeout: time.Second,
+		backoff: api.BackoffConfig{
+			BaseDelay: time.Millisecond,
+			Factor:    2,
+			MaxDelay:  10 * time.Millisecond,
+		},
+	}
+	device := repository.Device{
+		ID:            1,
+		DeviceID:      helper.RandomString(8),
+		Hostname:      "some.faked.host",
+		PollingStatus: lo.ToPtr(repository.PollingInProgress),
+		Protocols:     pq.StringArray([]string{"rest"}),
+	}
+	resp := &api.PollDeviceResponse{Hw: "hw", Sw: "sw", Fw: "fw", Checksum: "checksum", Status: "running"}
+	s.mockRepo.EXPECT().CreatePollingHistory(mock.Anything).Return(nil)
+	s.mockRepo.EXPECT().UpdateDevice(mock.Anything).Return(nil)
+	successLevel := func() string {
+		var level string
+		for _, line := range tl.GetLogLines() {
+			if strings.Contains(line, "successfully polled device data") {
+				s.Empty(level, "one success line per poll")
+				var entry struct {
+					Level string `json:"level"`
+				}
+				s.Require().NoError(json.Unmarshal([]byte(line), &entry))
+				level = entry.Level
+			}
+		}
+		return level
+	}
+
+	// a routine success is logged at debug level
+	s.mockMonitor.EXPECT().PollDevice(mock.Anything, mock.Anything).Return(resp, nil).Once()
+	rm.pollDeviceWithBackoff(ctx, &device, api.PollDeviceRequest{Hostname: device.Hostname})
+	s.Equal("debug", successLevel())
+
+	// a recovery within the polling cycle is logged at info level
+	s.mockMonitor.EXPECT().PollDevice(mock.Anything, mock.Anything).Return(nil, fmt.Errorf("connection refused")).Once()
+	s.mockMonitor.EXPECT().PollDevice(mock.Anything, mock.Anything).Return(resp, nil).Once()
+	rm.pollDeviceWithBackoff(ctx, &device, api.PollDeviceRequest{Hostname: device.Hostname})
+	s.Equal("info", successLevel())
+
+	// and so is a recovery in a later polling cycle
+	device.SuccessStreak = 0
+	s.mockMonitor.EXPECT().PollDevice(mock.Anything, mock.Anything).Return(resp, nil).Twice()
+	rm.pollDeviceWithBackoff(ctx, &device, api.PollDeviceRequest{Hostname: device.Hostname})
+	s.Equal("info", successLevel())
+	rm.pollDeviceWithBackoff(ctx, &device, api.PollDeviceRequest{Hostname: device.Hostname})
+	s.Equal("debug", successLevel())
+
+	rm.infoSuccess = true
+	s.mockMonitor.EXPECT().PollDevice(mock.Anything, mock.Anything).Return(resp, nil).Once()
+	rm.pollDeviceWithBackoff(ctx, &device, api.PollDeviceRequest{Hostname: device.Hostname})
+	s.Equal("info", successLevel())
+}
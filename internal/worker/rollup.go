@@ -0,0 +1,77 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"example.poc/device-monitoring-system/internal/repository"
+	"github.com/rs/zerolog"
+)
+
+// historyRollup periodically summarizes the polling history older than a threshold into hourly rollups,
+// the polling history summarized is deleted in the same transaction. The rollups older than the retention are
+// removed in turn, when it is set.
+type historyRollup struct {
+	repo      repository.IRepository
+	after     time.Duration
+	retention time.Duration
+	interval  time.Duration
+}
+
+func newHistoryRollup(repo repository.IRepository, after, retention, interval time.Duration) *historyRollup {
+	return &historyRollup{
+		repo:      repo,
+		after:     after,
+		retention: retention,
+		interval:  interval,
+	}
+}
+
+// rollup summarizes the polling history of the hours ended before the threshold
+func (hr *historyRollup) rollup(ctx context.Context) {
+	before := time.Now().Add(-hr.after).Truncate(time.Hour)
+	rolled, err := hr.repo.RollupPollingHistory(before)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Time("before", before).Msg("db error: failed to roll up polling history")
+		return
+	}
+	if rolled > 0 {
+		zerolog.Ctx(ctx).Info().Time("before", before).Int64("rolled", rolled).Msg("polling history rolled up")
+	}
+}
+
+// expire removes the rollups of the hours started before the retention
+func (hr *historyRollup) expire(ctx context.Context) {
+	if hr.retention <= 0 {
+		return
+	}
+
+	expired := time.Now().Add(-hr.retention).Truncate(time.Hour)
+	removed, err := hr.repo.DeletePollingHistoryRollupsBefore(expired)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Time("before", expired).Msg("db error: failed to remove expired polling history rollups")
+		return
+	}
+	if removed > 0 {
+		zerolog.Ctx(ctx).Info().Time("before", expired).Int64("removed", removed).Msg("expired polling history rollups removed")
+	}
+}
+
+// run rolls up the polling history and removes the expired rollups once and then at every interval until the context
+// is cancelled
+func (hr *historyRollup) run(ctx context.Context) {
+	ticker := time.NewTicker(hr.interval)
+	defer ticker.Stop()
+
+	hr.rollup(ctx)
+	hr.expire(ctx)
+	for {
+		select {
+		case <-ticker.C:
+			hr.rollup(ctx)
+			hr.expire(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"example.poc/device-monitoring-system/test/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestHistoryRollup(t *testing.T) {
+	mockRepo := mocks.NewMockIRepository(t)
+	hr := newHistoryRollup(mockRepo, 24*time.Hour, 0, 10*time.Millisecond)
+
+	var calls atomic.Int32
+	mockRepo.EXPECT().RollupPollingHistory(mock.Anything).RunAndReturn(func(before time.Time) (int64, error) {
+		// only the hours ended before the threshold are rolled up
+		assert.True(t, before.Equal(before.Truncate(time.Hour)))
+		assert.True(t, before.Before(time.Now().Add(-24*time.Hour)))
+		assert.True(t, before.After(time.Now().Add(-25*time.Hour)))
+		if calls.Add(1) == 2 {
+			return 0, fmt.Errorf("db error")
+		}
+		return 3, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		hr.run(ctx)
+		close(done)
+	}()
+
+	// an error does not stop the rollup
+	assert.Eventually(t, func() bool {
+		return calls.Load() > 3
+	}, time.Second, 5*time.Millisecond)
+	cancel()
+	<-done
+}
+
+func TestHistoryRollupRetention(t *testing.T) {
+	mockRepo := mocks.NewMockIRepository(t)
+	hr := newHistoryRollup(mockRepo, 24*time.Hour, 30*24*time.Hour, 10*time.Millisecond)
+
+	var calls atomic.Int32
+	mockRepo.EXPECT().RollupPollingHistory(mock.Anything).Return(0, fmt.Errorf("db error"))
+	mockRepo.EXPECT().DeletePollingHistoryRollupsBefore(mock.Anything).RunAndReturn(func(before time.Time) (int64, error) {
+		// the rollups of the hours started before the retention are removed
+		assert.True(t, before.Equal(before.Truncate(time.Hour)))
+		assert.True(t, before.Before(time.Now().Add(-30*24*time.Hour)))
+		assert.True(t, before.After(time.Now().Add(-30*24*time.Hour-time.Hour)))
+		calls.Add(1)
+		return 2, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		hr.run(ctx)
+		close(done)
+	}()
+
+	// the expired rollups are removed even if rolling up fails
+	assert.Eventually(t, func() bool {
+		return calls.Load() > 1
+	}, time.Second, 5*time.Millisecond)
+	cancel()
+	<-done
+
+	// and not at all without a retention
+	removals := calls.Load()
+	hr = newHistoryRollup(mockRepo, 24*time.Hour, 0, time.Hour)
+	hr.expire(context.Background())
+	assert.Equal(t, removals, calls.Load())
+}
@@ -0,0 +1,81 @@
+package worker
+
+import (
+	"sync/atomic"
+
+	"github.com/rs/zerolog"
+)
+
+// PollingSummary sums up the polling activity of a worker since it was built
+type PollingSummary struct {
+	Polled         int64 // the polling sequences started, one per device claimed on a polling cycle
+	Succeeded      int64 // the sequences ended with the device data
+	Failed         int64 // the sequences ended without the device data, e.g. on a checksum mismatch
+	Cancelled      int64 // the sequences cancelled, e.g. on shutdown
+	InProgress     int64 // the sequences not ended yet
+	FailedAttempts int64 // the failed polling attempts across all sequences
+}
+
+func (s PollingSummary) MarshalZerologObject(e *zerolog.Event) {
+	e.Int64("devices_polled", s.Polled).
+		Int64("polls_succeeded", s.Succeeded).
+		Int64("polls_failed", s.Failed).
+		Int64("polls_cancelled", s.Cancelled).
+		Int64("polls_in_progress", s.InProgress).
+		Int64("failed_attempts", s.FailedAttempts)
+}
+
+type pollOutcome int
+
+const (
+	pollCancelled pollOutcome = iota
+	pollSucceeded
+	pollFailed
+)
+
+// pollingStats counts the polling sequences of a worker by outcome, a nil stats counts nothing
+type pollingStats struct {
+	polled, succeeded, failed, cancelled, failedAttempts atomic.Int64
+}
+
+func (s *pollingStats) begin() {
+	if s != nil {
+		s.polled.Add(1)
+	}
+}
+
+func (s *pollingStats) end(outcome pollOutcome) {
+	if s == nil {
+		return
+	}
+	switch outcome {
+	case pollSucceeded:
+		s.succeeded.Add(1)
+	case pollFailed:
+		s.failed.Add(1)
+	default:
+		s.cancelled.Add(1)
+	}
+}
+
+func (s *pollingStats) failedAttempt() {
+	if s != nil {
+		s.failedAttempts.Add(1)
+	}
+}
+
+func (s *pollingStats) summary() PollingSummary {
+	if s == nil {
+		return PollingSummary{}
+	}
+	// the outcomes are loaded before the polled count, so that a sequence ending meanwhile is not counted twice
+	sum := PollingSummary{
+		Succeeded:      s.succeeded.Load(),
+		Failed:         s.failed.Load(),
+		Cancelled:      s.cancelled.Load(),
+		FailedAttempts: s.failedAttempts.Load(),
+	}
+	sum.Polled = s.polled.Load()
+	sum.InProgress = sum.Polled - sum.Succeeded - sum.Failed - sum.Cancelled
+	return sum
+}
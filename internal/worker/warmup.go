@@ -0,0 +1,88 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"example.poc/device-monitoring-system/internal/api"
+	"example.poc/device-monitoring-system/internal/repository"
+	"github.com/rs/zerolog"
+)
+
+const (
+	grpcWarmupTimeout   = 10 * time.Second
+	grpcWarmupBatchSize = 500
+)
+
+// connector is implemented by the device monitors which can connect to a device ahead of polling it
+type connector interface {
+	Connect(ctx context.Context, req api.PollDeviceRequest) error
+}
+
+// warmupGrpc connects to the devices polled over gRPC before the first polling cycle, so that their first polls do not
+// time out establishing the connections. The warmup as a whole is bound by a timeout, the devices not connected by then
+// are connected to on their first poll as usual.
+func (w *PollingWorker) warmupGrpc(ctx context.Context) {
+	c, ok := w.grpc.(connector)
+	if w.warmup <= 0 || !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, grpcWarmupTimeout)
+	defer cancel()
+
+	condition := "'" + repository.GRPC + "' = any(protocols)"
+	params := map[string]any{}
+	if len(w.deviceTypes) > 0 {
+		condition += " and device_type in @device_types"
+		params["device_types"] = w.deviceTypes
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	var connected, failed atomic.Int32
+	slots := make(chan struct{}, w.warmup)
+	for page := 0; ctx.Err() == nil; page++ {
+		devices, _, err := w.repo.GetDevicesByPage(page, grpcWarmupBatchSize, condition, params)
+		if err != nil {
+			zerolog.Ctx(ctx).Err(err).Msg("db error: failed to get the gRPC devices to warm up")
+			break
+		}
+		for _, device := range devices {
+			inner, port, _ := w.monitorOf(ctx, device)
+			if inner != w.grpc {
+				continue
+			}
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer func() {
+					<-slots
+					wg.Done()
+				}()
+				if err := c.Connect(ctx, api.PollDeviceRequest{DeviceID: device.DeviceID, Hostname: device.Hostname, Port: port}); err != nil {
+					failed.Add(1)
+					zerolog.Ctx(ctx).Debug().Err(err).Msgf("failed to warm up the connection to device %s", device.DeviceID)
+					return
+				}
+				connected.Add(1)
+			}()
+		}
+		if len(devices) < grpcWarmupBatchSize {
+			break
+		}
+	}
+	wg.Wait()
+
+	zerolog.Ctx(ctx).Info().
+		Int32("connected", connected.Load()).
+		Int32("failed", failed.Load()).
+		Str("duration", time.Since(start).String()).
+		Msg("warmed up the connections to the gRPC devices")
+}
@@ -0,0 +1,79 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"example.poc/device-monitoring-system/internal/api"
+	"example.poc/device-monitoring-system/internal/repository"
+	"example.poc/device-monitoring-system/test/mocks"
+	"github.com/lib/pq"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type fakeConnector struct {
+	*mocks.MockIDeviceMonitor
+	lock      sync.Mutex
+	connected map[string]*int
+	current   atomic.Int32
+	peak      atomic.Int32
+}
+
+func (c *fakeConnector) Connect(_ context.Context, req api.PollDeviceRequest) error {
+	n := c.current.Add(1)
+	defer c.current.Add(-1)
+	for {
+		p := c.peak.Load()
+		if n <= p || c.peak.CompareAndSwap(p, n) {
+			break
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.connected[req.DeviceID] = req.Port
+	return nil
+}
+
+func TestWarmupGrpc(t *testing.T) {
+	mockRepo := mocks.NewMockIRepository(t)
+	grpc := &fakeConnector{MockIDeviceMonitor: mocks.NewMockIDeviceMonitor(t), connected: make(map[string]*int)}
+	w := &PollingWorker{
+		repo:        mockRepo,
+		rest:        mocks.NewMockIDeviceMonitor(t),
+		grpc:        grpc,
+		deviceTypes: []string{repository.Router},
+	}
+
+	// no warmup by default
+	w.warmupGrpc(context.Background())
+
+	devices := []repository.Device{
+		{DeviceID: "grpc1", DeviceType: repository.Router, Hostname: "host1", Protocols: pq.StringArray{repository.GRPC}, GrpcPort: lo.ToPtr(50051)},
+		{DeviceID: "grpc2", DeviceType: repository.Router, Hostname: "host2", Protocols: pq.StringArray{repository.GRPC}, GrpcPort: lo.ToPtr(50052)},
+		{DeviceID: "grpc3", DeviceType: repository.Router, Hostname: "host3", Protocols: pq.StringArray{repository.GRPC}, Capabilities: repository.Capabilities{{Protocol: repository.GRPC, Port: lo.ToPtr(6000)}}},
+		// polled over rest, its first protocol
+		{DeviceID: "rest1", DeviceType: repository.Router, Hostname: "host4", Protocols: pq.StringArray{repository.REST, repository.GRPC}, GrpcPort: lo.ToPtr(50051)},
+	}
+	mockRepo.EXPECT().GetDevicesByPage(0, grpcWarmupBatchSize, mock.Anything, mock.Anything).RunAndReturn(func(_, _ int, condition string, params map[string]any) ([]repository.Device, int, error) {
+		assert.Contains(t, condition, "'grpc' = any(protocols)")
+		assert.Contains(t, condition, "device_type in @device_types")
+		assert.Equal(t, []string{repository.Router}, params["device_types"])
+		return devices, len(devices), nil
+	}).Once()
+
+	w.warmup = 2
+	w.warmupGrpc(context.Background())
+	assert.Equal(t, map[string]*int{"grpc1": lo.ToPtr(50051), "grpc2": lo.ToPtr(50052), "grpc3": lo.ToPtr(6000)}, grpc.connected)
+	assert.Equal(t, int32(2), grpc.peak.Load())
+
+	// a monitor which cannot connect ahead of polling is not warmed up
+	w.grpc = mocks.NewMockIDeviceMonitor(t)
+	w.warmupGrpc(context.Background())
+}
@@ -6,8 +6,10 @@ import (
 	"math/rand"
 	"net"
 	"net/http"
-	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"example.poc/device-monitoring-system/internal/api"
@@ -24,14 +26,7 @@ import (
 	"google.golang.org/grpc/status"
 )
 
-var states = []string{"operating", "rebooting", "loading configuration", "internal error", "offline"}
-
-var deviceTypes = []string{
-	repository.Router,
-	repository.Switch,
-	repository.Camera,
-	repository.DoorAccessSystem,
-}
+var states = config.DeviceStatuses
 
 type DeviceSimulator struct {
 	r                chi.Router
@@ -41,15 +36,28 @@ type DeviceSimulator struct {
 	stateIdx         int
 	deviceID         string
 	deviceType       string
+	protocols        []string
+	operational      []string
 	hwVersion        string
 	swVersion        string
 	fwVersion        string
 	checksum         string
 	transitionPeriod time.Duration
+	grpcLock         sync.Mutex
+	grpcServer       *grpc.Server
 	proto.UnimplementedDeviceMonitorServer
 }
 
-func NewDeviceSimulator() *DeviceSimulator {
+func NewDeviceSimulator() (*DeviceSimulator, error) {
+	weights, err := parseDeviceTypeWeights(config.SimTypeWeights())
+	if err != nil {
+		return nil, fmt.Errorf("invalid SIM_TYPE_WEIGHTS: %w", err)
+	}
+	protocols, err := parseProtocols(config.SimProtocols())
+	if err != nil {
+		return nil, fmt.Errorf("invalid PROTOCOLS: %w", err)
+	}
+
 	var checksum string
 	bs, err := ExecuteExternalChecksumGenerator()
 	if err != nil {
@@ -58,13 +66,14 @@ func NewDeviceSimulator() *DeviceSimulator {
 	}
 	checksum = string(bs)
 
-	n := rand.Intn(len(deviceTypes))
 	ds := &DeviceSimulator{
 		gRpcPort:         config.GrpcPort(),
 		restPort:         config.RESTApiPort(),
 		restPath:         config.RESTApiPath(),
 		deviceID:         uuid.NewString(),
-		deviceType:       deviceTypes[n],
+		deviceType:       weights.pick(),
+		protocols:        protocols,
+		operational:      config.OperationalStatuses(),
 		hwVersion:        helper.RandomString(10),
 		swVersion:        helper.RandomString(10),
 		fwVersion:        helper.RandomString(10),
@@ -73,22 +82,90 @@ func NewDeviceSimulator() *DeviceSimulator {
 	}
 	ds.r = ds.getRouter()
 
-	return ds
+	return ds, nil
 }
 
-func (ds *DeviceSimulator) Start(ctx context.Context) error {
-	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", ds.gRpcPort))
-	if err != nil {
-		return fmt.Errorf("failed to listen to port %d: %w", ds.gRpcPort, err)
+// parseProtocols parses comma separated protocols, e.g. 'rest, grpc'
+func parseProtocols(raw string) ([]string, error) {
+	var protocols []string
+	if raw == "" {
+		return protocols, nil
 	}
 
-	gs := grpc.NewServer()
-	proto.RegisterDeviceMonitorServer(gs, ds)
-	go func() {
-		if err := gs.Serve(lis); err != nil {
-			log.Error().Err(err).Msgf("failed to serve gRPC on port: %d", ds.gRpcPort)
+	known := []string{repository.REST, repository.GRPC, repository.TCP}
+	for _, pro := range strings.Split(raw, ",") {
+		pro = strings.ToLower(strings.TrimSpace(pro))
+		if !slices.Contains(known, pro) {
+			return nil, fmt.Errorf("unknown protocol '%s', expecting one of %s", pro, strings.Join(known, ", "))
 		}
-	}()
+		if !slices.Contains(protocols, pro) {
+			protocols = append(protocols, pro)
+		}
+	}
+
+	return protocols, nil
+}
+
+// deviceTypeWeights biases the random selection of the device type of a simulator, the device types not weighted
+// are never selected, and all of them are equally likely when there is no weight at all
+type deviceTypeWeights map[string]int
+
+// parseDeviceTypeWeights parses weights in the format of 'router:5,camera:50'
+func parseDeviceTypeWeights(raw string) (deviceTypeWeights, error) {
+	weights := make(deviceTypeWeights)
+	if raw == "" {
+		return weights, nil
+	}
+
+	sum := 0
+	for _, pair := range strings.Split(raw, ",") {
+		deviceType, weight, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid weight '%s', expecting <device_type>:<weight>", pair)
+		}
+		if !slices.Contains(repository.KnownDeviceTypes, deviceType) {
+			return nil, fmt.Errorf("unknown device type '%s'", deviceType)
+		}
+		n, err := strconv.Atoi(weight)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("weight of device type '%s' must be a non-negative integer", deviceType)
+		}
+		weights[deviceType] = n
+		sum += n
+	}
+	if sum <= 0 {
+		return nil, fmt.Errorf("the sum of the weights must be greater than 0")
+	}
+
+	return weights, nil
+}
+
+func (w deviceTypeWeights) pick() string {
+	if len(w) == 0 {
+		return repository.KnownDeviceTypes[rand.Intn(len(repository.KnownDeviceTypes))]
+	}
+
+	sum := 0
+	for _, weight := range w {
+		sum += weight
+	}
+	n := rand.Intn(sum)
+	for _, deviceType := range repository.KnownDeviceTypes {
+		if n < w[deviceType] {
+			return deviceType
+		}
+		n -= w[deviceType]
+	}
+	return ""
+}
+
+func (ds *DeviceSimulator) Start(ctx context.Context) error {
+	ds.grpcLock.Lock()
+	err := ds.serveGrpc()
+	ds.grpcLock.Unlock()
+	if err != nil {
+		return err
+	}
 
 	go func() {
 		ticker := time.NewTicker(ds.transitionPeriod)
@@ -111,25 +188,59 @@ func (ds *DeviceSimulator) Start(ctx context.Context) error {
 	return nil
 }
 
+// serveGrpc starts serving gRPC on the gRPC port, which is set to the port listened to when it is 0. The caller holds
+// the gRPC lock.
+func (ds *DeviceSimulator) serveGrpc() error {
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", ds.gRpcPort))
+	if err != nil {
+		return fmt.Errorf("failed to listen to port %d: %w", ds.gRpcPort, err)
+	}
+	ds.gRpcPort = lis.Addr().(*net.TCPAddr).Port
+
+	gs := grpc.NewServer()
+	proto.RegisterDeviceMonitorServer(gs, ds)
+	ds.grpcServer = gs
+	go func() {
+		if err := gs.Serve(lis); err != nil {
+			log.Error().Err(err).Msgf("failed to serve gRPC on port: %d", ds.gRpcPort)
+		}
+	}()
+	return nil
+}
+
+// RestartGrpcServer drops the gRPC server along with its connections and serves gRPC again on the same port, like a
+// rebooting device, so that the recovery of the monitor from lost connections can be tested
+func (ds *DeviceSimulator) RestartGrpcServer() error {
+	ds.grpcLock.Lock()
+	defer ds.grpcLock.Unlock()
+
+	if ds.grpcServer != nil {
+		ds.grpcServer.Stop()
+	}
+	log.Info().Msgf("restarting gRPC server on port: %d", ds.gRpcPort)
+	return ds.serveGrpc()
+}
+
 func (ds *DeviceSimulator) GetDeviceData(ctx context.Context, req *proto.DeviceDataRequest) (*proto.DeviceDataResponse, error) {
-	switch states[ds.stateIdx] {
-	case "operating", "rebooting", "loading configuration":
+	state := states[ds.stateIdx]
+	if slices.Contains(ds.operational, state) {
 		return &proto.DeviceDataResponse{
 			DeviceId:        &ds.deviceID,
 			DeviceType:      &ds.deviceType,
 			HardwareVersion: &ds.hwVersion,
 			SoftwareVersion: &ds.swVersion,
 			FirmwareVersion: &ds.fwVersion,
-			Status:          &states[ds.stateIdx],
+			Status:          &state,
 			Checksum:        &ds.checksum,
 		}, nil
-	case "internal error":
-		return nil, status.Error(codes.Internal, "simulated internal error")
+	}
+
+	switch state {
 	case "offline":
 		time.Sleep(60 * time.Second)
 		return nil, status.Error(codes.Unavailable, "simulated timeout error")
 	default:
-		return nil, status.Error(codes.Unknown, "unknown internal state")
+		return nil, status.Errorf(codes.Internal, "simulated %s", state)
 	}
 }
 
@@ -140,27 +251,30 @@ func (ds *DeviceSimulator) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 func (ds *DeviceSimulator) getRouter() chi.Router {
 	r := chi.NewRouter()
 	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
-		protos := os.Getenv("PROTOCOLS")
-		if protos == "" {
+		if len(ds.protocols) == 0 {
 			http.Error(w, "no protocol capabilities configured", http.StatusInternalServerError)
 			return
 		}
 
-		caps := make([]api.PollingCapability, 0)
-		parts := strings.SplitSeq(protos, ",")
-		for pro := range parts {
-			if strings.EqualFold(pro, "grpc") {
+		caps := make([]api.PollingCapability, 0, len(ds.protocols))
+		for _, pro := range ds.protocols {
+			switch pro {
+			case repository.GRPC:
 				caps = append(caps, api.PollingCapability{
-					Protocol: "grpc",
+					Protocol: repository.GRPC,
 					Port:     &ds.gRpcPort,
 				})
-			}
-			if strings.EqualFold(pro, "rest") {
+			case repository.REST:
 				caps = append(caps, api.PollingCapability{
-					Protocol: "rest",
+					Protocol: repository.REST,
 					Port:     &ds.restPort,
 					Path:     &ds.restPath,
 				})
+			case repository.TCP:
+				caps = append(caps, api.PollingCapability{
+					Protocol: repository.TCP,
+					Port:     &ds.restPort,
+				})
 			}
 		}
 
@@ -172,26 +286,36 @@ func (ds *DeviceSimulator) getRouter() chi.Router {
 		util.ResponseAsJSON(w, http.StatusOK, resp)
 	})
 
+	r.Post("/control/restart-grpc", func(w http.ResponseWriter, r *http.Request) {
+		if err := ds.RestartGrpcServer(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
 	r.Get(ds.restPath, func(w http.ResponseWriter, r *http.Request) {
-		switch states[ds.stateIdx] {
-		case "operating", "rebooting", "loading configuration":
+		state := states[ds.stateIdx]
+		if slices.Contains(ds.operational, state) {
 			resp := api.RestPollDeviceResponse{
 				Id:       ds.deviceID,
 				Type:     ds.deviceType,
 				Hw:       ds.hwVersion,
 				Sw:       ds.swVersion,
 				Fw:       ds.fwVersion,
-				Status:   states[ds.stateIdx],
+				Status:   state,
 				Checksum: ds.checksum,
 			}
 			util.ResponseAsJSON(w, http.StatusOK, resp)
-		case "internal error":
-			http.Error(w, "simulated internal error", http.StatusInternalServerError)
+			return
+		}
+
+		switch state {
 		case "offline":
 			time.Sleep(60 * time.Second)
 			http.Error(w, "simulated timeout error", http.StatusServiceUnavailable)
 		default:
-			http.Error(w, "unknown internal state", http.StatusNotFound)
+			http.Error(w, fmt.Sprintf("simulated %s", state), http.StatusInternalServerError)
 		}
 	})
 
@@ -0,0 +1,129 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"example.poc/device-monitoring-system/internal/api"
+	"example.poc/device-monitoring-system/internal/repository"
+	"example.poc/device-monitoring-system/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestParseDeviceTypeWeights(t *testing.T) {
+	weights, err := parseDeviceTypeWeights("")
+	assert.NoError(t, err)
+	assert.Empty(t, weights)
+
+	weights, err = parseDeviceTypeWeights("router:5, camera:50,switch:0")
+	assert.NoError(t, err)
+	assert.Equal(t, deviceTypeWeights{repository.Router: 5, repository.Camera: 50, repository.Switch: 0}, weights)
+
+	for _, raw := range []string{"router", "router:-1", "router:x", "printer:1", "router:0,camera:0"} {
+		_, err = parseDeviceTypeWeights(raw)
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestWeightedDeviceTypeSelection(t *testing.T) {
+	t.Setenv("SIM_TYPE_WEIGHTS", "router:5,camera:50,switch:10,door_access_system:0")
+
+	total := 2000
+	counts := make(map[string]int)
+	for range total {
+		ds, err := NewDeviceSimulator()
+		assert.NoError(t, err)
+		counts[ds.deviceType]++
+	}
+
+	assert.Zero(t, counts[repository.DoorAccessSystem])
+	assert.InDelta(t, 5.0/65, float64(counts[repository.Router])/float64(total), 0.03)
+	assert.InDelta(t, 50.0/65, float64(counts[repository.Camera])/float64(total), 0.03)
+	assert.InDelta(t, 10.0/65, float64(counts[repository.Switch])/float64(total), 0.03)
+
+	t.Setenv("SIM_TYPE_WEIGHTS", "router:-1")
+	_, err := NewDeviceSimulator()
+	assert.Error(t, err)
+}
+
+func TestParseProtocols(t *testing.T) {
+	protocols, err := parseProtocols(" rest , GRPC,rest")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{repository.REST, repository.GRPC}, protocols)
+
+	t.Setenv("PROTOCOLS", "rest,grpc,http")
+	_, err = NewDeviceSimulator()
+	assert.ErrorContains(t, err, "unknown protocol 'http'")
+
+	t.Setenv("PROTOCOLS", "grpc, rest")
+	ds, err := NewDeviceSimulator()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{repository.GRPC, repository.REST}, ds.protocols)
+}
+
+func TestOperationalStatuses(t *testing.T) {
+	t.Setenv("OPERATIONAL_STATUSES", "operating, internal error")
+	ds, err := NewDeviceSimulator()
+	assert.NoError(t, err)
+
+	poll := func(state string) (grpcErr error, restCode int) {
+		ds.stateIdx = slices.Index(states, state)
+		_, grpcErr = ds.GetDeviceData(context.Background(), &proto.DeviceDataRequest{})
+
+		w := httptest.NewRecorder()
+		ds.ServeHTTP(w, httptest.NewRequest(http.MethodGet, ds.restPath, nil))
+		return grpcErr, w.Code
+	}
+
+	for _, state := range []string{"operating", "internal error"} {
+		grpcErr, restCode := poll(state)
+		assert.NoError(t, grpcErr, state)
+		assert.Equal(t, http.StatusOK, restCode, state)
+	}
+	for _, state := range []string{"rebooting", "loading configuration"} {
+		grpcErr, restCode := poll(state)
+		assert.Error(t, grpcErr, state)
+		assert.Equal(t, http.StatusInternalServerError, restCode, state)
+	}
+}
+
+func TestRestartGrpcServer(t *testing.T) {
+	ds, err := NewDeviceSimulator()
+	require.NoError(t, err)
+	ds.stateIdx = slices.Index(states, "operating")
+	ds.checksum = "checksum"
+	ds.gRpcPort = 0
+	ds.grpcLock.Lock()
+	err = ds.serveGrpc()
+	ds.grpcLock.Unlock()
+	require.NoError(t, err)
+	t.Cleanup(func() { ds.grpcServer.Stop() })
+
+	monitor := api.NewGrpcDeviceMonitor(grpc.WithTransportCredentials(insecure.NewCredentials()))
+	poll := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		resp, err := monitor.PollDevice(ctx, api.PollDeviceRequest{Hostname: "localhost", Port: &ds.gRpcPort})
+		if err == nil {
+			assert.Equal(t, ds.deviceID, resp.Id)
+		}
+		return err
+	}
+	require.NoError(t, poll())
+
+	port := ds.gRpcPort
+	w := httptest.NewRecorder()
+	ds.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/control/restart-grpc", nil))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, port, ds.gRpcPort)
+
+	// the cached client of the monitor reconnects to the restarted server
+	assert.Eventually(t, func() bool { return poll() == nil }, 10*time.Second, 100*time.Millisecond)
+}
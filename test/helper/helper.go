@@ -10,6 +10,7 @@ import (
 	"io"
 	"math/big"
 	"net"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -25,10 +26,12 @@ type SimpleDeviceMonitorServer struct {
 	err   error
 	resp  *proto.DeviceDataResponse
 	delay time.Duration
+	last  atomic.Pointer[proto.DeviceDataRequest]
 	proto.UnimplementedDeviceMonitorServer
 }
 
-func (s *SimpleDeviceMonitorServer) GetDeviceData(context.Context, *proto.DeviceDataRequest) (*proto.DeviceDataResponse, error) {
+func (s *SimpleDeviceMonitorServer) GetDeviceData(_ context.Context, req *proto.DeviceDataRequest) (*proto.DeviceDataResponse, error) {
+	s.last.Store(req)
 	if s.delay > 0 {
 		time.Sleep(s.delay)
 	}
@@ -38,6 +41,11 @@ func (s *SimpleDeviceMonitorServer) GetDeviceData(context.Context, *proto.Device
 	return s.resp, nil
 }
 
+// LastRequest returns the latest request the server received, nil if none
+func (s *SimpleDeviceMonitorServer) LastRequest() *proto.DeviceDataRequest {
+	return s.last.Load()
+}
+
 func (s *SimpleDeviceMonitorServer) SetPort(port int) {
 	s.port = port
 }
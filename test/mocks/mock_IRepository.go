@@ -3,7 +3,10 @@
 package mocks
 
 import (
+	time "time"
+
 	repository "example.poc/device-monitoring-system/internal/repository"
+
 	mock "github.com/stretchr/testify/mock"
 )
 
@@ -20,6 +23,121 @@ func (_m *MockIRepository) EXPECT() *MockIRepository_Expecter {
 	return &MockIRepository_Expecter{mock: &_m.Mock}
 }
 
+// CountDevicesByType provides a mock function with no fields
+func (_m *MockIRepository) CountDevicesByType() (map[string]int, error) {
+	ret := _m.Called()
+
+	if len(ret) == 0 {
+		panic("no return value specified for CountDevicesByType")
+	}
+
+	var r0 map[string]int
+	var r1 error
+	if rf, ok := ret.Get(0).(func() (map[string]int, error)); ok {
+		return rf()
+	}
+	if rf, ok := ret.Get(0).(func() map[string]int); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(map[string]int)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MockIRepository_CountDevicesByType_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'CountDevicesByType'
+type MockIRepository_CountDevicesByType_Call struct {
+	*mock.Call
+}
+
+// CountDevicesByType is a helper method to define mock.On call
+func (_e *MockIRepository_Expecter) CountDevicesByType() *MockIRepository_CountDevicesByType_Call {
+	return &MockIRepository_CountDevicesByType_Call{Call: _e.mock.On("CountDevicesByType")}
+}
+
+func (_c *MockIRepository_CountDevicesByType_Call) Run(run func()) *MockIRepository_CountDevicesByType_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run()
+	})
+	return _c
+}
+
+func (_c *MockIRepository_CountDevicesByType_Call) Return(_a0 map[string]int, _a1 error) *MockIRepository_CountDevicesByType_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *MockIRepository_CountDevicesByType_Call) RunAndReturn(run func() (map[string]int, error)) *MockIRepository_CountDevicesByType_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// CountPollingHistory provides a mock function with given fields: deviceID, from, to
+func (_m *MockIRepository) CountPollingHistory(deviceID string, from *time.Time, to *time.Time) (repository.PollingCounts, error) {
+	ret := _m.Called(deviceID, from, to)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CountPollingHistory")
+	}
+
+	var r0 repository.PollingCounts
+	var r1 error
+	if rf, ok := ret.Get(0).(func(string, *time.Time, *time.Time) (repository.PollingCounts, error)); ok {
+		return rf(deviceID, from, to)
+	}
+	if rf, ok := ret.Get(0).(func(string, *time.Time, *time.Time) repository.PollingCounts); ok {
+		r0 = rf(deviceID, from, to)
+	} else {
+		r0 = ret.Get(0).(repository.PollingCounts)
+	}
+
+	if rf, ok := ret.Get(1).(func(string, *time.Time, *time.Time) error); ok {
+		r1 = rf(deviceID, from, to)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MockIRepository_CountPollingHistory_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'CountPollingHistory'
+type MockIRepository_CountPollingHistory_Call struct {
+	*mock.Call
+}
+
+// CountPollingHistory is a helper method to define mock.On call
+//   - deviceID string
+//   - from *time.Time
+//   - to *time.Time
+func (_e *MockIRepository_Expecter) CountPollingHistory(deviceID interface{}, from interface{}, to interface{}) *MockIRepository_CountPollingHistory_Call {
+	return &MockIRepository_CountPollingHistory_Call{Call: _e.mock.On("CountPollingHistory", deviceID, from, to)}
+}
+
+func (_c *MockIRepository_CountPollingHistory_Call) Run(run func(deviceID string, from *time.Time, to *time.Time)) *MockIRepository_CountPollingHistory_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(string), args[1].(*time.Time), args[2].(*time.Time))
+	})
+	return _c
+}
+
+func (_c *MockIRepository_CountPollingHistory_Call) Return(_a0 repository.PollingCounts, _a1 error) *MockIRepository_CountPollingHistory_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *MockIRepository_CountPollingHistory_Call) RunAndReturn(run func(string, *time.Time, *time.Time) (repository.PollingCounts, error)) *MockIRepository_CountPollingHistory_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // CreateDevice provides a mock function with given fields: device
 func (_m *MockIRepository) CreateDevice(device *repository.Device) error {
 	ret := _m.Called(device)
@@ -250,6 +368,175 @@ func (_c *MockIRepository_CreatePollingHistory_Call) RunAndReturn(run func(*repo
 	return _c
 }
 
+// DeleteDeviceHistory provides a mock function with given fields: deviceID, before
+func (_m *MockIRepository) DeleteDeviceHistory(deviceID string, before *time.Time) (int64, error) {
+	ret := _m.Called(deviceID, before)
+
+	if len(ret) == 0 {
+		panic("no return value specified for DeleteDeviceHistory")
+	}
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(string, *time.Time) (int64, error)); ok {
+		return rf(deviceID, before)
+	}
+	if rf, ok := ret.Get(0).(func(string, *time.Time) int64); ok {
+		r0 = rf(deviceID, before)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(string, *time.Time) error); ok {
+		r1 = rf(deviceID, before)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MockIRepository_DeleteDeviceHistory_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'DeleteDeviceHistory'
+type MockIRepository_DeleteDeviceHistory_Call struct {
+	*mock.Call
+}
+
+// DeleteDeviceHistory is a helper method to define mock.On call
+//   - deviceID string
+//   - before *time.Time
+func (_e *MockIRepository_Expecter) DeleteDeviceHistory(deviceID interface{}, before interface{}) *MockIRepository_DeleteDeviceHistory_Call {
+	return &MockIRepository_DeleteDeviceHistory_Call{Call: _e.mock.On("DeleteDeviceHistory", deviceID, before)}
+}
+
+func (_c *MockIRepository_DeleteDeviceHistory_Call) Run(run func(deviceID string, before *time.Time)) *MockIRepository_DeleteDeviceHistory_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(string), args[1].(*time.Time))
+	})
+	return _c
+}
+
+func (_c *MockIRepository_DeleteDeviceHistory_Call) Return(_a0 int64, _a1 error) *MockIRepository_DeleteDeviceHistory_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *MockIRepository_DeleteDeviceHistory_Call) RunAndReturn(run func(string, *time.Time) (int64, error)) *MockIRepository_DeleteDeviceHistory_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// DeletePollingHistoryBefore provides a mock function with given fields: before
+func (_m *MockIRepository) DeletePollingHistoryBefore(before time.Time) (int64, error) {
+	ret := _m.Called(before)
+
+	if len(ret) == 0 {
+		panic("no return value specified for DeletePollingHistoryBefore")
+	}
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(time.Time) (int64, error)); ok {
+		return rf(before)
+	}
+	if rf, ok := ret.Get(0).(func(time.Time) int64); ok {
+		r0 = rf(before)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(time.Time) error); ok {
+		r1 = rf(before)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MockIRepository_DeletePollingHistoryBefore_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'DeletePollingHistoryBefore'
+type MockIRepository_DeletePollingHistoryBefore_Call struct {
+	*mock.Call
+}
+
+// DeletePollingHistoryBefore is a helper method to define mock.On call
+//   - before time.Time
+func (_e *MockIRepository_Expecter) DeletePollingHistoryBefore(before interface{}) *MockIRepository_DeletePollingHistoryBefore_Call {
+	return &MockIRepository_DeletePollingHistoryBefore_Call{Call: _e.mock.On("DeletePollingHistoryBefore", before)}
+}
+
+func (_c *MockIRepository_DeletePollingHistoryBefore_Call) Run(run func(before time.Time)) *MockIRepository_DeletePollingHistoryBefore_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(time.Time))
+	})
+	return _c
+}
+
+func (_c *MockIRepository_DeletePollingHistoryBefore_Call) Return(_a0 int64, _a1 error) *MockIRepository_DeletePollingHistoryBefore_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *MockIRepository_DeletePollingHistoryBefore_Call) RunAndReturn(run func(time.Time) (int64, error)) *MockIRepository_DeletePollingHistoryBefore_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// DeletePollingHistoryRollupsBefore provides a mock function with given fields: before
+func (_m *MockIRepository) DeletePollingHistoryRollupsBefore(before time.Time) (int64, error) {
+	ret := _m.Called(before)
+
+	if len(ret) == 0 {
+		panic("no return value specified for DeletePollingHistoryRollupsBefore")
+	}
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(time.Time) (int64, error)); ok {
+		return rf(before)
+	}
+	if rf, ok := ret.Get(0).(func(time.Time) int64); ok {
+		r0 = rf(before)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(time.Time) error); ok {
+		r1 = rf(before)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MockIRepository_DeletePollingHistoryRollupsBefore_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'DeletePollingHistoryRollupsBefore'
+type MockIRepository_DeletePollingHistoryRollupsBefore_Call struct {
+	*mock.Call
+}
+
+// DeletePollingHistoryRollupsBefore is a helper method to define mock.On call
+//   - before time.Time
+func (_e *MockIRepository_Expecter) DeletePollingHistoryRollupsBefore(before interface{}) *MockIRepository_DeletePollingHistoryRollupsBefore_Call {
+	return &MockIRepository_DeletePollingHistoryRollupsBefore_Call{Call: _e.mock.On("DeletePollingHistoryRollupsBefore", before)}
+}
+
+func (_c *MockIRepository_DeletePollingHistoryRollupsBefore_Call) Run(run func(before time.Time)) *MockIRepository_DeletePollingHistoryRollupsBefore_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(time.Time))
+	})
+	return _c
+}
+
+func (_c *MockIRepository_DeletePollingHistoryRollupsBefore_Call) Return(_a0 int64, _a1 error) *MockIRepository_DeletePollingHistoryRollupsBefore_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *MockIRepository_DeletePollingHistoryRollupsBefore_Call) RunAndReturn(run func(time.Time) (int64, error)) *MockIRepository_DeletePollingHistoryRollupsBefore_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // GetAllDeviceTypes provides a mock function with no fields
 func (_m *MockIRepository) GetAllDeviceTypes() ([]repository.DeviceType, error) {
 	ret := _m.Called()
@@ -365,6 +652,62 @@ func (_c *MockIRepository_GetDeviceByID_Call) RunAndReturn(run func(string) (*re
 	return _c
 }
 
+// GetDeviceFreshness provides a mock function with given fields: param
+func (_m *MockIRepository) GetDeviceFreshness(param repository.DeviceFreshnessParameter) (repository.DeviceFreshness, error) {
+	ret := _m.Called(param)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetDeviceFreshness")
+	}
+
+	var r0 repository.DeviceFreshness
+	var r1 error
+	if rf, ok := ret.Get(0).(func(repository.DeviceFreshnessParameter) (repository.DeviceFreshness, error)); ok {
+		return rf(param)
+	}
+	if rf, ok := ret.Get(0).(func(repository.DeviceFreshnessParameter) repository.DeviceFreshness); ok {
+		r0 = rf(param)
+	} else {
+		r0 = ret.Get(0).(repository.DeviceFreshness)
+	}
+
+	if rf, ok := ret.Get(1).(func(repository.DeviceFreshnessParameter) error); ok {
+		r1 = rf(param)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MockIRepository_GetDeviceFreshness_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'GetDeviceFreshness'
+type MockIRepository_GetDeviceFreshness_Call struct {
+	*mock.Call
+}
+
+// GetDeviceFreshness is a helper method to define mock.On call
+//   - param repository.DeviceFreshnessParameter
+func (_e *MockIRepository_Expecter) GetDeviceFreshness(param interface{}) *MockIRepository_GetDeviceFreshness_Call {
+	return &MockIRepository_GetDeviceFreshness_Call{Call: _e.mock.On("GetDeviceFreshness", param)}
+}
+
+func (_c *MockIRepository_GetDeviceFreshness_Call) Run(run func(param repository.DeviceFreshnessParameter)) *MockIRepository_GetDeviceFreshness_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(repository.DeviceFreshnessParameter))
+	})
+	return _c
+}
+
+func (_c *MockIRepository_GetDeviceFreshness_Call) Return(_a0 repository.DeviceFreshness, _a1 error) *MockIRepository_GetDeviceFreshness_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *MockIRepository_GetDeviceFreshness_Call) RunAndReturn(run func(repository.DeviceFreshnessParameter) (repository.DeviceFreshness, error)) *MockIRepository_GetDeviceFreshness_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // GetDevicePollingHistory provides a mock function with given fields: deviceID, limit
 func (_m *MockIRepository) GetDevicePollingHistory(deviceID string, limit int) ([]repository.PollingHistory, error) {
 	ret := _m.Called(deviceID, limit)
@@ -482,9 +825,9 @@ func (_c *MockIRepository_GetDeviceTypeByName_Call) RunAndReturn(run func(string
 	return _c
 }
 
-// GetDevicesByPage provides a mock function with given fields: page, size, condition
-func (_m *MockIRepository) GetDevicesByPage(page int, size int, condition string) ([]repository.Device, int, error) {
-	ret := _m.Called(page, size, condition)
+// GetDevicesByPage provides a mock function with given fields: page, size, condition, params
+func (_m *MockIRepository) GetDevicesByPage(page int, size int, condition string, params map[string]any) ([]repository.Device, int, error) {
+	ret := _m.Called(page, size, condition, params)
 
 	if len(ret) == 0 {
 		panic("no return value specified for GetDevicesByPage")
@@ -493,25 +836,25 @@ func (_m *MockIRepository) GetDevicesByPage(page int, size int, condition string
 	var r0 []repository.Device
 	var r1 int
 	var r2 error
-	if rf, ok := ret.Get(0).(func(int, int, string) ([]repository.Device, int, error)); ok {
-		return rf(page, size, condition)
+	if rf, ok := ret.Get(0).(func(int, int, string, map[string]any) ([]repository.Device, int, error)); ok {
+		return rf(page, size, condition, params)
 	}
-	if rf, ok := ret.Get(0).(func(int, int, string) []repository.Device); ok {
-		r0 = rf(page, size, condition)
+	if rf, ok := ret.Get(0).(func(int, int, string, map[string]any) []repository.Device); ok {
+		r0 = rf(page, size, condition, params)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]repository.Device)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(int, int, string) int); ok {
-		r1 = rf(page, size, condition)
+	if rf, ok := ret.Get(1).(func(int, int, string, map[string]any) int); ok {
+		r1 = rf(page, size, condition, params)
 	} else {
 		r1 = ret.Get(1).(int)
 	}
 
-	if rf, ok := ret.Get(2).(func(int, int, string) error); ok {
-		r2 = rf(page, size, condition)
+	if rf, ok := ret.Get(2).(func(int, int, string, map[string]any) error); ok {
+		r2 = rf(page, size, condition, params)
 	} else {
 		r2 = ret.Error(2)
 	}
@@ -528,13 +871,14 @@ type MockIRepository_GetDevicesByPage_Call struct {
 //   - page int
 //   - size int
 //   - condition string
-func (_e *MockIRepository_Expecter) GetDevicesByPage(page interface{}, size interface{}, condition interface{}) *MockIRepository_GetDevicesByPage_Call {
-	return &MockIRepository_GetDevicesByPage_Call{Call: _e.mock.On("GetDevicesByPage", page, size, condition)}
+//   - params map[string]any
+func (_e *MockIRepository_Expecter) GetDevicesByPage(page interface{}, size interface{}, condition interface{}, params interface{}) *MockIRepository_GetDevicesByPage_Call {
+	return &MockIRepository_GetDevicesByPage_Call{Call: _e.mock.On("GetDevicesByPage", page, size, condition, params)}
 }
 
-func (_c *MockIRepository_GetDevicesByPage_Call) Run(run func(page int, size int, condition string)) *MockIRepository_GetDevicesByPage_Call {
+func (_c *MockIRepository_GetDevicesByPage_Call) Run(run func(page int, size int, condition string, params map[string]any)) *MockIRepository_GetDevicesByPage_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(int), args[1].(int), args[2].(string))
+		run(args[0].(int), args[1].(int), args[2].(string), args[3].(map[string]any))
 	})
 	return _c
 }
@@ -544,7 +888,7 @@ func (_c *MockIRepository_GetDevicesByPage_Call) Return(_a0 []repository.Device,
 	return _c
 }
 
-func (_c *MockIRepository_GetDevicesByPage_Call) RunAndReturn(run func(int, int, string) ([]repository.Device, int, error)) *MockIRepository_GetDevicesByPage_Call {
+func (_c *MockIRepository_GetDevicesByPage_Call) RunAndReturn(run func(int, int, string, map[string]any) ([]repository.Device, int, error)) *MockIRepository_GetDevicesByPage_Call {
 	_c.Call.Return(run)
 	return _c
 }
@@ -607,65 +951,359 @@ func (_c *MockIRepository_GetDevicesByPollingParameter_Call) RunAndReturn(run fu
 	return _c
 }
 
-// RestoreDevice provides a mock function with given fields: _a0
-func (_m *MockIRepository) RestoreDevice(_a0 uint) error {
-	ret := _m.Called(_a0)
+// GetFlappingDevices provides a mock function with given fields: since, minTransitions
+func (_m *MockIRepository) GetFlappingDevices(since time.Time, minTransitions int) ([]repository.FlappingDevice, error) {
+	ret := _m.Called(since, minTransitions)
 
 	if len(ret) == 0 {
-		panic("no return value specified for RestoreDevice")
+		panic("no return value specified for GetFlappingDevices")
 	}
 
-	var r0 error
-	if rf, ok := ret.Get(0).(func(uint) error); ok {
-		r0 = rf(_a0)
+	var r0 []repository.FlappingDevice
+	var r1 error
+	if rf, ok := ret.Get(0).(func(time.Time, int) ([]repository.FlappingDevice, error)); ok {
+		return rf(since, minTransitions)
+	}
+	if rf, ok := ret.Get(0).(func(time.Time, int) []repository.FlappingDevice); ok {
+		r0 = rf(since, minTransitions)
 	} else {
-		r0 = ret.Error(0)
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]repository.FlappingDevice)
+		}
 	}
 
-	return r0
+	if rf, ok := ret.Get(1).(func(time.Time, int) error); ok {
+		r1 = rf(since, minTransitions)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
 }
 
-// MockIRepository_RestoreDevice_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'RestoreDevice'
-type MockIRepository_RestoreDevice_Call struct {
+// MockIRepository_GetFlappingDevices_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'GetFlappingDevices'
+type MockIRepository_GetFlappingDevices_Call struct {
 	*mock.Call
 }
 
-// RestoreDevice is a helper method to define mock.On call
-//   - _a0 uint
-func (_e *MockIRepository_Expecter) RestoreDevice(_a0 interface{}) *MockIRepository_RestoreDevice_Call {
-	return &MockIRepository_RestoreDevice_Call{Call: _e.mock.On("RestoreDevice", _a0)}
+// GetFlappingDevices is a helper method to define mock.On call
+//   - since time.Time
+//   - minTransitions int
+func (_e *MockIRepository_Expecter) GetFlappingDevices(since interface{}, minTransitions interface{}) *MockIRepository_GetFlappingDevices_Call {
+	return &MockIRepository_GetFlappingDevices_Call{Call: _e.mock.On("GetFlappingDevices", since, minTransitions)}
 }
 
-func (_c *MockIRepository_RestoreDevice_Call) Run(run func(_a0 uint)) *MockIRepository_RestoreDevice_Call {
+func (_c *MockIRepository_GetFlappingDevices_Call) Run(run func(since time.Time, minTransitions int)) *MockIRepository_GetFlappingDevices_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(uint))
+		run(args[0].(time.Time), args[1].(int))
 	})
 	return _c
 }
 
-func (_c *MockIRepository_RestoreDevice_Call) Return(_a0 error) *MockIRepository_RestoreDevice_Call {
-	_c.Call.Return(_a0)
+func (_c *MockIRepository_GetFlappingDevices_Call) Return(_a0 []repository.FlappingDevice, _a1 error) *MockIRepository_GetFlappingDevices_Call {
+	_c.Call.Return(_a0, _a1)
 	return _c
 }
 
-func (_c *MockIRepository_RestoreDevice_Call) RunAndReturn(run func(uint) error) *MockIRepository_RestoreDevice_Call {
+func (_c *MockIRepository_GetFlappingDevices_Call) RunAndReturn(run func(time.Time, int) ([]repository.FlappingDevice, error)) *MockIRepository_GetFlappingDevices_Call {
 	_c.Call.Return(run)
 	return _c
 }
 
-// RestoreDeviceType provides a mock function with given fields: _a0
-func (_m *MockIRepository) RestoreDeviceType(_a0 uint) error {
-	ret := _m.Called(_a0)
+// GetLatestWorkerHeartbeat provides a mock function with no fields
+func (_m *MockIRepository) GetLatestWorkerHeartbeat() (*repository.WorkerHeartbeat, error) {
+	ret := _m.Called()
 
 	if len(ret) == 0 {
-		panic("no return value specified for RestoreDeviceType")
+		panic("no return value specified for GetLatestWorkerHeartbeat")
 	}
 
-	var r0 error
-	if rf, ok := ret.Get(0).(func(uint) error); ok {
-		r0 = rf(_a0)
-	} else {
-		r0 = ret.Error(0)
+	var r0 *repository.WorkerHeartbeat
+	var r1 error
+	if rf, ok := ret.Get(0).(func() (*repository.WorkerHeartbeat, error)); ok {
+		return rf()
+	}
+	if rf, ok := ret.Get(0).(func() *repository.WorkerHeartbeat); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*repository.WorkerHeartbeat)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MockIRepository_GetLatestWorkerHeartbeat_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'GetLatestWorkerHeartbeat'
+type MockIRepository_GetLatestWorkerHeartbeat_Call struct {
+	*mock.Call
+}
+
+// GetLatestWorkerHeartbeat is a helper method to define mock.On call
+func (_e *MockIRepository_Expecter) GetLatestWorkerHeartbeat() *MockIRepository_GetLatestWorkerHeartbeat_Call {
+	return &MockIRepository_GetLatestWorkerHeartbeat_Call{Call: _e.mock.On("GetLatestWorkerHeartbeat")}
+}
+
+func (_c *MockIRepository_GetLatestWorkerHeartbeat_Call) Run(run func()) *MockIRepository_GetLatestWorkerHeartbeat_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run()
+	})
+	return _c
+}
+
+func (_c *MockIRepository_GetLatestWorkerHeartbeat_Call) Return(_a0 *repository.WorkerHeartbeat, _a1 error) *MockIRepository_GetLatestWorkerHeartbeat_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *MockIRepository_GetLatestWorkerHeartbeat_Call) RunAndReturn(run func() (*repository.WorkerHeartbeat, error)) *MockIRepository_GetLatestWorkerHeartbeat_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// GetPollingHistoryRollups provides a mock function with given fields: deviceID, from, to
+func (_m *MockIRepository) GetPollingHistoryRollups(deviceID string, from *time.Time, to *time.Time) ([]repository.PollingHistoryRollup, error) {
+	ret := _m.Called(deviceID, from, to)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetPollingHistoryRollups")
+	}
+
+	var r0 []repository.PollingHistoryRollup
+	var r1 error
+	if rf, ok := ret.Get(0).(func(string, *time.Time, *time.Time) ([]repository.PollingHistoryRollup, error)); ok {
+		return rf(deviceID, from, to)
+	}
+	if rf, ok := ret.Get(0).(func(string, *time.Time, *time.Time) []repository.PollingHistoryRollup); ok {
+		r0 = rf(deviceID, from, to)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]repository.PollingHistoryRollup)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(string, *time.Time, *time.Time) error); ok {
+		r1 = rf(deviceID, from, to)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MockIRepository_GetPollingHistoryRollups_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'GetPollingHistoryRollups'
+type MockIRepository_GetPollingHistoryRollups_Call struct {
+	*mock.Call
+}
+
+// GetPollingHistoryRollups is a helper method to define mock.On call
+//   - deviceID string
+//   - from *time.Time
+//   - to *time.Time
+func (_e *MockIRepository_Expecter) GetPollingHistoryRollups(deviceID interface{}, from interface{}, to interface{}) *MockIRepository_GetPollingHistoryRollups_Call {
+	return &MockIRepository_GetPollingHistoryRollups_Call{Call: _e.mock.On("GetPollingHistoryRollups", deviceID, from, to)}
+}
+
+func (_c *MockIRepository_GetPollingHistoryRollups_Call) Run(run func(deviceID string, from *time.Time, to *time.Time)) *MockIRepository_GetPollingHistoryRollups_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(string), args[1].(*time.Time), args[2].(*time.Time))
+	})
+	return _c
+}
+
+func (_c *MockIRepository_GetPollingHistoryRollups_Call) Return(_a0 []repository.PollingHistoryRollup, _a1 error) *MockIRepository_GetPollingHistoryRollups_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *MockIRepository_GetPollingHistoryRollups_Call) RunAndReturn(run func(string, *time.Time, *time.Time) ([]repository.PollingHistoryRollup, error)) *MockIRepository_GetPollingHistoryRollups_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// GetSucceededPollingHistory provides a mock function with given fields: deviceID, from, to
+func (_m *MockIRepository) GetSucceededPollingHistory(deviceID string, from *time.Time, to *time.Time) ([]repository.PollingHistory, error) {
+	ret := _m.Called(deviceID, from, to)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetSucceededPollingHistory")
+	}
+
+	var r0 []repository.PollingHistory
+	var r1 error
+	if rf, ok := ret.Get(0).(func(string, *time.Time, *time.Time) ([]repository.PollingHistory, error)); ok {
+		return rf(deviceID, from, to)
+	}
+	if rf, ok := ret.Get(0).(func(string, *time.Time, *time.Time) []repository.PollingHistory); ok {
+		r0 = rf(deviceID, from, to)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]repository.PollingHistory)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(string, *time.Time, *time.Time) error); ok {
+		r1 = rf(deviceID, from, to)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MockIRepository_GetSucceededPollingHistory_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'GetSucceededPollingHistory'
+type MockIRepository_GetSucceededPollingHistory_Call struct {
+	*mock.Call
+}
+
+// GetSucceededPollingHistory is a helper method to define mock.On call
+//   - deviceID string
+//   - from *time.Time
+//   - to *time.Time
+func (_e *MockIRepository_Expecter) GetSucceededPollingHistory(deviceID interface{}, from interface{}, to interface{}) *MockIRepository_GetSucceededPollingHistory_Call {
+	return &MockIRepository_GetSucceededPollingHistory_Call{Call: _e.mock.On("GetSucceededPollingHistory", deviceID, from, to)}
+}
+
+func (_c *MockIRepository_GetSucceededPollingHistory_Call) Run(run func(deviceID string, from *time.Time, to *time.Time)) *MockIRepository_GetSucceededPollingHistory_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(string), args[1].(*time.Time), args[2].(*time.Time))
+	})
+	return _c
+}
+
+func (_c *MockIRepository_GetSucceededPollingHistory_Call) Return(_a0 []repository.PollingHistory, _a1 error) *MockIRepository_GetSucceededPollingHistory_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *MockIRepository_GetSucceededPollingHistory_Call) RunAndReturn(run func(string, *time.Time, *time.Time) ([]repository.PollingHistory, error)) *MockIRepository_GetSucceededPollingHistory_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// ResetDevicesPolling provides a mock function with given fields: deviceType, checkpoint, limit
+func (_m *MockIRepository) ResetDevicesPolling(deviceType string, checkpoint time.Time, limit int) (int64, error) {
+	ret := _m.Called(deviceType, checkpoint, limit)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ResetDevicesPolling")
+	}
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(string, time.Time, int) (int64, error)); ok {
+		return rf(deviceType, checkpoint, limit)
+	}
+	if rf, ok := ret.Get(0).(func(string, time.Time, int) int64); ok {
+		r0 = rf(deviceType, checkpoint, limit)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(string, time.Time, int) error); ok {
+		r1 = rf(deviceType, checkpoint, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MockIRepository_ResetDevicesPolling_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'ResetDevicesPolling'
+type MockIRepository_ResetDevicesPolling_Call struct {
+	*mock.Call
+}
+
+// ResetDevicesPolling is a helper method to define mock.On call
+//   - deviceType string
+//   - checkpoint time.Time
+//   - limit int
+func (_e *MockIRepository_Expecter) ResetDevicesPolling(deviceType interface{}, checkpoint interface{}, limit interface{}) *MockIRepository_ResetDevicesPolling_Call {
+	return &MockIRepository_ResetDevicesPolling_Call{Call: _e.mock.On("ResetDevicesPolling", deviceType, checkpoint, limit)}
+}
+
+func (_c *MockIRepository_ResetDevicesPolling_Call) Run(run func(deviceType string, checkpoint time.Time, limit int)) *MockIRepository_ResetDevicesPolling_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(string), args[1].(time.Time), args[2].(int))
+	})
+	return _c
+}
+
+func (_c *MockIRepository_ResetDevicesPolling_Call) Return(_a0 int64, _a1 error) *MockIRepository_ResetDevicesPolling_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *MockIRepository_ResetDevicesPolling_Call) RunAndReturn(run func(string, time.Time, int) (int64, error)) *MockIRepository_ResetDevicesPolling_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// RestoreDevice provides a mock function with given fields: _a0
+func (_m *MockIRepository) RestoreDevice(_a0 uint) error {
+	ret := _m.Called(_a0)
+
+	if len(ret) == 0 {
+		panic("no return value specified for RestoreDevice")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(uint) error); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MockIRepository_RestoreDevice_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'RestoreDevice'
+type MockIRepository_RestoreDevice_Call struct {
+	*mock.Call
+}
+
+// RestoreDevice is a helper method to define mock.On call
+//   - _a0 uint
+func (_e *MockIRepository_Expecter) RestoreDevice(_a0 interface{}) *MockIRepository_RestoreDevice_Call {
+	return &MockIRepository_RestoreDevice_Call{Call: _e.mock.On("RestoreDevice", _a0)}
+}
+
+func (_c *MockIRepository_RestoreDevice_Call) Run(run func(_a0 uint)) *MockIRepository_RestoreDevice_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(uint))
+	})
+	return _c
+}
+
+func (_c *MockIRepository_RestoreDevice_Call) Return(_a0 error) *MockIRepository_RestoreDevice_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockIRepository_RestoreDevice_Call) RunAndReturn(run func(uint) error) *MockIRepository_RestoreDevice_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// RestoreDeviceType provides a mock function with given fields: _a0
+func (_m *MockIRepository) RestoreDeviceType(_a0 uint) error {
+	ret := _m.Called(_a0)
+
+	if len(ret) == 0 {
+		panic("no return value specified for RestoreDeviceType")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(uint) error); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Error(0)
 	}
 
 	return r0
@@ -699,6 +1337,173 @@ func (_c *MockIRepository_RestoreDeviceType_Call) RunAndReturn(run func(uint) er
 	return _c
 }
 
+// RollupPollingHistory provides a mock function with given fields: before
+func (_m *MockIRepository) RollupPollingHistory(before time.Time) (int64, error) {
+	ret := _m.Called(before)
+
+	if len(ret) == 0 {
+		panic("no return value specified for RollupPollingHistory")
+	}
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(time.Time) (int64, error)); ok {
+		return rf(before)
+	}
+	if rf, ok := ret.Get(0).(func(time.Time) int64); ok {
+		r0 = rf(before)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(time.Time) error); ok {
+		r1 = rf(before)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MockIRepository_RollupPollingHistory_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'RollupPollingHistory'
+type MockIRepository_RollupPollingHistory_Call struct {
+	*mock.Call
+}
+
+// RollupPollingHistory is a helper method to define mock.On call
+//   - before time.Time
+func (_e *MockIRepository_Expecter) RollupPollingHistory(before interface{}) *MockIRepository_RollupPollingHistory_Call {
+	return &MockIRepository_RollupPollingHistory_Call{Call: _e.mock.On("RollupPollingHistory", before)}
+}
+
+func (_c *MockIRepository_RollupPollingHistory_Call) Run(run func(before time.Time)) *MockIRepository_RollupPollingHistory_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(time.Time))
+	})
+	return _c
+}
+
+func (_c *MockIRepository_RollupPollingHistory_Call) Return(_a0 int64, _a1 error) *MockIRepository_RollupPollingHistory_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *MockIRepository_RollupPollingHistory_Call) RunAndReturn(run func(time.Time) (int64, error)) *MockIRepository_RollupPollingHistory_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// SaveWorkerHeartbeat provides a mock function with given fields: workerID
+func (_m *MockIRepository) SaveWorkerHeartbeat(workerID string) error {
+	ret := _m.Called(workerID)
+
+	if len(ret) == 0 {
+		panic("no return value specified for SaveWorkerHeartbeat")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string) error); ok {
+		r0 = rf(workerID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MockIRepository_SaveWorkerHeartbeat_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'SaveWorkerHeartbeat'
+type MockIRepository_SaveWorkerHeartbeat_Call struct {
+	*mock.Call
+}
+
+// SaveWorkerHeartbeat is a helper method to define mock.On call
+//   - workerID string
+func (_e *MockIRepository_Expecter) SaveWorkerHeartbeat(workerID interface{}) *MockIRepository_SaveWorkerHeartbeat_Call {
+	return &MockIRepository_SaveWorkerHeartbeat_Call{Call: _e.mock.On("SaveWorkerHeartbeat", workerID)}
+}
+
+func (_c *MockIRepository_SaveWorkerHeartbeat_Call) Run(run func(workerID string)) *MockIRepository_SaveWorkerHeartbeat_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(string))
+	})
+	return _c
+}
+
+func (_c *MockIRepository_SaveWorkerHeartbeat_Call) Return(_a0 error) *MockIRepository_SaveWorkerHeartbeat_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockIRepository_SaveWorkerHeartbeat_Call) RunAndReturn(run func(string) error) *MockIRepository_SaveWorkerHeartbeat_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// SearchDevices provides a mock function with given fields: search
+func (_m *MockIRepository) SearchDevices(search repository.DeviceSearch) ([]repository.Device, int, error) {
+	ret := _m.Called(search)
+
+	if len(ret) == 0 {
+		panic("no return value specified for SearchDevices")
+	}
+
+	var r0 []repository.Device
+	var r1 int
+	var r2 error
+	if rf, ok := ret.Get(0).(func(repository.DeviceSearch) ([]repository.Device, int, error)); ok {
+		return rf(search)
+	}
+	if rf, ok := ret.Get(0).(func(repository.DeviceSearch) []repository.Device); ok {
+		r0 = rf(search)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]repository.Device)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(repository.DeviceSearch) int); ok {
+		r1 = rf(search)
+	} else {
+		r1 = ret.Get(1).(int)
+	}
+
+	if rf, ok := ret.Get(2).(func(repository.DeviceSearch) error); ok {
+		r2 = rf(search)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// MockIRepository_SearchDevices_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'SearchDevices'
+type MockIRepository_SearchDevices_Call struct {
+	*mock.Call
+}
+
+// SearchDevices is a helper method to define mock.On call
+//   - search repository.DeviceSearch
+func (_e *MockIRepository_Expecter) SearchDevices(search interface{}) *MockIRepository_SearchDevices_Call {
+	return &MockIRepository_SearchDevices_Call{Call: _e.mock.On("SearchDevices", search)}
+}
+
+func (_c *MockIRepository_SearchDevices_Call) Run(run func(search repository.DeviceSearch)) *MockIRepository_SearchDevices_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(repository.DeviceSearch))
+	})
+	return _c
+}
+
+func (_c *MockIRepository_SearchDevices_Call) Return(_a0 []repository.Device, _a1 int, _a2 error) *MockIRepository_SearchDevices_Call {
+	_c.Call.Return(_a0, _a1, _a2)
+	return _c
+}
+
+func (_c *MockIRepository_SearchDevices_Call) RunAndReturn(run func(repository.DeviceSearch) ([]repository.Device, int, error)) *MockIRepository_SearchDevices_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // UpdateDevice provides a mock function with given fields: device
 func (_m *MockIRepository) UpdateDevice(device *repository.Device) error {
 	ret := _m.Called(device)
@@ -745,6 +1550,288 @@ func (_c *MockIRepository_UpdateDevice_Call) RunAndReturn(run func(*repository.D
 	return _c
 }
 
+// UpdateDeviceExpectedFwVersion provides a mock function with given fields: deviceID, version
+func (_m *MockIRepository) UpdateDeviceExpectedFwVersion(deviceID string, version *string) error {
+	ret := _m.Called(deviceID, version)
+
+	if len(ret) == 0 {
+		panic("no return value specified for UpdateDeviceExpectedFwVersion")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, *string) error); ok {
+		r0 = rf(deviceID, version)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MockIRepository_UpdateDeviceExpectedFwVersion_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'UpdateDeviceExpectedFwVersion'
+type MockIRepository_UpdateDeviceExpectedFwVersion_Call struct {
+	*mock.Call
+}
+
+// UpdateDeviceExpectedFwVersion is a helper method to define mock.On call
+//   - deviceID string
+//   - version *string
+func (_e *MockIRepository_Expecter) UpdateDeviceExpectedFwVersion(deviceID interface{}, version interface{}) *MockIRepository_UpdateDeviceExpectedFwVersion_Call {
+	return &MockIRepository_UpdateDeviceExpectedFwVersion_Call{Call: _e.mock.On("UpdateDeviceExpectedFwVersion", deviceID, version)}
+}
+
+func (_c *MockIRepository_UpdateDeviceExpectedFwVersion_Call) Run(run func(deviceID string, version *string)) *MockIRepository_UpdateDeviceExpectedFwVersion_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(string), args[1].(*string))
+	})
+	return _c
+}
+
+func (_c *MockIRepository_UpdateDeviceExpectedFwVersion_Call) Return(_a0 error) *MockIRepository_UpdateDeviceExpectedFwVersion_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockIRepository_UpdateDeviceExpectedFwVersion_Call) RunAndReturn(run func(string, *string) error) *MockIRepository_UpdateDeviceExpectedFwVersion_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// UpdateDeviceInsecureSkipVerify provides a mock function with given fields: deviceID, insecureSkipVerify
+func (_m *MockIRepository) UpdateDeviceInsecureSkipVerify(deviceID string, insecureSkipVerify bool) error {
+	ret := _m.Called(deviceID, insecureSkipVerify)
+
+	if len(ret) == 0 {
+		panic("no return value specified for UpdateDeviceInsecureSkipVerify")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, bool) error); ok {
+		r0 = rf(deviceID, insecureSkipVerify)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MockIRepository_UpdateDeviceInsecureSkipVerify_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'UpdateDeviceInsecureSkipVerify'
+type MockIRepository_UpdateDeviceInsecureSkipVerify_Call struct {
+	*mock.Call
+}
+
+// UpdateDeviceInsecureSkipVerify is a helper method to define mock.On call
+//   - deviceID string
+//   - insecureSkipVerify bool
+func (_e *MockIRepository_Expecter) UpdateDeviceInsecureSkipVerify(deviceID interface{}, insecureSkipVerify interface{}) *MockIRepository_UpdateDeviceInsecureSkipVerify_Call {
+	return &MockIRepository_UpdateDeviceInsecureSkipVerify_Call{Call: _e.mock.On("UpdateDeviceInsecureSkipVerify", deviceID, insecureSkipVerify)}
+}
+
+func (_c *MockIRepository_UpdateDeviceInsecureSkipVerify_Call) Run(run func(deviceID string, insecureSkipVerify bool)) *MockIRepository_UpdateDeviceInsecureSkipVerify_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(string), args[1].(bool))
+	})
+	return _c
+}
+
+func (_c *MockIRepository_UpdateDeviceInsecureSkipVerify_Call) Return(_a0 error) *MockIRepository_UpdateDeviceInsecureSkipVerify_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockIRepository_UpdateDeviceInsecureSkipVerify_Call) RunAndReturn(run func(string, bool) error) *MockIRepository_UpdateDeviceInsecureSkipVerify_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// UpdateDevicePollIntervalOverride provides a mock function with given fields: deviceID, interval
+func (_m *MockIRepository) UpdateDevicePollIntervalOverride(deviceID string, interval *time.Duration) error {
+	ret := _m.Called(deviceID, interval)
+
+	if len(ret) == 0 {
+		panic("no return value specified for UpdateDevicePollIntervalOverride")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, *time.Duration) error); ok {
+		r0 = rf(deviceID, interval)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MockIRepository_UpdateDevicePollIntervalOverride_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'UpdateDevicePollIntervalOverride'
+type MockIRepository_UpdateDevicePollIntervalOverride_Call struct {
+	*mock.Call
+}
+
+// UpdateDevicePollIntervalOverride is a helper method to define mock.On call
+//   - deviceID string
+//   - interval *time.Duration
+func (_e *MockIRepository_Expecter) UpdateDevicePollIntervalOverride(deviceID interface{}, interval interface{}) *MockIRepository_UpdateDevicePollIntervalOverride_Call {
+	return &MockIRepository_UpdateDevicePollIntervalOverride_Call{Call: _e.mock.On("UpdateDevicePollIntervalOverride", deviceID, interval)}
+}
+
+func (_c *MockIRepository_UpdateDevicePollIntervalOverride_Call) Run(run func(deviceID string, interval *time.Duration)) *MockIRepository_UpdateDevicePollIntervalOverride_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(string), args[1].(*time.Duration))
+	})
+	return _c
+}
+
+func (_c *MockIRepository_UpdateDevicePollIntervalOverride_Call) Return(_a0 error) *MockIRepository_UpdateDevicePollIntervalOverride_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockIRepository_UpdateDevicePollIntervalOverride_Call) RunAndReturn(run func(string, *time.Duration) error) *MockIRepository_UpdateDevicePollIntervalOverride_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// UpdateDeviceSite provides a mock function with given fields: deviceID, site
+func (_m *MockIRepository) UpdateDeviceSite(deviceID string, site *string) error {
+	ret := _m.Called(deviceID, site)
+
+	if len(ret) == 0 {
+		panic("no return value specified for UpdateDeviceSite")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, *string) error); ok {
+		r0 = rf(deviceID, site)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MockIRepository_UpdateDeviceSite_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'UpdateDeviceSite'
+type MockIRepository_UpdateDeviceSite_Call struct {
+	*mock.Call
+}
+
+// UpdateDeviceSite is a helper method to define mock.On call
+//   - deviceID string
+//   - site *string
+func (_e *MockIRepository_Expecter) UpdateDeviceSite(deviceID interface{}, site interface{}) *MockIRepository_UpdateDeviceSite_Call {
+	return &MockIRepository_UpdateDeviceSite_Call{Call: _e.mock.On("UpdateDeviceSite", deviceID, site)}
+}
+
+func (_c *MockIRepository_UpdateDeviceSite_Call) Run(run func(deviceID string, site *string)) *MockIRepository_UpdateDeviceSite_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(string), args[1].(*string))
+	})
+	return _c
+}
+
+func (_c *MockIRepository_UpdateDeviceSite_Call) Return(_a0 error) *MockIRepository_UpdateDeviceSite_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockIRepository_UpdateDeviceSite_Call) RunAndReturn(run func(string, *string) error) *MockIRepository_UpdateDeviceSite_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// UpdateDeviceTypeEnabled provides a mock function with given fields: name, enabled
+func (_m *MockIRepository) UpdateDeviceTypeEnabled(name string, enabled bool) error {
+	ret := _m.Called(name, enabled)
+
+	if len(ret) == 0 {
+		panic("no return value specified for UpdateDeviceTypeEnabled")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, bool) error); ok {
+		r0 = rf(name, enabled)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MockIRepository_UpdateDeviceTypeEnabled_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'UpdateDeviceTypeEnabled'
+type MockIRepository_UpdateDeviceTypeEnabled_Call struct {
+	*mock.Call
+}
+
+// UpdateDeviceTypeEnabled is a helper method to define mock.On call
+//   - name string
+//   - enabled bool
+func (_e *MockIRepository_Expecter) UpdateDeviceTypeEnabled(name interface{}, enabled interface{}) *MockIRepository_UpdateDeviceTypeEnabled_Call {
+	return &MockIRepository_UpdateDeviceTypeEnabled_Call{Call: _e.mock.On("UpdateDeviceTypeEnabled", name, enabled)}
+}
+
+func (_c *MockIRepository_UpdateDeviceTypeEnabled_Call) Run(run func(name string, enabled bool)) *MockIRepository_UpdateDeviceTypeEnabled_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(string), args[1].(bool))
+	})
+	return _c
+}
+
+func (_c *MockIRepository_UpdateDeviceTypeEnabled_Call) Return(_a0 error) *MockIRepository_UpdateDeviceTypeEnabled_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockIRepository_UpdateDeviceTypeEnabled_Call) RunAndReturn(run func(string, bool) error) *MockIRepository_UpdateDeviceTypeEnabled_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// UpdateDeviceTypeExpectedFwVersion provides a mock function with given fields: name, version
+func (_m *MockIRepository) UpdateDeviceTypeExpectedFwVersion(name string, version *string) error {
+	ret := _m.Called(name, version)
+
+	if len(ret) == 0 {
+		panic("no return value specified for UpdateDeviceTypeExpectedFwVersion")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, *string) error); ok {
+		r0 = rf(name, version)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MockIRepository_UpdateDeviceTypeExpectedFwVersion_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'UpdateDeviceTypeExpectedFwVersion'
+type MockIRepository_UpdateDeviceTypeExpectedFwVersion_Call struct {
+	*mock.Call
+}
+
+// UpdateDeviceTypeExpectedFwVersion is a helper method to define mock.On call
+//   - name string
+//   - version *string
+func (_e *MockIRepository_Expecter) UpdateDeviceTypeExpectedFwVersion(name interface{}, version interface{}) *MockIRepository_UpdateDeviceTypeExpectedFwVersion_Call {
+	return &MockIRepository_UpdateDeviceTypeExpectedFwVersion_Call{Call: _e.mock.On("UpdateDeviceTypeExpectedFwVersion", name, version)}
+}
+
+func (_c *MockIRepository_UpdateDeviceTypeExpectedFwVersion_Call) Run(run func(name string, version *string)) *MockIRepository_UpdateDeviceTypeExpectedFwVersion_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(string), args[1].(*string))
+	})
+	return _c
+}
+
+func (_c *MockIRepository_UpdateDeviceTypeExpectedFwVersion_Call) Return(_a0 error) *MockIRepository_UpdateDeviceTypeExpectedFwVersion_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockIRepository_UpdateDeviceTypeExpectedFwVersion_Call) RunAndReturn(run func(string, *string) error) *MockIRepository_UpdateDeviceTypeExpectedFwVersion_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // NewMockIRepository creates a new instance of MockIRepository. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
 // The first argument is typically a *testing.T value.
 func NewMockIRepository(t interface {